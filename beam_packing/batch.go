@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"sync"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The outcome of packing a single file in batch mode.
+type batch_result struct {
+	infile  string
+	outfile string
+	nbeams  int
+	stats   beampack.LoadStats
+	report  beampack.Report
+	err     error
+}
+
+// The machine-readable summary of a single file in batch mode.
+type batch_file_summary struct {
+	File        string   `json:"file"`
+	Output      string   `json:"output,omitempty"`
+	Beams       int      `json:"beams"`
+	Rows        int      `json:"rows"`
+	Skipped     int      `json:"skipped"`
+	SkipReasons []string `json:"skip_reasons,omitempty"`
+	Bunches     int      `json:"bunches"`
+	MaxSep      float64  `json:"max_sep"`
+	MeanSep     float64  `json:"mean_sep"`
+	Status      string   `json:"status"`
+	Error       string   `json:"error,omitempty"`
+}
+
+// The machine-readable summary of a batch run.
+type batch_summary struct {
+	Files     int                  `json:"files"`
+	Succeeded int                  `json:"succeeded"`
+	Failed    int                  `json:"failed"`
+	Rows      int                  `json:"rows"`
+	Skipped   int                  `json:"skipped"`
+	Beams     int                  `json:"beams"`
+	Results   []batch_file_summary `json:"results"`
+}
+
+// Get the machine-readable summary of the batch results.
+func get_batch_summary(results []batch_result) batch_summary {
+	summary := batch_summary{Files: len(results), Results: make([]batch_file_summary, 0, len(results))}
+
+	for _, r := range results {
+		item := batch_file_summary{
+			File:        r.infile,
+			Beams:       r.nbeams,
+			Rows:        r.stats.Rows,
+			Skipped:     r.stats.Skipped,
+			SkipReasons: r.stats.Reasons,
+			Status:      "ok",
+		}
+
+		if r.err != nil {
+			item.Status = "failed"
+			item.Error = r.err.Error()
+			summary.Failed++
+		} else {
+			item.Output = r.outfile
+			item.Bunches = len(r.report.Bunches)
+			item.MaxSep = r.report.MaxSep
+			item.MeanSep = r.report.MeanSep
+			summary.Succeeded++
+		}
+
+		summary.Rows += item.Rows
+		summary.Skipped += item.Skipped
+		summary.Beams += item.Beams
+		summary.Results = append(summary.Results, item)
+	}
+
+	return summary
+}
+
+// Write the machine-readable summary of the batch results as JSON.
+func write_batch_summary(filename string, results []batch_result) error {
+	out, err := create_output(filename)
+	if err != nil {
+		return err
+	}
+
+	enc := json.NewEncoder(out)
+	enc.SetIndent("", "  ")
+
+	err = enc.Encode(get_batch_summary(results))
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not write summary: %s, %s", filename, err)
+	}
+
+	return nil
+}
+
+// Get the file extension of the output format.
+func get_format_ext() string {
+	switch {
+	case slices.Contains(beampack.TargetFormats, *format):
+		return "katpoint"
+	case *format == "text":
+		return "txt"
+	default:
+		return *format
+	}
+}
+
+// Derive the output file name from the input file name, or from the name
+// template and the packing.
+func get_batch_output(filename string, packing *beampack.Packing) (string, error) {
+	dir := *outdir
+	if dir == "" {
+		dir = filepath.Dir(filename)
+	}
+
+	if *nametemplate != "" {
+		name, err := expand_output_name(*nametemplate, packing, filename)
+		if err != nil {
+			return "", err
+		}
+
+		return beampack.JoinSink(dir, name), nil
+	}
+
+	return beampack.JoinSink(dir, fmt.Sprintf("%s_packing.%s", get_input_base(filename), get_format_ext())), nil
+}
+
+// Pack a single file and write the packing.
+func pack_batch_file(filename string, dist beampack.DistanceFunc) batch_result {
+	result := batch_result{infile: filename}
+
+	beams, err := load_beams_with(filename, &result.stats)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	result.nbeams = len(beams)
+	dist = get_metric(dist, beams)
+
+	// every file gets the same seed, so that the results do not depend on
+	// the scheduling of the workers
+	packing, err := compute_packing(beams, dist, *seed)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	packing.Provenance = get_provenance(filename)
+
+	result.outfile, err = get_batch_output(filename, packing)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	if local, ok := beampack.LocalPath(result.outfile); ok {
+		if err := os.MkdirAll(filepath.Dir(local), 0755); err != nil {
+			result.err = fmt.Errorf("Could not create output directory: %s, %s", filepath.Dir(local), err)
+			return result
+		}
+	}
+
+	f, err := create_output(result.outfile)
+	if err != nil {
+		result.err = err
+		return result
+	}
+
+	err = write_output(f, packing, *format, dist)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		result.err = fmt.Errorf("Could not write packing: %s", err)
+		return result
+	}
+
+	result.report = beampack.Score(packing, dist)
+
+	return result
+}
+
+// Pack every matching file in the input directory using a pool of workers
+// and print a combined summary.
+func run_batch() {
+	if *indir == "" {
+		usagef("No input directory given.")
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	get_seed()
+
+	files, err := filepath.Glob(filepath.Join(*indir, *pattern))
+	if err != nil {
+		usagef("Invalid file name pattern: %s, %s", *pattern, err)
+	}
+
+	sort.Strings(files)
+
+	if len(files) == 0 {
+		fatal(classify(exit_missing, fmt.Errorf("No input files found: %s", filepath.Join(*indir, *pattern))))
+	}
+
+	if local, ok := beampack.LocalPath(*outdir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			fatalf("Could not create output directory: %s, %s", *outdir, err)
+		}
+	}
+
+	nworkers := *workers
+	if nworkers < 1 {
+		nworkers = 1
+	}
+
+	jobs := make(chan int)
+	results := make([]batch_result, len(files))
+
+	var wg sync.WaitGroup
+
+	for w := 0; w < nworkers; w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range jobs {
+				results[i] = pack_batch_file(files[i], dist)
+			}
+		}()
+	}
+
+	for i := range files {
+		jobs <- i
+	}
+
+	close(jobs)
+	wg.Wait()
+
+	// a template that does not distinguish the inputs writes them to the
+	// same file
+	written := make(map[string][]string)
+	for _, r := range results {
+		if r.err == nil {
+			written[r.outfile] = append(written[r.outfile], r.infile)
+		}
+	}
+
+	for _, r := range results {
+		if inputs := written[r.outfile]; r.err == nil && len(inputs) > 1 && inputs[0] == r.infile {
+			slog.Warn("Several inputs were written to the same output file", "file", r.outfile, "inputs", len(inputs))
+		}
+	}
+
+	var failed, rows, skipped int
+
+	fmt.Printf("# %-40s %6s %7s %7s %10s %10s %s\n", "file", "beams", "skipped", "bunches", "maxsep", "meansep", "status")
+
+	for _, r := range results {
+		rows += r.stats.Rows
+		skipped += r.stats.Skipped
+
+		if r.err != nil {
+			failed++
+			fmt.Printf("  %-40s %6d %7d %7s %10s %10s %s\n", filepath.Base(r.infile), r.nbeams, r.stats.Skipped, "-", "-", "-", r.err)
+			continue
+		}
+
+		fmt.Printf("  %-40s %6d %7d %7d %10.6f %10.6f ok\n",
+			filepath.Base(r.infile), r.nbeams, r.stats.Skipped, len(r.report.Bunches), r.report.MaxSep, r.report.MeanSep)
+	}
+
+	fmt.Printf("\nFiles: %d, succeeded: %d, failed: %d, rows: %d, skipped: %d\n", len(results), len(results)-failed, failed, rows, skipped)
+
+	if *summaryfile != "" {
+		if err := write_batch_summary(*summaryfile, results); err != nil {
+			fatal(err)
+		}
+	}
+
+	if failed > 0 {
+		slog.Error("Some inputs failed", "files", len(results), "failed", failed, "class", exit_classes[exit_check], "exit_code", exit_check)
+		exit(exit_check)
+	}
+}
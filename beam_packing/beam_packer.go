@@ -1,73 +1,1534 @@
 package main
 
 import (
-	"bufio"
-	"encoding/csv"
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"io/fs"
+	"log/slog"
+	"maps"
+	"math/rand"
 	"os"
-	//"sort"
+	"path/filepath"
+	"runtime"
+	"slices"
 	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+var (
+	infile         = flag.String("in", "input/134.0696_0.0_beam_pos.dat", "Input file with the beam positions, - for stdin, an HTTP(S) URL to download them, or sb:ID or cb:ID to fetch them from the portal for a schedule or capture block.")
+	nbeams         = flag.Int("nbeams", 0, "Only consider that many beams for packing (default: all beams in the input), or the number of beams to generate in tile mode (default: 396) or in coverage mode (default: the least that reach -coverage).")
+	expect         = flag.Int("expect-nbeams", 0, "Expected number of coherent beams in the input. A mismatch is logged as warning.")
+	bunch          = flag.Int("bunch", 6, "Number of beams to pack into a group.")
+	ngroups        = flag.Int("ngroups", 0, "Partition the beams into that many groups of approximately equal size instead of using -bunch.")
+	remainder      = flag.String("remainder", "smaller", "Handling of the remaining beams if their number is not divisible by -bunch: smaller (one smaller bunch), pad (fill it up with dummy beams), balance (bunch sizes that differ by at most one) or abort.")
+	flaggedfile    = flag.String("flagged", "", "File with the dead or RFI-flagged beams to exclude before packing, one per line with an optional reason.")
+	dead           = flag.String("dead", "", "Comma-separated list of dead or RFI-flagged beams to exclude before packing.")
+	rebalance      = flag.Bool("rebalance", true, "Balance the bunch sizes if beams are flagged, instead of leaving one smaller bunch.")
+	subtilings     = flag.String("subtilings", "none", "Look for disconnected sub-tilings and outlier beams by density-based clustering before packing: none, split (pack every sub-tiling independently) or review (pack as usual and flag the layout for operator review).")
+	subtileps      = flag.Float64("subtiling-eps", 0, "Maximum separation of neighbouring beams in the sub-tiling detection (default: 1.5 times the median nearest-neighbour separation).")
+	subtilemin     = flag.Int("subtiling-min-points", 3, "Minimum number of neighbours, including itself, of a core beam in the sub-tiling detection.")
+	ratefile       = flag.String("rates", "", "File with the expected candidate rate of every beam, which sets the load of the processing nodes (default: one per beam).")
+	balance        = flag.Float64("balance", 0, "Weight of the node load balance against the compactness of the bunches in the annealing objective (requires -optimize anneal).")
+	previousfile   = flag.String("previous", "", "Previous packing file to change as little as possible: the bunches keep their IDs and nodes, and the annealing optimizer penalizes moving beams away from their previous bunch.")
+	churn          = flag.Float64("churn", 1, "Weight of moving all beams away from their previous bunch against the compactness of the bunches in the annealing objective (requires -previous).")
+	pbweight       = flag.Bool("pb-weight", false, "Scale the beam priority weights by their sensitivity, so that the compactness of the central beams counts more (requires -primary-beam).")
+	pborder        = flag.Bool("pb-order", false, "Assign the bunches to the nodes in order of decreasing mean sensitivity, so that the low-sensitivity edge bunches share the last nodes (requires -primary-beam).")
+	paretofile     = flag.String("pareto", "", "Write the trade-off between compactness and node load balance for the -pareto-weights to this file.")
+	paretoweights  = flag.String("pareto-weights", "0,0.1,0.2,0.5,1,2,5,10", "Comma-separated load balance weights for -pareto.")
+	loadfile       = flag.String("loads", "", "Write the expected candidate load of every processing node to this file.")
+	throughput     = flag.Float64("throughput", 0, "Candidate rate a processing node keeps up with, in the units of the -rates, for the nodes without throughput= in the -nodes file (0: unknown).")
+	maxutil        = flag.Float64("max-utilization", 0.8, "Fraction of its throughput above which a node is likely to fall behind.")
+	outfile        = flag.String("out", "", "Output file for the packing (default: stdout). In pack mode, it can be a template like -name-template.")
+	metric         = flag.String("metric", "euclidean", "Distance metric to use: elliptical or one of the registered metrics, by default euclidean and angular.")
+	distcache      = flag.String("dist-cache", "", "Directory of the cache of the pairwise beam distance matrices, which are reused by later runs on the same beam positions with the same expensive metric, e.g. angular.")
+	format         = flag.String("format", "text", "Output format: text, json or csv, jsonl for a JSON Lines stream of the beam assignments, or katpoint or katpoint-beams for katpoint target descriptions of the bunch centroids (and beams).")
+	method         = flag.String("method", "greedy", "Packing method: greedy, kmeans, hilbert, partition or mst.")
+	projection     = flag.String("projection", "none", "Project the beam positions before packing: none or gnomonic (tangent plane about -boresight, default: the mean beam direction).")
+	optimize       = flag.String("optimize", "none", "Refine the packing using an optimizer: none, anneal or ga.")
+	iterations     = flag.Int("iterations", 100000, "Number of optimizer iterations.")
+	population     = flag.Int("population", 50, "Population size of the genetic optimizer.")
+	generations    = flag.Int("generations", 200, "Number of generations of the genetic optimizer.")
+	chains         = flag.Int("chains", 1, "Number of independent optimizer chains that run in parallel, keeping the best result (0: one per core).")
+	fitness        = flag.String("fitness", "cost", "Fitness function of the genetic optimizer: cost or maxsep.")
+	progress       = flag.Duration("progress", 10*time.Second, "Interval of the optimizer progress reports, 0 to disable them.")
+	maxruntime     = flag.Duration("max-runtime", 0, "Stop the optimizer after this runtime and keep the best packing found so far, e.g. 5m (default: unlimited).")
+	checkpointfile = flag.String("checkpoint", "", "Periodically write the state of the annealing optimizer to this file.")
+	checkpointint  = flag.Duration("checkpoint-interval", 5*time.Minute, "Interval of the optimizer checkpoints.")
+	resume         = flag.Bool("resume", false, "Resume the annealing optimizer from the -checkpoint file, if it exists.")
+	reportfile     = flag.String("report", "", "Output file for the packing quality report.")
+	sepfile        = flag.String("separations", "", "Output file for the distribution of the intra-bunch separations.")
+	sepbins        = flag.Int("sep-bins", 20, "Number of histogram bins of the separation distribution, 0 to write the raw values.")
+	plotfile       = flag.String("plot", "", "Plot the packing to this file (svg or png).")
+	graphfile      = flag.String("graph", "", "Export the beam adjacency graph to this file (dot or graphml).")
+	graphsep       = flag.Float64("graph-sep", 0, "Maximum separation of neighbouring beams in the adjacency graph (default: 1.5 times the median nearest-neighbour separation).")
+	voronoifile    = flag.String("voronoi", "", "Export the Voronoi cells of the beams to this file (geojson, json or svg for an overlay of the -plot).")
+	voronoirad     = flag.Float64("voronoi-radius", 0, "Maximum radius of the Voronoi cells around their beams (default: about the beam spacing, from the mean area per beam).")
+	duptol         = flag.Float64("dup-tol", 1e-6, "Separation below which two beams are duplicates in the spacing diagnostics.")
+	outlierfactor  = flag.Float64("outlier-factor", 5, "Beams whose nearest neighbour is more than this many times the median nearest-neighbour separation away are outliers in the spacing diagnostics.")
+	pipelinedir    = flag.String("pipeline", "", "Write the per-node single-pulse search pipeline configurations into this directory.")
+	mcastbase      = flag.String("mcast-base", "239.11.1.0", "Multicast group of the first bunch in the pipeline configuration.")
+	mcastport      = flag.Int("mcast-port", 7147, "Port of the multicast groups in the pipeline configuration.")
+	mcastmap       = flag.String("mcast-map", "", "File with the multicast group of every beam, instead of one group per bunch from -mcast-base.")
+	mcastgroups    = flag.String("mcast-groups", "", "Write the multicast groups every node and bunch must subscribe to to this file.")
+	dbfile         = flag.String("db", "", "Store every computed packing in this SQLite database, which is created if needed.")
+	obsid          = flag.String("obs-id", "", "Observation ID of the packings stored in the database.")
+	queryid        = flag.Int64("query-id", 0, "ID of the stored packing to output in query mode (default: list the packings).")
+	queryat        = flag.String("at", "", "Only list the packing in effect at this time in query mode, e.g. 2024-05-01T18:00:00Z.")
+	delimiter      = flag.String("delimiter", "auto", "Input field delimiter: auto, tab, comma, space, semicolon or a single character.")
+	header         = flag.String("header", "auto", "Input header row handling: auto, none, skip or parse.")
+	lenient        = flag.Bool("lenient", false, "Skip malformed input rows with a warning instead of failing.")
+	informat       = flag.String("informat", "auto", "Input format: auto, dat, fbfuse or fits.")
+	hdu            = flag.String("hdu", "", "HDU of a FITS input file that holds the beam table, by number or extension name (default: the first binary table).")
+	portal         = flag.String("portal", "", "Base URL of the sensor query proxy of the MeerKAT portal to fetch beam positions from (default: $KATPORTAL_URL).")
+	portaltoken    = flag.String("portal-token", "", "Access token for the portal (default: $KATPORTAL_TOKEN).")
+	portalsensors  = flag.String("portal-sensors", beampack.DefaultBeamSensors, "Regular expression matching the beam position sensor names on the portal.")
+	httptimeout    = flag.Duration("http-timeout", 30*time.Second, "Timeout of the download of an input given as HTTP(S) URL.")
+	httpretries    = flag.Int("http-retries", 3, "Number of retries of a failed download.")
+	httpwait       = flag.Duration("http-retry-wait", 2*time.Second, "Wait before the first retry of a failed download, which doubles after each retry.")
+	s3endpoint     = flag.String("s3-endpoint", "", "Endpoint URL of the S3 compatible object store for s3:// outputs, e.g. a MinIO server (default: $AWS_ENDPOINT_URL, or AWS S3).")
+	s3region       = flag.String("s3-region", "", "Region of the object store (default: $AWS_REGION or us-east-1).")
+	units          = flag.String("units", "deg", "Units of the beam position table: deg, arcmin, arcsec, rad or auto. The positions are converted to degrees.")
+	coords         = flag.String("coords", "auto", "Coordinate notation of the beam position table: auto, decimal or sexagesimal (RA in hh:mm:ss.s, Dec in dd:mm:ss.s).")
+	equinox        = flag.String("equinox", "J2000", "Equinox of the beam positions, which are converted to J2000 when loaded: J2000, a Julian epoch such as J2024.5, or mean, date or apparent for the mean, true or apparent equator and equinox at the -start time.")
+	frame          = flag.String("frame", "equatorial", "Coordinate frame of the beam positions: equatorial (RA and Dec) or galactic (l and b), which are converted to equatorial when loaded.")
+	outframe       = flag.String("out-frame", "equatorial", "Coordinate frame of the beam positions in the packing output: equatorial or galactic.")
+	primarybeam    = flag.String("primary-beam", "none", "Primary beam model that sets the relative sensitivity of the coherent beams from their offset from -boresight (default: the mean beam direction): none, gaussian or cosine.")
+	pbfwhm         = flag.Float64("pb-fwhm", 1.12, "Full width at half maximum of the primary beam in degrees (default: MeerKAT at 1284 MHz).")
+	xcol           = flag.String("xcol", "", "Column of the x coordinate (RA), by number starting at 1 or by header name (default: detected).")
+	ycol           = flag.String("ycol", "", "Column of the y coordinate (Dec), by number starting at 1 or by header name (default: detected).")
+	namecol        = flag.String("namecol", "", "Column of the beam names, by number starting at 1 or by header name (default: detected).")
+	nodefile       = flag.String("nodes", "", "File with the list of processing nodes to assign the bunches to.")
+	constraints    = flag.String("constraints", "", "File with groups of beams that must be packed into the same bunch, one group per line.")
+	regionfile     = flag.String("regions", "", "File with masked sky regions (circles or polygons). The beams within them are dropped before packing.")
+	weightfile     = flag.String("weights", "", "File with beam priority weights (beam and weight per line). High-priority beams get tighter bunches in the optimizer.")
+	capacity       = flag.Int("capacity", 1, "Default number of bunches per processing node.")
+	offline        = flag.String("offline", "", "Comma-separated list of processing nodes that are offline.")
+	ibpolicy       = flag.String("ib-policy", "bunch", "Incoherent beam handling: bunch, pin or exclude.")
+	ibname         = flag.String("ib-name", "", "Comma-separated names of additional incoherent beams (default: names starting with ifbf).")
+	ibnode         = flag.String("ib-node", "", "Processing node to pin the incoherent beam to.")
+	mode           = flag.String("mode", "pack", "Operation mode if no command is given: pack, tile, coverage, psf, simulate, plot, validate, diff, load, stats, serve, batch, bench, mosaic, bus, match, which-beam, crossmatch, coincidence, ibmatch, tui, epochs, query, localize, cluster, filinfo, dm-plan, summary, drift, overlap, convert, bundle or healpix.")
+	tuiwidth       = flag.Int("tui-width", 72, "Width of the tiling plot in the interactive mode in characters.")
+	indir          = flag.String("indir", "", "Batch mode input directory.")
+	outdir         = flag.String("outdir", "", "Batch mode output directory (default: the input directory).")
+	nametemplate   = flag.String("name-template", "", "Template of the output file names in batch and watch mode, e.g. {{.Boresight}}_{{.Method}}_{{.Date}}.{{.Ext}} (default: the input name with _packing).")
+	pattern        = flag.String("pattern", "*_beam_pos.dat", "Batch mode input file name pattern.")
+	workers        = flag.Int("workers", runtime.NumCPU(), "Number of parallel workers in batch mode.")
+	summaryfile    = flag.String("summary", "", "Write a machine-readable JSON summary of all files to this file in batch mode.")
+	seed           = flag.Int64("seed", 0, "Seed for the stochastic methods (default: derived from the current time).")
+	configfile     = flag.String("config", "", "Read the settings from this YAML or TOML file. Command-line flags take precedence.")
+	boresight      = flag.String("boresight", "0,0", "Tiling boresight position as x,y, and the tangent point of the gnomonic projection.")
+	semimajor      = flag.Float64("semimajor", 0.01, "Beam semi-major axis at half power for tiling and the elliptical metric.")
+	semiminor      = flag.Float64("semiminor", 0.01, "Beam semi-minor axis at half power for tiling and the elliptical metric.")
+	pa             = flag.Float64("pa", 0, "Beam position angle in degrees for tiling and the elliptical metric.")
+	antennafile    = flag.String("antennas", "", "File with the antenna positions (katpoint descriptions or name, east, north and up offsets in metres), from which the coherent beam shape at -freq and -hour-angle for the Dec of -boresight is estimated. It replaces -semimajor, -semiminor and -pa.")
+	ants           = flag.String("ants", "", "Comma-separated names of the antennas used in the beamforming (default: all in -antennas).")
+	freq           = flag.Float64("freq", 1284, "Observing frequency in MHz for the beam shape estimate.")
+	hourangle      = flag.Float64("hour-angle", 0, "Hour angle in hours for the beam shape estimate.")
+	starttime      = flag.String("start", "", "Start time of the observation in epochs and drift mode and for the equinox of date, e.g. 2024-05-01T18:00:00Z (default: now).")
+	duration       = flag.Duration("duration", 8*time.Hour, "Duration of the observation in epochs and drift mode.")
+	nepochs        = flag.Int("epochs", 9, "Number of epochs to evaluate over the observation in epochs mode.")
+	degrade        = flag.Float64("degrade", 1.1, "Flag epochs at which the mean intra-bunch separation exceeds the one of a repacking by this factor.")
+	epochdir       = flag.String("epoch-dir", "", "Write the repacking of every epoch into this directory.")
+	driftstep      = flag.Duration("drift-step", 5*time.Minute, "Time step of the beam drift simulation.")
+	minoverlap     = flag.Float64("min-overlap", 0.01, "Minimum overlap fraction of either beam for the pairs listed in overlap mode.")
+	convertto      = flag.String("to", "auto", "Output beam format of convert mode: auto, dat or fbfuse (default: fbfuse for -out files ending in .json, else the other one of the input).")
+	bundledir      = flag.String("bundle-dir", "bundles", "Output directory of the candidate bundles in bundle mode.")
+	bundleby       = flag.String("bundle-by", "node", "Grouping of the candidate bundles: node or bunch.")
+	nside          = flag.Int64("nside", 4096, "Resolution parameter of the HEALPix coverage map, a power of two.")
+	healpixorder   = flag.String("healpix-order", "ring", "Pixel ordering of the HEALPix coverage map: ring or nested.")
+	healpixadd     = flag.String("healpix-add", "", "HEALPix coverage map in FITS format to add the coverage of the pointings to.")
+	overlap        = flag.Float64("overlap", 0.5, "Tiling power level at which neighbouring beams overlap (default in coverage mode: the one that needs the fewest beams).")
+	coverage       = flag.Float64("coverage", 0.9, "Fraction of the target region the coherent beams must cover in coverage mode.")
+	pblevel        = flag.Float64("pb-level", 0.5, "Primary beam response that bounds the target region in coverage mode, e.g. 0.5 for the half-power area.")
+	cblevel        = flag.Float64("cb-level", 0.5, "Coherent beam response, relative to its peak, at which a position counts as covered in coverage mode.")
+	coveragecurve  = flag.String("coverage-curve", "", "Write the coverage of the target region as function of the number of beams to this file in coverage mode.")
+	watchdir       = flag.String("watch", "", "Watch this directory and pack new beam position files as they appear.")
+	interval       = flag.Duration("interval", time.Second, "Polling interval for the watched directory.")
+	listen         = flag.String("listen", ":8080", "Address to listen on in serve mode.")
+	pprofon        = flag.Bool("pprof", false, "Serve the net/http/pprof profiling endpoints under /debug/pprof/ in serve mode.")
+	dashboardon    = flag.Bool("dashboard", true, "Serve the web dashboard of the last packing under / in serve mode.")
+	redisaddr      = flag.String("redis", "localhost:6379", "Address of the Redis server in bus mode.")
+	subchannel     = flag.String("subscribe", "meertrap:beam_config", "Channel to receive new beam configurations on in bus mode.")
+	pubchannel     = flag.String("publish", "meertrap:beam_packing", "Channel to publish the packings on in bus mode.")
+	catalogue      = flag.String("catalogue", "", "Source catalogue (PSRCAT output or CSV) to match against the beams in match mode, or to look up in which-beam mode.")
+	catequinox     = flag.String("cat-equinox", "J2000", "Equinox of the source catalogue positions, like -equinox.")
+	tagpulsars     = flag.String("tag-pulsars", "", "Pulsar catalogue (PSRCAT output or CSV) whose pulsars annotate the bunches that contain them in the packing output.")
+	tagcals        = flag.String("tag-calibrators", "", "Calibrator catalogue (PSRCAT output or CSV) whose sources annotate the bunches that contain them in the packing output.")
+	radius         = flag.Float64("radius", 0, "Beam radius in degrees for source matching (default: the beam semi-major axis or half the beam spacing).")
+	nearest        = flag.Int("nearest", 1, "Number of nearest beams to report per position in which-beam mode.")
+	packingfile    = flag.String("packing", "", "Packing output file to cross-match the candidates against in crossmatch mode, to look the positions up in which-beam mode, or to check in validate mode.")
+	maxradius      = flag.Float64("max-radius", 0, "Maximum bounding circle radius of a bunch in validate mode (default: no limit).")
+	locgrid        = flag.Int("loc-grid", 201, "Number of grid points along each axis of the localization grid.")
+	locmap         = flag.String("loc-map", "", "Write the chi-squared map of the localization to this file.")
+	locnondet      = flag.Bool("loc-nondetections", true, "Count the beams near the detections that are not in the detections file as non-detections with zero S/N.")
+	clustermethod  = flag.String("cluster-method", "fof", "Candidate clustering method: fof (friends-of-friends) or dbscan.")
+	clustertime    = flag.Float64("cluster-time", 0.05, "Linking length of the candidate clustering in time, in seconds.")
+	clusterdm      = flag.Float64("cluster-dm", 5, "Linking length of the candidate clustering in DM.")
+	clusterwidth   = flag.Float64("cluster-width", 0, "Maximum width ratio of neighbouring candidates in the clustering (default: no width criterion).")
+	minpoints      = flag.Int("min-points", 3, "Minimum number of neighbours of a core candidate in DBSCAN clustering.")
+	members        = flag.Bool("members", false, "Write every candidate with its event ID instead of the events in cluster mode.")
+	window         = flag.Float64("window", 0.1, "Time window in seconds within which candidates are coincident.")
+	dmtol          = flag.Float64("dm-tol", 0, "DM tolerance for coincident candidates (default: no DM criterion).")
+	dmplanfile     = flag.String("dm-plan", "", "Dedispersion plan with one DM range per line: start, end, step and optionally the downsampling factor.")
+	dmworkers      = flag.Int("dm-workers", 1, "Number of dedispersion workers, e.g. GPUs, per node.")
+	dmschedules    = flag.String("dm-schedules", "", "Write the per-node DM schedules into this directory.")
+	sbfile         = flag.String("sb", "", "Schedule block JSON file with the observation metadata: ID, proposal, targets, start, duration, antennas and band.")
+	maxtargetsep   = flag.Float64("max-target-sep", 1, "Warn if the boresight of the beams is further than this from the target of the schedule block, in degrees.")
+	ibcands        = flag.String("ib-cands", "", "Comma-separated incoherent beam candidate files in ibmatch mode.")
+	minbeams       = flag.Int("min-beams", 6, "Minimum number of beams for an event to be flagged as RFI.")
+	maxgroups      = flag.Int("max-groups", 2, "Maximum number of groups of adjacent beams for an event not to be flagged as RFI.")
+	jitter         = flag.Float64("jitter", 0.1, "Simulated beam position jitter in units of the beam semi-minor axis.")
+	missing        = flag.Float64("missing", 0.02, "Fraction of missing beams in simulate mode.")
+	verbose        = flag.Bool("verbose", false, "Log debug messages.")
+	quiet          = flag.Bool("quiet", false, "Only log warnings and errors.")
+	logformat      = flag.String("log-format", "text", "Log format: text or json.")
+	cpuprofile     = flag.String("cpuprofile", "", "Write a CPU profile of the run to this file, for go tool pprof.")
+	memprofile     = flag.String("memprofile", "", "Write a heap profile at the end of the run to this file, for go tool pprof.")
+	benchsizes     = flag.String("bench-sizes", "396,1024,4096", "Comma-separated numbers of beams to benchmark.")
 )
 
-type Data struct {
-	x float64
-	y float64
-	dist float64
+// Open the output, which is a local file, an object in the object store
+// given as s3://bucket/key, or stdout if no file name is given.
+func create_output(filename string) (io.WriteCloser, error) {
+	return beampack.OpenSink(filename, get_sink_options())
+}
+
+// Write an output like a plot in the format given by the extension of its
+// name, which must be one of the formats, e.g. plot.png for png. The kind
+// of the output is used in error messages.
+func write_format_output(filename string, kind string, formats []string, write func(io.Writer, string) error) error {
+	format := strings.ToLower(strings.TrimPrefix(filepath.Ext(filename), "."))
+	if !slices.Contains(formats, format) {
+		return fmt.Errorf("Unknown %s format: %s", kind, filename)
+	}
+
+	f, err := create_output(filename)
+	if err != nil {
+		return err
+	}
+
+	err = write(f, format)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Close the output and exit if it could not be written, e.g. because the
+// upload to the object store failed.
+func close_output(out io.Closer) {
+	if err := out.Close(); err != nil {
+		fatalf("Could not write output: %s", err)
+	}
+}
+
+// Get the settings of the output sinks. The object store settings and
+// credentials default to the environment.
+func get_sink_options() beampack.SinkOptions {
+	opts := beampack.S3Options{
+		Endpoint:     *s3endpoint,
+		Region:       *s3region,
+		AccessKey:    os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretKey:    os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken: os.Getenv("AWS_SESSION_TOKEN"),
+	}
+
+	if opts.Endpoint == "" {
+		opts.Endpoint = os.Getenv("AWS_ENDPOINT_URL")
+	}
+
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_REGION")
+	}
+
+	if opts.Region == "" {
+		opts.Region = os.Getenv("AWS_DEFAULT_REGION")
+	}
+
+	return beampack.SinkOptions{S3: opts}
 }
 
-func load_data(filename string) ([][]float64, error) {
-	f, err := os.Open(filename)
+// Parse a position given as x,y.
+func parse_position(text string) (float64, float64, error) {
+	parts := strings.Split(text, ",")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("Invalid position: %s", text)
+	}
 
+	x, err := strconv.ParseFloat(strings.TrimSpace(parts[0]), 64)
 	if err != nil {
-		error := fmt.Errorf("Could not open file: %s, %s", filename, err)
-		return nil, error
+		return 0, 0, fmt.Errorf("Invalid position: %s", text)
+	}
+
+	y, err := strconv.ParseFloat(strings.TrimSpace(parts[1]), 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("Invalid position: %s", text)
+	}
+
+	return x, y, nil
+}
+
+// Check whether the flag was set on the command line or in the
+// configuration file.
+func is_set(name string) bool {
+	set := false
+	cmdline.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+
+	return set
+}
+
+// Get the tangent point of the projection. Unless the boresight is given,
+// the mean beam direction is used.
+func get_tangent() (*beampack.Tangent, error) {
+	if *projection == "none" || !is_set("boresight") {
+		return nil, nil
 	}
 
-	defer f.Close()
+	ra, dec, err := parse_position(*boresight)
+	if err != nil {
+		return nil, err
+	}
 
-	reader := csv.NewReader(bufio.NewReader(f))
-	reader.Comma = '\t'
+	return &beampack.Tangent{RA: ra, Dec: dec}, nil
+}
 
-	lines, err := reader.ReadAll()
+// The number of beams to generate in tile mode by default, which is the
+// number of FBFUSE coherent beams in the standard configuration.
+const default_tile_beams = 396
 
+// Get the tiling options from the tiling settings.
+func get_tile_options() (beampack.TileOptions, error) {
+	x0, y0, err := parse_position(*boresight)
 	if err != nil {
-		error := fmt.Errorf("Could not parse csv data: %s", err)
-		return nil, error
+		return beampack.TileOptions{}, err
+	}
+
+	n := *nbeams
+	if n == 0 {
+		n = default_tile_beams
 	}
 
-	var data [][]float64
+	opts := beampack.TileOptions{
+		X0:        x0,
+		Y0:        y0,
+		SemiMajor: *semimajor,
+		SemiMinor: *semiminor,
+		PA:        *pa,
+		Overlap:   *overlap,
+		NBeams:    n,
+	}
+
+	return opts, nil
+}
+
+// Write the beam positions in the input format.
+func write_beams(beams []beampack.Beam) {
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
 
-	for _, line := range lines {
-		x, _ := strconv.ParseFloat(line[0], 64)
-		y, _ := strconv.ParseFloat(line[1], 64)
+	if err := beampack.WriteBeams(out, beams); err != nil {
+		fatalf("Could not write beam positions: %s", err)
+	}
+}
 
-		item := []float64{x, y}
+// Generate a hexagonal beam tiling and write it in the input format.
+func run_tile() {
+	opts, err := get_tile_options()
+	if err != nil {
+		fatal(err)
+	}
 
-		data = append(data, item)
+	beams, err := beampack.Tile(opts)
+	if err != nil {
+		fatal(err)
 	}
 
-	return data, nil
+	write_beams(beams)
 }
 
+// Generate the hexagonal beam tiling with the least number of beams that
+// covers the requested fraction of the primary beam and write it in the
+// input format.
+func run_coverage() {
+	x0, y0, err := parse_position(*boresight)
+	if err != nil {
+		usagef("%s", err)
+	}
 
-//func get_beam_packing(data [][]float64){
-//	const nbeams = 396
-//	const bunch = 6
-//
-//	sort.Float64s(data)
-//}
+	// the MeerKAT primary beam, unless another model is given
+	model := *primarybeam
+	if model == "none" {
+		model = "cosine"
+	}
 
+	if !slices.Contains(beampack.PrimaryBeamModels, model) {
+		usagef("Unknown primary beam model: %s", model)
+	}
 
-func main() {
-	infile := "input/134.0696_0.0_beam_pos.dat"
+	opts := beampack.CoverageOptions{
+		TileOptions: beampack.TileOptions{
+			X0:        x0,
+			Y0:        y0,
+			SemiMajor: *semimajor,
+			SemiMinor: *semiminor,
+			PA:        *pa,
+			Overlap:   *overlap,
+			NBeams:    *nbeams,
+		},
+		PrimaryBeam: beampack.PrimaryBeam{Model: model, FWHM: *pbfwhm, Centre: beampack.Tangent{RA: x0, Dec: y0}},
+		PBLevel:     *pblevel,
+		BeamLevel:   *cblevel,
+		Coverage:    *coverage,
+	}
+
+	// the spacing is optimized unless the overlap is given
+	if !is_set("overlap") {
+		opts.Overlap = 0
+	}
 
-	data, err := load_data(infile)
+	t, err := beampack.TileCoverage(opts)
 	if err != nil {
-		log.Fatalf("Could not load data from file: %s, %s", infile, err)
+		fatal(classify(exit_usage, err))
+	}
+
+	slog.Info("Tiled the primary beam", "beams", len(t.Beams), "coverage", t.Coverage, "overlap", t.Overlap, "radius", t.Radius)
+
+	if *coveragecurve != "" {
+		f, err := create_output(*coveragecurve)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteCoverageCurve(f, t)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write coverage curve: %s", err)
+		}
+	}
+
+	write_beams(t.Beams)
+}
+
+// Generate a synthetic beam layout with jitter and missing beams and write
+// it in the input format.
+func run_simulate() {
+	opts, err := get_tile_options()
+	if err != nil {
+		fatal(err)
+	}
+
+	beams, err := beampack.Simulate(beampack.SimulateOptions{
+		TileOptions: opts,
+		Jitter:      *jitter,
+		Missing:     *missing,
+	}, rand.New(rand.NewSource(get_seed())))
+	if err != nil {
+		fatal(err)
+	}
+
+	write_beams(beams)
+}
+
+// Check the packing settings and look up the distance metric. Invalid
+// settings are usage errors.
+func check_settings() (beampack.DistanceFunc, error) {
+	dist, err := check_flags()
+	return dist, classify(exit_usage, err)
+}
+
+// Check the flags of the packing settings and look up the distance metric.
+func check_flags() (beampack.DistanceFunc, error) {
+	if !slices.Contains(beampack.Formats, *format) && !slices.Contains(beampack.StreamFormats, *format) && !slices.Contains(beampack.TargetFormats, *format) {
+		return nil, fmt.Errorf("Unknown output format: %s", *format)
+	}
+
+	if !slices.Contains(beampack.Methods, *method) {
+		return nil, fmt.Errorf("Unknown packing method: %s", *method)
+	}
+
+	switch *optimize {
+	case "none", "anneal", "ga":
+	default:
+		return nil, fmt.Errorf("Unknown optimizer: %s", *optimize)
+	}
+
+	if *chains < 0 {
+		return nil, fmt.Errorf("The number of optimizer chains must not be negative: %d", *chains)
+	}
+
+	if *balance < 0 {
+		return nil, fmt.Errorf("The load balance weight must not be negative: %g", *balance)
+	}
+
+	if *churn < 0 {
+		return nil, fmt.Errorf("The churn weight must not be negative: %g", *churn)
+	}
+
+	if *balance > 0 && *optimize != "anneal" {
+		return nil, fmt.Errorf("The load balance objective requires -optimize anneal.")
+	}
+
+	if *httpretries < 0 {
+		return nil, fmt.Errorf("The number of download retries must not be negative: %d", *httpretries)
+	}
+
+	if *resume && *checkpointfile == "" {
+		return nil, fmt.Errorf("No checkpoint file given to resume from.")
+	}
+
+	if *checkpointfile != "" {
+		if *optimize != "anneal" || get_chains() > 1 {
+			return nil, fmt.Errorf("Checkpoints require -optimize anneal with a single chain.")
+		}
+
+		if *watchdir != "" {
+			return nil, fmt.Errorf("Checkpoints cannot be used in watch mode.")
+		}
+
+		if *checkpointint <= 0 {
+			return nil, fmt.Errorf("The checkpoint interval must be positive: %v", *checkpointint)
+		}
+	}
+
+	if !slices.Contains(beampack.Fitnesses, *fitness) {
+		return nil, fmt.Errorf("Unknown fitness function: %s", *fitness)
+	}
+
+	if !slices.Contains(beampack.Units, *units) {
+		return nil, fmt.Errorf("Unknown units: %s", *units)
+	}
+
+	if !slices.Contains(beampack.Projections, *projection) {
+		return nil, fmt.Errorf("Unknown projection: %s", *projection)
+	}
+
+	if !slices.Contains(beampack.Notations, *coords) {
+		return nil, fmt.Errorf("Unknown coordinate notation: %s", *coords)
+	}
+
+	for _, f := range []string{*frame, *outframe} {
+		if !slices.Contains(beampack.Frames, f) {
+			return nil, fmt.Errorf("Unknown coordinate frame: %s", f)
+		}
+	}
+
+	if *primarybeam != "none" && !slices.Contains(beampack.PrimaryBeamModels, *primarybeam) {
+		return nil, fmt.Errorf("Unknown primary beam model: %s", *primarybeam)
+	}
+
+	if *pbfwhm <= 0 {
+		return nil, fmt.Errorf("The primary beam width must be positive: %g", *pbfwhm)
+	}
+
+	if (*pbweight || *pborder) && *primarybeam == "none" {
+		return nil, fmt.Errorf("The sensitivity weighting requires -primary-beam.")
+	}
+
+	for _, text := range []string{*equinox, *catequinox} {
+		if _, err := get_equinox(text); err != nil {
+			return nil, err
+		}
+	}
+
+	if !slices.Contains(beampack.RemainderPolicies, *remainder) {
+		return nil, fmt.Errorf("Unknown remainder policy: %s", *remainder)
 	}
 
-	for _, blo := range data {
-		fmt.Println(blo[0], blo[1])
+	if !slices.Contains(beampack.SubTilingPolicies, *subtilings) {
+		return nil, fmt.Errorf("Unknown sub-tiling policy: %s", *subtilings)
 	}
-}
\ No newline at end of file
+
+	if *subtileps < 0 || *subtilemin < 1 {
+		return nil, fmt.Errorf("The sub-tiling linking length must not be negative and the minimum number of points must be positive: %g, %d", *subtileps, *subtilemin)
+	}
+
+	if !slices.Contains(beampack.IncoherentPolicies, *ibpolicy) {
+		return nil, fmt.Errorf("Unknown incoherent beam policy: %s", *ibpolicy)
+	}
+
+	if *metric == "elliptical" {
+		if *semimajor <= 0 || *semiminor <= 0 {
+			return nil, fmt.Errorf("The beam semi-axes must be positive: %g, %g", *semimajor, *semiminor)
+		}
+
+		return beampack.Elliptical(*semimajor, *semiminor, *pa), nil
+	}
+
+	return beampack.GetMetric(*metric)
+}
+
+// Get the distance metric for the beams. The elliptical metric uses the
+// mean shape of the beams if the input has beam shapes, otherwise the beam
+// shape settings.
+func get_metric(dist beampack.DistanceFunc, beams []beampack.Beam) beampack.DistanceFunc {
+	if *metric != "elliptical" {
+		return get_cached_metric(dist, beams)
+	}
+
+	a, b, angle, ok := beampack.MeanShape(beams)
+	if !ok || b <= 0 {
+		return dist
+	}
+
+	return beampack.Elliptical(a, b, angle)
+}
+
+// Look the distances between the beams up in their distance matrix from the
+// -dist-cache directory. The Euclidean and elliptical metrics are cheaper to
+// compute than to look up and are not cached.
+func get_cached_metric(dist beampack.DistanceFunc, beams []beampack.Beam) beampack.DistanceFunc {
+	if *distcache == "" || *metric == "euclidean" || *metric == "elliptical" {
+		return dist
+	}
+
+	cached, hit, err := beampack.CachedDistance(*distcache, *metric, beams, dist)
+	if err != nil {
+		slog.Warn("Could not cache the distance matrix", "dir", *distcache, "error", err)
+	}
+
+	if hit {
+		slog.Debug("Read the distance matrix from the cache", "dir", *distcache, "beams", len(beams))
+	}
+
+	return cached
+}
+
+// Get the load options from the input settings.
+func get_load_options() (beampack.LoadOptions, error) {
+	delim, err := beampack.ParseDelimiter(*delimiter)
+	if err != nil {
+		return beampack.LoadOptions{}, err
+	}
+
+	opts := beampack.LoadOptions{
+		Delimiter:   delim,
+		Header:      *header,
+		Lenient:     *lenient,
+		Format:      *informat,
+		HDU:         *hdu,
+		Units:       *units,
+		Coordinates: *coords,
+		XCol:        *xcol,
+		YCol:        *ycol,
+		NameCol:     *namecol,
+		HTTP: beampack.HTTPOptions{
+			Timeout:   *httptimeout,
+			Retries:   *httpretries,
+			RetryWait: *httpwait,
+		},
+	}
+
+	return opts, nil
+}
+
+// Mark the additional incoherent beams given in the settings.
+func mark_incoherent(beams []beampack.Beam) {
+	if *ibname != "" {
+		beampack.MarkIncoherent(beams, strings.Split(*ibname, ","))
+	}
+}
+
+// Fetch the beam positions of a schedule or capture block from the portal.
+// The portal URL and token default to the environment.
+func fetch_beams(kind, id string) ([]beampack.Beam, error) {
+	opts := beampack.PortalOptions{
+		URL:     *portal,
+		Token:   *portaltoken,
+		Sensors: *portalsensors,
+	}
+
+	if opts.URL == "" {
+		opts.URL = os.Getenv("KATPORTAL_URL")
+	}
+
+	if opts.Token == "" {
+		opts.Token = os.Getenv("KATPORTAL_TOKEN")
+	}
+
+	slog.Debug("Fetching beam positions", "portal", opts.URL, "block", kind, "id", id)
+
+	beams, err := beampack.FetchBeams(kind, id, opts)
+	if err != nil {
+		return nil, fmt.Errorf("Could not fetch beam positions: %s:%s, %s", kind, id, err)
+	}
+
+	return beams, nil
+}
+
+// Load the beam positions using the input settings. Inputs of the form
+// sb:ID or cb:ID are fetched from the portal.
+func load_beams(filename string) ([]beampack.Beam, error) {
+	return load_beams_with(filename, nil)
+}
+
+// Load the beam positions like load_beams and collect the numbers of parsed
+// and skipped rows of files, if the stats are given.
+func load_beams_with(filename string, stats *beampack.LoadStats) ([]beampack.Beam, error) {
+	opts, err := get_load_options()
+	if err != nil {
+		return nil, err
+	}
+
+	opts.Stats = stats
+
+	var beams []beampack.Beam
+
+	if kind, id, found := strings.Cut(filename, ":"); found && (kind == "sb" || kind == "cb") {
+		beams, err = fetch_beams(kind, id)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		beams, err = beampack.LoadWith(filename, opts)
+		if err != nil {
+			return nil, input_error(fmt.Errorf("Could not load data from file: %s, %w", filename, err))
+		}
+	}
+
+	mark_incoherent(beams)
+
+	eq, err := get_equinox(*equinox)
+	if err != nil {
+		return nil, classify(exit_usage, err)
+	}
+
+	// Galactic coordinates do not depend on the equinox
+	switch {
+	case *frame == "galactic":
+		if err := beampack.ConvertFrame(beams, "galactic", "equatorial"); err != nil {
+			return nil, classify(exit_usage, err)
+		}
+
+		slog.Debug("Converted the beam positions from Galactic coordinates")
+	case eq != beampack.J2000:
+		beampack.ConvertBeams(beams, eq, beampack.J2000)
+		slog.Debug("Converted the beam positions to J2000", "equinox", eq.String())
+	}
+
+	pb, err := get_primary_beam(beams)
+	if err != nil {
+		return nil, classify(exit_usage, err)
+	}
+
+	if pb != nil {
+		beampack.SetSensitivity(beams, *pb)
+		slog.Debug("Set the beam sensitivities", "model", pb.Model, "fwhm", pb.FWHM, "ra", pb.Centre.RA, "dec", pb.Centre.Dec)
+	}
+
+	slog.Debug("Loaded beams", "file", filename, "beams", len(beams))
+
+	return beams, nil
+}
+
+// Get the primary beam model, centred on the boresight or by default on the
+// mean direction of the coherent beams. It returns nil without a model.
+func get_primary_beam(beams []beampack.Beam) (*beampack.PrimaryBeam, error) {
+	if *primarybeam == "none" {
+		return nil, nil
+	}
+
+	if !slices.Contains(beampack.PrimaryBeamModels, *primarybeam) {
+		return nil, fmt.Errorf("Unknown primary beam model: %s", *primarybeam)
+	}
+
+	pb := &beampack.PrimaryBeam{Model: *primarybeam, FWHM: *pbfwhm}
+
+	if is_set("boresight") {
+		ra, dec, err := parse_position(*boresight)
+		if err != nil {
+			return nil, err
+		}
+
+		pb.Centre = beampack.Tangent{RA: ra, Dec: dec}
+	} else {
+		var coherent []beampack.Beam
+		for _, beam := range beams {
+			if !beam.Incoherent {
+				coherent = append(coherent, beam)
+			}
+		}
+
+		pb.Centre = beampack.GetBoresight(coherent)
+	}
+
+	return pb, nil
+}
+
+// Parse an equinox at the start time of the observation.
+func get_equinox(text string) (beampack.Equinox, error) {
+	t, err := get_start_time()
+	if err != nil {
+		return beampack.Equinox{}, err
+	}
+
+	return beampack.ParseEquinox(text, t)
+}
+
+// Load the processing nodes and mark the offline ones.
+func load_nodes() ([]beampack.Node, error) {
+	nodes, err := beampack.LoadNodes(*nodefile, *capacity)
+	if err != nil {
+		return nil, input_error(err)
+	}
+
+	for _, name := range strings.Split(*offline, ",") {
+		for i := range nodes {
+			if nodes[i].Name == strings.TrimSpace(name) {
+				nodes[i].Offline = true
+			}
+		}
+	}
+
+	return nodes, nil
+}
+
+// Get the random seed. Unless given, it is derived from the current time.
+func get_seed() int64 {
+	if *seed != 0 {
+		return *seed
+	}
+
+	*seed = time.Now().UnixNano()
+	slog.Info("Using random seed", "seed", *seed)
+
+	return *seed
+}
+
+// Check the number of coherent beams in the input against the expected
+// number, if given.
+func check_nbeams(beams []beampack.Beam) {
+	var n int
+	for _, beam := range beams {
+		if !beam.Incoherent {
+			n++
+		}
+	}
+
+	if *expect > 0 && n != *expect {
+		slog.Warn("Number of coherent beams in the input differs from the expected number", "beams", n, "expected", *expect)
+	}
+
+	if *nbeams > n {
+		slog.Warn("Fewer coherent beams in the input than requested", "beams", n, "nbeams", *nbeams)
+	}
+}
+
+// Get the dead or RFI-flagged beams from the file and the list given.
+func get_flagged(beams []beampack.Beam) (map[int]string, error) {
+	flagged := make(map[int]string)
+
+	if *flaggedfile != "" {
+		f, err := beampack.LoadFlagged(*flaggedfile, beams)
+		if err != nil {
+			return nil, input_error(err)
+		}
+
+		maps.Copy(flagged, f)
+	}
+
+	if *dead != "" {
+		f, err := beampack.ParseFlagged(*dead, beams)
+		if err != nil {
+			return nil, input_error(err)
+		}
+
+		maps.Copy(flagged, f)
+	}
+
+	return flagged, nil
+}
+
+// Pack the beams using the packing settings, optionally refine the packing
+// and assign the bunches to the processing nodes.
+func compute_packing(beams []beampack.Beam, dist beampack.DistanceFunc, seed int64) (*beampack.Packing, error) {
+	check_nbeams(beams)
+
+	rng := rand.New(rand.NewSource(seed))
+
+	tangent, err := get_tangent()
+	if err != nil {
+		return nil, err
+	}
+
+	if *weightfile != "" {
+		if err := beampack.LoadWeights(*weightfile, beams); err != nil {
+			return nil, input_error(err)
+		}
+	}
+
+	var pinned [][]int
+	if *constraints != "" {
+		if pinned, err = beampack.LoadConstraints(*constraints, beams); err != nil {
+			return nil, input_error(err)
+		}
+
+		slog.Debug("Loaded constraints", "file", *constraints, "groups", len(pinned))
+	}
+
+	if *pbweight {
+		beampack.WeightBySensitivity(beams)
+	}
+
+	if *ratefile != "" {
+		if err := beampack.LoadRates(*ratefile, beams); err != nil {
+			return nil, input_error(err)
+		}
+	}
+
+	flagged, err := get_flagged(beams)
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []beampack.Region
+	if *regionfile != "" {
+		if regions, err = beampack.LoadRegions(*regionfile); err != nil {
+			return nil, input_error(err)
+		}
+
+		slog.Debug("Loaded regions", "file", *regionfile, "regions", len(regions))
+	}
+
+	opts := beampack.Options{
+		NBeams:  *nbeams,
+		Bunch:   *bunch,
+		NGroups: *ngroups,
+		Method:  *method,
+		Metric:  dist,
+		Seed:    seed,
+		Rand:    rng,
+
+		Incoherent:     *ibpolicy,
+		IncoherentNode: *ibnode,
+
+		Projection: *projection,
+		Boresight:  tangent,
+
+		Pinned:    pinned,
+		Regions:   regions,
+		Remainder: *remainder,
+		Flagged:   flagged,
+		Rebalance: *rebalance,
+
+		SubTilings: *subtilings,
+		SubTiling: beampack.SubTilingOptions{
+			Eps:       *subtileps,
+			MinPoints: *subtilemin,
+		},
+	}
+
+	slog.Debug("Packing beams", "method", opts.Method, "nbeams", opts.NBeams, "bunch", opts.Bunch, "ngroups", opts.NGroups, "seed", seed)
+
+	packing, err := beampack.Pack(beams, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(packing.Flagged) > 0 {
+		for _, f := range packing.Flagged {
+			slog.Debug("Dropped beam", "beam", f.Beam.Name, "reason", f.Reason)
+		}
+
+		slog.Info("Dropped dead or flagged beams", "beams", len(packing.Flagged), "remainder", packing.Remainder)
+	}
+
+	if len(packing.Masked) > 0 {
+		for _, m := range packing.Masked {
+			slog.Debug("Dropped beam", "beam", m.Beam.Name, "region", m.Region.String())
+		}
+
+		slog.Info("Dropped beams within masked regions", "beams", len(packing.Masked))
+	}
+
+	if l := packing.Layout; l.NeedsReview() {
+		for _, beam := range l.Outliers {
+			slog.Debug("Outlier beam", "beam", beam.Name, "x", beam.X, "y", beam.Y)
+		}
+
+		sizes := make([]int, len(l.SubTilings))
+		for i, members := range l.SubTilings {
+			sizes[i] = len(members)
+		}
+
+		if l.Split {
+			slog.Info("Packed the sub-tilings independently", "subtilings", sizes, "outliers", len(l.Outliers), "eps", l.Eps)
+		} else {
+			slog.Warn("The beam layout needs review", "subtilings", sizes, "outliers", len(l.Outliers), "eps", l.Eps)
+		}
+	}
+
+	var previous []beampack.Record
+	if *previousfile != "" {
+		if previous, err = match_previous(packing); err != nil {
+			return nil, err
+		}
+	}
+
+	// the node loads are balanced between the nodes the bunches are
+	// assigned to, so they are assigned before optimizing
+	balanced := *balance > 0 || *paretofile != ""
+
+	if balanced && *nodefile != "" {
+		if err := assign_nodes(packing); err != nil {
+			return nil, err
+		}
+	}
+
+	if *paretofile != "" {
+		if err := write_pareto(packing, dist, seed); err != nil {
+			return nil, err
+		}
+	}
+
+	switch *optimize {
+	case "anneal":
+		m := get_monitor()
+
+		if err := set_checkpoint(m, packing); err != nil {
+			return nil, err
+		}
+
+		before := beampack.Cost(packing, dist)
+		lb := beampack.GetLoadBalance(packing)
+		if n := get_chains(); n > 1 {
+			var chain int
+			packing, chain = beampack.AnnealChains(packing, *iterations, *balance, dist, n, rng, m)
+			slog.Debug("Kept the best optimizer chain", "chains", n, "chain", chain)
+		} else {
+			packing = beampack.AnnealBalanced(packing, *iterations, *balance, dist, rng, m)
+		}
+		after := beampack.Cost(packing, dist)
+
+		log_stopped(m)
+
+		if *balance > 0 {
+			slog.Info("Optimized packing", "before", before, "after", after, "variation_before", lb.CV, "variation_after", beampack.GetLoadBalance(packing).CV)
+		} else {
+			slog.Info("Optimized packing", "before", before, "after", after)
+		}
+
+	case "ga":
+		opts, err := get_ga_options()
+		if err != nil {
+			return nil, err
+		}
+
+		opts.Monitor = get_monitor()
+
+		before := opts.Fitness(packing, dist)
+		if n := get_chains(); n > 1 {
+			var chain int
+			packing, chain = beampack.EvolveChains(packing, opts, dist, n, rng)
+			slog.Debug("Kept the best optimizer chain", "chains", n, "chain", chain)
+		} else {
+			packing = beampack.Evolve(packing, opts, dist, rng)
+		}
+		after := opts.Fitness(packing, dist)
+
+		log_stopped(opts.Monitor)
+		slog.Info("Optimized packing", "fitness", *fitness, "before", before, "after", after)
+	}
+
+	if *nodefile != "" && !balanced {
+		if err := assign_nodes(packing); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tag_bunches(packing); err != nil {
+		return nil, err
+	}
+
+	if previous != nil {
+		d := beampack.Compare(previous, beampack.Records(packing))
+		slog.Info("Changed the previous packing", "moved", len(d.Moved), "added", len(d.Added), "removed", len(d.Removed),
+			"nodes_changed", len(d.Nodes), "churn", d.Churn())
+	}
+
+	return packing, nil
+}
+
+// Match the bunches to the ones of the previous packing, so that they keep
+// their IDs and nodes, and return its records.
+func match_previous(packing *beampack.Packing) ([]beampack.Record, error) {
+	records, err := beampack.ReadPacking(*previousfile)
+	if err != nil {
+		return nil, input_error(err)
+	}
+
+	prev := beampack.GetPrevious(records, *churn)
+	kept := beampack.MatchPrevious(packing, prev)
+
+	slog.Info("Matched the previous packing", "file", *previousfile, "beams", len(prev.Bunches), "kept", kept)
+
+	return records, nil
+}
+
+// Assign the bunches to the processing nodes. The nodes of the previous
+// packing are kept if they are still online.
+func assign_nodes(packing *beampack.Packing) error {
+	nodes, err := load_nodes()
+	if err != nil {
+		return err
+	}
+
+	if packing.Previous != nil {
+		online := make(map[string]bool)
+		for _, node := range nodes {
+			online[node.Name] = !node.Offline
+		}
+
+		for i, b := range packing.Bunches {
+			if b.Node != "" && b.Node == packing.Previous.Nodes[b.ID] && !online[b.Node] {
+				slog.Debug("Released the previous node", "bunch", b.ID, "node", b.Node)
+				packing.Bunches[i].Node = ""
+			}
+		}
+	}
+
+	if *pborder {
+		return beampack.AssignBySensitivity(packing, nodes)
+	}
+
+	return beampack.Assign(packing, nodes)
+}
+
+// Write the trade-off between compactness and node load balance of the
+// packing for the load balance weights.
+func write_pareto(packing *beampack.Packing, dist beampack.DistanceFunc, seed int64) error {
+	var weights []float64
+
+	for _, field := range strings.Split(*paretoweights, ",") {
+		w, err := strconv.ParseFloat(strings.TrimSpace(field), 64)
+		if err != nil || w < 0 {
+			return fmt.Errorf("Invalid load balance weight: %s", field)
+		}
+
+		weights = append(weights, w)
+	}
+
+	points := beampack.ParetoFront(packing, weights, *iterations, dist, seed)
+
+	f, err := create_output(*paretofile)
+	if err != nil {
+		return err
+	}
+
+	err = beampack.WriteParetoFront(f, points)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return fmt.Errorf("Could not write Pareto front: %s", err)
+	}
+
+	return nil
+}
+
+// Get the number of optimizer chains, one per core if not given.
+func get_chains() int {
+	if *chains <= 0 {
+		return runtime.NumCPU()
+	}
+
+	return *chains
+}
+
+// Get the optimizer monitor that logs the progress and stops at the maximum
+// runtime.
+func get_monitor() *beampack.Monitor {
+	return &beampack.Monitor{
+		Progress: func(p beampack.Progress) {
+			slog.Info("Optimizer progress", "iteration", p.Iteration, "total", p.Total, "best", p.Best,
+				"temperature", p.Temperature, "elapsed", p.Elapsed.Round(time.Second), "remaining", p.Remaining.Round(time.Second))
+		},
+		Interval:   *progress,
+		MaxRuntime: *maxruntime,
+	}
+}
+
+// Set up the checkpoints of the annealing optimizer and the checkpoint to
+// resume from, if it exists.
+func set_checkpoint(m *beampack.Monitor, packing *beampack.Packing) error {
+	if *checkpointfile == "" {
+		return nil
+	}
+
+	m.CheckpointInterval = *checkpointint
+	m.Checkpoint = func(c *beampack.Checkpoint) {
+		if err := beampack.WriteCheckpoint(*checkpointfile, c); err != nil {
+			slog.Warn("Could not write checkpoint", "file", *checkpointfile, "error", err)
+			return
+		}
+
+		slog.Debug("Wrote checkpoint", "file", *checkpointfile, "iteration", c.Iteration, "total", c.Iterations)
+	}
+
+	if !*resume {
+		return nil
+	}
+
+	c, err := beampack.ReadCheckpoint(*checkpointfile)
+	if errors.Is(err, fs.ErrNotExist) {
+		slog.Info("No checkpoint to resume from, starting afresh", "file", *checkpointfile)
+		return nil
+	} else if err != nil {
+		return input_error(err)
+	}
+
+	if err := c.Check(packing, *iterations, *balance); err != nil {
+		return classify(exit_usage, fmt.Errorf("Could not resume from checkpoint: %s, %s", *checkpointfile, err))
+	}
+
+	slog.Info("Resuming from checkpoint", "file", *checkpointfile, "iteration", c.Iteration, "total", c.Iterations)
+	m.Resume = c
+
+	return nil
+}
+
+// Log whether the optimizer was stopped at the maximum runtime.
+func log_stopped(m *beampack.Monitor) {
+	if m.Stopped {
+		slog.Warn("Stopped the optimizer at the maximum runtime", "max_runtime", m.MaxRuntime)
+	}
+}
+
+// Get the settings of the genetic optimizer.
+func get_ga_options() (beampack.GAOptions, error) {
+	f, err := beampack.GetFitness(*fitness)
+	if err != nil {
+		return beampack.GAOptions{}, err
+	}
+
+	return beampack.GAOptions{
+		Population:  *population,
+		Generations: *generations,
+		Fitness:     f,
+	}, nil
+}
+
+// Compute the beam packing.
+func run_pack() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	packing.Provenance = get_provenance(*infile)
+
+	if is_template(*outfile) {
+		name, err := expand_output_name(*outfile, packing, *infile)
+		if err != nil {
+			fatal(err)
+		}
+
+		if err := os.MkdirAll(filepath.Dir(name), 0755); err != nil {
+			fatalf("Could not create output directory: %s, %s", filepath.Dir(name), err)
+		}
+
+		*outfile = name
+		slog.Info("Writing packing", "file", name)
+	}
+
+	write_packing(packing, dist)
+
+	if *subtilings == "review" && packing.Layout.NeedsReview() {
+		slog.Error("The beam layout needs operator review", "class", exit_classes[exit_check], "exit_code", exit_check)
+		exit(exit_check)
+	}
+}
+
+// Write the packing in the output format, with the beam positions in the
+// output frame. The katpoint targets are always equatorial.
+func write_output(w io.Writer, packing *beampack.Packing, format string, dist beampack.DistanceFunc) error {
+	if !slices.Contains(beampack.TargetFormats, format) {
+		var err error
+		if packing, err = beampack.ToFrame(packing, *outframe); err != nil {
+			return err
+		}
+	}
+
+	return beampack.Write(w, packing, format, dist)
+}
+
+// Write the packing to the output file, together with the report, plot and
+// adjacency graph if requested.
+func write_packing(packing *beampack.Packing, dist beampack.DistanceFunc) {
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := write_output(out, packing, *format, dist); err != nil {
+		fatalf("Could not write packing: %s", err)
+	}
+
+	report := beampack.Score(packing, dist)
+
+	slog.Info("Packed beams", "bunches", len(report.Bunches), "max_sep", report.MaxSep, "mean_sep", report.MeanSep, "p95_sep", report.P95Sep)
+
+	if *reportfile != "" {
+		f, err := create_output(*reportfile)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteReport(f, report)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write report: %s", err)
+		}
+	}
+
+	if *sepfile != "" {
+		write_separations(packing, dist)
+	}
+
+	if *loadfile != "" {
+		f, err := create_output(*loadfile)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteLoadBalance(f, beampack.GetLoadBalance(packing))
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write node loads: %s", err)
+		}
+	}
+
+	if *plotfile != "" {
+		err := write_format_output(*plotfile, "plot", beampack.PlotFormats, func(w io.Writer, format string) error {
+			return beampack.Plot(w, packing, format)
+		})
+		if err != nil {
+			fatalf("Could not plot packing: %s", err)
+		}
+	}
+
+	if *graphfile != "" {
+		g := beampack.Adjacency(packing, *graphsep, dist)
+
+		err := write_format_output(*graphfile, "graph", beampack.GraphFormats, func(w io.Writer, format string) error {
+			return beampack.WriteGraph(w, g, format)
+		})
+		if err != nil {
+			fatalf("Could not write adjacency graph: %s", err)
+		}
+
+		slog.Info("Wrote adjacency graph", "beams", len(g.Beams), "edges", len(g.Edges), "max_sep", g.MaxSep)
+	}
+
+	if *voronoifile != "" {
+		cells, err := beampack.Voronoi(packing, *voronoirad)
+		if err != nil {
+			fatalf("Could not compute Voronoi cells: %s", err)
+		}
+
+		err = write_format_output(*voronoifile, "Voronoi", beampack.VoronoiFormats, func(w io.Writer, format string) error {
+			return beampack.WriteVoronoi(w, packing, cells, format)
+		})
+		if err != nil {
+			fatalf("Could not write Voronoi cells: %s", err)
+		}
+
+		slog.Info("Wrote Voronoi cells", "cells", len(cells))
+	}
+
+	if *pipelinedir != "" {
+		if err := write_pipeline(packing, *pipelinedir); err != nil {
+			fatal(fmt.Errorf("Could not write pipeline configuration: %w", err))
+		}
+	}
+
+	if *mcastgroups != "" {
+		if err := write_multicast_groups(packing); err != nil {
+			fatal(fmt.Errorf("Could not write multicast groups: %w", err))
+		}
+	}
+
+	if *dbfile != "" {
+		store_packing(packing)
+	}
+}
+
+// Write the distribution of the intra-bunch separations into the
+// separations file.
+func write_separations(packing *beampack.Packing, dist beampack.DistanceFunc) {
+	f, err := create_output(*sepfile)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = beampack.WriteSeparations(f, beampack.Separations(packing, dist), *sepbins)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		fatalf("Could not write separations: %s", err)
+	}
+}
+
+// Get the per-node search pipeline configurations, with the multicast
+// groups of the beam mapping if given.
+func get_pipeline_configs(packing *beampack.Packing) ([]beampack.NodeConfig, error) {
+	opts := beampack.PipelineOptions{
+		MulticastBase: *mcastbase,
+		Port:          *mcastport,
+	}
+
+	if *mcastmap != "" {
+		var beams []beampack.Beam
+		for _, b := range packing.Bunches {
+			beams = append(beams, b.Beams...)
+		}
+
+		groups, err := beampack.LoadMulticast(*mcastmap, beams, *mcastport)
+		if err != nil {
+			return nil, input_error(err)
+		}
+
+		opts.Groups = groups
+	}
+
+	return beampack.PipelineConfigs(packing, opts)
+}
+
+// Write the multicast groups every node and bunch must subscribe to.
+func write_multicast_groups(packing *beampack.Packing) error {
+	configs, err := get_pipeline_configs(packing)
+	if err != nil {
+		return err
+	}
+
+	f, err := create_output(*mcastgroups)
+	if err != nil {
+		return err
+	}
+
+	err = beampack.WriteMulticastGroups(f, configs)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Write the search pipeline configuration of every node into the directory.
+// The files are named after the nodes, or after the bunch for bunches
+// without node.
+func write_pipeline(packing *beampack.Packing, dir string) error {
+	configs, err := get_pipeline_configs(packing)
+	if err != nil {
+		return err
+	}
+
+	if local, ok := beampack.LocalPath(dir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, cfg := range configs {
+		name := cfg.Node
+		if name == "" {
+			name = fmt.Sprintf("bunch%03d", cfg.Bunches[0].ID)
+		}
+
+		filename := beampack.JoinSink(dir, name+".yaml")
+
+		f, err := create_output(filename)
+		if err != nil {
+			return err
+		}
+
+		err = beampack.WritePipelineConfig(f, cfg)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			return fmt.Errorf("%s, %s", filename, err)
+		}
+	}
+
+	slog.Info("Wrote pipeline configuration", "dir", dir, "files", len(configs))
+
+	return nil
+}
+
+func main() {
+	parse_command_line()
+
+	if *configfile != "" {
+		if err := apply_config(*configfile); err != nil {
+			fatal(input_error(err))
+		}
+	}
+
+	if err := setup_logging(); err != nil {
+		fatal(err)
+	}
+
+	if err := start_profiling(); err != nil {
+		fatal(err)
+	}
+
+	if *antennafile != "" {
+		if err := apply_psf(); err != nil {
+			fatal(err)
+		}
+	}
+
+	if *watchdir != "" {
+		run_watch()
+		stop_profiling()
+		return
+	}
+
+	cmd, ok := find_command(*mode)
+	if !ok {
+		usagef("Unknown mode: %s", *mode)
+	}
+
+	cmd.run()
+	stop_profiling()
+}
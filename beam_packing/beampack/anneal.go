@@ -0,0 +1,475 @@
+package beampack
+
+import (
+	"math"
+	"math/rand"
+)
+
+// Flatten a packing into its beams and their bunch indices.
+func flatten_packing(p *Packing) ([]Beam, []int) {
+	var beams []Beam
+	var groups []int
+
+	for i, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			beams = append(beams, beam)
+			groups = append(groups, i)
+		}
+	}
+
+	return beams, groups
+}
+
+// Rebuild the bunches from the beams and their group indices.
+func regroup(beams []Beam, groups []int, ngroups int) [][]Beam {
+	result := make([][]Beam, ngroups)
+
+	for i, beam := range beams {
+		result[groups[i]] = append(result[groups[i]], beam)
+	}
+
+	return result
+}
+
+// Get the number of neighbours to consider for swaps or transfers, which
+// is a few times the size of the largest bunch.
+func get_nneighbours(sizes []int) int {
+	var largest int
+
+	for _, size := range sizes {
+		largest = max(largest, size)
+	}
+
+	return max(3*largest, 12)
+}
+
+// Anneal refines a packing using simulated annealing. Pairs of neighbouring
+// beams in different bunches are swapped to minimise the sum of intra-bunch
+// pairwise distances, weighted by the mean priority weight of the beams,
+// so that the bunches of high-priority beams get tighter. The temperature
+// decreases geometrically over the iterations and the best packing found is
+// returned. Pinned beams stay in their bunches.
+func Anneal(p *Packing, iterations int, dist DistanceFunc, rng *rand.Rand) *Packing {
+	return AnnealMonitored(p, iterations, dist, rng, nil)
+}
+
+// AnnealMonitored refines a packing like Anneal, reporting the progress to
+// the monitor and stopping at its maximum runtime.
+func AnnealMonitored(p *Packing, iterations int, dist DistanceFunc, rng *rand.Rand, m *Monitor) *Packing {
+	return AnnealBalanced(p, iterations, 0, dist, rng, m)
+}
+
+// AnnealBalanced refines a packing like AnnealMonitored, trading off the
+// compactness of the bunches against the load balance across the
+// processing nodes. The objective is the cost relative to the one of the
+// initial packing plus balance times the coefficient of variation of the
+// node loads, which are the summed candidate rates of their beams. The
+// bunches keep their IDs and nodes, so the packing must be assigned to
+// nodes first, otherwise the loads of the bunches are balanced. With zero
+// balance, this is the same as AnnealMonitored. If the packing was matched
+// to a previous one, the objective additionally includes the churn penalty,
+// the weight times the fraction of the previous beams that left their
+// bunch.
+func AnnealBalanced(p *Packing, iterations int, balance float64, dist DistanceFunc, rng *rand.Rand, m *Monitor) *Packing {
+	beams, group := flatten_packing(p)
+	ngroups := len(p.Bunches)
+	n := len(beams)
+
+	result := *p
+
+	if n < 2 || ngroups < 2 || iterations <= 0 {
+		result.set_groups(regroup(beams, group, ngroups))
+		keep_bunches(&result, p)
+		return &result
+	}
+
+	// the current and best bunches of an interrupted run, which was checked
+	// before
+	var order [][]int
+	var saved []int
+
+	resume := m.resume()
+	if resume != nil {
+		var err error
+		if order, saved, err = resume.match(beams, ngroups, iterations, balance); err != nil {
+			resume = nil
+		}
+
+		for g, bunch := range order {
+			for _, i := range bunch {
+				group[i] = g
+			}
+		}
+	}
+
+	// the positions in contiguous arrays, the distances are computed on the
+	// fly as only the members of two bunches are involved in a swap
+	xs := make([]float64, n)
+	ys := make([]float64, n)
+	ws := make([]float64, n)
+
+	// projected packings are refined on the tangent plane
+	work := beams
+	if p.Tangent != nil {
+		if projected, err := project_beams(beams, *p.Tangent); err == nil {
+			work = projected
+		}
+	}
+
+	for i, beam := range work {
+		xs[i], ys[i], ws[i] = beam.X, beam.Y, beam.weight()
+	}
+
+	// members of each bunch by index into beams, in the order of the
+	// checkpoint if resumed, as it determines the order of the cost sums
+	members := make([][]int, ngroups)
+	slot := make([]int, n)
+	sizes := make([]int, ngroups)
+
+	add := func(i, g int) {
+		slot[i] = len(members[g])
+		members[g] = append(members[g], i)
+		sizes[g]++
+	}
+
+	if resume != nil {
+		for g, bunch := range order {
+			for _, i := range bunch {
+				add(i, g)
+			}
+		}
+	} else {
+		for i, g := range group {
+			add(i, g)
+		}
+	}
+
+	// only swaps between nearby beams can improve a reasonable packing
+	neighbours := get_neighbours(work, get_nneighbours(sizes), dist)
+	fixed := get_fixed(beams, p.Pinned)
+
+	// the node loads, tracked by the sum of their squared deviations from
+	// the mean, which does not change
+	unit, names := get_load_units(p)
+	rates := make([]float64, n)
+	loads := make([]float64, len(names))
+
+	var mean, squares float64
+
+	for i, beam := range beams {
+		rates[i] = beam.rate()
+		loads[unit[group[i]]] += rates[i]
+		mean += rates[i]
+	}
+
+	mean /= float64(len(names))
+
+	for _, l := range loads {
+		squares += (l - mean) * (l - mean)
+	}
+
+	// the previous bunch of every beam, for the churn penalty
+	var home []int
+	var nprevious int
+
+	churn := 0.0
+	if p.Previous.active() {
+		home, nprevious = get_homes(p, beams)
+
+		if nprevious > 0 {
+			churn = p.Previous.Weight / float64(nprevious)
+		}
+	}
+
+	// the cost is relative to the initial one in the balanced objective
+	scale := 1.0
+	if resume != nil {
+		scale = resume.Scale
+	} else if balance > 0 || churn > 0 {
+		var q Packing
+		q.set_groups(regroup(work, group, ngroups))
+		scale = math.Max(Cost(&q, dist), 1e-12)
+	}
+
+	// the change of the squared deviations when beam a leaves its bunch and
+	// beam b takes its place
+	squares_delta := func(a, b int) float64 {
+		ua, ub := unit[group[a]], unit[group[b]]
+		if ua == ub {
+			return 0
+		}
+
+		dr := rates[b] - rates[a]
+		la, lb := loads[ua]+dr, loads[ub]-dr
+
+		return (la-mean)*(la-mean) + (lb-mean)*(lb-mean) - (loads[ua]-mean)*(loads[ua]-mean) - (loads[ub]-mean)*(loads[ub]-mean)
+	}
+
+	// the balance term change of a swap
+	balance_delta := func(a, b int) float64 {
+		if balance <= 0 {
+			return 0
+		}
+
+		cv := get_cv(squares, len(names), mean)
+		return balance * (get_cv(squares+squares_delta(a, b), len(names), mean) - cv)
+	}
+
+	// the churn term change of a swap
+	churn_delta := func(a, b int) float64 {
+		if churn <= 0 {
+			return 0
+		}
+
+		moved := func(k, g int) float64 {
+			if home[k] >= 0 && home[k] != g {
+				return 1
+			}
+
+			return 0
+		}
+
+		ga, gb := group[a], group[b]
+		return churn * (moved(a, gb) - moved(a, ga) + moved(b, ga) - moved(b, gb))
+	}
+
+	// the distance cost change when beam a leaves its bunch and beam b
+	// takes its place
+	cost_delta := func(a, b int) float64 {
+		var d float64
+
+		for _, k := range members[group[a]] {
+			if k != a {
+				d += (ws[b]+ws[k])/2*dist(xs[b], ys[b], xs[k], ys[k]) - (ws[a]+ws[k])/2*dist(xs[a], ys[a], xs[k], ys[k])
+			}
+		}
+
+		for _, k := range members[group[b]] {
+			if k != b {
+				d += (ws[a]+ws[k])/2*dist(xs[a], ys[a], xs[k], ys[k]) - (ws[b]+ws[k])/2*dist(xs[b], ys[b], xs[k], ys[k])
+			}
+		}
+
+		return d
+	}
+
+	// the objective change of a swap
+	delta := func(a, b int) float64 {
+		return cost_delta(a, b)/scale + balance_delta(a, b) + churn_delta(a, b)
+	}
+
+	propose := func() (int, int) {
+		a := rng.Intn(n)
+		return a, neighbours[a][rng.Intn(len(neighbours[a]))]
+	}
+
+	// swap beams a and b between their bunches
+	swap := func(a, b int) {
+		ga, gb := group[a], group[b]
+
+		if ua, ub := unit[ga], unit[gb]; ua != ub {
+			squares += squares_delta(a, b)
+			dr := rates[b] - rates[a]
+			loads[ua] += dr
+			loads[ub] -= dr
+		}
+
+		members[ga][slot[a]] = b
+		members[gb][slot[b]] = a
+		slot[a], slot[b] = slot[b], slot[a]
+		group[a], group[b] = gb, ga
+	}
+
+	// the churn penalty mostly decreases when beams swap back into their
+	// previous bunches, which the annealing rarely proposes, so the beams
+	// are first moved back greedily as long as the objective decreases
+	for improved := churn > 0 && resume == nil; improved; {
+		improved = false
+
+		for a := range beams {
+			if home[a] < 0 || home[a] == group[a] || fixed[a] {
+				continue
+			}
+
+			b, bestd := -1, 0.0
+			for _, k := range members[home[a]] {
+				if d := delta(a, k); !fixed[k] && d < bestd {
+					b, bestd = k, d
+				}
+			}
+
+			if b >= 0 {
+				swap(a, b)
+				improved = true
+			}
+		}
+	}
+
+	// start at a temperature comparable to the typical swap cost
+	var temp, cooling float64
+
+	if resume != nil {
+		temp, cooling = resume.Temperature, resume.Cooling
+	} else {
+		var t0 float64
+		for i := 0; i < 100; i++ {
+			a, b := propose()
+			if group[a] != group[b] && !fixed[a] && !fixed[b] {
+				t0 += math.Abs(delta(a, b))
+			}
+		}
+		t0 = math.Max(t0/100, 1e-12)
+
+		tend := t0 * 1e-4
+		cooling = math.Pow(tend/t0, 1/float64(iterations))
+		temp = t0
+	}
+
+	// keep track of the best packing seen, it is only saved before the
+	// packing gets worse
+	var cost, bestcost float64
+	best := make([]int, n)
+	copy(best, group)
+	unsaved := false
+
+	// the costs are tracked relative to the initial packing
+	var initial float64
+	if resume != nil {
+		cost, bestcost, initial = resume.Cost, resume.BestCost, resume.Initial
+		copy(best, saved)
+	} else if m != nil {
+		var q Packing
+		q.set_groups(regroup(work, group, ngroups))
+		initial = Cost(&q, dist) / scale
+
+		if balance > 0 {
+			initial += balance * get_cv(squares, len(names), mean)
+		}
+
+		for i, h := range home {
+			if h >= 0 && h != group[i] {
+				initial += churn
+			}
+		}
+	}
+
+	// the state is checkpointed in blocks of iterations, at whose start the
+	// random number generator is reseeded, so that a resumed run continues
+	// from the start of its last block like the interrupted one. It is
+	// reseeded without checkpoints as well, so that the packing of a seed
+	// does not depend on them.
+	start := 0
+
+	state := Checkpoint{
+		Optimizer:  "anneal",
+		Iterations: iterations,
+		Balance:    balance,
+		Cooling:    cooling,
+		Scale:      scale,
+		Initial:    initial,
+		Bunches:    ngroups,
+	}
+
+	// the beams of the resumed run can be in a different order
+	if resume != nil {
+		state = *resume
+		state.Beams = nil
+		state.Current = order
+		state.Best = append([]int(nil), best...)
+
+		for _, beam := range beams {
+			state.Beams = append(state.Beams, beam.Name)
+		}
+
+		start = resume.Iteration
+		rng.Seed(resume.Seed)
+	}
+
+	m.begin()
+
+	for iter := start; iter < iterations; iter++ {
+		if iter%checkpoint_block == 0 && (resume == nil || iter > start) {
+			reseed_block(&state, rng)
+
+			if m.checkpointing() {
+				if unsaved {
+					copy(best, group)
+					unsaved = false
+				}
+
+				state.Iteration, state.Temperature, state.Cost, state.BestCost = iter, temp, cost, bestcost
+				take_checkpoint(&state, beams, members, best)
+				m.save(&state, false)
+			}
+		}
+
+		if iter%1024 == 0 && m.update(iter, iterations, initial+bestcost, temp) {
+			break
+		}
+
+		a, b := propose()
+		ga, gb := group[a], group[b]
+
+		if ga != gb && !fixed[a] && !fixed[b] {
+			d := delta(a, b)
+
+			if d < 0 || rng.Float64() < math.Exp(-d/temp) {
+				if d > 0 && unsaved {
+					copy(best, group)
+					unsaved = false
+				}
+
+				swap(a, b)
+				cost += d
+
+				if cost < bestcost {
+					bestcost = cost
+					unsaved = true
+				}
+			}
+		}
+
+		temp *= cooling
+	}
+
+	if unsaved {
+		copy(best, group)
+	}
+
+	// a stopped run is resumed from the start of its last block, a finished
+	// one only returns its best packing
+	if m.checkpointing() {
+		if !m.Stopped {
+			state.Iteration, state.Temperature, state.Cost, state.BestCost = iterations, temp, cost, bestcost
+			reseed_block(&state, rng)
+			take_checkpoint(&state, beams, members, best)
+		}
+
+		m.save(&state, true)
+	}
+
+	groups := regroup(work, best, ngroups)
+	rank_groups(groups, dist)
+
+	if p.Tangent != nil {
+		restore_beams(groups, beams)
+	}
+
+	result.set_groups(groups)
+	keep_bunches(&result, p)
+
+	return &result
+}
+
+// Keep the IDs and processing nodes of the bunches of the original packing
+// in the refined one, whose bunches are in the same order.
+func keep_bunches(result *Packing, p *Packing) {
+	if len(result.Bunches) != len(p.Bunches) {
+		return
+	}
+
+	for i := range result.Bunches {
+		result.Bunches[i].ID = p.Bunches[i].ID
+		result.Bunches[i].Node = p.Bunches[i].Node
+	}
+}
@@ -0,0 +1,311 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// The speed of light in m/s.
+const speed_of_light = 299792458.0
+
+// The WGS84 ellipsoid: the equatorial radius in metres and the flattening.
+const (
+	wgs84_radius     = 6378137.0
+	wgs84_flattening = 1 / 298.257223563
+)
+
+// Antenna is an antenna of the array with its offset from the array
+// reference in metres, east, north and up.
+type Antenna struct {
+	Name  string
+	East  float64
+	North float64
+	Up    float64
+}
+
+// GeodeticToITRF converts the geodetic position of a site to geocentric
+// ITRF coordinates in metres on the WGS84 ellipsoid.
+func GeodeticToITRF(site Site) (float64, float64, float64) {
+	const deg = math.Pi / 180.0
+
+	sinlat, coslat := math.Sincos(site.Latitude * deg)
+	sinlon, coslon := math.Sincos(site.Longitude * deg)
+
+	e2 := wgs84_flattening * (2 - wgs84_flattening)
+	n := wgs84_radius / math.Sqrt(1-e2*sinlat*sinlat)
+
+	x := (n + site.Altitude) * coslat * coslon
+	y := (n + site.Altitude) * coslat * sinlon
+	z := (n*(1-e2) + site.Altitude) * sinlat
+
+	return x, y, z
+}
+
+// ITRFToENU converts a geocentric ITRF position in metres to the east,
+// north and up offsets from the site.
+func ITRFToENU(x, y, z float64, site Site) (float64, float64, float64) {
+	const deg = math.Pi / 180.0
+
+	x0, y0, z0 := GeodeticToITRF(site)
+	dx, dy, dz := x-x0, y-y0, z-z0
+
+	sinlat, coslat := math.Sincos(site.Latitude * deg)
+	sinlon, coslon := math.Sincos(site.Longitude * deg)
+
+	east := -sinlon*dx + coslon*dy
+	north := -sinlat*coslon*dx - sinlat*sinlon*dy + coslat*dz
+	up := coslat*coslon*dx + coslat*sinlon*dy + sinlat*dz
+
+	return east, north, up
+}
+
+// The largest offset of an antenna from the array reference in metres.
+// Larger positions are geocentric ITRF coordinates.
+const max_antenna_offset = 1e6
+
+// The number of comma-separated fields of a katpoint antenna description
+// without delay model: name, latitude, longitude, altitude and diameter.
+// The plain antenna positions have at most four fields.
+const katpoint_antenna_fields = 5
+
+// Parse the position of an antenna from a katpoint antenna description:
+// the east, north and up offsets of its delay model, or its geodetic
+// position, in sexagesimal or decimal degrees, converted to offsets from
+// the site if it has none.
+func parse_katpoint_antenna(fields []string, site Site) (float64, float64, float64, error) {
+	if len(fields) > katpoint_antenna_fields && fields[katpoint_antenna_fields] != "" {
+		return parse_antenna_position(strings.Fields(fields[katpoint_antenna_fields]), site)
+	}
+
+	var pos Site
+	var err error
+
+	if pos.Latitude, err = parse_angle(fields[1], false); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid latitude: %s", fields[1])
+	}
+
+	if pos.Longitude, err = parse_angle(fields[2], false); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid longitude: %s", fields[2])
+	}
+
+	if pos.Altitude, err = strconv.ParseFloat(fields[3], 64); err != nil {
+		return 0, 0, 0, fmt.Errorf("invalid altitude: %s", fields[3])
+	}
+
+	x, y, z := GeodeticToITRF(pos)
+
+	e, n, u := ITRFToENU(x, y, z, site)
+	return e, n, u, nil
+}
+
+// Parse the position of an antenna from fields: either its east, north and
+// optionally up offsets, or its geocentric ITRF coordinates, which are
+// recognised by their distance from the centre of the Earth and converted
+// to offsets from the site.
+func parse_antenna_position(fields []string, site Site) (float64, float64, float64, error) {
+	if len(fields) < 2 {
+		return 0, 0, 0, fmt.Errorf("expected the east and north offsets or the ITRF coordinates")
+	}
+
+	var pos [3]float64
+
+	for i := 0; i < min(len(fields), 3); i++ {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return 0, 0, 0, fmt.Errorf("invalid position: %s", fields[i])
+		}
+
+		pos[i] = v
+	}
+
+	// no antenna is more than 1000 km from the array reference, while ITRF
+	// positions are more than 6000 km from the centre of the Earth
+	if math.Sqrt(pos[0]*pos[0]+pos[1]*pos[1]+pos[2]*pos[2]) > max_antenna_offset {
+		if len(fields) < 3 {
+			return 0, 0, 0, fmt.Errorf("expected the ITRF x, y and z coordinates")
+		}
+
+		e, n, u := ITRFToENU(pos[0], pos[1], pos[2], site)
+		return e, n, u, nil
+	}
+
+	return pos[0], pos[1], pos[2], nil
+}
+
+// LoadAntennas reads the antenna positions from file. Every line holds
+// either a katpoint antenna description, whose delay model starts with the
+// east, north and up offsets, e.g. "m000, -30:42:39.8, 21:26:38.0, 1035.0,
+// 13.5, -8.258 -207.29 8.5965, ...", or the antenna name followed by its
+// east, north and optionally up offsets in metres, or by its geocentric
+// ITRF x, y and z coordinates in metres, separated by whitespace or
+// commas. Katpoint descriptions are recognised by their at least five
+// comma-separated fields, and those without delay model are placed at
+// their geodetic position. The ITRF and geodetic positions are converted to
+// offsets from the site. Empty lines and lines starting with # are ignored.
+func LoadAntennas(filename string, site Site) ([]Antenna, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	var ants []Antenna
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		var fields []string
+
+		if strings.Contains(line, ",") {
+			fields = strings.Split(line, ",")
+			for i := range fields {
+				fields[i] = strings.TrimSpace(fields[i])
+			}
+		} else {
+			fields = strings.Fields(line)
+		}
+
+		ant := Antenna{Name: fields[0]}
+
+		if strings.Contains(line, ",") && len(fields) >= katpoint_antenna_fields {
+			ant.East, ant.North, ant.Up, err = parse_katpoint_antenna(fields, site)
+		} else {
+			ant.East, ant.North, ant.Up, err = parse_antenna_position(fields[1:], site)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, nr, err)
+		}
+
+		ants = append(ants, ant)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read antennas: %s", err)
+	}
+
+	return ants, nil
+}
+
+// SelectAntennas returns the antennas with the names, in the order of the
+// antenna list.
+func SelectAntennas(ants []Antenna, names []string) ([]Antenna, error) {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = true
+	}
+
+	var selected []Antenna
+
+	for _, ant := range ants {
+		if wanted[ant.Name] {
+			selected = append(selected, ant)
+			delete(wanted, ant.Name)
+		}
+	}
+
+	for _, name := range names {
+		if wanted[name] {
+			return nil, fmt.Errorf("Unknown antenna: %s", name)
+		}
+	}
+
+	return selected, nil
+}
+
+// Baseline is the offset between two antennas in metres, east, north and
+// up, from the first to the second antenna.
+type Baseline struct {
+	A      string
+	B      string
+	East   float64
+	North  float64
+	Up     float64
+	Length float64
+}
+
+// Baselines returns all baselines between the antennas, in the order of
+// the antennas.
+func Baselines(ants []Antenna) []Baseline {
+	var baselines []Baseline
+
+	for i, a := range ants {
+		for _, b := range ants[i+1:] {
+			e, n, u := b.East-a.East, b.North-a.North, b.Up-a.Up
+
+			baselines = append(baselines, Baseline{
+				A:      a.Name,
+				B:      b.Name,
+				East:   e,
+				North:  n,
+				Up:     u,
+				Length: math.Sqrt(e*e + n*n + u*u),
+			})
+		}
+	}
+
+	return baselines
+}
+
+// ArrayExtent summarizes the layout of the antennas: the numbers of
+// antennas and baselines, the shortest, median and longest baseline and
+// the largest offset of an antenna from their centroid in metres.
+type ArrayExtent struct {
+	NAntennas  int
+	NBaselines int
+	Shortest   float64
+	Median     float64
+	Longest    float64
+	Radius     float64
+}
+
+// GetArrayExtent computes the extent of the array of antennas.
+func GetArrayExtent(ants []Antenna) ArrayExtent {
+	e := ArrayExtent{NAntennas: len(ants)}
+
+	var lengths []float64
+	for _, b := range Baselines(ants) {
+		lengths = append(lengths, b.Length)
+	}
+
+	e.NBaselines = len(lengths)
+
+	if len(lengths) > 0 {
+		sort.Float64s(lengths)
+
+		e.Shortest = lengths[0]
+		e.Median = Percentile(lengths, 50)
+		e.Longest = lengths[len(lengths)-1]
+	}
+
+	var ce, cn, cu float64
+	for _, ant := range ants {
+		ce += ant.East
+		cn += ant.North
+		cu += ant.Up
+	}
+
+	if n := float64(len(ants)); n > 0 {
+		ce, cn, cu = ce/n, cn/n, cu/n
+	}
+
+	for _, ant := range ants {
+		de, dn, du := ant.East-ce, ant.North-cn, ant.Up-cu
+		e.Radius = math.Max(e.Radius, math.Sqrt(de*de+dn*dn+du*du))
+	}
+
+	return e
+}
@@ -0,0 +1,102 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestLoadAntennas(t *testing.T) {
+	x, y, z := GeodeticToITRF(Site{Latitude: MeerKAT.Latitude + 0.01, Longitude: MeerKAT.Longitude, Altitude: MeerKAT.Altitude})
+
+	lines := []string{
+		"# katpoint antenna descriptions with delay models",
+		"m000, -30:42:39.8, 21:26:38.0, 1035.0, 13.5, -8.258 -207.29 8.5965, , 1.22",
+		"m001, -30.7110565, 21.4438888, 1035.0, 13.5, 1.1263 -171.762 8.4965",
+		"",
+		"# katpoint antenna descriptions without delay model",
+		"m002, -30.7010565, 21.4438888, 1035.0, 13.5",
+		"m003, -30:42:39.8, 21:26:38.0, 1045.0, 13.5, , ",
+		"# plain offsets and ITRF positions",
+		"m004 100.5 -20 1.5",
+		"m005, 30, 40",
+		fmt.Sprintf("m006 %.4f %.4f %.4f", x, y, z),
+	}
+
+	filename := filepath.Join(t.TempDir(), "antennas.txt")
+
+	content := ""
+	for _, line := range lines {
+		content += line + "\n"
+	}
+
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	ants, err := LoadAntennas(filename, MeerKAT)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 0.01 deg of latitude is about 1108 m
+	want := []Antenna{
+		{"m000", -8.258, -207.29, 8.5965},
+		{"m001", 1.1263, -171.762, 8.4965},
+		{"m002", 0, 1108.6, 0},
+		{"m003", 0, 0, 10},
+		{"m004", 100.5, -20, 1.5},
+		{"m005", 30, 40, 0},
+		{"m006", 0, 1108.6, 0},
+	}
+
+	if len(ants) != len(want) {
+		t.Fatalf("%d antennas, want %d", len(ants), len(want))
+	}
+
+	for i, w := range want {
+		a := ants[i]
+
+		// the geodetic positions are on the curved surface of the Earth
+		if a.Name != w.Name || math.Abs(a.East-w.East) > 0.01 || math.Abs(a.North-w.North) > 1 || math.Abs(a.Up-w.Up) > 0.2 {
+			t.Errorf("wrong antenna: %+v, want %+v", a, w)
+		}
+	}
+}
+
+func TestLoadAntennasInvalid(t *testing.T) {
+	cases := map[string]string{
+		"latitude": "m000, -30:xx:39.8, 21:26:38.0, 1035.0, 13.5\n",
+		"offsets":  "m000 1\n",
+		"itrf":     "m000 5109224.0 2006790.0\n",
+		"value":    "m000 1 north\n",
+	}
+
+	for name, content := range cases {
+		filename := filepath.Join(t.TempDir(), "antennas.txt")
+		if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := LoadAntennas(filename, MeerKAT); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestITRFRoundTrip(t *testing.T) {
+	// the katpoint array reference of MeerKAT, at its ellipsoidal height
+	site := Site{Latitude: -(30 + 42/60.0 + 39.8/3600), Longitude: 21 + 26/60.0 + 38.0/3600, Altitude: 1086.6}
+
+	x, y, z := GeodeticToITRF(site)
+
+	if math.Abs(x-5109360.1) > 1 || math.Abs(y-2006852.6) > 1 || math.Abs(z+3238948.1) > 1 {
+		t.Errorf("wrong ITRF position: %f, %f, %f", x, y, z)
+	}
+
+	if e, n, u := ITRFToENU(x, y, z, site); math.Abs(e) > 1e-6 || math.Abs(n) > 1e-6 || math.Abs(u) > 1e-6 {
+		t.Errorf("wrong offsets of the reference: %g, %g, %g", e, n, u)
+	}
+}
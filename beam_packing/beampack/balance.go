@@ -0,0 +1,251 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadRates sets the expected candidate rates of the beams from file, e.g.
+// the per-beam rates of a previous observation of the field. Every line
+// holds a beam, given by name or by number, and its rate, separated by a
+// comma or whitespace. Empty lines and lines starting with # are ignored.
+// The beams that are not listed keep their rates.
+func LoadRates(filename string, beams []Beam) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected a beam and its rate", filename, nr)
+		}
+
+		i, ok := lookup(fields[0])
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown beam: %s", filename, nr, fields[0])
+		}
+
+		rate, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || rate < 0 {
+			return fmt.Errorf("%s:%d: invalid rate: %s", filename, nr, fields[1])
+		}
+
+		beams[i].Rate = rate
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Could not read rates: %s", err)
+	}
+
+	return nil
+}
+
+// NodeLoad is the expected candidate load of a processing node, or of a
+// bunch in packings that are not assigned to nodes.
+type NodeLoad struct {
+	Node    string
+	Bunches int
+	Beams   int
+	// The sum of the candidate rates of the beams.
+	Load float64
+}
+
+// LoadBalance summarises the node loads of a packing.
+type LoadBalance struct {
+	Loads []NodeLoad
+	Mean  float64
+	Max   float64
+	// The coefficient of variation of the loads, i.e. their standard
+	// deviation relative to the mean, which is zero for a perfectly
+	// balanced packing.
+	CV float64
+}
+
+// Get the load unit of every bunch, which is its node, or the bunch itself
+// if it is not assigned to one, and the names of the units.
+func get_load_units(p *Packing) ([]int, []string) {
+	units := make([]int, len(p.Bunches))
+	var names []string
+	index := make(map[string]int)
+
+	for i, b := range p.Bunches {
+		name := b.Node
+		if name == "" {
+			name = fmt.Sprintf("bunch %d", b.ID)
+		}
+
+		u, ok := index[name]
+		if !ok {
+			u = len(names)
+			index[name] = u
+			names = append(names, name)
+		}
+
+		units[i] = u
+	}
+
+	return units, names
+}
+
+// Compute the coefficient of variation from the sum of squared deviations
+// of the loads.
+func get_cv(squares float64, n int, mean float64) float64 {
+	if n == 0 || mean <= 0 {
+		return 0
+	}
+
+	return math.Sqrt(math.Max(squares, 0)/float64(n)) / mean
+}
+
+// GetLoadBalance computes the expected candidate load of every processing
+// node, or of every bunch if the packing is not assigned to nodes, as the
+// sum of the candidate rates of its beams.
+func GetLoadBalance(p *Packing) LoadBalance {
+	units, names := get_load_units(p)
+
+	lb := LoadBalance{Loads: make([]NodeLoad, len(names))}
+	for u, name := range names {
+		lb.Loads[u].Node = name
+	}
+
+	for i, b := range p.Bunches {
+		l := &lb.Loads[units[i]]
+		l.Bunches++
+		l.Beams += len(b.Beams)
+
+		for _, beam := range b.Beams {
+			l.Load += beam.rate()
+		}
+	}
+
+	if len(names) == 0 {
+		return lb
+	}
+
+	for _, l := range lb.Loads {
+		lb.Mean += l.Load
+		lb.Max = math.Max(lb.Max, l.Load)
+	}
+
+	lb.Mean /= float64(len(names))
+
+	var squares float64
+	for _, l := range lb.Loads {
+		squares += (l.Load - lb.Mean) * (l.Load - lb.Mean)
+	}
+
+	lb.CV = get_cv(squares, len(names), lb.Mean)
+
+	return lb
+}
+
+// WriteLoadBalance writes the node loads and their summary to w.
+func WriteLoadBalance(w io.Writer, lb LoadBalance) error {
+	fmt.Fprintf(w, "# %-16s %7s %6s %12s\n", "node", "bunches", "beams", "load")
+
+	for _, l := range lb.Loads {
+		fmt.Fprintf(w, "  %-16s %7d %6d %12.4f\n", l.Node, l.Bunches, l.Beams, l.Load)
+	}
+
+	ratio := 0.0
+	if lb.Mean > 0 {
+		ratio = lb.Max / lb.Mean
+	}
+
+	_, err := fmt.Fprintf(w, "\nMean load: %.4f, maximum load: %.4f (%.3f times the mean), variation: %.4f\n", lb.Mean, lb.Max, ratio, lb.CV)
+
+	return err
+}
+
+// ParetoPoint is a packing optimized for a trade-off between compactness
+// and load balance.
+type ParetoPoint struct {
+	// The weight of the load balance in the objective.
+	Balance float64
+	Cost    float64
+	MaxSep  float64
+	// The coefficient of variation and the maximum relative to the mean of
+	// the node loads.
+	CV       float64
+	MaxRatio float64
+	// Whether no other point has both a lower cost and a lower variation.
+	Optimal bool
+}
+
+// ParetoFront refines the packing with simulated annealing once for every
+// load balance weight, starting from the same packing with the same seed,
+// and reports the compactness and the load balance of the results. The
+// points that are not dominated by another point form the Pareto front.
+func ParetoFront(p *Packing, weights []float64, iterations int, dist DistanceFunc, seed int64) []ParetoPoint {
+	points := make([]ParetoPoint, len(weights))
+
+	for i, balance := range weights {
+		q := AnnealBalanced(p, iterations, balance, dist, rand.New(rand.NewSource(seed)), nil)
+		lb := GetLoadBalance(q)
+
+		points[i] = ParetoPoint{
+			Balance: balance,
+			Cost:    Cost(q, dist),
+			MaxSep:  Score(q, dist).MaxSep,
+			CV:      lb.CV,
+		}
+
+		if lb.Mean > 0 {
+			points[i].MaxRatio = lb.Max / lb.Mean
+		}
+	}
+
+	for i := range points {
+		points[i].Optimal = true
+
+		for j := range points {
+			a, b := points[j], points[i]
+
+			if a.Cost <= b.Cost && a.CV <= b.CV && (a.Cost < b.Cost || a.CV < b.CV) {
+				points[i].Optimal = false
+				break
+			}
+		}
+	}
+
+	return points
+}
+
+// WriteParetoFront writes the trade-off between compactness and load
+// balance to w, one line per load balance weight.
+func WriteParetoFront(w io.Writer, points []ParetoPoint) error {
+	fmt.Fprintf(w, "# %8s %12s %12s %10s %10s %7s\n", "balance", "cost", "max_sep", "variation", "max_ratio", "optimal")
+
+	for _, pt := range points {
+		_, err := fmt.Fprintf(w, "  %8.4g %12.6f %12.6f %10.4f %10.4f %7t\n", pt.Balance, pt.Cost, pt.MaxSep, pt.CV, pt.MaxRatio, pt.Optimal)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
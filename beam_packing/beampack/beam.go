@@ -0,0 +1,940 @@
+// Package beampack determines the assignment of coherent beams to compute
+// nodes and IP multicast groups on the MeerTRAP cluster. It groups
+// neighbouring beams on the sky into bunches, so that multi-beam filtering,
+// clustering and sifting of single-pulse candidates can be done locally on
+// each compute node.
+package beampack
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Beam is a single coherent beam and its position on the sky.
+type Beam struct {
+	Nr   int
+	Name string
+	X    float64
+	Y    float64
+	// Beam semi-major and semi-minor axes at half power and position angle
+	// of the major axis in degrees, if known.
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+	// The incoherent beam has no meaningful sky position.
+	Incoherent bool
+	// The pointing the beam belongs to in mosaic packings.
+	Pointing string
+	// Priority weight of the beam in the packing cost. Zero means the
+	// default weight of one.
+	Weight float64
+	// Expected candidate rate of the beam, which is its contribution to the
+	// load of its processing node. Zero means the default rate of one.
+	Rate float64
+	// Relative sensitivity of the beam from the primary beam response at
+	// its position. Zero means unknown.
+	Sensitivity float64
+	// Dummy beams are placeholders that pad bunches to the full size.
+	Dummy bool
+}
+
+// Get the priority weight of the beam.
+func (b Beam) weight() float64 {
+	if b.Weight == 0 {
+		return 1
+	}
+
+	return b.Weight
+}
+
+// Get the expected candidate rate of the beam. Dummy beams produce no
+// candidates.
+func (b Beam) rate() float64 {
+	switch {
+	case b.Dummy:
+		return 0
+	case b.Rate == 0:
+		return 1
+	}
+
+	return b.Rate
+}
+
+// Get the key that identifies a beam. The names of the beams in mosaic
+// packings are qualified by their pointing.
+func get_beam_key(pointing, name string) string {
+	if pointing == "" {
+		return name
+	}
+
+	return pointing + "/" + name
+}
+
+func (b Beam) key() string {
+	return get_beam_key(b.Pointing, b.Name)
+}
+
+// Get a function that looks up beams given by name, qualified name or
+// number and returns their index.
+func get_beam_lookup(beams []Beam) func(field string) (int, bool) {
+	bynr := make(map[int]int)
+	byname := make(map[string]int)
+
+	for i, beam := range beams {
+		bynr[beam.Nr] = i
+		byname[beam.Name] = i
+		byname[beam.key()] = i
+	}
+
+	return func(field string) (int, bool) {
+		if i, ok := byname[field]; ok {
+			return i, true
+		}
+
+		n, err := strconv.Atoi(field)
+		if err != nil {
+			return 0, false
+		}
+
+		i, ok := bynr[n]
+
+		return i, ok
+	}
+}
+
+// Stdin is the file name that refers to the standard input.
+const Stdin = "-"
+
+// IncoherentPrefix is the name prefix of FBFUSE incoherent beams.
+const IncoherentPrefix = "ifbf"
+
+// DummyPrefix is the name prefix of dummy placeholder beams.
+const DummyPrefix = "dummy"
+
+// BeamName returns the FBFUSE coherent beam name for the beam number.
+func BeamName(nr int) string {
+	return fmt.Sprintf("cfbf%05d", nr)
+}
+
+// LoadOptions configure how beam position files are parsed.
+type LoadOptions struct {
+	// Field delimiter. It is detected from the data if zero. A space
+	// splits on any run of whitespace.
+	Delimiter rune
+	// Header row handling: auto, none, skip or parse. In auto mode, a
+	// first row that does not contain at least two numbers is parsed as
+	// header.
+	Header string
+	// Skip malformed rows with a warning instead of failing.
+	Lenient bool
+	// Input format: auto, dat, fbfuse or fits. In auto mode, files ending
+	// in .json are read as FBFUSE beam configuration and files ending in
+	// .fits or .fit as FITS binary table.
+	Format string
+	// HDU of a FITS file that holds the beam table, given by number (0 is
+	// the primary HDU) or extension name. Empty means the first binary
+	// table.
+	HDU string
+	// Units of the beam position table: deg (default), arcmin, arcsec, rad
+	// or auto to detect them from the beam spacing. The positions are
+	// converted to degrees. FBFUSE beam configurations are always in
+	// degrees.
+	Units string
+	// Coordinate notation of the beam position table: auto, decimal or
+	// sexagesimal. Sexagesimal coordinates are RA in hours and Dec in
+	// degrees (hh:mm:ss.s dd:mm:ss.s), which are converted to decimal
+	// degrees. In auto mode, values that contain a colon or h/d/m/s
+	// separators are parsed as sexagesimal.
+	Coordinates string
+	// Columns of the x and y coordinates and the beam names, given by
+	// number starting at 1 or by header name. Empty means detected
+	// automatically. With an explicit column in a file without header row,
+	// the beam shapes are not detected from the data rows.
+	XCol    string
+	YCol    string
+	NameCol string
+	// If given, the numbers of parsed and skipped rows are collected.
+	Stats *LoadStats
+	// The download settings of inputs given as URL.
+	HTTP HTTPOptions
+}
+
+// LoadStats are the numbers of rows of a beam position file that were
+// parsed and that were skipped as malformed in lenient mode, with the
+// reasons. The rows of FBFUSE beam configurations and FITS tables are the
+// beams.
+type LoadStats struct {
+	Rows    int
+	Skipped int
+	Reasons []string
+}
+
+// BeamFormats lists the formats that beam files can be written in.
+var BeamFormats = []string{"dat", "fbfuse"}
+
+// Notations lists the available coordinate notations.
+var Notations = []string{"auto", "decimal", "sexagesimal"}
+
+// ParseError reports a malformed row in a beam position file.
+type ParseError struct {
+	File  string
+	Line  int
+	Field int
+	Value string
+	Err   error
+}
+
+func (e *ParseError) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s:%d: could not parse field %d: %q, %s", e.File, e.Line, e.Field+1, e.Value, e.Err)
+	}
+
+	return fmt.Sprintf("%s:%d: missing field %d", e.File, e.Line, e.Field+1)
+}
+
+func (e *ParseError) Unwrap() error {
+	return e.Err
+}
+
+// Load the beam positions from file, detecting the delimiter and header row
+// automatically. The beams are numbered in the order in which they appear in
+// the file. A non-numeric column holds the beam names. If there is none, the
+// names are derived from the beam numbers.
+func Load(filename string) ([]Beam, error) {
+	return LoadWith(filename, LoadOptions{})
+}
+
+// LoadWith loads the beam positions from file using the given options. The
+// file name "-" reads the beam positions from stdin, and an HTTP or HTTPS
+// URL downloads them.
+func LoadWith(filename string, opts LoadOptions) ([]Beam, error) {
+	if filename == Stdin {
+		return load_stdin(opts)
+	}
+
+	if IsURL(filename) {
+		return load_url(filename, opts)
+	}
+
+	format := opts.Format
+	if format == "" || format == "auto" {
+		format = InputFormat(filename)
+	}
+
+	var beams []Beam
+	var err error
+
+	switch format {
+	case "dat":
+		beams, err = load_data(filename, opts)
+	case "fbfuse":
+		beams, err = LoadFBFUSE(filename)
+	case "fits":
+		var raw []byte
+		if raw, err = read_input(filename); err != nil {
+			return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+		}
+
+		beams, err = parse_fits(raw, filename, opts)
+	default:
+		return nil, fmt.Errorf("Unknown input format: %s", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	set_defaults(beams)
+
+	if opts.Stats != nil && format != "dat" {
+		opts.Stats.Rows = len(beams)
+	}
+
+	return beams, nil
+}
+
+// InputFormat returns the input format of a beam file in auto mode from its
+// name: fbfuse for files ending in .json, fits for files ending in .fits,
+// .fit or .fts and dat otherwise, also if compressed.
+func InputFormat(filename string) string {
+	switch strings.ToLower(filepath.Ext(strip_compression(filename))) {
+	case ".json":
+		return "fbfuse"
+	case ".fits", ".fit", ".fts":
+		return "fits"
+	}
+
+	return "dat"
+}
+
+// The largest number of bytes at the start of an input used to detect its
+// format.
+const sniff_size = 4096
+
+// Detect the format of the input that starts with the data: fbfuse for a
+// JSON object or list, fits for the FITS signature and dat otherwise.
+func detect_format(head []byte) string {
+	text := bytes.TrimLeft(head, " \t\r\n")
+
+	switch {
+	case bytes.HasPrefix(text, []byte("{")) || bytes.HasPrefix(text, []byte("[")):
+		return "fbfuse"
+	case bytes.HasPrefix(head, []byte(FITSSignature)):
+		return "fits"
+	}
+
+	return "dat"
+}
+
+// Detect the format of a streamed input from its first line with content,
+// without waiting for more of the input than that. Only the data already
+// buffered or one more read of the input is peeked at a time.
+func sniff_format(br *bufio.Reader) string {
+	n := 1
+
+	for {
+		head, err := br.Peek(n)
+
+		text := bytes.TrimLeft(head, " \t\r\n")
+		complete := bytes.IndexByte(text, '\n') >= 0 || bytes.HasPrefix(head, []byte(FITSSignature))
+
+		if err != nil || complete || n >= sniff_size {
+			return detect_format(head)
+		}
+
+		n = min(max(n+1, br.Buffered()), sniff_size)
+	}
+}
+
+// Read the beam positions from stdin. Beam position tables are parsed while
+// they are read, so that large inputs are not held in memory as a whole.
+func load_stdin(opts LoadOptions) ([]Beam, error) {
+	r, err := decompress(os.Stdin)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read data from stdin: %s", err)
+	}
+
+	br := bufio.NewReaderSize(r, sniff_size)
+
+	format := opts.Format
+	if format == "" || format == "auto" {
+		format = sniff_format(br)
+	}
+
+	var beams []Beam
+	var raw []byte
+
+	if format == "dat" {
+		beams, err = read_data(br, "stdin", opts)
+	} else {
+		raw, err = io.ReadAll(br)
+	}
+
+	if cerr := r.Close(); err == nil && cerr != nil {
+		err = fmt.Errorf("Could not read data from stdin: %s", cerr)
+	}
+
+	if err != nil {
+		if format != "dat" {
+			err = fmt.Errorf("Could not read data from stdin: %s", err)
+		}
+
+		return nil, err
+	}
+
+	if format != "dat" {
+		opts.Format = format
+		return Parse(raw, "stdin", opts)
+	}
+
+	set_defaults(beams)
+
+	return beams, nil
+}
+
+// Parse the beam positions from raw data, e.g. read from stdin or received
+// in a message. In auto mode, data that starts with a JSON object or list
+// is parsed as FBFUSE beam configuration and data that starts with the FITS
+// signature as FITS binary table. The name is only used in error messages.
+func Parse(raw []byte, name string, opts LoadOptions) ([]Beam, error) {
+	format := opts.Format
+	if format == "" || format == "auto" {
+		format = detect_format(raw)
+	}
+
+	var beams []Beam
+	var err error
+
+	switch format {
+	case "dat":
+		beams, err = read_data(bytes.NewReader(raw), name, opts)
+	case "fbfuse":
+		beams, err = parse_fbfuse(raw)
+		if err != nil {
+			err = fmt.Errorf("Could not parse FBFUSE beam configuration: %s, %s", name, err)
+		}
+	case "fits":
+		beams, err = parse_fits(raw, name, opts)
+	default:
+		return nil, fmt.Errorf("Unknown input format: %s", format)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	set_defaults(beams)
+
+	if opts.Stats != nil && format != "dat" {
+		opts.Stats.Rows = len(beams)
+	}
+
+	return beams, nil
+}
+
+// Default the beam names and flag the incoherent beams by name.
+func set_defaults(beams []Beam) {
+	for i := range beams {
+		if beams[i].Name == "" {
+			beams[i].Name = BeamName(beams[i].Nr)
+		}
+
+		if strings.HasPrefix(beams[i].Name, IncoherentPrefix) {
+			beams[i].Incoherent = true
+		}
+	}
+}
+
+// MarkIncoherent flags the beams with the given names as incoherent beams.
+func MarkIncoherent(beams []Beam, names []string) {
+	for i := range beams {
+		for _, name := range names {
+			if beams[i].Name == name {
+				beams[i].Incoherent = true
+			}
+		}
+	}
+}
+
+// ParseDelimiter converts a delimiter name (auto, tab, comma, space,
+// semicolon or a single character) to its rune. Auto yields zero.
+func ParseDelimiter(name string) (rune, error) {
+	switch name {
+	case "", "auto":
+		return 0, nil
+	case "tab", "\\t":
+		return '\t', nil
+	case "comma":
+		return ',', nil
+	case "space", "whitespace":
+		return ' ', nil
+	case "semicolon":
+		return ';', nil
+	}
+
+	r := []rune(name)
+	if len(r) != 1 {
+		return 0, fmt.Errorf("Invalid delimiter: %s", name)
+	}
+
+	return r[0], nil
+}
+
+// Guess the field delimiter from a line of data.
+func detect_delimiter(line string) rune {
+	for _, r := range []rune{'\t', ',', ';'} {
+		if strings.ContainsRune(line, r) {
+			return r
+		}
+	}
+
+	return ' '
+}
+
+// Split a line into its fields.
+func split_line(line string, delimiter rune) []string {
+	if delimiter == ' ' {
+		return strings.Fields(line)
+	}
+
+	fields := strings.Split(line, string(delimiter))
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	return fields
+}
+
+// The columns that hold the beam data. A negative name column means that
+// the file has no beam names, and negative shape columns that the file has
+// no beam shapes.
+type layout struct {
+	xcol    int
+	ycol    int
+	namecol int
+	acol    int
+	bcol    int
+	pacol   int
+	wcol    int
+}
+
+// Check whether the field is a number.
+func is_number(field string) bool {
+	_, err := strconv.ParseFloat(field, 64)
+	return err == nil
+}
+
+// Convert the h/d/m/s separators of a sexagesimal value to colons, e.g.
+// 08h56m16.7s to 08:56:16.7.
+func normalize_sexagesimal(field string) string {
+	r := strings.NewReplacer("h", ":", "d", ":", "m", ":", "'", ":", "s", "", "\"", "")
+	return strings.TrimSuffix(r.Replace(field), ":")
+}
+
+// Check whether the field is a sexagesimal value.
+func is_sexagesimal(field string) bool {
+	text := normalize_sexagesimal(field)
+	if !strings.Contains(text, ":") {
+		return false
+	}
+
+	_, err := parse_sexagesimal(text)
+	return err == nil
+}
+
+// Check whether the field is a decimal or sexagesimal value.
+func is_value(field string) bool {
+	return is_number(field) || is_sexagesimal(field)
+}
+
+// Check whether the row looks like a header, i.e. it does not contain at
+// least two values.
+func is_header(fields []string) bool {
+	var n int
+
+	for _, field := range fields {
+		if is_value(field) {
+			n++
+		}
+	}
+
+	return n < 2
+}
+
+// Determine the columns from a data row. The first two value fields hold
+// the coordinates and the first other field the beam name. If there are at
+// least five value fields, the third to fifth hold the beam semi-major and
+// semi-minor axes and the position angle.
+func get_row_layout(fields []string) layout {
+	l := layout{xcol: -1, ycol: -1, namecol: -1, acol: -1, bcol: -1, pacol: -1, wcol: -1}
+
+	var numeric []int
+
+	for i, field := range fields {
+		if is_value(field) {
+			numeric = append(numeric, i)
+		} else if l.namecol < 0 {
+			l.namecol = i
+		}
+	}
+
+	if len(numeric) < 2 {
+		l.xcol, l.ycol = 0, 1
+		return l
+	}
+
+	l.xcol, l.ycol = numeric[0], numeric[1]
+
+	if len(numeric) >= 5 {
+		l.acol, l.bcol, l.pacol = numeric[2], numeric[3], numeric[4]
+	}
+
+	return l
+}
+
+// Parse the field at the given column of a row.
+func parse_field(filename string, nr int, fields []string, col int) (float64, error) {
+	if col >= len(fields) {
+		return 0, &ParseError{File: filename, Line: nr, Field: col}
+	}
+
+	value, err := strconv.ParseFloat(fields[col], 64)
+	if err != nil {
+		return 0, &ParseError{File: filename, Line: nr, Field: col, Value: fields[col], Err: err}
+	}
+
+	return value, nil
+}
+
+// Parse the coordinate at the given column of a row in the coordinate
+// notation. Sexagesimal values are converted to degrees, where the x
+// coordinate is in hours. It is returned whether the value is sexagesimal.
+func parse_coordinate(filename string, nr int, fields []string, col int, hours bool, notation string) (float64, bool, error) {
+	if col >= len(fields) {
+		return 0, false, &ParseError{File: filename, Line: nr, Field: col}
+	}
+
+	field := fields[col]
+
+	switch notation {
+	case "", "auto":
+		if !is_sexagesimal(field) {
+			value, err := parse_field(filename, nr, fields, col)
+			return value, false, err
+		}
+	case "decimal":
+		value, err := parse_field(filename, nr, fields, col)
+		return value, false, err
+	case "sexagesimal":
+		if !is_sexagesimal(field) {
+			err := fmt.Errorf("Not a sexagesimal value")
+			return 0, false, &ParseError{File: filename, Line: nr, Field: col, Value: field, Err: err}
+		}
+	default:
+		return 0, false, fmt.Errorf("Unknown coordinate notation: %s", notation)
+	}
+
+	value, err := parse_angle(normalize_sexagesimal(field), hours)
+	if err != nil {
+		return 0, false, &ParseError{File: filename, Line: nr, Field: col, Value: field, Err: err}
+	}
+
+	return value, true, nil
+}
+
+// Override the detected columns with the explicitly selected ones. The
+// header fields are nil for files without header row.
+func select_columns(l *layout, opts LoadOptions, header []string) error {
+	selected := false
+
+	for _, sel := range []struct {
+		value string
+		col   *int
+	}{{opts.XCol, &l.xcol}, {opts.YCol, &l.ycol}, {opts.NameCol, &l.namecol}} {
+		if sel.value == "" {
+			continue
+		}
+
+		selected = true
+
+		if n, err := strconv.Atoi(sel.value); err == nil {
+			if n < 1 {
+				return fmt.Errorf("Invalid column number: %d", n)
+			}
+
+			*sel.col = n - 1
+			continue
+		}
+
+		if header == nil {
+			return fmt.Errorf("Column name without header row: %s", sel.value)
+		}
+
+		found := false
+		for i, field := range header {
+			if strings.EqualFold(field, sel.value) {
+				*sel.col = i
+				found = true
+				break
+			}
+		}
+
+		if !found {
+			return fmt.Errorf("Unknown column: %s", sel.value)
+		}
+	}
+
+	// the shape detected from the data rows may be in other columns
+	if selected && header == nil {
+		l.acol, l.bcol, l.pacol = -1, -1, -1
+	}
+
+	return nil
+}
+
+// Determine the columns from the header names.
+func get_header_layout(fields []string) layout {
+	l := layout{xcol: 0, ycol: 1, namecol: -1, acol: -1, bcol: -1, pacol: -1, wcol: -1}
+
+	for i, field := range fields {
+		switch strings.ToLower(field) {
+		case "x", "ra", "ra_deg", "xoff", "dx":
+			l.xcol = i
+		case "y", "dec", "dec_deg", "yoff", "dy":
+			l.ycol = i
+		case "name", "beam", "beam_name", "id":
+			l.namecol = i
+		case "a", "semimajor", "semi_major", "bmaj":
+			l.acol = i
+		case "b", "semiminor", "semi_minor", "bmin":
+			l.bcol = i
+		case "pa", "angle", "bpa":
+			l.pacol = i
+		case "weight", "priority", "w":
+			l.wcol = i
+		}
+	}
+
+	// the shape is only used if complete
+	if l.acol < 0 || l.bcol < 0 || l.pacol < 0 {
+		l.acol, l.bcol, l.pacol = -1, -1, -1
+	}
+
+	return l
+}
+
+func load_data(filename string, opts LoadOptions) ([]Beam, error) {
+	f, err := open_input(filename)
+
+	if err != nil {
+		error := fmt.Errorf("Could not open file: %s, %w", filename, err)
+		return nil, error
+	}
+
+	beams, err := read_data(f, filename, opts)
+
+	// decompression errors only show up on close
+	if cerr := f.Close(); err == nil && cerr != nil {
+		return nil, fmt.Errorf("Could not read file: %s, %s", filename, cerr)
+	}
+
+	return beams, err
+}
+
+// The largest number of beams preallocated from the size of an input.
+const max_prealloc = 1 << 22
+
+// A streaming parser of beam position tables, which parses the rows one at
+// a time as they are read.
+type beam_scanner struct {
+	scanner  *bufio.Scanner
+	filename string
+	opts     LoadOptions
+	header   string
+
+	delimiter rune
+	cols      *layout
+	first     bool
+
+	// the line number, the number of beams and the length of the first
+	// data row
+	nr    int
+	count int
+	width int
+
+	// whether any coordinates are sexagesimal
+	sexagesimal bool
+}
+
+func new_beam_scanner(r io.Reader, filename string, opts LoadOptions) (*beam_scanner, error) {
+	header := opts.Header
+	if header == "" {
+		header = "auto"
+	}
+
+	switch header {
+	case "auto", "none", "skip", "parse":
+	default:
+		return nil, fmt.Errorf("Invalid header mode: %s", header)
+	}
+
+	s := &beam_scanner{
+		scanner:   bufio.NewScanner(r),
+		filename:  filename,
+		opts:      opts,
+		header:    header,
+		delimiter: opts.Delimiter,
+		first:     true,
+	}
+
+	return s, nil
+}
+
+// Parse the next beam. It returns io.EOF at the end of the input. Malformed
+// rows are skipped with a warning in lenient mode.
+func (s *beam_scanner) next() (Beam, error) {
+	opts := s.opts
+	filename := s.filename
+
+	for s.scanner.Scan() {
+		s.nr++
+		nr := s.nr
+		line := strings.TrimSpace(s.scanner.Text())
+
+		// skip empty lines and comments
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if s.delimiter == 0 {
+			s.delimiter = detect_delimiter(line)
+		}
+
+		fields := split_line(line, s.delimiter)
+
+		if s.first {
+			s.first = false
+
+			if s.header == "skip" {
+				continue
+			}
+
+			if s.header == "parse" || (s.header == "auto" && is_header(fields)) {
+				l := get_header_layout(fields)
+				if err := select_columns(&l, opts, fields); err != nil {
+					return Beam{}, fmt.Errorf("%s: %s", filename, err)
+				}
+
+				s.cols = &l
+				continue
+			}
+		}
+
+		if s.cols == nil {
+			l := get_row_layout(fields)
+			if err := select_columns(&l, opts, nil); err != nil {
+				return Beam{}, fmt.Errorf("%s: %s", filename, err)
+			}
+
+			s.cols = &l
+		}
+
+		cols := s.cols
+
+		x, xsexa, err := parse_coordinate(filename, nr, fields, cols.xcol, true, opts.Coordinates)
+
+		var y float64
+		var ysexa bool
+
+		if err == nil {
+			y, ysexa, err = parse_coordinate(filename, nr, fields, cols.ycol, false, opts.Coordinates)
+		}
+
+		var a, b, pa float64
+
+		if err == nil && cols.acol >= 0 {
+			a, err = parse_field(filename, nr, fields, cols.acol)
+			if err == nil {
+				b, err = parse_field(filename, nr, fields, cols.bcol)
+			}
+			if err == nil {
+				pa, err = parse_field(filename, nr, fields, cols.pacol)
+			}
+		}
+
+		var w float64
+
+		if err == nil && cols.wcol >= 0 {
+			w, err = parse_field(filename, nr, fields, cols.wcol)
+			if err == nil && w < 0 {
+				err = &ParseError{File: filename, Line: nr, Field: cols.wcol, Value: fields[cols.wcol], Err: fmt.Errorf("negative weight")}
+			}
+		}
+
+		if err != nil {
+			if opts.Lenient {
+				slog.Warn("Skipping malformed row", "error", err)
+
+				if opts.Stats != nil {
+					opts.Stats.Skipped++
+					opts.Stats.Reasons = append(opts.Stats.Reasons, err.Error())
+				}
+
+				continue
+			}
+
+			return Beam{}, err
+		}
+
+		s.sexagesimal = s.sexagesimal || xsexa || ysexa
+
+		item := Beam{Nr: s.count, X: x, Y: y, SemiMajor: a, SemiMinor: b, PA: pa, Weight: w}
+
+		if opts.Stats != nil {
+			opts.Stats.Rows++
+		}
+
+		if cols.namecol >= 0 && cols.namecol < len(fields) {
+			item.Name = fields[cols.namecol]
+		}
+
+		if s.count == 0 {
+			s.width = len(line) + 1
+		}
+
+		s.count++
+
+		return item, nil
+	}
+
+	if err := s.scanner.Err(); err != nil {
+		return Beam{}, fmt.Errorf("Could not read data: %s", err)
+	}
+
+	return Beam{}, io.EOF
+}
+
+// Get the number of beams to preallocate for an input of the size in
+// bytes, from the length of its first data row, or zero if the size is
+// unknown.
+func get_prealloc(size int64, width int) int {
+	if size <= 0 || width <= 0 {
+		return 0
+	}
+
+	return int(min(size/int64(width)+1, max_prealloc))
+}
+
+// Get the size of an input, or zero if it is unknown. For compressed files,
+// it is the compressed size, which underestimates the number of rows.
+func get_input_size(r io.Reader) int64 {
+	if sized, ok := r.(interface{ Size() int64 }); ok {
+		return sized.Size()
+	}
+
+	return 0
+}
+
+// Parse the beam positions from a reader, one row at a time as they are
+// read. The file name is only used in error messages.
+func read_data(r io.Reader, filename string, opts LoadOptions) ([]Beam, error) {
+	s, err := new_beam_scanner(r, filename, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []Beam
+
+	for {
+		beam, err := s.next()
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		if data == nil {
+			data = make([]Beam, 0, get_prealloc(get_input_size(r), s.width))
+		}
+
+		data = append(data, beam)
+	}
+
+	// sexagesimal coordinates are already in degrees
+	if !s.sexagesimal {
+		if err := convert_units(data, opts.Units); err != nil {
+			return nil, err
+		}
+	}
+
+	return data, nil
+}
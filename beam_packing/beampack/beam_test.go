@@ -0,0 +1,207 @@
+package beampack
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func parse_test_beams(t *testing.T, text string, opts LoadOptions) []Beam {
+	t.Helper()
+
+	beams, err := Parse([]byte(text), "test.dat", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return beams
+}
+
+func TestParsePlain(t *testing.T) {
+	beams := parse_test_beams(t, "# comment\n\n1.5 2.5\n-1e-2\t3\n", LoadOptions{})
+
+	if len(beams) != 2 {
+		t.Fatalf("%d beams, want 2", len(beams))
+	}
+
+	if beams[0].X != 1.5 || beams[0].Y != 2.5 || beams[1].X != -0.01 || beams[1].Y != 3 {
+		t.Errorf("wrong positions: %+v", beams)
+	}
+
+	if beams[0].Name != "cfbf00000" || beams[1].Nr != 1 || beams[1].Name != "cfbf00001" {
+		t.Errorf("wrong names: %+v", beams)
+	}
+}
+
+func TestParseHeader(t *testing.T) {
+	text := "name,ra,dec,semimajor,semiminor,pa,weight\n" +
+		"cfbf00010,10.0,-30.0,0.01,0.005,45,2\n" +
+		"cfbf00011,10.1,-30.1,0.01,0.005,45,0.5\n"
+
+	beams := parse_test_beams(t, text, LoadOptions{})
+
+	if len(beams) != 2 {
+		t.Fatalf("%d beams, want 2", len(beams))
+	}
+
+	b := beams[1]
+	if b.Name != "cfbf00011" || b.X != 10.1 || b.Y != -30.1 || b.SemiMajor != 0.01 || b.SemiMinor != 0.005 || b.PA != 45 || b.Weight != 0.5 {
+		t.Errorf("wrong beam: %+v", b)
+	}
+}
+
+func TestParseSexagesimal(t *testing.T) {
+	beams := parse_test_beams(t, "08:56:16.7 -30:00:00\n", LoadOptions{})
+
+	if math.Abs(beams[0].X-134.0696) > 1e-3 || beams[0].Y != -30 {
+		t.Errorf("wrong position: %g, %g", beams[0].X, beams[0].Y)
+	}
+}
+
+func TestParseUnits(t *testing.T) {
+	beams := parse_test_beams(t, "60 -30\n", LoadOptions{Units: "arcmin"})
+
+	if beams[0].X != 1 || beams[0].Y != -0.5 {
+		t.Errorf("wrong position: %g, %g", beams[0].X, beams[0].Y)
+	}
+}
+
+func TestParseMalformed(t *testing.T) {
+	text := "1 2\n3 x\n5 6\n"
+
+	_, err := Parse([]byte(text), "test.dat", LoadOptions{})
+
+	var perr *ParseError
+	if !errors.As(err, &perr) || perr.Line != 2 || perr.Field != 1 {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	stats := &LoadStats{}
+
+	beams := parse_test_beams(t, text, LoadOptions{Lenient: true, Stats: stats})
+	if len(beams) != 2 || stats.Rows != 2 || stats.Skipped != 1 {
+		t.Errorf("wrong lenient parse: %d beams, %+v", len(beams), stats)
+	}
+}
+
+func TestDetectFormat(t *testing.T) {
+	cases := map[string]string{
+		"1 2\n":                  "dat",
+		"  \n[{\"ra\": 1}]":      "fbfuse",
+		"{\"beams\": []}":        "fbfuse",
+		FITSSignature + "     T": "fits",
+		"":                       "dat",
+	}
+
+	for text, want := range cases {
+		if got := detect_format([]byte(text)); got != want {
+			t.Errorf("%q: got %s, want %s", text, got, want)
+		}
+	}
+}
+
+// The format of a streamed input is detected from its first line with
+// content, without waiting for more of the input.
+func TestSniffFormat(t *testing.T) {
+	cases := []struct {
+		chunks []string
+		want   string
+	}{
+		{[]string{"# name ra dec\n"}, "dat"},
+		{[]string{"\n", "  \n", "1 2", "\n"}, "dat"},
+		{[]string{" ", "\n[", "{\"ra\": 1}]\n"}, "fbfuse"},
+		{[]string{"SIMPLE", FITSSignature[6:] + "     T"}, "fits"},
+	}
+
+	for _, c := range cases {
+		r, w := io.Pipe()
+
+		go func() {
+			for _, chunk := range c.chunks {
+				w.Write([]byte(chunk))
+			}
+		}()
+
+		done := make(chan string, 1)
+		go func() { done <- sniff_format(bufio.NewReaderSize(r, sniff_size)) }()
+
+		select {
+		case got := <-done:
+			if got != c.want {
+				t.Errorf("%q: got %s, want %s", c.chunks, got, c.want)
+			}
+		case <-time.After(5 * time.Second):
+			t.Errorf("%q: sniffing waits for more input", c.chunks)
+		}
+
+		// the remaining input is discarded and the sniffing released
+		w.Close()
+	}
+
+	if got := sniff_format(bufio.NewReader(strings.NewReader("3 4"))); got != "dat" {
+		t.Errorf("wrong format of an input without newline: %s", got)
+	}
+}
+
+func TestLoadInput(t *testing.T) {
+	beams, err := Load("../input/134.0696_0.0_beam_pos.dat")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(beams) != 407 || beams[0].X != 0 || beams[0].Y != 0 {
+		t.Errorf("wrong beams: %d", len(beams))
+	}
+}
+
+// The scanner parses the rows as they arrive, without waiting for the end
+// of the input.
+func TestBeamScannerIncremental(t *testing.T) {
+	r, w := io.Pipe()
+	defer w.Close()
+
+	s, err := new_beam_scanner(r, "pipe", LoadOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	go w.Write([]byte("1 2\n"))
+
+	beam, err := s.next()
+	if err != nil || beam.X != 1 || beam.Y != 2 {
+		t.Fatalf("wrong first beam: %+v, %v", beam, err)
+	}
+
+	go func() {
+		w.Write([]byte("3 4\n"))
+		w.Close()
+	}()
+
+	if beam, err = s.next(); err != nil || beam.Nr != 1 || beam.X != 3 {
+		t.Fatalf("wrong second beam: %+v, %v", beam, err)
+	}
+
+	if _, err = s.next(); err != io.EOF {
+		t.Fatalf("no end of input: %v", err)
+	}
+}
+
+func BenchmarkParse(b *testing.B) {
+	var raw []byte
+	for _, beam := range get_test_tiling(b, 1024) {
+		raw = append(raw, []byte(fmt.Sprintf("%.18e\t%.18e\n", beam.X, beam.Y))...)
+	}
+
+	b.SetBytes(int64(len(raw)))
+
+	for i := 0; i < b.N; i++ {
+		if _, err := Parse(raw, "bench.dat", LoadOptions{}); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
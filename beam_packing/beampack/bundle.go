@@ -0,0 +1,323 @@
+package beampack
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode"
+)
+
+// BundleGroupings lists the groupings of the candidate bundles: one bundle
+// per processing node or one per bunch.
+var BundleGroupings = []string{"node", "bunch"}
+
+// ManifestName is the name of the manifest of a bundle, the last entry of
+// its archive.
+const ManifestName = "MANIFEST.json"
+
+// BundleFile is a candidate file in a bundle.
+type BundleFile struct {
+	// The path of the file in the archive and on disk.
+	Path   string `json:"path"`
+	Source string `json:"-"`
+	Beam   string `json:"beam"`
+	Bunch  int    `json:"bunch"`
+	// The size and SHA-256 hash of the file, set when it is bundled.
+	Size   int64  `json:"size"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Bundle is the archive of the candidate files of the beams of a processing
+// node or a bunch.
+type Bundle struct {
+	Name    string       `json:"name"`
+	Node    string       `json:"node,omitempty"`
+	Bunches []int        `json:"bunches"`
+	Beams   []string     `json:"beams"`
+	Files   []BundleFile `json:"files"`
+	// The file name, size and SHA-256 hash of the archive, set when it is
+	// written.
+	Archive string `json:"archive,omitempty"`
+	Size    int64  `json:"size,omitempty"`
+	SHA256  string `json:"sha256,omitempty"`
+}
+
+// BundleManifest lists the candidate bundles of an observation and the
+// packing they follow. Unassigned are the candidate files that belong to
+// no beam of the packing.
+type BundleManifest struct {
+	Created    time.Time   `json:"created"`
+	Dir        string      `json:"dir"`
+	Method     string      `json:"method,omitempty"`
+	Seed       int64       `json:"seed,omitempty"`
+	Provenance *Provenance `json:"provenance,omitempty"`
+	Bundles    []Bundle    `json:"bundles"`
+	Unassigned []string    `json:"unassigned"`
+}
+
+// Get the beam a candidate file belongs to from its path. A path belongs to
+// a beam if one of its components, or one of their alphanumeric parts,
+// e.g. cfbf00012 in cfbf00012_2024-05-01.spccl, is the name of the beam.
+// It returns -1 for paths of no beam and an error for paths of several.
+func get_file_beam(rel string, lookup map[string]int) (int, error) {
+	found := -1
+
+	check := func(token string) error {
+		i, ok := lookup[token]
+		if !ok || i == found {
+			return nil
+		}
+
+		if found >= 0 {
+			return fmt.Errorf("File of several beams: %s", rel)
+		}
+
+		found = i
+		return nil
+	}
+
+	is_separator := func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	}
+
+	for _, part := range strings.Split(filepath.ToSlash(rel), "/") {
+		if err := check(part); err != nil {
+			return -1, err
+		}
+
+		for _, token := range strings.FieldsFunc(part, is_separator) {
+			if err := check(token); err != nil {
+				return -1, err
+			}
+		}
+	}
+
+	return found, nil
+}
+
+// PlanBundles assigns the candidate files in the directory tree to bundles
+// by the packing, by processing node or by bunch. Bunches without node are
+// bundled on their own and named after their ID, like the pipeline
+// configurations. The bundles are ordered by name and the files by path.
+// The files are found by their beam names, as in get_file_beam.
+func PlanBundles(p *Packing, dir string, grouping string) (*BundleManifest, error) {
+	if grouping != "node" && grouping != "bunch" {
+		return nil, fmt.Errorf("Unknown bundle grouping: %s", grouping)
+	}
+
+	m := &BundleManifest{Created: time.Now().UTC(), Dir: dir, Method: p.Method, Seed: p.Seed, Provenance: p.Provenance, Unassigned: []string{}}
+
+	// the bundle, name and bunch of every beam
+	lookup := make(map[string]int)
+	var owners, bunches []int
+	var beams []string
+
+	bynode := make(map[string]int)
+
+	for _, b := range p.Bunches {
+		i, ok := bynode[b.Node]
+		if !ok || b.Node == "" || grouping == "bunch" {
+			i = len(m.Bundles)
+
+			name := b.Node
+			if name == "" || grouping == "bunch" {
+				name = fmt.Sprintf("bunch%03d", b.ID)
+			}
+
+			m.Bundles = append(m.Bundles, Bundle{Name: name, Node: b.Node, Bunches: []int{}, Beams: []string{}, Files: []BundleFile{}})
+
+			if b.Node != "" {
+				bynode[b.Node] = i
+			}
+		}
+
+		m.Bundles[i].Bunches = append(m.Bundles[i].Bunches, b.ID)
+
+		for _, beam := range b.Beams {
+			if beam.Dummy {
+				continue
+			}
+
+			m.Bundles[i].Beams = append(m.Bundles[i].Beams, beam.key())
+
+			lookup[beam.Name] = len(owners)
+			lookup[beam.key()] = len(owners)
+			owners = append(owners, i)
+			beams = append(beams, beam.key())
+			bunches = append(bunches, b.ID)
+		}
+	}
+
+	err := filepath.WalkDir(dir, func(filename string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if !d.Type().IsRegular() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(dir, filename)
+		if err != nil {
+			return err
+		}
+
+		k, err := get_file_beam(rel, lookup)
+		if err != nil {
+			return err
+		}
+
+		if k < 0 {
+			m.Unassigned = append(m.Unassigned, filepath.ToSlash(rel))
+			return nil
+		}
+
+		b := &m.Bundles[owners[k]]
+		b.Files = append(b.Files, BundleFile{
+			Path:   path.Join(b.Name, filepath.ToSlash(rel)),
+			Source: filename,
+			Beam:   beams[k],
+			Bunch:  bunches[k],
+		})
+
+		return nil
+	})
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read candidate directory: %s, %s", dir, err)
+	}
+
+	sort.SliceStable(m.Bundles, func(i, j int) bool {
+		return m.Bundles[i].Name < m.Bundles[j].Name
+	})
+
+	return m, nil
+}
+
+// Add a file to the archive and set its size and hash.
+func add_bundle_file(tw *tar.Writer, f *BundleFile) error {
+	in, err := os.Open(f.Source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	hdr := &tar.Header{
+		Name:    f.Path,
+		Mode:    0644,
+		Size:    info.Size(),
+		ModTime: info.ModTime(),
+		Format:  tar.FormatPAX,
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	h := sha256.New()
+
+	if _, err := io.Copy(io.MultiWriter(tw, h), in); err != nil {
+		return err
+	}
+
+	f.Size = info.Size()
+	f.SHA256 = hex.EncodeToString(h.Sum(nil))
+
+	return nil
+}
+
+// A writer that counts and hashes the data written.
+type hash_writer struct {
+	w    io.Writer
+	hash hash.Hash
+	n    int64
+}
+
+func (w *hash_writer) Write(p []byte) (int, error) {
+	n, err := w.w.Write(p)
+	w.hash.Write(p[:n])
+	w.n += int64(n)
+
+	return n, err
+}
+
+// WriteBundle writes the bundle as gzipped tar archive: its candidate
+// files under the bundle name, followed by the manifest with the hashes of
+// the files. It sets the sizes and hashes of the files and of the archive.
+func WriteBundle(w io.Writer, b *Bundle) error {
+	hw := &hash_writer{w: w, hash: sha256.New()}
+
+	if err := write_bundle(hw, b); err != nil {
+		return err
+	}
+
+	b.Size = hw.n
+	b.SHA256 = hex.EncodeToString(hw.hash.Sum(nil))
+
+	return nil
+}
+
+func write_bundle(w io.Writer, b *Bundle) error {
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+
+	for i := range b.Files {
+		if err := add_bundle_file(tw, &b.Files[i]); err != nil {
+			return fmt.Errorf("%s, %s", b.Files[i].Source, err)
+		}
+	}
+
+	manifest, err := json.MarshalIndent(b, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	manifest = append(manifest, '\n')
+
+	hdr := &tar.Header{
+		Name:    path.Join(b.Name, ManifestName),
+		Mode:    0644,
+		Size:    int64(len(manifest)),
+		ModTime: time.Now(),
+		Format:  tar.FormatPAX,
+	}
+
+	if err := tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+
+	if _, err := tw.Write(manifest); err != nil {
+		return err
+	}
+
+	if err := tw.Close(); err != nil {
+		return err
+	}
+
+	return gz.Close()
+}
+
+// WriteBundleManifest writes the manifest of the bundles as JSON.
+func WriteBundleManifest(w io.Writer, m *BundleManifest) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(m)
+}
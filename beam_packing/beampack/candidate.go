@@ -0,0 +1,231 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Candidate is a single-pulse candidate from the MeerTRAP pipeline.
+type Candidate struct {
+	MJD float64 `json:"mjd"`
+	DM  float64 `json:"dm"`
+	// Pulse width in ms.
+	Width float64 `json:"width"`
+	SNR   float64 `json:"snr"`
+	Beam  int     `json:"beam"`
+}
+
+// The columns of a candidate file.
+type candidate_layout struct {
+	mjd   int
+	dm    int
+	width int
+	snr   int
+	beam  int
+}
+
+// Determine the candidate columns from a header row.
+func get_candidate_layout(fields []string) (candidate_layout, bool) {
+	l := candidate_layout{-1, -1, -1, -1, -1}
+
+	for i, field := range fields {
+		switch strings.ToLower(strings.Trim(field, "#()")) {
+		case "mjd":
+			l.mjd = i
+		case "dm":
+			l.dm = i
+		case "width", "boxcar", "width_ms":
+			l.width = i
+		case "snr", "s/n", "sn", "sigma":
+			l.snr = i
+		case "beam", "beam_id", "beam_nr", "beamno":
+			l.beam = i
+		}
+	}
+
+	ok := l.mjd >= 0 && l.dm >= 0 && l.width >= 0 && l.snr >= 0 && l.beam >= 0
+
+	return l, ok
+}
+
+// LoadCandidates reads the single-pulse candidates from an .spccl file.
+// The columns are MJD, DM, width in ms, S/N and beam number, unless a
+// header row (which may be a comment) names them differently.
+func LoadCandidates(filename string) ([]Candidate, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	defer f.Close()
+
+	cols := candidate_layout{0, 1, 2, 3, 4}
+	first := true
+
+	var cands []Candidate
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" {
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimPrefix(line, "#"))
+
+		if first && is_header(fields) {
+			first = false
+
+			if l, ok := get_candidate_layout(fields); ok {
+				cols = l
+			}
+
+			continue
+		}
+
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		first = false
+
+		var c Candidate
+		var beam float64
+
+		c.MJD, err = parse_field(filename, nr, fields, cols.mjd)
+		if err == nil {
+			c.DM, err = parse_field(filename, nr, fields, cols.dm)
+		}
+		if err == nil {
+			c.Width, err = parse_field(filename, nr, fields, cols.width)
+		}
+		if err == nil {
+			c.SNR, err = parse_field(filename, nr, fields, cols.snr)
+		}
+		if err == nil {
+			beam, err = parse_field(filename, nr, fields, cols.beam)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+
+		c.Beam = int(beam)
+		cands = append(cands, c)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read candidates: %s", err)
+	}
+
+	return cands, nil
+}
+
+// Annotated is a candidate annotated with the packing of its beam.
+type Annotated struct {
+	Candidate
+	Name  string  `json:"name"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Bunch int     `json:"bunch"`
+	Node  string  `json:"node,omitempty"`
+	// Whether the beam is part of the packing.
+	Found bool `json:"found"`
+}
+
+// CrossMatch annotates the candidates with the bunch, node and sky position
+// of their beams, which are looked up in the packing records by beam
+// number. Candidates whose beam is not in the packing get bunch -1.
+func CrossMatch(cands []Candidate, records []Record) []Annotated {
+	beams := make(map[int]Record)
+	for _, rec := range records {
+		beams[rec.Beam] = rec
+	}
+
+	result := make([]Annotated, len(cands))
+
+	for i, c := range cands {
+		a := Annotated{Candidate: c, Bunch: -1}
+
+		if rec, ok := beams[c.Beam]; ok {
+			a.Name = rec.Name
+			a.X = rec.X
+			a.Y = rec.Y
+			a.Bunch = rec.Bunch
+			a.Node = rec.Node
+			a.Found = true
+		}
+
+		result[i] = a
+	}
+
+	return result
+}
+
+// WriteAnnotated writes the annotated candidates to w in the requested
+// format: text, json or csv.
+func WriteAnnotated(w io.Writer, cands []Annotated, format string) error {
+	switch format {
+	case "text":
+		for _, a := range cands {
+			_, err := fmt.Fprintf(w, "MJD: %.8f, DM: %.3f, width: %.3f, S/N: %.2f, beam: %d, name: %s, bunch: %d, x: %.6f, y: %.6f",
+				a.MJD, a.DM, a.Width, a.SNR, a.Beam, a.Name, a.Bunch, a.X, a.Y)
+			if err == nil && a.Node != "" {
+				_, err = fmt.Fprintf(w, ", node: %s", a.Node)
+			}
+			if err == nil {
+				_, err = fmt.Fprintln(w)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(cands)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"mjd", "dm", "width", "snr", "beam", "name", "x", "y", "bunch", "node"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, a := range cands {
+			writer.Write([]string{
+				format_float(a.MJD),
+				format_float(a.DM),
+				format_float(a.Width),
+				format_float(a.SNR),
+				strconv.Itoa(a.Beam),
+				a.Name,
+				format_float(a.X),
+				format_float(a.Y),
+				strconv.Itoa(a.Bunch),
+				a.Node,
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
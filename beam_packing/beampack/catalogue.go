@@ -0,0 +1,365 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/csv"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+// Source is a known source on the sky, e.g. a pulsar from PSRCAT.
+type Source struct {
+	Name string `json:"name"`
+	// RA and Dec in decimal degrees.
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+	// The kind of source, e.g. pulsar or calibrator, if known.
+	Kind string `json:"kind,omitempty"`
+}
+
+// Match is a known source that lies within a packed beam.
+type Match struct {
+	Source Source
+	Beam   Beam
+	Bunch  int
+	Node   string
+	// The separation of the source from the beam centre in degrees.
+	Sep float64
+}
+
+// LoadCatalogue loads a source catalogue. Files ending in .csv are read as
+// CSV export with a header row that names the name, RA and Dec columns, and
+// optionally a kind or type column.
+// Other files are read as PSRCAT output, either in the native database
+// format with PSRJ, RAJ and DECJ keys, or as the table that psrcat prints
+// for the parameters name, RAJ and DECJ. The coordinates can be given in
+// sexagesimal notation (RA in hours) or in decimal degrees.
+func LoadCatalogue(filename string) ([]Source, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	defer f.Close()
+
+	var sources []Source
+
+	if strings.ToLower(filepath.Ext(filename)) == ".csv" {
+		sources, err = read_catalogue_csv(f)
+	} else {
+		sources, err = read_psrcat(f)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read catalogue: %s, %s", filename, err)
+	}
+
+	return sources, nil
+}
+
+// Check whether the text looks like a pulsar name.
+func is_source_name(text string) bool {
+	return len(text) > 1 && (text[0] == 'J' || text[0] == 'B') && strings.ContainsAny(text, "+-")
+}
+
+// Read a PSRCAT database or table.
+func read_psrcat(r io.Reader) ([]Source, error) {
+	var sources []Source
+
+	// the current record of a database file
+	var current Source
+	var nkeys int
+
+	flush := func() {
+		if nkeys == 3 {
+			sources = append(sources, current)
+		}
+
+		current = Source{}
+		nkeys = 0
+	}
+
+	scanner := bufio.NewScanner(r)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		// records are separated by @ lines
+		if strings.HasPrefix(line, "@") {
+			flush()
+			continue
+		}
+
+		if line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, "-") || strings.HasPrefix(line, "*") {
+			continue
+		}
+
+		fields := strings.Fields(line)
+
+		var err error
+
+		switch {
+		case fields[0] == "PSRJ" && len(fields) > 1:
+			current.Name = fields[1]
+			nkeys++
+		case fields[0] == "RAJ" && len(fields) > 1:
+			current.RA, err = parse_angle(fields[1], true)
+			nkeys++
+		case fields[0] == "DECJ" && len(fields) > 1:
+			current.Dec, err = parse_angle(fields[1], false)
+			nkeys++
+		default:
+			// a table row: the coordinates follow the name, possibly
+			// with their uncertainties in between
+			for i, field := range fields {
+				if !is_source_name(field) {
+					continue
+				}
+
+				var coords []string
+				for _, value := range fields[i+1:] {
+					if strings.Contains(value, ":") {
+						coords = append(coords, value)
+					}
+				}
+
+				if len(coords) < 2 {
+					break
+				}
+
+				src := Source{Name: field}
+
+				src.RA, err = parse_angle(coords[0], true)
+				if err == nil {
+					src.Dec, err = parse_angle(coords[1], false)
+				}
+
+				if err == nil {
+					sources = append(sources, src)
+				}
+
+				break
+			}
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", nr, err)
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	flush()
+
+	return sources, nil
+}
+
+// Read a CSV catalogue export.
+func read_catalogue_csv(r io.Reader) ([]Source, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	namecol, racol, deccol, kindcol := -1, -1, -1, -1
+
+	for i, field := range rows[0] {
+		switch strings.ToLower(strings.TrimSpace(field)) {
+		case "name", "psrj", "jname", "psrb", "bname":
+			if namecol < 0 {
+				namecol = i
+			}
+		case "raj", "ra", "ra_deg", "raj_deg":
+			racol = i
+		case "decj", "dec", "dec_deg", "decj_deg":
+			deccol = i
+		case "kind", "type", "class":
+			kindcol = i
+		}
+	}
+
+	if namecol < 0 || racol < 0 || deccol < 0 {
+		return nil, fmt.Errorf("The header must name the name, RA and Dec columns: %s", strings.Join(rows[0], ","))
+	}
+
+	var sources []Source
+
+	for nr, row := range rows[1:] {
+		if max(namecol, racol, deccol) >= len(row) {
+			return nil, fmt.Errorf("row %d: missing fields", nr+1)
+		}
+
+		src := Source{Name: strings.TrimSpace(row[namecol])}
+
+		if kindcol >= 0 && kindcol < len(row) {
+			src.Kind = strings.ToLower(strings.TrimSpace(row[kindcol]))
+		}
+
+		src.RA, err = parse_angle(row[racol], true)
+		if err == nil {
+			src.Dec, err = parse_angle(row[deccol], false)
+		}
+
+		if err != nil {
+			return nil, fmt.Errorf("row %d: %s", nr+1, err)
+		}
+
+		sources = append(sources, src)
+	}
+
+	return sources, nil
+}
+
+// Get the beam radius for matching. It is the beam semi-major axis if the
+// beam has a shape, otherwise the given radius.
+func get_beam_radius(beam Beam, radius float64) float64 {
+	if beam.SemiMajor > 0 {
+		return beam.SemiMajor
+	}
+
+	return radius
+}
+
+// MatchSources finds the known sources within the packed coherent beams. The
+// beam positions are interpreted as RA and Dec in degrees. A source is
+// matched to every beam whose centre is within the beam radius, which is
+// the beam semi-major axis if known, otherwise the given radius. A
+// non-positive radius selects half the median separation of the beams from
+// their nearest neighbour. The matches are ordered by source and
+// separation.
+func MatchSources(p *Packing, sources []Source, radius float64) []Match {
+	var beams []Beam
+	var bunches []Bunch
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+				bunches = append(bunches, b)
+			}
+		}
+	}
+
+	if radius <= 0 {
+		radius = get_median_separation(beams, Angular) / 2
+	}
+
+	var matches []Match
+
+	for _, src := range sources {
+		for i, beam := range beams {
+			r := get_beam_radius(beam, radius)
+
+			// quick rejection in declination
+			if math.Abs(beam.Y-src.Dec) > r {
+				continue
+			}
+
+			sep := Angular(beam.X, beam.Y, src.RA, src.Dec)
+
+			if sep <= r {
+				matches = append(matches, Match{
+					Source: src,
+					Beam:   beam,
+					Bunch:  bunches[i].ID,
+					Node:   bunches[i].Node,
+					Sep:    sep,
+				})
+			}
+		}
+	}
+
+	sort.SliceStable(matches, func(i, j int) bool {
+		if matches[i].Source.Name != matches[j].Source.Name {
+			return matches[i].Source.Name < matches[j].Source.Name
+		}
+
+		return matches[i].Sep < matches[j].Sep
+	})
+
+	return matches
+}
+
+// WriteMatches writes the known sources within the beams to w.
+func WriteMatches(w io.Writer, matches []Match) error {
+	for _, m := range matches {
+		fmt.Fprintf(w, "Source: %s, beam: %s, bunch: %d, separation: %.6f",
+			m.Source.Name, m.Beam.Name, m.Bunch, m.Sep)
+
+		if m.Node != "" {
+			fmt.Fprintf(w, ", node: %s", m.Node)
+		}
+
+		fmt.Fprintln(w)
+	}
+
+	sources := make(map[string]bool)
+	bunches := make(map[int]bool)
+
+	for _, m := range matches {
+		sources[m.Source.Name] = true
+		bunches[m.Bunch] = true
+	}
+
+	_, err := fmt.Fprintf(w, "\nSources: %d, beams: %d, bunches: %d\n", len(sources), len(matches), len(bunches))
+
+	return err
+}
+
+// Tag is a known source within a beam of a bunch.
+type Tag struct {
+	Source string `json:"source"`
+	Kind   string `json:"kind,omitempty"`
+	// The nearest beam of the bunch and the separation of the source from
+	// its centre in degrees.
+	Beam string  `json:"beam"`
+	Sep  float64 `json:"sep"`
+}
+
+// TagBunches annotates the bunches of the packing with the known sources
+// within their beams, matched like in MatchSources. A source within several
+// beams of a bunch is tagged once, with the nearest beam. The tags are
+// ordered by source. It returns the number of tagged bunches.
+func TagBunches(p *Packing, sources []Source, radius float64) int {
+	index := make(map[int]int, len(p.Bunches))
+	for i := range p.Bunches {
+		p.Bunches[i].Tags = nil
+		index[p.Bunches[i].ID] = i
+	}
+
+	var tagged int
+
+	for _, m := range MatchSources(p, sources, radius) {
+		b := &p.Bunches[index[m.Bunch]]
+
+		// the matches of a source are ordered by separation
+		if n := len(b.Tags); n > 0 && b.Tags[n-1].Source == m.Source.Name {
+			continue
+		}
+
+		if len(b.Tags) == 0 {
+			tagged++
+		}
+
+		b.Tags = append(b.Tags, Tag{Source: m.Source.Name, Kind: m.Source.Kind, Beam: m.Beam.Name, Sep: m.Sep})
+	}
+
+	return tagged
+}
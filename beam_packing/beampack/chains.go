@@ -0,0 +1,105 @@
+package beampack
+
+import (
+	"math/rand"
+	"sync"
+)
+
+// Run independent optimization chains concurrently, each with its own
+// random number generator seeded from rng and its own monitor, and return
+// the result with the lowest score and the index of its chain. Only the
+// first chain reports the progress, but all stop at the maximum runtime.
+func run_chains(chains int, rng *rand.Rand, m *Monitor, run func(rng *rand.Rand, m *Monitor) *Packing, score func(*Packing) float64) (*Packing, int) {
+	chains = max(chains, 1)
+
+	seeds := make([]int64, chains)
+	for i := range seeds {
+		seeds[i] = rng.Int63()
+	}
+
+	results := make([]*Packing, chains)
+	monitors := make([]*Monitor, chains)
+
+	var wg sync.WaitGroup
+
+	for i := range results {
+		if m != nil {
+			monitors[i] = &Monitor{MaxRuntime: m.MaxRuntime}
+
+			if i == 0 {
+				monitors[i].Progress = m.Progress
+				monitors[i].Interval = m.Interval
+			}
+		}
+
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+			results[i] = run(rand.New(rand.NewSource(seeds[i])), monitors[i])
+		}(i)
+	}
+
+	wg.Wait()
+
+	best := 0
+	scores := make([]float64, chains)
+
+	for i, r := range results {
+		scores[i] = score(r)
+
+		if scores[i] < scores[best] {
+			best = i
+		}
+
+		if m != nil && monitors[i].Stopped {
+			m.Stopped = true
+		}
+	}
+
+	return results[best], best
+}
+
+// AnnealChains refines a packing like AnnealBalanced with several
+// independent annealing chains that run concurrently, e.g. one per core,
+// and returns the best result and the index of its chain. The chains are
+// seeded from rng, so the result is reproducible for a given seed.
+func AnnealChains(p *Packing, iterations int, balance float64, dist DistanceFunc, chains int, rng *rand.Rand, m *Monitor) (*Packing, int) {
+	scale := Cost(p, dist)
+	if scale <= 0 {
+		scale = 1
+	}
+
+	return run_chains(chains, rng, m,
+		func(rng *rand.Rand, m *Monitor) *Packing {
+			return AnnealBalanced(p, iterations, balance, dist, rng, m)
+		},
+		func(q *Packing) float64 {
+			if balance > 0 || p.Previous.active() {
+				return Cost(q, dist)/scale + balance*GetLoadBalance(q).CV + get_churn(q)
+			}
+
+			return Cost(q, dist)
+		})
+}
+
+// EvolveChains refines a packing like Evolve with several independent
+// populations that evolve concurrently and returns the best result and the
+// index of its chain. The chains are seeded from rng, so the result is
+// reproducible for a given seed.
+func EvolveChains(p *Packing, opts GAOptions, dist DistanceFunc, chains int, rng *rand.Rand) (*Packing, int) {
+	fitness := opts.Fitness
+	if fitness == nil {
+		fitness = Cost
+	}
+
+	return run_chains(chains, rng, opts.Monitor,
+		func(rng *rand.Rand, m *Monitor) *Packing {
+			o := opts
+			o.Monitor = m
+			return Evolve(p, o, dist, rng)
+		},
+		func(q *Packing) float64 {
+			return fitness(q, dist)
+		})
+}
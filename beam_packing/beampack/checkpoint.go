@@ -0,0 +1,207 @@
+package beampack
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+)
+
+// The number of annealing iterations between the points at which the state
+// of the optimizer is checkpointed. The random number generator is reseeded
+// at every such point, so that a resumed run continues exactly like an
+// uninterrupted one.
+const checkpoint_block = 1 << 16
+
+// Checkpoint is the state of the annealing optimizer, from which an
+// interrupted run is resumed.
+type Checkpoint struct {
+	Optimizer  string  `json:"optimizer"`
+	Iterations int     `json:"iterations"`
+	Balance    float64 `json:"balance"`
+	// The number of completed iterations, the temperature and its factor
+	// per iteration.
+	Iteration   int     `json:"iteration"`
+	Temperature float64 `json:"temperature"`
+	Cooling     float64 `json:"cooling"`
+	// The seed of the random number generator from this iteration on.
+	Seed int64 `json:"seed"`
+	// The normalisation of the cost and the initial, current and best
+	// values of the objective, relative to the initial one.
+	Scale    float64 `json:"scale"`
+	Initial  float64 `json:"initial"`
+	Cost     float64 `json:"cost"`
+	BestCost float64 `json:"best_cost"`
+	Bunches  int     `json:"bunches"`
+	// The beam names, the beams of the bunches of the current packing in
+	// their order by index into the names, which determines the order of
+	// the cost sums, and the bunches of the beams in the best packing.
+	Beams   []string `json:"beams"`
+	Current [][]int  `json:"current"`
+	Best    []int    `json:"best"`
+}
+
+// Reseed the random number generator at the start of a block of
+// iterations, with the seed recorded in the checkpoint state.
+func reseed_block(c *Checkpoint, rng *rand.Rand) {
+	c.Seed = rng.Int63()
+	rng.Seed(c.Seed)
+}
+
+// Take a checkpoint of the annealing state.
+func take_checkpoint(c *Checkpoint, beams []Beam, members [][]int, best []int) {
+	if c.Beams == nil {
+		c.Beams = make([]string, len(beams))
+		for i, beam := range beams {
+			c.Beams[i] = beam.Name
+		}
+	}
+
+	c.Current = make([][]int, len(members))
+	for g, m := range members {
+		c.Current[g] = append([]int(nil), m...)
+	}
+
+	c.Best = append(c.Best[:0], best...)
+}
+
+// Get the beams of the bunches of the current packing and the bunches of
+// the beams in the best packing of the checkpoint by index into the beams.
+func (c *Checkpoint) restore(beams []Beam) ([][]int, []int, error) {
+	if len(c.Beams) != len(beams) || len(c.Best) != len(beams) || len(c.Current) != c.Bunches {
+		return nil, nil, fmt.Errorf("The checkpoint has a different number of beams: %d, %d", len(c.Beams), len(beams))
+	}
+
+	index := make(map[string]int, len(c.Beams))
+	for i, name := range c.Beams {
+		if _, ok := index[name]; ok {
+			return nil, nil, fmt.Errorf("Duplicate beam in checkpoint: %s", name)
+		}
+
+		index[name] = i
+	}
+
+	// the index of every beam of the checkpoint into the beams
+	local := make([]int, len(beams))
+	best := make([]int, len(beams))
+
+	for i, beam := range beams {
+		k, ok := index[beam.Name]
+		if !ok {
+			return nil, nil, fmt.Errorf("The beam is not in the checkpoint: %s", beam.Name)
+		}
+
+		delete(index, beam.Name)
+
+		if c.Best[k] < 0 || c.Best[k] >= c.Bunches {
+			return nil, nil, fmt.Errorf("Invalid bunch of beam in checkpoint: %s", beam.Name)
+		}
+
+		local[k], best[i] = i, c.Best[k]
+	}
+
+	members := make([][]int, len(c.Current))
+	seen := make([]bool, len(beams))
+
+	for g, m := range c.Current {
+		for _, k := range m {
+			if k < 0 || k >= len(beams) || seen[k] {
+				return nil, nil, fmt.Errorf("Invalid beam of bunch in checkpoint: %d", k)
+			}
+
+			seen[k] = true
+			members[g] = append(members[g], local[k])
+		}
+	}
+
+	for k, ok := range seen {
+		if !ok {
+			return nil, nil, fmt.Errorf("The beam is in no bunch of the checkpoint: %s", c.Beams[k])
+		}
+	}
+
+	return members, best, nil
+}
+
+// Check checks that the annealing of the packing with the settings can be
+// resumed from the checkpoint: the packing must have the same beams and
+// number of bunches, and the number of iterations and the balance weight
+// must be the same.
+func (c *Checkpoint) Check(p *Packing, iterations int, balance float64) error {
+	beams, _ := flatten_packing(p)
+
+	_, _, err := c.match(beams, len(p.Bunches), iterations, balance)
+	return err
+}
+
+// Get the bunches of the current and best packings of the checkpoint like
+// restore if it matches the beams and settings.
+func (c *Checkpoint) match(beams []Beam, ngroups, iterations int, balance float64) ([][]int, []int, error) {
+	if c.Optimizer != "anneal" {
+		return nil, nil, fmt.Errorf("The checkpoint is not from the annealing optimizer: %s", c.Optimizer)
+	}
+
+	if c.Iterations != iterations {
+		return nil, nil, fmt.Errorf("The checkpoint has a different number of iterations: %d, %d", c.Iterations, iterations)
+	}
+
+	if c.Balance != balance {
+		return nil, nil, fmt.Errorf("The checkpoint has a different balance weight: %g, %g", c.Balance, balance)
+	}
+
+	if c.Bunches != ngroups {
+		return nil, nil, fmt.Errorf("The checkpoint has a different number of bunches: %d, %d", c.Bunches, ngroups)
+	}
+
+	return c.restore(beams)
+}
+
+// ReadCheckpoint reads an optimizer checkpoint from a JSON file.
+func ReadCheckpoint(filename string) (*Checkpoint, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	var c Checkpoint
+	if err := json.Unmarshal(data, &c); err != nil {
+		return nil, fmt.Errorf("Could not parse checkpoint: %s, %s", filename, err)
+	}
+
+	return &c, nil
+}
+
+// WriteCheckpoint writes an optimizer checkpoint to a JSON file. The file is
+// replaced atomically, so that an interruption while writing keeps the
+// previous checkpoint, and is readable by all users.
+func WriteCheckpoint(filename string, c *Checkpoint) error {
+	data, err := json.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("Could not create checkpoint: %s, %s", filename, err)
+	}
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not write checkpoint: %s, %s", filename, err)
+	}
+
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not write checkpoint: %s, %s", filename, err)
+	}
+
+	// the temporary file is private, but a checkpoint is resumed by others
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not write checkpoint: %s, %s", filename, err)
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
@@ -0,0 +1,120 @@
+package beampack
+
+import (
+	"encoding/json"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// Get the bunch of every beam of a packing, by beam number.
+func get_test_assignment(p *Packing) map[int]int {
+	assignment := make(map[int]int)
+	for g, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			assignment[beam.Nr] = g
+		}
+	}
+
+	return assignment
+}
+
+// The checkpoints of an annealing run do not change its result, and a run
+// resumed from one of them gives the same packing as the uninterrupted one.
+func TestAnnealCheckpoint(t *testing.T) {
+	p, err := Pack(get_test_tiling(t, 200), Options{Bunch: 6, Method: "hilbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	iterations := 3*checkpoint_block + 1000
+
+	plain := get_test_assignment(Anneal(p, iterations, Euclidean, rand.New(rand.NewSource(42))))
+
+	var saved []Checkpoint
+
+	m := &Monitor{Checkpoint: func(c *Checkpoint) {
+		// the checkpoint is only valid during the call
+		var copied Checkpoint
+
+		data, err := json.Marshal(c)
+		if err == nil {
+			err = json.Unmarshal(data, &copied)
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		saved = append(saved, copied)
+	}}
+
+	checkpointed := get_test_assignment(AnnealMonitored(p, iterations, Euclidean, rand.New(rand.NewSource(42)), m))
+
+	if !reflect.DeepEqual(checkpointed, plain) {
+		t.Error("the checkpoints change the packing")
+	}
+
+	// the checkpoints at the start of every block and at the end
+	if len(saved) != 5 || saved[2].Iteration != 2*checkpoint_block || saved[4].Iteration != iterations {
+		t.Fatalf("wrong checkpoints: %d", len(saved))
+	}
+
+	filename := filepath.Join(t.TempDir(), "anneal.ckpt")
+	if err := WriteCheckpoint(filename, &saved[2]); err != nil {
+		t.Fatal(err)
+	}
+
+	if info, err := os.Stat(filename); err != nil || info.Mode().Perm() != 0644 {
+		t.Errorf("wrong checkpoint file mode: %v, %v", info, err)
+	}
+
+	c, err := ReadCheckpoint(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.Check(p, iterations, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	// the random number generator is reseeded from the checkpoint
+	resumed := get_test_assignment(AnnealMonitored(p, iterations, Euclidean, rand.New(rand.NewSource(7)), &Monitor{Resume: c}))
+
+	if !reflect.DeepEqual(resumed, plain) {
+		t.Error("the resumed run gives a different packing")
+	}
+}
+
+func TestCheckpointCheck(t *testing.T) {
+	p, err := Pack(get_test_tiling(t, 60), Options{Bunch: 6, Method: "hilbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var c *Checkpoint
+
+	AnnealMonitored(p, 1000, Euclidean, rand.New(rand.NewSource(1)), &Monitor{Checkpoint: func(s *Checkpoint) {
+		copied := *s
+		c = &copied
+	}})
+
+	if c == nil || c.Check(p, 1000, 0) != nil {
+		t.Fatalf("no valid checkpoint: %+v", c)
+	}
+
+	q, err := Pack(get_test_tiling(t, 66), Options{Bunch: 6, Method: "hilbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Check(p, 2000, 0) == nil || c.Check(p, 1000, 0.5) == nil || c.Check(q, 1000, 0) == nil {
+		t.Error("no error for a checkpoint with different settings or beams")
+	}
+
+	if _, err := ReadCheckpoint(filepath.Join("testdata", "missing.ckpt")); err == nil {
+		t.Error("no error for a missing checkpoint")
+	}
+}
@@ -0,0 +1,194 @@
+package beampack
+
+import (
+	"sort"
+)
+
+// Previous is the assignment of the beams of a previous packing, e.g. the
+// one in use before a mid-session reconfiguration, that a repacking should
+// change as little as possible, as every beam that moves changes the
+// multicast subscriptions of two nodes.
+type Previous struct {
+	// The previous bunch ID of every beam, by beam key.
+	Bunches map[string]int
+	// The previous processing node of every bunch, by ID.
+	Nodes map[int]string
+	// The penalty of moving all beams away from their previous bunches in
+	// the annealing objective, relative to the cost of the packing.
+	Weight float64
+}
+
+// GetPrevious gets the previous assignment from the records of a packing.
+func GetPrevious(records []Record, weight float64) *Previous {
+	prev := &Previous{
+		Bunches: make(map[string]int),
+		Nodes:   make(map[int]string),
+		Weight:  weight,
+	}
+
+	for _, rec := range records {
+		prev.Bunches[rec.key()] = rec.Bunch
+
+		if rec.Node != "" {
+			prev.Nodes[rec.Bunch] = rec.Node
+		}
+	}
+
+	return prev
+}
+
+// Whether the churn penalty applies.
+func (prev *Previous) active() bool {
+	return prev != nil && prev.Weight > 0 && len(prev.Bunches) > 0
+}
+
+// Get the index of the previous bunch of every beam among the bunches of
+// the packing, or -1 if the beam is new or its bunch is gone, and the
+// number of beams with a previous bunch.
+func get_homes(p *Packing, beams []Beam) ([]int, int) {
+	home := make([]int, len(beams))
+
+	index := make(map[int]int)
+	for i, b := range p.Bunches {
+		index[b.ID] = i
+	}
+
+	var n int
+
+	for i, beam := range beams {
+		home[i] = -1
+
+		id, ok := p.Previous.Bunches[beam.key()]
+		if !ok {
+			continue
+		}
+
+		n++
+
+		if k, ok := index[id]; ok {
+			home[i] = k
+		}
+	}
+
+	return home, n
+}
+
+// Get the churn penalty of the packing: the weight times the fraction of
+// the beams of the previous packing that are not in their previous bunch.
+func get_churn(p *Packing) float64 {
+	if !p.Previous.active() {
+		return 0
+	}
+
+	var moved, n int
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			id, ok := p.Previous.Bunches[beam.key()]
+			if !ok {
+				continue
+			}
+
+			n++
+
+			if id != b.ID {
+				moved++
+			}
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return p.Previous.Weight * float64(moved) / float64(n)
+}
+
+// MatchPrevious labels the bunches of a fresh packing after the bunches of
+// the previous one they share the most beams with, so that they keep their
+// IDs and thereby their multicast groups, and their processing nodes. The
+// remaining bunches get new IDs above the previous ones. The packing keeps
+// the previous assignment, so that the annealing optimizer penalises moving
+// the beams away from their previous bunches. It returns the number of
+// beams that stay in their previous bunch.
+func MatchPrevious(p *Packing, prev *Previous) int {
+	type pair struct {
+		bunch   int
+		id      int
+		overlap int
+	}
+
+	var pairs []pair
+	next := 0
+
+	for id := range prev.Nodes {
+		next = max(next, id+1)
+	}
+
+	for _, id := range prev.Bunches {
+		next = max(next, id+1)
+	}
+
+	for i, b := range p.Bunches {
+		overlap := make(map[int]int)
+
+		for _, beam := range b.Beams {
+			if id, ok := prev.Bunches[beam.key()]; ok {
+				overlap[id]++
+			}
+		}
+
+		for id, n := range overlap {
+			pairs = append(pairs, pair{i, id, n})
+		}
+	}
+
+	// greedily match the pairs with the largest overlap first, the ties are
+	// broken by the order of the bunches for reproducibility
+	sort.Slice(pairs, func(i, j int) bool {
+		a, b := pairs[i], pairs[j]
+
+		if a.overlap != b.overlap {
+			return a.overlap > b.overlap
+		}
+
+		if a.bunch != b.bunch {
+			return a.bunch < b.bunch
+		}
+
+		return a.id < b.id
+	})
+
+	ids := make([]int, len(p.Bunches))
+	for i := range ids {
+		ids[i] = -1
+	}
+
+	used := make(map[int]bool)
+	var kept int
+
+	for _, m := range pairs {
+		if ids[m.bunch] >= 0 || used[m.id] {
+			continue
+		}
+
+		ids[m.bunch] = m.id
+		used[m.id] = true
+		kept += m.overlap
+	}
+
+	for i := range p.Bunches {
+		if ids[i] < 0 {
+			ids[i] = next
+			next++
+		} else if p.Bunches[i].Node == "" {
+			p.Bunches[i].Node = prev.Nodes[ids[i]]
+		}
+
+		p.Bunches[i].ID = ids[i]
+	}
+
+	p.Previous = prev
+
+	return kept
+}
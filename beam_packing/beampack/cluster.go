@@ -0,0 +1,363 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ClusterOptions configure the clustering of single-pulse candidates.
+type ClusterOptions struct {
+	// The clustering method: fof (friends-of-friends) or dbscan.
+	Method string
+	// Candidates closer than that in time, in seconds, and in DM are
+	// neighbours.
+	Time float64
+	DM   float64
+	// If larger than one, neighbouring candidates must also have widths
+	// that differ by less than that factor.
+	Width float64
+	// DBSCAN only: the minimum number of neighbours, including itself, of a
+	// core candidate.
+	MinPoints int
+	// If given, candidates in adjacent beams are neighbours too. Otherwise,
+	// only candidates in the same beam are.
+	Graph *Graph
+}
+
+// Clustered is a candidate with the event it belongs to.
+type Clustered struct {
+	Candidate
+	// The ID of the event, or -1 for DBSCAN noise.
+	Event int `json:"event"`
+}
+
+// Event is a cluster of candidates, represented by its brightest candidate.
+type Event struct {
+	ID int `json:"id"`
+	// The candidate with the highest S/N.
+	Candidate
+	NCandidates int   `json:"ncandidates"`
+	Beams       []int `json:"beams"`
+	// The time and DM range of the candidates.
+	MJDStart float64 `json:"mjd_start"`
+	MJDEnd   float64 `json:"mjd_end"`
+	DMMin    float64 `json:"dm_min"`
+	DMMax    float64 `json:"dm_max"`
+}
+
+// Get the neighbours of every candidate within the linking lengths.
+func get_candidate_neighbours(cands []Candidate, opts ClusterOptions) [][]int {
+	const day = 86400.0
+
+	adjacent := make(map[[2]int]bool)
+	if opts.Graph != nil {
+		for _, e := range opts.Graph.Edges {
+			a, b := opts.Graph.Beams[e.A].Nr, opts.Graph.Beams[e.B].Nr
+			adjacent[[2]int{a, b}] = true
+			adjacent[[2]int{b, a}] = true
+		}
+	}
+
+	order := make([]int, len(cands))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return cands[order[i]].MJD < cands[order[j]].MJD
+	})
+
+	neighbours := make([][]int, len(cands))
+
+	for k, i := range order {
+		a := cands[i]
+
+		for _, j := range order[k+1:] {
+			b := cands[j]
+
+			if (b.MJD-a.MJD)*day > opts.Time {
+				break
+			}
+
+			if math.Abs(b.DM-a.DM) > opts.DM {
+				continue
+			}
+
+			if a.Beam != b.Beam && !adjacent[[2]int{a.Beam, b.Beam}] {
+				continue
+			}
+
+			if opts.Width > 1 && a.Width > 0 && b.Width > 0 &&
+				math.Abs(math.Log(a.Width/b.Width)) > math.Log(opts.Width) {
+				continue
+			}
+
+			neighbours[i] = append(neighbours[i], j)
+			neighbours[j] = append(neighbours[j], i)
+		}
+	}
+
+	return neighbours
+}
+
+// ClusterCandidates groups the single-pulse candidates into unique events.
+// Candidates are neighbours if they are closer than the linking lengths in
+// time and DM, optionally have similar widths, and are in the same beam or,
+// with an adjacency graph, in adjacent beams. With friends-of-friends, every
+// group of candidates connected by neighbours is an event. With DBSCAN, the
+// candidates with at least MinPoints neighbours are core candidates, the
+// events are the connected core candidates and their neighbours, and the
+// other candidates are noise. The event IDs are in order of time.
+func ClusterCandidates(cands []Candidate, opts ClusterOptions) ([]Clustered, error) {
+	if opts.Time <= 0 || opts.DM <= 0 {
+		return nil, fmt.Errorf("The linking lengths must be positive: %g, %g", opts.Time, opts.DM)
+	}
+
+	neighbours := get_candidate_neighbours(cands, opts)
+
+	core := make([]bool, len(cands))
+
+	switch opts.Method {
+	case "fof":
+		for i := range core {
+			core[i] = true
+		}
+
+	case "dbscan":
+		if opts.MinPoints < 1 {
+			return nil, fmt.Errorf("The minimum number of points must be positive: %d", opts.MinPoints)
+		}
+
+		for i := range core {
+			core[i] = len(neighbours[i])+1 >= opts.MinPoints
+		}
+
+	default:
+		return nil, fmt.Errorf("Unknown clustering method: %s", opts.Method)
+	}
+
+	// expand the events from the core candidates in order of time
+	order := make([]int, len(cands))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return cands[order[i]].MJD < cands[order[j]].MJD
+	})
+
+	event := make([]int, len(cands))
+	for i := range event {
+		event[i] = -1
+	}
+
+	var nevents int
+
+	for _, i := range order {
+		if !core[i] || event[i] >= 0 {
+			continue
+		}
+
+		event[i] = nevents
+		stack := []int{i}
+
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			for _, next := range neighbours[cur] {
+				if event[next] >= 0 {
+					continue
+				}
+
+				event[next] = nevents
+
+				// border candidates do not extend the event
+				if core[next] {
+					stack = append(stack, next)
+				}
+			}
+		}
+
+		nevents++
+	}
+
+	result := make([]Clustered, len(cands))
+	for i, c := range cands {
+		result[i] = Clustered{c, event[i]}
+	}
+
+	return result, nil
+}
+
+// GetEvents summarises the clustered candidates by event, in order of the
+// event IDs. Noise candidates are left out.
+func GetEvents(cands []Clustered) []Event {
+	byid := make(map[int]*Event)
+	beams := make(map[int]map[int]bool)
+	var ids []int
+
+	for _, c := range cands {
+		if c.Event < 0 {
+			continue
+		}
+
+		e, ok := byid[c.Event]
+		if !ok {
+			e = &Event{
+				ID:        c.Event,
+				Candidate: c.Candidate,
+				MJDStart:  c.MJD,
+				MJDEnd:    c.MJD,
+				DMMin:     c.DM,
+				DMMax:     c.DM,
+			}
+
+			byid[c.Event] = e
+			beams[c.Event] = make(map[int]bool)
+			ids = append(ids, c.Event)
+		}
+
+		if c.SNR > e.SNR {
+			e.Candidate = c.Candidate
+		}
+
+		e.NCandidates++
+		e.MJDStart = math.Min(e.MJDStart, c.MJD)
+		e.MJDEnd = math.Max(e.MJDEnd, c.MJD)
+		e.DMMin = math.Min(e.DMMin, c.DM)
+		e.DMMax = math.Max(e.DMMax, c.DM)
+
+		if !beams[c.Event][c.Beam] {
+			beams[c.Event][c.Beam] = true
+			e.Beams = append(e.Beams, c.Beam)
+		}
+	}
+
+	sort.Ints(ids)
+
+	events := make([]Event, len(ids))
+	for k, id := range ids {
+		events[k] = *byid[id]
+		sort.Ints(events[k].Beams)
+	}
+
+	return events
+}
+
+// WriteEvents writes the events to w in the requested format: text, json or
+// csv. The beams are separated by spaces in the csv output.
+func WriteEvents(w io.Writer, events []Event, format string) error {
+	join_beams := func(beams []int) string {
+		s := make([]string, len(beams))
+		for i, nr := range beams {
+			s[i] = strconv.Itoa(nr)
+		}
+
+		return strings.Join(s, " ")
+	}
+
+	switch format {
+	case "text":
+		for _, e := range events {
+			_, err := fmt.Fprintf(w, "Event: %d, MJD: %.8f, DM: %.3f, width: %.3f, S/N: %.2f, beam: %d, candidates: %d, beams: %s, DM range: %.3f .. %.3f\n",
+				e.ID, e.MJD, e.DM, e.Width, e.SNR, e.Beam, e.NCandidates, join_beams(e.Beams), e.DMMin, e.DMMax)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(events)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"event", "mjd", "dm", "width", "snr", "beam", "ncandidates", "beams", "mjd_start", "mjd_end", "dm_min", "dm_max"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, e := range events {
+			writer.Write([]string{
+				strconv.Itoa(e.ID),
+				format_float(e.MJD),
+				format_float(e.DM),
+				format_float(e.Width),
+				format_float(e.SNR),
+				strconv.Itoa(e.Beam),
+				strconv.Itoa(e.NCandidates),
+				join_beams(e.Beams),
+				format_float(e.MJDStart),
+				format_float(e.MJDEnd),
+				format_float(e.DMMin),
+				format_float(e.DMMax),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
+
+// WriteClustered writes the candidates with their event IDs to w in the
+// requested format: text, json or csv.
+func WriteClustered(w io.Writer, cands []Clustered, format string) error {
+	switch format {
+	case "text":
+		for _, c := range cands {
+			_, err := fmt.Fprintf(w, "MJD: %.8f, DM: %.3f, width: %.3f, S/N: %.2f, beam: %d, event: %d\n",
+				c.MJD, c.DM, c.Width, c.SNR, c.Beam, c.Event)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(cands)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"mjd", "dm", "width", "snr", "beam", "event"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, c := range cands {
+			writer.Write([]string{
+				format_float(c.MJD),
+				format_float(c.DM),
+				format_float(c.Width),
+				format_float(c.SNR),
+				strconv.Itoa(c.Beam),
+				strconv.Itoa(c.Event),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,213 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+)
+
+// CoincidenceOptions configure the multibeam coincidence filter.
+type CoincidenceOptions struct {
+	// Candidates closer than that in time, in seconds, are coincident.
+	Window float64
+	// If positive, coincident candidates must also be closer than that in
+	// DM.
+	DMTol float64
+	// Events detected in at least that many beams are tested for RFI.
+	MinBeams int
+	// Events whose beams form more than that many groups of adjacent beams
+	// are flagged as RFI.
+	MaxGroups int
+}
+
+// Flagged is a candidate with the outcome of the coincidence filter.
+type Flagged struct {
+	Candidate
+	// The ID of the coincident event the candidate belongs to.
+	Event int `json:"event"`
+	// The number of beams the event was detected in and the number of
+	// groups of adjacent beams they form.
+	NBeams  int  `json:"nbeams"`
+	NGroups int  `json:"ngroups"`
+	RFI     bool `json:"rfi"`
+}
+
+// Group the candidates into coincident events using a friends-of-friends
+// criterion in time and, optionally, in DM. The events hold the candidate
+// indices.
+func get_events(cands []Candidate, opts CoincidenceOptions) [][]int {
+	const day = 86400.0
+
+	order := make([]int, len(cands))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return cands[order[i]].MJD < cands[order[j]].MJD
+	})
+
+	var events [][]int
+	var current []int
+
+	split_dm := func(members []int) {
+		if opts.DMTol <= 0 {
+			events = append(events, members)
+			return
+		}
+
+		sort.SliceStable(members, func(i, j int) bool {
+			return cands[members[i]].DM < cands[members[j]].DM
+		})
+
+		start := 0
+		for i := 1; i <= len(members); i++ {
+			if i == len(members) || cands[members[i]].DM-cands[members[i-1]].DM > opts.DMTol {
+				events = append(events, members[start:i])
+				start = i
+			}
+		}
+	}
+
+	for k, i := range order {
+		if k > 0 && (cands[i].MJD-cands[order[k-1]].MJD)*day > opts.Window {
+			split_dm(current)
+			current = nil
+		}
+
+		current = append(current, i)
+	}
+
+	if len(current) > 0 {
+		split_dm(current)
+	}
+
+	return events
+}
+
+// Count the groups of adjacent beams, i.e. the connected components of the
+// adjacency graph restricted to the given beam numbers. Beams that are not
+// part of the graph form their own group.
+func count_groups(beams map[int]bool, adjacent map[int][]int) int {
+	seen := make(map[int]bool)
+	var groups int
+
+	for nr := range beams {
+		if seen[nr] {
+			continue
+		}
+
+		groups++
+		seen[nr] = true
+
+		stack := []int{nr}
+
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			for _, next := range adjacent[cur] {
+				if beams[next] && !seen[next] {
+					seen[next] = true
+					stack = append(stack, next)
+				}
+			}
+		}
+	}
+
+	return groups
+}
+
+// Coincidence applies the multibeam coincidence filter to the candidates.
+// Candidates that are coincident in time (and DM) form an event. An event
+// that is detected in at least opts.MinBeams beams, which form more than
+// opts.MaxGroups groups of adjacent beams, is flagged as RFI, as an
+// astrophysical signal is confined to a compact group of neighbouring
+// beams. The beams are identified by their number in the adjacency graph.
+func Coincidence(cands []Candidate, g *Graph, opts CoincidenceOptions) []Flagged {
+	adjacent := make(map[int][]int)
+
+	for _, e := range g.Edges {
+		a, b := g.Beams[e.A].Nr, g.Beams[e.B].Nr
+		adjacent[a] = append(adjacent[a], b)
+		adjacent[b] = append(adjacent[b], a)
+	}
+
+	result := make([]Flagged, len(cands))
+
+	for id, members := range get_events(cands, opts) {
+		beams := make(map[int]bool)
+		for _, i := range members {
+			beams[cands[i].Beam] = true
+		}
+
+		ngroups := count_groups(beams, adjacent)
+		rfi := len(beams) >= opts.MinBeams && ngroups > opts.MaxGroups
+
+		for _, i := range members {
+			result[i] = Flagged{
+				Candidate: cands[i],
+				Event:     id,
+				NBeams:    len(beams),
+				NGroups:   ngroups,
+				RFI:       rfi,
+			}
+		}
+	}
+
+	return result
+}
+
+// WriteFlagged writes the candidates with the outcome of the coincidence
+// filter to w in the requested format: text, json or csv.
+func WriteFlagged(w io.Writer, cands []Flagged, format string) error {
+	switch format {
+	case "text":
+		for _, f := range cands {
+			_, err := fmt.Fprintf(w, "MJD: %.8f, DM: %.3f, width: %.3f, S/N: %.2f, beam: %d, event: %d, beams: %d, groups: %d, rfi: %t\n",
+				f.MJD, f.DM, f.Width, f.SNR, f.Beam, f.Event, f.NBeams, f.NGroups, f.RFI)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(cands)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"mjd", "dm", "width", "snr", "beam", "event", "nbeams", "ngroups", "rfi"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, f := range cands {
+			writer.Write([]string{
+				format_float(f.MJD),
+				format_float(f.DM),
+				format_float(f.Width),
+				format_float(f.SNR),
+				strconv.Itoa(f.Beam),
+				strconv.Itoa(f.Event),
+				strconv.Itoa(f.NBeams),
+				strconv.Itoa(f.NGroups),
+				strconv.FormatBool(f.RFI),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,148 @@
+package beampack
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// The magic numbers of the compressed input formats.
+var (
+	gzip_magic = []byte{0x1f, 0x8b}
+	zstd_magic = []byte{0x28, 0xb5, 0x2f, 0xfd}
+)
+
+// Strip the compression extension from a file name, so that the format of
+// the contents can be derived from the remaining extension.
+func strip_compression(filename string) string {
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".gz", ".zst":
+		return strings.TrimSuffix(filename, filepath.Ext(filename))
+	}
+
+	return filename
+}
+
+// A reader that decompresses zstd data with the zstd command, as the
+// standard library has no zstd decoder.
+type zstd_reader struct {
+	cmd    *exec.Cmd
+	out    io.ReadCloser
+	stderr bytes.Buffer
+}
+
+func new_zstd_reader(r io.Reader) (*zstd_reader, error) {
+	path, err := exec.LookPath("zstd")
+	if err != nil {
+		return nil, fmt.Errorf("zstd compressed input needs the zstd command: %s", err)
+	}
+
+	z := &zstd_reader{cmd: exec.Command(path, "-d", "-c", "-q")}
+	z.cmd.Stdin = r
+	z.cmd.Stderr = &z.stderr
+
+	if z.out, err = z.cmd.StdoutPipe(); err != nil {
+		return nil, err
+	}
+
+	if err := z.cmd.Start(); err != nil {
+		return nil, err
+	}
+
+	return z, nil
+}
+
+func (z *zstd_reader) Read(p []byte) (int, error) {
+	return z.out.Read(p)
+}
+
+func (z *zstd_reader) Close() error {
+	// drain the output, so that the command can exit
+	io.Copy(io.Discard, z.out)
+
+	if err := z.cmd.Wait(); err != nil {
+		return fmt.Errorf("zstd: %s, %s", err, strings.TrimSpace(z.stderr.String()))
+	}
+
+	return nil
+}
+
+// Wrap the reader in a decompressor if the data starts with the gzip or
+// zstd magic number. Uncompressed data is passed through.
+func decompress(r io.Reader) (io.ReadCloser, error) {
+	br := bufio.NewReader(r)
+
+	magic, _ := br.Peek(len(zstd_magic))
+
+	switch {
+	case bytes.HasPrefix(magic, gzip_magic):
+		return gzip.NewReader(br)
+	case bytes.HasPrefix(magic, zstd_magic):
+		return new_zstd_reader(br)
+	}
+
+	return io.NopCloser(br), nil
+}
+
+// A decompressed input file.
+type input_file struct {
+	io.ReadCloser
+	f *os.File
+}
+
+func (in input_file) Close() error {
+	err := in.ReadCloser.Close()
+	if ferr := in.f.Close(); err == nil {
+		err = ferr
+	}
+
+	return err
+}
+
+// Size returns the size of the file as stored.
+func (in input_file) Size() int64 {
+	info, err := in.f.Stat()
+	if err != nil {
+		return 0
+	}
+
+	return info.Size()
+}
+
+// Open an input file and decompress it transparently if it is gzip or zstd
+// compressed, as detected from its contents.
+func open_input(filename string) (io.ReadCloser, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompress(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	return input_file{r, f}, nil
+}
+
+// Read a whole input file, decompressing it transparently.
+func read_input(filename string) ([]byte, error) {
+	r, err := open_input(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	raw, err := io.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+
+	return raw, err
+}
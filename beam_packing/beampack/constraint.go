@@ -0,0 +1,271 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// LoadConstraints loads the groups of beams that must be packed into the
+// same bunch. Every line lists the beams of one group, given by name or by
+// number and separated by commas or whitespace. In mosaic packings, the
+// names can be qualified by the pointing, e.g. pointing/cfbf00012. Empty lines and lines
+// starting with # are ignored. The groups are returned as beam numbers.
+func LoadConstraints(filename string, beams []Beam) ([][]int, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	var groups [][]int
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		group, err := get_constraint_group(fields, beams, lookup)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, nr, err)
+		}
+
+		groups = append(groups, group)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read constraints: %s", err)
+	}
+
+	return groups, nil
+}
+
+// Get the numbers of the beams of a group given by name or number, without
+// duplicates.
+func get_constraint_group(fields []string, beams []Beam, lookup func(field string) (int, bool)) ([]int, error) {
+	var group []int
+	seen := make(map[int]bool)
+
+	for _, field := range fields {
+		i, ok := lookup(field)
+		if !ok {
+			return nil, fmt.Errorf("unknown beam: %s", field)
+		}
+
+		n := beams[i].Nr
+
+		if !seen[n] {
+			group = append(group, n)
+			seen[n] = true
+		}
+	}
+
+	return group, nil
+}
+
+// ResolveConstraints resolves the groups of beams that must be packed into
+// the same bunch, given by name or by number as in LoadConstraints, to beam
+// numbers.
+func ResolveConstraints(names [][]string, beams []Beam) ([][]int, error) {
+	lookup := get_beam_lookup(beams)
+	groups := make([][]int, 0, len(names))
+
+	for _, fields := range names {
+		group, err := get_constraint_group(fields, beams, lookup)
+		if err != nil {
+			return nil, err
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// Check that every pinned beam is part of at most one group and return the
+// group index of the pinned beams.
+func check_constraints(pinned [][]int) (map[int]int, error) {
+	owner := make(map[int]int)
+
+	for i, group := range pinned {
+		for _, n := range group {
+			if j, ok := owner[n]; ok && j != i {
+				return nil, unsatisfiable("Beam pinned in more than one group: %d", n)
+			}
+
+			owner[n] = i
+		}
+	}
+
+	return owner, nil
+}
+
+// Move the beams of every pinned group into a common bunch. The target
+// bunch is the one that holds most of the group already, or the closest one
+// with enough space. The beams are swapped with the unpinned members of the
+// target bunch that are farthest from the group, so that the bunch sizes do
+// not change. The modified bunches are ranked again.
+func apply_constraints(groups [][]Beam, pinned [][]int, dist DistanceFunc) error {
+	owner, err := check_constraints(pinned)
+	if err != nil {
+		return err
+	}
+
+	// the bunch and slot of every beam
+	type location struct {
+		group int
+		slot  int
+	}
+
+	where := make(map[int]location)
+	for g, members := range groups {
+		for s, beam := range members {
+			where[beam.Nr] = location{g, s}
+		}
+	}
+
+	for _, group := range pinned {
+		for _, n := range group {
+			if _, ok := where[n]; !ok {
+				return unsatisfiable("Pinned beam is not packed: %d", n)
+			}
+		}
+	}
+
+	// place the largest groups first
+	order := make([]int, len(pinned))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return len(pinned[order[i]]) > len(pinned[order[j]])
+	})
+
+	// the number of beams of placed groups in every bunch
+	locked := make([]int, len(groups))
+	placed := make(map[int]bool)
+	modified := make(map[int]bool)
+
+	for _, c := range order {
+		group := pinned[c]
+		if len(group) == 0 {
+			continue
+		}
+
+		// the centroid of the group and the number of members per bunch
+		var cx, cy float64
+		count := make(map[int]int)
+
+		for _, n := range group {
+			loc := where[n]
+			beam := groups[loc.group][loc.slot]
+			cx += beam.X
+			cy += beam.Y
+			count[loc.group]++
+		}
+
+		cx /= float64(len(group))
+		cy /= float64(len(group))
+
+		target := -1
+		var bestcount int
+		var bestdist float64
+
+		for g, members := range groups {
+			if len(members)-locked[g] < len(group) {
+				continue
+			}
+
+			mx, my := get_centroid(members)
+			d := dist(cx, cy, mx, my)
+
+			if target < 0 || count[g] > bestcount || (count[g] == bestcount && d < bestdist) {
+				target, bestcount, bestdist = g, count[g], d
+			}
+		}
+
+		if target < 0 {
+			return unsatisfiable("No bunch with space for the pinned beams: %v", group)
+		}
+
+		for _, n := range group {
+			from := where[n]
+			if from.group == target {
+				continue
+			}
+
+			// the unpinned member of the target farthest from the group
+			swap := -1
+			var farthest float64
+
+			for s, beam := range groups[target] {
+				if placed[beam.Nr] {
+					continue
+				}
+
+				if i, ok := owner[beam.Nr]; ok && i == c {
+					continue
+				}
+
+				d := dist(cx, cy, beam.X, beam.Y)
+				if swap < 0 || d > farthest {
+					swap, farthest = s, d
+				}
+			}
+
+			other := groups[target][swap]
+
+			groups[target][swap], groups[from.group][from.slot] = groups[from.group][from.slot], other
+			where[n] = location{target, swap}
+			where[other.Nr] = from
+
+			modified[target] = true
+			modified[from.group] = true
+		}
+
+		for _, n := range group {
+			placed[n] = true
+		}
+
+		locked[target] += len(group)
+	}
+
+	for g := range modified {
+		rank_groups(groups[g:g+1], dist)
+	}
+
+	return nil
+}
+
+// Get the beams that must not be moved by the optimizers, which are the
+// pinned, the dummy and the incoherent beams, which stay in their own bunch.
+func get_fixed(beams []Beam, pinned [][]int) []bool {
+	set := make(map[int]bool)
+	for _, group := range pinned {
+		for _, n := range group {
+			set[n] = true
+		}
+	}
+
+	fixed := make([]bool, len(beams))
+	for i, beam := range beams {
+		fixed[i] = set[beam.Nr] || beam.Dummy || beam.Incoherent
+	}
+
+	return fixed
+}
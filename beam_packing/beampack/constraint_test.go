@@ -0,0 +1,50 @@
+package beampack
+
+import (
+	"math/rand"
+	"testing"
+)
+
+// The optimizers keep the incoherent beam in its own bunch, on its node
+// with the pin policy.
+func TestOptimizeIncoherent(t *testing.T) {
+	beams := get_test_tiling(t, 60)
+	beams = append(beams, Beam{Nr: len(beams), Name: "ifbf00000", X: 134.0696, Y: -30, Incoherent: true})
+
+	optimizers := map[string]func(*Packing, *rand.Rand) *Packing{
+		"anneal": func(p *Packing, rng *rand.Rand) *Packing {
+			return Anneal(p, 20000, Euclidean, rng)
+		},
+		"ga": func(p *Packing, rng *rand.Rand) *Packing {
+			return Evolve(p, GAOptions{Population: 20, Generations: 50}, Euclidean, rng)
+		},
+	}
+
+	for _, policy := range []string{"bunch", "pin"} {
+		p, err := Pack(beams, Options{Bunch: 6, Method: "greedy", Incoherent: policy, IncoherentNode: "n9"})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for name, optimize := range optimizers {
+			q := optimize(p, rand.New(rand.NewSource(3)))
+
+			last := q.Bunches[len(q.Bunches)-1]
+			if len(last.Beams) != 1 || !last.Beams[0].Incoherent {
+				t.Errorf("%s, %s: wrong incoherent beam bunch: %v", policy, name, last.Beams)
+			}
+
+			if policy == "pin" && last.Node != "n9" {
+				t.Errorf("%s, %s: incoherent beam bunch on node %q", policy, name, last.Node)
+			}
+
+			for _, b := range q.Bunches[:len(q.Bunches)-1] {
+				for _, beam := range b.Beams {
+					if beam.Incoherent {
+						t.Errorf("%s, %s: incoherent beam in bunch %d", policy, name, b.ID)
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,296 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// The maximum number of grid points at which the coverage is evaluated.
+const max_coverage_points = 1000000
+
+// CoverageOptions configure the coverage-optimized tiling. If the overlap
+// level of the tiling is zero, the spacing of the beams is optimized.
+type CoverageOptions struct {
+	TileOptions
+	// The primary beam. The target region is the one in which its response
+	// is at least the level.
+	PrimaryBeam PrimaryBeam
+	PBLevel     float64
+	// The response of a coherent beam, relative to its peak, at which a
+	// position counts as covered.
+	BeamLevel float64
+	// The requested fraction of the target region that is covered. It is
+	// ignored if the number of beams is given.
+	Coverage float64
+}
+
+// CoveragePoint is the coverage of the target region by the first beams of
+// the tiling.
+type CoveragePoint struct {
+	NBeams   int
+	Coverage float64
+}
+
+// CoverageTiling is a tiling of the target region with the least number of
+// beams that reaches the requested coverage.
+type CoverageTiling struct {
+	Beams []Beam
+	// The radius of the target region in degrees.
+	Radius float64
+	// The overlap level of the tiling.
+	Overlap float64
+	// The coverage reached by the beams.
+	Coverage float64
+	// The coverage as function of the number of beams.
+	Curve []CoveragePoint
+}
+
+// Get the offset from the pointing centre in degrees at which the response
+// of the primary beam drops to the level, inside its first null.
+func get_level_radius(pb PrimaryBeam, level float64) float64 {
+	lo, hi := 0.0, pb.FWHM
+
+	// the first null of the cosine model is at 1.26 times the full width,
+	// and the Gaussian falls below any level eventually
+	for pb.Model != "cosine" && pb.Response(hi) > level {
+		hi *= 2
+	}
+
+	for i := 0; i < 60; i++ {
+		mid := (lo + hi) / 2
+
+		if pb.Response(mid) >= level {
+			lo = mid
+		} else {
+			hi = mid
+		}
+	}
+
+	return lo
+}
+
+// Get the radius of the contour of a Gaussian beam at the relative power
+// level in units of the half-power radius.
+func get_contour_radius(level float64) float64 {
+	return math.Sqrt(math.Log(1/level) / math.Log(2))
+}
+
+// TileCoverage computes the hexagonal tiling with the least number of
+// coherent beams that covers the requested fraction of the region in which
+// the primary beam response is at least the level, e.g. its half-power
+// area. A position is covered if the response of a coherent beam there is
+// at least the beam level. Unless the overlap level is given, the spacing
+// of the beams is chosen from a range that spans both dense tilings, which
+// cover the region without gaps, and sparse ones, which cover lower
+// fractions with fewer beams. If the number of beams is given, it
+// maximizes the coverage of that many beams instead, which allows to plan
+// the trade-off between the number of beams and the coverage.
+func TileCoverage(opts CoverageOptions) (*CoverageTiling, error) {
+	if opts.SemiMajor <= 0 || opts.SemiMinor <= 0 {
+		return nil, fmt.Errorf("The beam semi-axes must be positive: %g, %g", opts.SemiMajor, opts.SemiMinor)
+	}
+
+	if opts.Overlap < 0 || opts.Overlap >= 1 {
+		return nil, fmt.Errorf("The overlap level must be between 0 and 1: %g", opts.Overlap)
+	}
+
+	for _, level := range []float64{opts.PBLevel, opts.BeamLevel} {
+		if level <= 0 || level >= 1 {
+			return nil, fmt.Errorf("The sensitivity level must be between 0 and 1: %g", level)
+		}
+	}
+
+	if opts.NBeams <= 0 && (opts.Coverage <= 0 || opts.Coverage > 1) {
+		return nil, fmt.Errorf("The coverage fraction must be between 0 and 1: %g", opts.Coverage)
+	}
+
+	if opts.PrimaryBeam.FWHM <= 0 {
+		return nil, fmt.Errorf("The primary beam width must be positive: %g", opts.PrimaryBeam.FWHM)
+	}
+
+	if opts.Overlap > 0 {
+		return tile_coverage(opts)
+	}
+
+	// the overlap contours at 0.87 times the coverage contours touch for
+	// gapless coverage, the ones at up to twice the radius cover less
+	rho := get_contour_radius(opts.BeamLevel)
+
+	var best *CoverageTiling
+
+	for f := 0.5; f <= 2+1e-9; f += 0.05 {
+		o := opts
+		o.Overlap = math.Pow(2, -rho*rho*f*f)
+
+		t, err := tile_coverage(o)
+		if err != nil {
+			continue
+		}
+
+		switch {
+		case best == nil:
+			best = t
+		case opts.NBeams > 0 && t.Coverage > best.Coverage:
+			best = t
+		case opts.NBeams <= 0 && len(t.Beams) < len(best.Beams):
+			best = t
+		}
+	}
+
+	if best == nil {
+		return nil, fmt.Errorf("Could not reach the coverage: %g", opts.Coverage)
+	}
+
+	return best, nil
+}
+
+// Compute the tiling with the least number of beams that reaches the
+// coverage for the overlap level of the options. The beams are added in
+// order of increasing offset from the boresight, and the coverage is
+// evaluated on a grid with a spacing of a fraction of the beam size.
+func tile_coverage(opts CoverageOptions) (*CoverageTiling, error) {
+	const deg = math.Pi / 180.0
+
+	radius := get_level_radius(opts.PrimaryBeam, opts.PBLevel)
+	a, b := opts.SemiMajor, opts.SemiMinor
+
+	// the radii of the overlap and coverage contours in units of the
+	// half-power radius
+	rho := get_contour_radius(opts.Overlap)
+	cover := get_contour_radius(opts.BeamLevel)
+
+	// enough beams to cover the region and its margin, from the area of
+	// the hexagonal cells in units of the beam axes. The tiling is ordered
+	// by the offset in these units, so that the region must fit along the
+	// minor axis.
+	cell := 2 * math.Sqrt(3) * rho * rho
+	margin := (radius + 2*max(rho, cover)*a) / b
+	n := int(math.Ceil(1.2*math.Pi*margin*margin/cell)) + 7
+
+	tiling := opts.TileOptions
+	tiling.NBeams = max(n, opts.NBeams)
+
+	beams, err := Tile(tiling)
+	if err != nil {
+		return nil, err
+	}
+
+	scale := math.Cos(opts.Y0 * deg)
+
+	// the offsets of the beams from the boresight as true angles
+	offset := func(beam Beam) (float64, float64) {
+		return (beam.X - opts.X0) * scale, beam.Y - opts.Y0
+	}
+
+	sort.SliceStable(beams, func(i, j int) bool {
+		xi, yi := offset(beams[i])
+		xj, yj := offset(beams[j])
+
+		return math.Hypot(xi, yi) < math.Hypot(xj, yj)-1e-12
+	})
+
+	// the grid over the target region, which is offset from the boresight
+	// beam so that it does not align with the tiling
+	step := b * cover / 8
+	if points := math.Pi * radius * radius / (step * step); points > max_coverage_points {
+		step *= math.Sqrt(points / max_coverage_points)
+	}
+
+	ngrid := int(math.Ceil(radius/step)) + 1
+	width := 2*ngrid + 1
+
+	grid := func(i int) float64 {
+		return (float64(i-ngrid) + 0.37) * step
+	}
+
+	inside := make([]bool, width*width)
+	covered := make([]bool, width*width)
+	var total int
+
+	for j := 0; j < width; j++ {
+		for i := 0; i < width; i++ {
+			x, y := grid(i), grid(j)
+
+			if math.Hypot(x, y) <= radius {
+				inside[j*width+i] = true
+				total++
+			}
+		}
+	}
+
+	if total == 0 {
+		return nil, fmt.Errorf("The target region is empty.")
+	}
+
+	sinpa, cospa := math.Sincos(opts.PA * deg)
+	extent := cover * a
+
+	result := &CoverageTiling{Radius: radius, Overlap: opts.Overlap}
+	var count int
+
+	for k, beam := range beams {
+		bx, by := offset(beam)
+
+		i0 := max(int(math.Floor((bx-extent)/step))+ngrid-1, 0)
+		i1 := min(int(math.Ceil((bx+extent)/step))+ngrid, width-1)
+		j0 := max(int(math.Floor((by-extent)/step))+ngrid-1, 0)
+		j1 := min(int(math.Ceil((by+extent)/step))+ngrid, width-1)
+
+		for j := j0; j <= j1; j++ {
+			for i := i0; i <= i1; i++ {
+				g := j*width + i
+				if !inside[g] || covered[g] {
+					continue
+				}
+
+				dx, dy := grid(i)-bx, grid(j)-by
+
+				// the offset along the minor and major axes
+				u := dx*cospa - dy*sinpa
+				v := dx*sinpa + dy*cospa
+
+				if (u/b)*(u/b)+(v/a)*(v/a) <= cover*cover {
+					covered[g] = true
+					count++
+				}
+			}
+		}
+
+		coverage := float64(count) / float64(total)
+		result.Curve = append(result.Curve, CoveragePoint{k + 1, coverage})
+
+		if (opts.NBeams > 0 && k+1 == opts.NBeams) || (opts.NBeams <= 0 && coverage >= opts.Coverage) {
+			result.Coverage = coverage
+			result.Beams = beams[:k+1]
+			break
+		}
+	}
+
+	if result.Beams == nil {
+		return nil, fmt.Errorf("Could not reach the coverage: %g", opts.Coverage)
+	}
+
+	for i := range result.Beams {
+		result.Beams[i].Nr = i
+		result.Beams[i].Name = BeamName(i)
+	}
+
+	return result, nil
+}
+
+// WriteCoverageCurve writes the coverage of the target region as function
+// of the number of beams to w, one number of beams per line.
+func WriteCoverageCurve(w io.Writer, t *CoverageTiling) error {
+	fmt.Fprintf(w, "# radius: %.6f, overlap: %.4f, beams: %d, coverage: %.6f\n", t.Radius, t.Overlap, len(t.Beams), t.Coverage)
+	fmt.Fprintf(w, "# nbeams coverage\n")
+
+	for _, p := range t.Curve {
+		if _, err := fmt.Fprintf(w, "%d %.6f\n", p.NBeams, p.Coverage); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,134 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Move is a beam that changed bunch between two packings.
+type Move struct {
+	Name string
+	From int
+	To   int
+}
+
+// NodeChange is a bunch that changed processing node between two packings.
+type NodeChange struct {
+	Bunch int
+	From  string
+	To    string
+}
+
+// Diff holds the differences between two packings.
+type Diff struct {
+	Moved   []Move
+	Nodes   []NodeChange
+	Added   []string
+	Removed []string
+	// The number of beams present in both packings.
+	Common int
+}
+
+// Churn returns the percentage of the common beams that changed bunch.
+func (d Diff) Churn() float64 {
+	if d.Common == 0 {
+		return 0
+	}
+
+	return 100 * float64(len(d.Moved)) / float64(d.Common)
+}
+
+// Compare two packings given by their records. The beams are matched by
+// name, qualified by the pointing in mosaic packings, and the bunches by
+// their IDs.
+func Compare(old, new []Record) Diff {
+	var d Diff
+
+	before := make(map[string]Record)
+	for _, rec := range old {
+		before[rec.key()] = rec
+	}
+
+	after := make(map[string]Record)
+	for _, rec := range new {
+		after[rec.key()] = rec
+	}
+
+	for _, rec := range new {
+		prev, ok := before[rec.key()]
+		if !ok {
+			d.Added = append(d.Added, rec.key())
+			continue
+		}
+
+		d.Common++
+
+		if prev.Bunch != rec.Bunch {
+			d.Moved = append(d.Moved, Move{Name: rec.key(), From: prev.Bunch, To: rec.Bunch})
+		}
+	}
+
+	for _, rec := range old {
+		if _, ok := after[rec.key()]; !ok {
+			d.Removed = append(d.Removed, rec.key())
+		}
+	}
+
+	// the node of every bunch
+	get_nodes := func(records []Record) map[int]string {
+		nodes := make(map[int]string)
+		for _, rec := range records {
+			nodes[rec.Bunch] = rec.Node
+		}
+
+		return nodes
+	}
+
+	oldnodes := get_nodes(old)
+	newnodes := get_nodes(new)
+
+	for bunch, node := range newnodes {
+		if prev, ok := oldnodes[bunch]; ok && prev != node {
+			d.Nodes = append(d.Nodes, NodeChange{Bunch: bunch, From: prev, To: node})
+		}
+	}
+
+	sort.Slice(d.Nodes, func(i, j int) bool {
+		return d.Nodes[i].Bunch < d.Nodes[j].Bunch
+	})
+
+	return d
+}
+
+// WriteDiff writes the differences between two packings to w.
+func WriteDiff(w io.Writer, d Diff) error {
+	label := func(node string) string {
+		if node == "" {
+			return "-"
+		}
+
+		return node
+	}
+
+	for _, m := range d.Moved {
+		fmt.Fprintf(w, "Beam: %s, bunch: %d -> %d\n", m.Name, m.From, m.To)
+	}
+
+	for _, c := range d.Nodes {
+		fmt.Fprintf(w, "Bunch: %d, node: %s -> %s\n", c.Bunch, label(c.From), label(c.To))
+	}
+
+	for _, name := range d.Added {
+		fmt.Fprintf(w, "Beam: %s, added\n", name)
+	}
+
+	for _, name := range d.Removed {
+		fmt.Fprintf(w, "Beam: %s, removed\n", name)
+	}
+
+	_, err := fmt.Fprintf(w, "\nBeams: %d, moved: %d, added: %d, removed: %d, bunches changed node: %d, churn: %.2f%%\n",
+		d.Common, len(d.Moved), len(d.Added), len(d.Removed), len(d.Nodes), d.Churn())
+
+	return err
+}
@@ -0,0 +1,305 @@
+package beampack
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+)
+
+// The magic number at the start of the distance matrix files.
+const distances_magic = "BPDIST1\n"
+
+// The maximum number of beams whose distance matrix is cached, which then
+// takes 1 GiB.
+const max_cached_beams = 16384
+
+// DistanceMatrix holds the pairwise distances between beams, so that an
+// expensive metric is computed only once per pair.
+type DistanceMatrix struct {
+	// The beam positions, in the order of the matrix rows.
+	X, Y []float64
+	// The upper triangle of the matrix without the diagonal, by rows.
+	values []float64
+	// The open-addressing hash table of the rows of the beam positions by
+	// the bits of their coordinates, with -1 for empty slots. It is much
+	// faster than a map, whose hashing of floats would cost about as much as
+	// the metric.
+	keys  [][2]uint64
+	slots []int32
+	shift uint
+}
+
+// Get the index of the distance between the beams i < j in the upper
+// triangle.
+func (m *DistanceMatrix) offset(i, j int) int {
+	n := len(m.X)
+	return i*(2*n-i-1)/2 + j - i - 1
+}
+
+// Get the slot of a position in the hash table.
+func (m *DistanceMatrix) hash(key [2]uint64) int {
+	h := (key[0]*0x9e3779b97f4a7c15 ^ key[1]) * 0xbf58476d1ce4e5b9
+	return int(h >> m.shift)
+}
+
+// Build the hash table of the beam positions. Duplicate positions map to
+// the first beam.
+func (m *DistanceMatrix) build_index() {
+	size, bits := 1, uint(0)
+	for size < 2*len(m.X) {
+		size, bits = size*2, bits+1
+	}
+
+	m.keys = make([][2]uint64, size)
+	m.slots = make([]int32, size)
+	m.shift = 64 - bits
+
+	for i := range m.slots {
+		m.slots[i] = -1
+	}
+
+	for i := range m.X {
+		key := [2]uint64{math.Float64bits(m.X[i]), math.Float64bits(m.Y[i])}
+
+		k := m.hash(key)
+		for m.slots[k] >= 0 && m.keys[k] != key {
+			k = (k + 1) & (size - 1)
+		}
+
+		if m.slots[k] < 0 {
+			m.keys[k], m.slots[k] = key, int32(i)
+		}
+	}
+}
+
+// Get the row of a position, or -1 if it is not a beam of the matrix.
+func (m *DistanceMatrix) row(x, y float64) int {
+	key := [2]uint64{math.Float64bits(x), math.Float64bits(y)}
+
+	for k := m.hash(key); m.slots[k] >= 0; k = (k + 1) & (len(m.slots) - 1) {
+		if m.keys[k] == key {
+			return int(m.slots[k])
+		}
+	}
+
+	return -1
+}
+
+// ComputeDistances computes the matrix of the pairwise distances between
+// the beams with the metric, in parallel on all cores.
+func ComputeDistances(beams []Beam, dist DistanceFunc) *DistanceMatrix {
+	n := len(beams)
+
+	m := &DistanceMatrix{X: make([]float64, n), Y: make([]float64, n)}
+	for i, beam := range beams {
+		m.X[i], m.Y[i] = beam.X, beam.Y
+	}
+
+	m.values = make([]float64, n*(n-1)/2)
+
+	var wg sync.WaitGroup
+	rows := make(chan int)
+
+	for w := 0; w < runtime.NumCPU(); w++ {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range rows {
+				k := m.offset(i, i+1)
+				for j := i + 1; j < n; j++ {
+					m.values[k] = dist(m.X[i], m.Y[i], m.X[j], m.Y[j])
+					k++
+				}
+			}
+		}()
+	}
+
+	for i := 0; i < n; i++ {
+		rows <- i
+	}
+
+	close(rows)
+	wg.Wait()
+
+	m.build_index()
+
+	return m
+}
+
+// Look the distance between two positions up, or compute it with the
+// metric if either is not a beam of the matrix.
+func (m *DistanceMatrix) lookup(x1, y1, x2, y2 float64, dist DistanceFunc) float64 {
+	i := m.row(x1, y1)
+	if i < 0 {
+		return dist(x1, y1, x2, y2)
+	}
+
+	j := m.row(x2, y2)
+	if j < 0 || i == j {
+		return dist(x1, y1, x2, y2)
+	}
+
+	if i > j {
+		i, j = j, i
+	}
+
+	return m.values[m.offset(i, j)]
+}
+
+// Get the distance function of the angular metric looked up in the matrix.
+// All such functions are recognised as angular metric, so that the KD-tree
+// lookups still apply to them.
+func cached_angular(m *DistanceMatrix) DistanceFunc {
+	return func(x1, y1, x2, y2 float64) float64 {
+		return m.lookup(x1, y1, x2, y2, Angular)
+	}
+}
+
+// Get the distance function that looks the distances up in the matrix, and
+// computes the ones of other positions, e.g. projected beams or centroids,
+// with the metric.
+func (m *DistanceMatrix) Distance(dist DistanceFunc) DistanceFunc {
+	if reflect_equal(dist, Angular) {
+		return cached_angular(m)
+	}
+
+	return func(x1, y1, x2, y2 float64) float64 {
+		return m.lookup(x1, y1, x2, y2, dist)
+	}
+}
+
+// DistanceKey returns the key of the distance matrix of the beams with the
+// metric, which is the hash of the metric description and of the beam
+// positions in their order.
+func DistanceKey(beams []Beam, metric string) string {
+	h := sha256.New()
+	io.WriteString(h, metric+"\n")
+
+	var buf [16]byte
+	for _, beam := range beams {
+		binary.LittleEndian.PutUint64(buf[:8], math.Float64bits(beam.X))
+		binary.LittleEndian.PutUint64(buf[8:], math.Float64bits(beam.Y))
+		h.Write(buf[:])
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ReadDistances reads a distance matrix from a binary file, as written by
+// WriteDistances.
+func ReadDistances(filename string) (*DistanceMatrix, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	defer f.Close()
+
+	r := bufio.NewReaderSize(f, 1<<20)
+
+	magic := make([]byte, len(distances_magic))
+	var n uint64
+
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != distances_magic {
+		return nil, fmt.Errorf("Not a distance matrix file: %s", filename)
+	}
+
+	if err := binary.Read(r, binary.LittleEndian, &n); err != nil || n > max_cached_beams {
+		return nil, fmt.Errorf("Invalid distance matrix file: %s", filename)
+	}
+
+	m := &DistanceMatrix{X: make([]float64, n), Y: make([]float64, n), values: make([]float64, n*(n-1)/2)}
+
+	for _, data := range [][]float64{m.X, m.Y, m.values} {
+		if err := binary.Read(r, binary.LittleEndian, data); err != nil {
+			return nil, fmt.Errorf("Could not read distance matrix: %s, %s", filename, err)
+		}
+	}
+
+	m.build_index()
+
+	return m, nil
+}
+
+// WriteDistances writes the distance matrix to a binary file: the magic
+// number, the number of beams, their x and y coordinates and the upper
+// triangle of the matrix as little-endian numbers. The file is replaced
+// atomically, so that concurrent runs never read a partial file, and is
+// readable by all users.
+func WriteDistances(filename string, m *DistanceMatrix) error {
+	tmp, err := os.CreateTemp(filepath.Dir(filename), filepath.Base(filename)+".*")
+	if err != nil {
+		return fmt.Errorf("Could not create distance matrix: %s, %s", filename, err)
+	}
+
+	w := bufio.NewWriterSize(tmp, 1<<20)
+
+	io.WriteString(w, distances_magic)
+	binary.Write(w, binary.LittleEndian, uint64(len(m.X)))
+
+	for _, data := range [][]float64{m.X, m.Y, m.values} {
+		binary.Write(w, binary.LittleEndian, data)
+	}
+
+	err = w.Flush()
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+
+	// the temporary file is private, but the cache is shared between users
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Could not write distance matrix: %s, %s", filename, err)
+	}
+
+	return os.Rename(tmp.Name(), filename)
+}
+
+// CachedDistance returns the distance function of the metric that looks
+// the distances between the beams up in their distance matrix, which is read
+// from the cache directory if it was stored there by an earlier run on the
+// same beam positions with the same metric, or computed and stored
+// otherwise. The metric description must identify the metric with all its
+// parameters. It also returns whether the matrix was read from the cache.
+// Layouts of more than 16384 beams are not cached.
+func CachedDistance(dir, metric string, beams []Beam, dist DistanceFunc) (DistanceFunc, bool, error) {
+	if len(beams) < 2 || len(beams) > max_cached_beams {
+		return dist, false, nil
+	}
+
+	filename := filepath.Join(dir, DistanceKey(beams, metric)+".dist")
+
+	m, err := ReadDistances(filename)
+	if err == nil && len(m.X) == len(beams) {
+		return m.Distance(dist), true, nil
+	}
+
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("Ignoring the invalid distance matrix in the cache", "file", filename, "error", err)
+	}
+
+	m = ComputeDistances(beams, dist)
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return m.Distance(dist), false, fmt.Errorf("Could not create cache directory: %s, %s", dir, err)
+	}
+
+	return m.Distance(dist), false, WriteDistances(filename, m)
+}
@@ -0,0 +1,100 @@
+package beampack
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// A distance matrix read back from its file gives the distances of the
+// metric, for the beams and for other positions.
+func TestDistancesRoundTrip(t *testing.T) {
+	beams := get_test_tiling(t, 50)
+
+	filename := filepath.Join(t.TempDir(), "test.dist")
+	if err := WriteDistances(filename, ComputeDistances(beams, Angular)); err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := ReadDistances(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(m.X) != len(beams) {
+		t.Fatalf("wrong number of beams: %d", len(m.X))
+	}
+
+	dist := m.Distance(Angular)
+
+	for i := range beams {
+		for j := range beams {
+			a, b := beams[i], beams[j]
+			if got, want := dist(a.X, a.Y, b.X, b.Y), Angular(a.X, a.Y, b.X, b.Y); got != want {
+				t.Fatalf("wrong distance between beams %d and %d: %g, %g", i, j, got, want)
+			}
+		}
+	}
+
+	// a position that is not a beam is computed with the metric
+	x, y := beams[0].X+0.003, beams[0].Y-0.002
+	if got, want := dist(x, y, beams[1].X, beams[1].Y), Angular(x, y, beams[1].X, beams[1].Y); got != want {
+		t.Errorf("wrong distance to another position: %g, %g", got, want)
+	}
+
+	// the cache files are shared between the users of the pipeline
+	info, err := os.Stat(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Mode().Perm() != 0644 {
+		t.Errorf("wrong file mode: %s", info.Mode())
+	}
+}
+
+// The distance matrix is computed on the first run and read from the cache
+// on the next one with the same beams and metric only.
+func TestCachedDistance(t *testing.T) {
+	beams := get_test_tiling(t, 40)
+	dir := filepath.Join(t.TempDir(), "cache")
+
+	for i, want := range []bool{false, true} {
+		dist, cached, err := CachedDistance(dir, "euclidean", beams, Euclidean)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if cached != want {
+			t.Errorf("run %d: wrong cache use: %t", i, cached)
+		}
+
+		a, b := beams[3], beams[17]
+		if got, want := dist(a.X, a.Y, b.X, b.Y), Euclidean(a.X, a.Y, b.X, b.Y); got != want {
+			t.Errorf("run %d: wrong distance: %g, %g", i, got, want)
+		}
+	}
+
+	// another metric or other beams have their own matrix
+	if _, cached, err := CachedDistance(dir, "angular", beams, Angular); err != nil || cached {
+		t.Errorf("matrix of another metric from the cache: %t, %v", cached, err)
+	}
+
+	if _, cached, err := CachedDistance(dir, "euclidean", beams[:39], Euclidean); err != nil || cached {
+		t.Errorf("matrix of other beams from the cache: %t, %v", cached, err)
+	}
+
+	// an invalid file is ignored and replaced
+	filename := filepath.Join(dir, DistanceKey(beams, "euclidean")+".dist")
+	if err := os.WriteFile(filename, []byte("invalid"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, cached, err := CachedDistance(dir, "euclidean", beams, Euclidean); err != nil || cached {
+		t.Errorf("invalid matrix from the cache: %t, %v", cached, err)
+	}
+
+	if _, err := ReadDistances(filename); err != nil {
+		t.Errorf("invalid matrix not replaced: %s", err)
+	}
+}
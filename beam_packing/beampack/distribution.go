@@ -0,0 +1,125 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// Bin is a histogram bin of the intra-bunch separations, from Lo up to
+// excluding Hi, except for the last bin, which includes Hi.
+type Bin struct {
+	Lo    float64
+	Hi    float64
+	Count int
+}
+
+// Separations computes all intra-bunch pairwise separations of the coherent
+// beams of the packing in ascending order. The incoherent and dummy beams,
+// which have no meaningful sky position, are left out.
+func Separations(p *Packing, dist DistanceFunc) []float64 {
+	var seps []float64
+
+	for _, b := range p.Bunches {
+		var members []Beam
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				members = append(members, beam)
+			}
+		}
+
+		for i := range members {
+			for j := i + 1; j < len(members); j++ {
+				seps = append(seps, dist(members[i].X, members[i].Y, members[j].X, members[j].Y))
+			}
+		}
+	}
+
+	sort.Float64s(seps)
+
+	return seps
+}
+
+// Percentile computes the q-th percentile (0 to 100) of the sorted values
+// with linear interpolation between the closest ranks.
+func Percentile(sorted []float64, q float64) float64 {
+	if len(sorted) == 0 {
+		return 0
+	}
+
+	pos := math.Max(0, math.Min(q, 100)) / 100 * float64(len(sorted)-1)
+	i := int(pos)
+
+	if i >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+
+	frac := pos - float64(i)
+
+	return sorted[i] + frac*(sorted[i+1]-sorted[i])
+}
+
+// Histogram bins the sorted values into nbins bins of equal width between
+// zero and the largest value.
+func Histogram(sorted []float64, nbins int) []Bin {
+	if len(sorted) == 0 || nbins <= 0 {
+		return nil
+	}
+
+	width := sorted[len(sorted)-1] / float64(nbins)
+	if width == 0 {
+		width = 1
+	}
+
+	bins := make([]Bin, nbins)
+	for i := range bins {
+		bins[i] = Bin{Lo: float64(i) * width, Hi: float64(i+1) * width}
+	}
+
+	for _, v := range sorted {
+		i := min(int(v/width), nbins-1)
+		bins[i].Count++
+	}
+
+	return bins
+}
+
+// The percentiles listed with the separation distribution.
+var distribution_percentiles = []float64{50, 90, 95, 99}
+
+// WriteSeparations writes the distribution of the sorted intra-bunch
+// separations: the percentiles as # comment lines, followed by the
+// histogram with the bin edges, counts and cumulative fraction, or by the
+// raw values, one per line, if nbins is zero.
+func WriteSeparations(w io.Writer, sorted []float64, nbins int) error {
+	fmt.Fprintf(w, "# pairs: %d\n", len(sorted))
+
+	for _, q := range distribution_percentiles {
+		fmt.Fprintf(w, "# p%g: %.6f\n", q, Percentile(sorted, q))
+	}
+
+	if nbins <= 0 {
+		for _, v := range sorted {
+			if _, err := fmt.Fprintf(w, "%.6f\n", v); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	fmt.Fprintf(w, "# %10s %12s %8s %10s\n", "lo", "hi", "count", "cumulative")
+
+	var total int
+
+	for _, bin := range Histogram(sorted, nbins) {
+		total += bin.Count
+
+		if _, err := fmt.Fprintf(w, "  %10.6f %12.6f %8d %10.4f\n", bin.Lo, bin.Hi, bin.Count, float64(total)/float64(len(sorted))); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,380 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// DMStep is one range of the dedispersion plan, searched with a constant
+// DM step and downsampling in time.
+type DMStep struct {
+	Start      float64 `json:"start"`
+	End        float64 `json:"end"`
+	Step       float64 `json:"step"`
+	Downsample int     `json:"downsample"`
+}
+
+// Trials returns the number of DM trials of the step, at Start, Start +
+// Step and so on below End.
+func (s DMStep) Trials() int {
+	return int(math.Ceil((s.End-s.Start)/s.Step - 1e-9))
+}
+
+// Get the DM of the trial, rounded to suppress the accumulated errors of
+// decimal steps.
+func (s DMStep) dm(trial int) float64 {
+	return math.Round((s.Start+float64(trial)*s.Step)*1e9) / 1e9
+}
+
+// LoadDMPlan reads the dedispersion plan from a text file with one step per
+// line: the start and end DM, the DM step and optionally the downsampling
+// factor, which defaults to 1. Lines starting with # are comments. The
+// steps must be in order and must not overlap.
+func LoadDMPlan(filename string) ([]DMStep, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	var plan []DMStep
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(fields) < 3 || len(fields) > 4 {
+			return nil, fmt.Errorf("%s:%d: expected start, end, step and downsampling", filename, nr)
+		}
+
+		var values [3]float64
+		for i := range values {
+			values[i], err = strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid number: %s", filename, nr, fields[i])
+			}
+		}
+
+		s := DMStep{Start: values[0], End: values[1], Step: values[2], Downsample: 1}
+
+		if len(fields) == 4 {
+			s.Downsample, err = strconv.Atoi(fields[3])
+			if err != nil || s.Downsample < 1 {
+				return nil, fmt.Errorf("%s:%d: invalid downsampling: %s", filename, nr, fields[3])
+			}
+		}
+
+		if s.Start < 0 || s.End <= s.Start || s.Step <= 0 {
+			return nil, fmt.Errorf("%s:%d: invalid DM range: %s", filename, nr, line)
+		}
+
+		if len(plan) > 0 && s.Start < plan[len(plan)-1].End {
+			return nil, fmt.Errorf("%s:%d: overlapping DM range: %s", filename, nr, line)
+		}
+
+		plan = append(plan, s)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read DM plan: %s", err)
+	}
+
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("Empty DM plan: %s", filename)
+	}
+
+	return plan, nil
+}
+
+// DMScheduleOptions configure the allocation of the DM trials.
+type DMScheduleOptions struct {
+	// The number of dedispersion workers, e.g. GPUs, per node, defaults to 1.
+	Workers int
+}
+
+// DMTask is a contiguous block of DM trials of one step of the plan that a
+// worker searches for the beams of a bunch.
+type DMTask struct {
+	Bunch int      `json:"bunch"`
+	Beams []string `json:"beams"`
+	// The DMs of the first and last trial.
+	DMStart    float64 `json:"dm_start"`
+	DMEnd      float64 `json:"dm_end"`
+	Step       float64 `json:"step"`
+	Downsample int     `json:"downsample"`
+	Trials     int     `json:"trials"`
+	// The number of beam trials, in units of full time resolution.
+	Cost float64 `json:"cost"`
+}
+
+// DMWorker is the schedule of one worker of a node.
+type DMWorker struct {
+	Worker int      `json:"worker"`
+	Tasks  []DMTask `json:"tasks"`
+	Cost   float64  `json:"cost"`
+}
+
+// DMSchedule is the DM schedule of one node. Bunches without node get a
+// schedule of their own with an empty node name.
+type DMSchedule struct {
+	Node    string     `json:"node"`
+	Bunches []int      `json:"bunches"`
+	Beams   int        `json:"beams"`
+	Workers []DMWorker `json:"workers"`
+	Cost    float64    `json:"cost"`
+	// The ratio of the highest worker cost to the mean.
+	Imbalance float64 `json:"imbalance"`
+}
+
+// Split the DM trials of the bunches of a node across its workers. Every
+// bunch is searched over the whole plan, and the cost of a trial is the
+// number of beams of the bunch over the downsampling. The sequence of all
+// trials, by bunch and DM, is cut into contiguous blocks of about equal
+// cost, so that every worker dedisperses as few bunches as possible.
+func schedule_node(s *DMSchedule, bunches []Bunch, plan []DMStep, nworkers int) {
+	type item struct {
+		task DMTask
+		step DMStep
+		cost float64
+	}
+
+	var items []item
+	var total float64
+
+	for _, b := range bunches {
+		var beams []string
+		for _, beam := range b.Beams {
+			if !beam.Dummy {
+				beams = append(beams, beam.key())
+			}
+		}
+
+		if len(beams) == 0 {
+			continue
+		}
+
+		s.Beams += len(beams)
+
+		for _, step := range plan {
+			cost := float64(len(beams)) / float64(step.Downsample)
+			items = append(items, item{DMTask{Bunch: b.ID, Beams: beams}, step, cost})
+			total += cost * float64(step.Trials())
+		}
+	}
+
+	s.Workers = make([]DMWorker, nworkers)
+	for w := range s.Workers {
+		s.Workers[w].Worker = w
+	}
+
+	s.Cost = total
+
+	target := total / float64(nworkers)
+	var done float64
+	w := 0
+
+	for _, it := range items {
+		ntrials := it.step.Trials()
+
+		for k := 0; k < ntrials; {
+			// the trials up to the cost boundary of the worker, the rest
+			// for the last one
+			n := ntrials - k
+			if w < nworkers-1 {
+				n = min(n, int(math.Round((target*float64(w+1)-done)/it.cost)))
+			}
+
+			if n <= 0 {
+				w++
+				continue
+			}
+
+			task := it.task
+			task.DMStart = it.step.dm(k)
+			task.DMEnd = it.step.dm(k + n - 1)
+			task.Step = it.step.Step
+			task.Downsample = it.step.Downsample
+			task.Trials = n
+			task.Cost = float64(n) * it.cost
+
+			s.Workers[w].Tasks = append(s.Workers[w].Tasks, task)
+			s.Workers[w].Cost += task.Cost
+
+			done += task.Cost
+			k += n
+
+			if w < nworkers-1 && done >= target*float64(w+1)-it.cost/2 {
+				w++
+			}
+		}
+	}
+
+	var highest float64
+	for _, worker := range s.Workers {
+		highest = max(highest, worker.Cost)
+	}
+
+	if total > 0 {
+		s.Imbalance = highest / target
+	}
+}
+
+// DMSchedules allocates the DM trials of the plan to the workers of every
+// node of the packing, so that all beams are searched over the whole plan
+// and the workers of a node have about the same load. The nodes are ordered
+// by name, as the pipeline configurations. Dummy beams are left out.
+func DMSchedules(p *Packing, plan []DMStep, opts DMScheduleOptions) ([]DMSchedule, error) {
+	if len(plan) == 0 {
+		return nil, fmt.Errorf("Empty DM plan")
+	}
+
+	nworkers := opts.Workers
+	if nworkers == 0 {
+		nworkers = 1
+	}
+
+	if nworkers < 0 {
+		return nil, fmt.Errorf("Invalid number of workers: %d", opts.Workers)
+	}
+
+	var schedules []DMSchedule
+	var members [][]Bunch
+	bynode := make(map[string]int)
+
+	for _, b := range p.Bunches {
+		i, ok := bynode[b.Node]
+		if !ok || b.Node == "" {
+			i = len(schedules)
+			schedules = append(schedules, DMSchedule{Node: b.Node})
+			members = append(members, nil)
+
+			if b.Node != "" {
+				bynode[b.Node] = i
+			}
+		}
+
+		schedules[i].Bunches = append(schedules[i].Bunches, b.ID)
+		members[i] = append(members[i], b)
+	}
+
+	for i := range schedules {
+		schedule_node(&schedules[i], members[i], plan, nworkers)
+	}
+
+	sort.SliceStable(schedules, func(i, j int) bool {
+		return schedules[i].Node < schedules[j].Node
+	})
+
+	return schedules, nil
+}
+
+// WriteDMSchedule writes the DM schedule of a node as YAML.
+func WriteDMSchedule(w io.Writer, s DMSchedule) error {
+	fmt.Fprintf(w, "# dedispersion schedule\n")
+	fmt.Fprintf(w, "node: %q\n", s.Node)
+	fmt.Fprintf(w, "nbeams: %d\n", s.Beams)
+	fmt.Fprintf(w, "bunches: %s\n", join_ints(s.Bunches))
+	fmt.Fprintf(w, "workers:\n")
+
+	for _, worker := range s.Workers {
+		fmt.Fprintf(w, "  - id: %d\n", worker.Worker)
+		fmt.Fprintf(w, "    cost: %g\n", worker.Cost)
+		fmt.Fprintf(w, "    tasks:\n")
+
+		for _, t := range worker.Tasks {
+			fmt.Fprintf(w, "      - bunch: %d\n", t.Bunch)
+			fmt.Fprintf(w, "        dm_start: %g\n", t.DMStart)
+			fmt.Fprintf(w, "        dm_end: %g\n", t.DMEnd)
+			fmt.Fprintf(w, "        dm_step: %g\n", t.Step)
+			fmt.Fprintf(w, "        ndm: %d\n", t.Trials)
+			fmt.Fprintf(w, "        downsample: %d\n", t.Downsample)
+			if _, err := fmt.Fprintf(w, "        beams: %s\n", join_strings(t.Beams)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// WriteDMSchedules writes the tasks of the DM schedules in the format.
+func WriteDMSchedules(w io.Writer, schedules []DMSchedule, format string) error {
+	switch format {
+	case "text":
+		for _, s := range schedules {
+			fmt.Fprintf(w, "# node: %s, bunches: %d, beams: %d, workers: %d, cost: %g, imbalance: %.3f\n",
+				s.Node, len(s.Bunches), s.Beams, len(s.Workers), s.Cost, s.Imbalance)
+
+			for _, worker := range s.Workers {
+				for _, t := range worker.Tasks {
+					fmt.Fprintf(w, "Node: %s, worker: %d, bunch: %d, beams: %d, DM: %g-%g, step: %g, downsample: %d, trials: %d, cost: %g",
+						s.Node, worker.Worker, t.Bunch, len(t.Beams), t.DMStart, t.DMEnd, t.Step, t.Downsample, t.Trials, t.Cost)
+
+					if _, err := fmt.Fprintln(w); err != nil {
+						return err
+					}
+				}
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(schedules)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"node", "worker", "bunch", "beams", "dm_start", "dm_end", "step", "downsample", "trials", "cost"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, s := range schedules {
+			for _, worker := range s.Workers {
+				for _, t := range worker.Tasks {
+					writer.Write([]string{
+						s.Node,
+						strconv.Itoa(worker.Worker),
+						strconv.Itoa(t.Bunch),
+						strconv.Itoa(len(t.Beams)),
+						format_float(t.DMStart),
+						format_float(t.DMEnd),
+						format_float(t.Step),
+						strconv.Itoa(t.Downsample),
+						strconv.Itoa(t.Trials),
+						format_float(t.Cost),
+					})
+				}
+			}
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
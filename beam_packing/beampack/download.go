@@ -0,0 +1,125 @@
+package beampack
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// HTTPOptions configure the downloads of inputs given as HTTP or HTTPS URL.
+type HTTPOptions struct {
+	// Timeout of a single request, defaults to 30 s.
+	Timeout time.Duration
+	// Number of retries after a failed request. The wait before the first
+	// retry doubles after each one.
+	Retries   int
+	RetryWait time.Duration
+	// HTTP client, defaults to one with the timeout.
+	Client *http.Client
+}
+
+// IsURL reports whether the input name is an HTTP or HTTPS URL.
+func IsURL(name string) bool {
+	lower := strings.ToLower(name)
+	return strings.HasPrefix(lower, "http://") || strings.HasPrefix(lower, "https://")
+}
+
+// An error of a download that is not worth retrying.
+type permanent_error struct {
+	err error
+}
+
+func (e permanent_error) Error() string {
+	return e.err.Error()
+}
+
+func (e permanent_error) Unwrap() error {
+	return e.err
+}
+
+// Request the URL once.
+func download(client *http.Client, address string) ([]byte, error) {
+	resp, err := client.Get(address)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusNotFound || resp.StatusCode == http.StatusGone:
+		return nil, permanent_error{fmt.Errorf("%s, %w", resp.Status, fs.ErrNotExist)}
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode/100 == 5:
+		return nil, fmt.Errorf("%s", resp.Status)
+	case resp.StatusCode/100 != 2:
+		return nil, permanent_error{fmt.Errorf("%s", resp.Status)}
+	}
+
+	return io.ReadAll(resp.Body)
+}
+
+// Download fetches the contents of an HTTP or HTTPS URL. Network errors,
+// timeouts and server errors are retried, so that a busy web server does
+// not fail a run. The errors match ErrUnavailable, and the ones of a missing
+// file also fs.ErrNotExist.
+func Download(address string, opts HTTPOptions) ([]byte, error) {
+	client := opts.Client
+	if client == nil {
+		timeout := opts.Timeout
+		if timeout <= 0 {
+			timeout = 30 * time.Second
+		}
+
+		client = &http.Client{Timeout: timeout}
+	}
+
+	wait := opts.RetryWait
+
+	for attempt := 0; ; attempt++ {
+		raw, err := download(client, address)
+		if err == nil {
+			return raw, nil
+		}
+
+		var perm permanent_error
+		if attempt >= opts.Retries || errors.As(err, &perm) {
+			return nil, &unavailable_error{fmt.Errorf("Could not download file: %s, %w", address, err)}
+		}
+
+		slog.Warn("Retrying the download", "url", address, "attempt", attempt+1, "retries", opts.Retries, "wait", wait, "error", err)
+
+		time.Sleep(wait)
+		wait *= 2
+	}
+}
+
+// Load the beam positions from an HTTP or HTTPS URL. The data are
+// decompressed transparently and the format is detected from the contents
+// in auto mode.
+func load_url(address string, opts LoadOptions) ([]Beam, error) {
+	raw, err := Download(address, opts.HTTP)
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := decompress(bytes.NewReader(raw))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read data from URL: %s, %s", address, err)
+	}
+
+	raw, err = io.ReadAll(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read data from URL: %s, %s", address, err)
+	}
+
+	return Parse(raw, address, opts)
+}
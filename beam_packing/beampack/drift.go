@@ -0,0 +1,191 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+)
+
+// Drift is the fate of a target over an observation, as the beam
+// footprints evolve with hour angle: whether and when it drifts out of the
+// half-power contour of the beam it was in at the start.
+type Drift struct {
+	Source Source `json:"source"`
+	// The status: inside if the target stays within its beam, drifted if it
+	// leaves it into a neighbouring beam, gap if it leaves it into the gaps
+	// between the beams, and outside if it was within no beam at the start.
+	Status string `json:"status"`
+	// The beam of the target at the start and its offset in beam widths.
+	Beam   string  `json:"beam"`
+	Bunch  int     `json:"bunch"`
+	Node   string  `json:"node,omitempty"`
+	Widths float64 `json:"widths"`
+	// The first epoch at which the target is outside its beam and the time
+	// since the start in seconds, or -1 if it stays inside, with the hour
+	// angle and elevation of the boresight.
+	Epoch     int        `json:"epoch"`
+	Time      *time.Time `json:"time,omitempty"`
+	Elapsed   float64    `json:"elapsed"`
+	HA        float64    `json:"ha"`
+	Elevation float64    `json:"el"`
+	// The beam nearest to the target in beam widths at that epoch, i.e. the
+	// one it drifts into, and the offset from it.
+	Next       string  `json:"next,omitempty"`
+	NextBunch  int     `json:"next_bunch"`
+	NextNode   string  `json:"next_node,omitempty"`
+	NextWidths float64 `json:"next_widths"`
+}
+
+// Get the coherent beam nearest to the position in beam widths for the beam
+// shape, and the offset from it.
+func get_nearest_beam(beams []Beam, ra, dec float64, a, b, pa float64) (int, float64) {
+	best, widths := -1, 0.0
+
+	for i, beam := range beams {
+		if _, w := get_beam_offset(beam, ra, dec, a, b, pa); best < 0 || w < widths {
+			best, widths = i, w
+		}
+	}
+
+	return best, widths
+}
+
+// SimulateDrift follows the targets through the epochs of an observation.
+// The beams track their sky positions, while their footprints take the
+// modelled beam shape of every epoch. A target is within a beam if it lies
+// within its half-power contour, i.e. less than half a beam width from its
+// centre. The beam positions are interpreted as RA and Dec in degrees. The
+// result is ordered by source.
+func SimulateDrift(p *Packing, sources []Source, epochs []Epoch) []Drift {
+	var beams []Beam
+	var bunches []Bunch
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+				bunches = append(bunches, b)
+			}
+		}
+	}
+
+	result := make([]Drift, 0, len(sources))
+
+	if len(beams) == 0 || len(epochs) == 0 {
+		return result
+	}
+
+	for _, src := range sources {
+		d := Drift{Source: src, Status: "inside", Epoch: -1, Elapsed: -1}
+
+		e := epochs[0]
+		i, widths := get_nearest_beam(beams, src.RA, src.Dec, e.SemiMajor, e.SemiMinor, e.PA)
+
+		d.Beam, d.Bunch, d.Node, d.Widths = beams[i].Name, bunches[i].ID, bunches[i].Node, widths
+
+		if widths > 0.5 {
+			d.Status = "outside"
+			result = append(result, d)
+			continue
+		}
+
+		for k, e := range epochs[1:] {
+			if _, w := get_beam_offset(beams[i], src.RA, src.Dec, e.SemiMajor, e.SemiMinor, e.PA); w <= 0.5 {
+				continue
+			}
+
+			next, w := get_nearest_beam(beams, src.RA, src.Dec, e.SemiMajor, e.SemiMinor, e.PA)
+
+			d.Status = "drifted"
+			if w > 0.5 {
+				d.Status = "gap"
+			}
+
+			d.Epoch, d.Time, d.HA, d.Elevation = k+1, &e.Time, e.HourAngle, e.Elevation
+			d.Elapsed = e.Time.Sub(epochs[0].Time).Seconds()
+			d.Next, d.NextBunch, d.NextNode, d.NextWidths = beams[next].Name, bunches[next].ID, bunches[next].Node, w
+
+			break
+		}
+
+		result = append(result, d)
+	}
+
+	return result
+}
+
+// WriteDrift writes the drifts of the targets in the format.
+func WriteDrift(w io.Writer, list []Drift, format string) error {
+	switch format {
+	case "text":
+		for _, d := range list {
+			fmt.Fprintf(w, "Source: %s, RA: %.6f, Dec: %.6f, status: %s, beam: %s, bunch: %d, widths: %.3f",
+				d.Source.Name, d.Source.RA, d.Source.Dec, d.Status, d.Beam, d.Bunch, d.Widths)
+
+			if d.Epoch >= 0 {
+				fmt.Fprintf(w, ", epoch: %d, time: %s, after: %s, ha: %.3f, el: %.2f, next: %s, next bunch: %d, next widths: %.3f",
+					d.Epoch, d.Time.UTC().Format(time.RFC3339), time.Duration(d.Elapsed*float64(time.Second)), d.HA, d.Elevation, d.Next, d.NextBunch, d.NextWidths)
+			}
+
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(list)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"source", "ra", "dec", "status", "beam", "bunch", "node", "widths",
+			"epoch", "time", "elapsed", "ha", "el", "next", "next_bunch", "next_node", "next_widths"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, d := range list {
+			row := []string{
+				d.Source.Name,
+				format_float(d.Source.RA),
+				format_float(d.Source.Dec),
+				d.Status,
+				d.Beam,
+				strconv.Itoa(d.Bunch),
+				d.Node,
+				format_float(d.Widths),
+				strconv.Itoa(d.Epoch),
+				"", "", "", "", "", "", "", "",
+			}
+
+			if d.Epoch >= 0 {
+				copy(row[9:], []string{
+					d.Time.UTC().Format(time.RFC3339),
+					format_float(d.Elapsed),
+					format_float(d.HA),
+					format_float(d.Elevation),
+					d.Next,
+					strconv.Itoa(d.NextBunch),
+					d.NextNode,
+					format_float(d.NextWidths),
+				})
+			}
+
+			writer.Write(row)
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
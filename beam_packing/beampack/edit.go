@@ -0,0 +1,129 @@
+package beampack
+
+import (
+	"fmt"
+)
+
+// Find a beam given by name, qualified name or number and return the index
+// of its bunch and its slot in the bunch.
+func (p *Packing) find_beam(field string) (int, int, error) {
+	beams, groups := flatten_packing(p)
+
+	i, ok := get_beam_lookup(beams)(field)
+	if !ok {
+		return 0, 0, fmt.Errorf("Unknown beam: %s", field)
+	}
+
+	g := groups[i]
+	for s, beam := range p.Bunches[g].Beams {
+		if beam.Nr == beams[i].Nr && beam.key() == beams[i].key() {
+			return g, s, nil
+		}
+	}
+
+	return 0, 0, fmt.Errorf("Unknown beam: %s", field)
+}
+
+// Check that a beam may be moved manually.
+func (p *Packing) check_movable(beam Beam) error {
+	if beam.Dummy {
+		return fmt.Errorf("Dummy beams cannot be moved: %s", beam.key())
+	}
+
+	for _, group := range p.Pinned {
+		for _, n := range group {
+			if n == beam.Nr {
+				return fmt.Errorf("Pinned beams cannot be moved: %s", beam.key())
+			}
+		}
+	}
+
+	return nil
+}
+
+// Get the index of the bunch with the given ID.
+func (p *Packing) find_bunch(id int) (int, error) {
+	for g, b := range p.Bunches {
+		if b.ID == id {
+			return g, nil
+		}
+	}
+
+	return 0, fmt.Errorf("Unknown bunch: %d", id)
+}
+
+// Move moves a beam, given by name, qualified name or number, into the bunch
+// with the given ID to override the automatic packing. The sizes of the two
+// bunches change and their beams are ranked again. Bunches that become
+// empty are kept. Pinned and dummy beams cannot be moved.
+func (p *Packing) Move(field string, id int, dist DistanceFunc) error {
+	from, slot, err := p.find_beam(field)
+	if err != nil {
+		return err
+	}
+
+	to, err := p.find_bunch(id)
+	if err != nil {
+		return err
+	}
+
+	beam := p.Bunches[from].Beams[slot]
+	if err := p.check_movable(beam); err != nil {
+		return err
+	}
+
+	if from == to {
+		return nil
+	}
+
+	src := p.Bunches[from].Beams
+	p.Bunches[from].Beams = append(src[:slot:slot], src[slot+1:]...)
+	p.Bunches[to].Beams = append(p.Bunches[to].Beams, beam)
+
+	rank_groups([][]Beam{p.Bunches[from].Beams, p.Bunches[to].Beams}, dist)
+
+	return nil
+}
+
+// Swap exchanges the bunches of two beams, which keeps the bunch sizes.
+func (p *Packing) Swap(a, b string, dist DistanceFunc) error {
+	ga, sa, err := p.find_beam(a)
+	if err != nil {
+		return err
+	}
+
+	gb, sb, err := p.find_beam(b)
+	if err != nil {
+		return err
+	}
+
+	for _, beam := range []Beam{p.Bunches[ga].Beams[sa], p.Bunches[gb].Beams[sb]} {
+		if err := p.check_movable(beam); err != nil {
+			return err
+		}
+	}
+
+	if ga == gb {
+		return nil
+	}
+
+	p.Bunches[ga].Beams[sa], p.Bunches[gb].Beams[sb] = p.Bunches[gb].Beams[sb], p.Bunches[ga].Beams[sa]
+
+	rank_groups([][]Beam{p.Bunches[ga].Beams, p.Bunches[gb].Beams}, dist)
+
+	return nil
+}
+
+// Clone returns a copy of the packing whose bunches can be modified without
+// changing the original.
+func (p *Packing) Clone() *Packing {
+	q := *p
+	q.Bunches = make([]Bunch, len(p.Bunches))
+
+	for i, b := range p.Bunches {
+		q.Bunches[i] = b
+		q.Bunches[i].Beams = append([]Beam(nil), b.Beams...)
+	}
+
+	return &q
+}
@@ -0,0 +1,128 @@
+package beampack
+
+import (
+	"math"
+	"time"
+)
+
+// Site is the geodetic position of a telescope in degrees, with the
+// longitude measured east, and its altitude above the WGS84 ellipsoid in
+// metres.
+type Site struct {
+	Latitude  float64
+	Longitude float64
+	Altitude  float64
+}
+
+// MeerKAT is the position of the MeerKAT array reference.
+var MeerKAT = Site{Latitude: -30.7110565, Longitude: 21.4438888, Altitude: 1035}
+
+// Epoch is the modelled beam shape of a pointing at one time during an
+// observation. The angles are in degrees and the hour angle in hours.
+type Epoch struct {
+	Time        time.Time
+	HourAngle   float64
+	Elevation   float64
+	Parallactic float64
+	SemiMajor   float64
+	SemiMinor   float64
+	PA          float64
+}
+
+// Get the Greenwich mean sidereal time in degrees.
+func get_gmst(t time.Time) float64 {
+	days := get_j2000_days(t)
+
+	gmst := math.Mod(280.46061837+360.98564736629*days, 360)
+	if gmst < 0 {
+		gmst += 360
+	}
+
+	return gmst
+}
+
+// GetEpoch models the beam shape of a pointing at RA and Dec in degrees at
+// the given time. The nominal beam shape is the one of the array seen
+// face-on at the zenith, with the position angle measured in the horizon
+// frame. Away from the zenith, the projected array is foreshortened by the
+// sine of the elevation towards the zenith and rotates with the
+// parallactic angle: the beam rotates by the parallactic angle and is
+// stretched by the inverse sine of the elevation along the direction
+// towards the zenith. This is only an approximation of the synthesized
+// beam, but it follows the change of the beam ellipticity and orientation
+// with hour angle.
+func GetEpoch(site Site, ra, dec float64, t time.Time, semimajor, semiminor, pa float64) Epoch {
+	const deg = math.Pi / 180.0
+
+	lst := get_gmst(t) + site.Longitude
+	ha := math.Mod(lst-ra+540, 360) - 180
+
+	sinlat, coslat := math.Sincos(site.Latitude * deg)
+	sindec, cosdec := math.Sincos(dec * deg)
+	sinha, cosha := math.Sincos(ha * deg)
+
+	sinel := sinlat*sindec + coslat*cosdec*cosha
+	el := math.Asin(math.Max(-1, math.Min(1, sinel))) / deg
+	q := math.Atan2(sinha, sinlat/coslat*cosdec-sindec*cosha) / deg
+
+	e := Epoch{
+		Time:        t,
+		HourAngle:   ha / 15,
+		Elevation:   el,
+		Parallactic: q,
+	}
+
+	// the elongation diverges at the horizon
+	stretch := 1 / math.Max(sinel, 0.05)
+
+	// rotate the nominal beam, then stretch it along the unit vector u
+	// towards the zenith with I + (stretch - 1) u u^T
+	sxx, sxy, syy := get_covariance(semimajor, semiminor, pa+q)
+
+	ux, uy := math.Sincos(q * deg)
+	k := stretch - 1
+
+	m := [2][2]float64{
+		{1 + k*ux*ux, k * ux * uy},
+		{k * ux * uy, 1 + k*uy*uy},
+	}
+
+	c := [2][2]float64{{sxx, sxy}, {sxy, syy}}
+
+	var r [2][2]float64
+	for i := 0; i < 2; i++ {
+		for j := 0; j < 2; j++ {
+			for a := 0; a < 2; a++ {
+				for b := 0; b < 2; b++ {
+					r[i][j] += m[i][a] * c[a][b] * m[j][b]
+				}
+			}
+		}
+	}
+
+	e.SemiMajor, e.SemiMinor, e.PA = get_shape(r[0][0], r[0][1], r[1][1])
+
+	return e
+}
+
+// GetEpochs models the beam shapes at n epochs evenly spread over the
+// observation from start over the duration, including both ends.
+func GetEpochs(site Site, ra, dec float64, start time.Time, duration time.Duration, n int, semimajor, semiminor, pa float64) []Epoch {
+	epochs := make([]Epoch, 0, n)
+
+	for i := 0; i < n; i++ {
+		t := start
+		if n > 1 {
+			t = start.Add(time.Duration(float64(duration) * float64(i) / float64(n-1)))
+		}
+
+		epochs = append(epochs, GetEpoch(site, ra, dec, t, semimajor, semiminor, pa))
+	}
+
+	return epochs
+}
+
+// Metric returns the elliptical distance metric of the modelled beam shape.
+func (e Epoch) Metric() DistanceFunc {
+	return Elliptical(e.SemiMajor, math.Max(e.SemiMinor, 1e-12), e.PA)
+}
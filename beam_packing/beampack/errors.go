@@ -0,0 +1,51 @@
+package beampack
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrUnsatisfiable is matched by the errors of packings whose constraints
+// cannot be satisfied, e.g. pinned beams without space in their bunch or
+// too little node capacity, as opposed to invalid settings or inputs.
+var ErrUnsatisfiable = errors.New("unsatisfiable constraints")
+
+// An error of constraints that cannot be satisfied.
+type unsatisfiable_error struct {
+	msg string
+}
+
+func (e *unsatisfiable_error) Error() string {
+	return e.msg
+}
+
+func (e *unsatisfiable_error) Is(target error) bool {
+	return target == ErrUnsatisfiable
+}
+
+// ErrUnavailable is matched by the errors of inputs that could not be
+// downloaded, e.g. because the web server is down, as opposed to inputs that
+// cannot be parsed.
+var ErrUnavailable = errors.New("input unavailable")
+
+// An error of an input that could not be downloaded.
+type unavailable_error struct {
+	err error
+}
+
+func (e *unavailable_error) Error() string {
+	return e.err.Error()
+}
+
+func (e *unavailable_error) Unwrap() error {
+	return e.err
+}
+
+func (e *unavailable_error) Is(target error) bool {
+	return target == ErrUnavailable
+}
+
+// Format an error of constraints that cannot be satisfied.
+func unsatisfiable(format string, args ...any) error {
+	return &unsatisfiable_error{msg: fmt.Sprintf(format, args...)}
+}
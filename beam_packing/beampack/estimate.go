@@ -0,0 +1,154 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+)
+
+// The states of the nodes in the load estimate.
+const (
+	// The node keeps up with its expected load.
+	LoadOK = "ok"
+	// The load is close to the throughput, so that the fluctuations of the
+	// candidate rates are likely to make the node fall behind.
+	LoadRisk = "risk"
+	// The load exceeds the throughput.
+	LoadBehind = "behind"
+	// The throughput of the node is unknown.
+	LoadUnknown = "unknown"
+	// The node is offline, but has bunches assigned.
+	LoadOffline = "offline"
+	// The node is online, but has no bunches assigned.
+	LoadIdle = "idle"
+)
+
+// EstimateOptions are the settings of the node load estimate.
+type EstimateOptions struct {
+	// The throughput of the nodes without one. Zero means unknown.
+	Throughput float64
+	// The utilization of the throughput above which a node is likely to
+	// fall behind, defaults to 0.8.
+	MaxUtilization float64
+}
+
+// NodeEstimate is the estimated processing load of a node.
+type NodeEstimate struct {
+	NodeLoad
+	Throughput float64
+	// The load relative to the throughput.
+	Utilization float64
+	Status      string
+}
+
+// Flagged returns whether the node is likely to fall behind.
+func (e NodeEstimate) Flagged() bool {
+	return e.Status == LoadRisk || e.Status == LoadBehind || e.Status == LoadOffline
+}
+
+// LoadEstimate is the estimated processing load of all nodes.
+type LoadEstimate struct {
+	Nodes []NodeEstimate
+	// The total load, and the total throughput of the online nodes with
+	// known throughput.
+	Load       float64
+	Throughput float64
+	// The number of nodes that are likely to fall behind.
+	Flagged int
+	// The maximum utilization of a node.
+	MaxUtilization float64
+}
+
+// EstimateLoad estimates the processing load of every node of the packing,
+// which is the sum of the expected candidate rates of its beams, relative
+// to the throughput of the node, and flags the nodes that are likely to
+// fall behind: the nodes whose load exceeds the maximum utilization of
+// their throughput and the offline nodes with bunches. The online nodes of
+// the list without bunches are reported as idle. If the packing is not
+// assigned to nodes, every bunch gets the default throughput.
+func EstimateLoad(p *Packing, nodes []Node, opts EstimateOptions) LoadEstimate {
+	if opts.MaxUtilization <= 0 {
+		opts.MaxUtilization = 0.8
+	}
+
+	bynode := make(map[string]Node)
+	for _, node := range nodes {
+		bynode[node.Name] = node
+	}
+
+	var e LoadEstimate
+	seen := make(map[string]bool)
+
+	estimate := func(l NodeLoad, node Node, known bool) {
+		ne := NodeEstimate{NodeLoad: l, Throughput: node.Throughput}
+		if ne.Throughput == 0 {
+			ne.Throughput = opts.Throughput
+		}
+
+		if ne.Throughput > 0 {
+			ne.Utilization = l.Load / ne.Throughput
+		}
+
+		switch {
+		case known && node.Offline && l.Bunches > 0:
+			ne.Status = LoadOffline
+		case l.Bunches == 0:
+			ne.Status = LoadIdle
+		case ne.Throughput == 0:
+			ne.Status = LoadUnknown
+		case ne.Utilization > 1:
+			ne.Status = LoadBehind
+		case ne.Utilization > opts.MaxUtilization:
+			ne.Status = LoadRisk
+		default:
+			ne.Status = LoadOK
+		}
+
+		e.Load += l.Load
+
+		if !node.Offline && ne.Throughput > 0 {
+			e.Throughput += ne.Throughput
+		}
+
+		if ne.Flagged() {
+			e.Flagged++
+		}
+
+		e.MaxUtilization = max(e.MaxUtilization, ne.Utilization)
+		e.Nodes = append(e.Nodes, ne)
+	}
+
+	for _, l := range GetLoadBalance(p).Loads {
+		node, known := bynode[l.Node]
+		seen[l.Node] = true
+		estimate(l, node, known)
+	}
+
+	for _, node := range nodes {
+		if !seen[node.Name] && !node.Offline {
+			estimate(NodeLoad{Node: node.Name}, node, true)
+		}
+	}
+
+	return e
+}
+
+// WriteLoadEstimate writes the estimated load of every node and the summary
+// to w.
+func WriteLoadEstimate(w io.Writer, e LoadEstimate) error {
+	fmt.Fprintf(w, "# %-16s %7s %6s %12s %12s %11s %s\n", "node", "bunches", "beams", "load", "throughput", "utilization", "status")
+
+	for _, n := range e.Nodes {
+		fmt.Fprintf(w, "  %-16s %7d %6d %12.4f %12.4f %11.3f %s\n",
+			n.Node, n.Bunches, n.Beams, n.Load, n.Throughput, n.Utilization, n.Status)
+	}
+
+	utilization := 0.0
+	if e.Throughput > 0 {
+		utilization = e.Load / e.Throughput
+	}
+
+	_, err := fmt.Fprintf(w, "\nTotal load: %.4f, throughput: %.4f (utilization %.3f), maximum node utilization: %.3f, flagged nodes: %d\n",
+		e.Load, e.Throughput, utilization, e.MaxUtilization, e.Flagged)
+
+	return err
+}
@@ -0,0 +1,275 @@
+package beampack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// An FBFUSE beam entry given as object.
+type fbfuse_beam struct {
+	Name string          `json:"name"`
+	RA   json.RawMessage `json:"ra"`
+	Dec  json.RawMessage `json:"dec"`
+	// The beam shape and priority weight, if given.
+	SemiMajor *float64 `json:"semi_major,omitempty"`
+	SemiMinor *float64 `json:"semi_minor,omitempty"`
+	PA        *float64 `json:"pa,omitempty"`
+	Weight    *float64 `json:"weight,omitempty"`
+}
+
+// LoadFBFUSE loads the coherent beam positions from an FBFUSE beam
+// configuration JSON file. The beams can be given as a list of objects with
+// name, ra and dec, or as a map from beam name to either such an object or a
+// katpoint target string ("name, radec, hh:mm:ss.s, dd:mm:ss.s"). The map may
+// be nested under a "beams" or "coherent_beams" key. RA/Dec are returned in
+// decimal degrees. Beam objects may also give the beam shape as
+// semi_major, semi_minor and pa in degrees and the priority weight. Beams
+// given as map are ordered by name.
+func LoadFBFUSE(filename string) ([]Beam, error) {
+	raw, err := read_input(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	beams, err := parse_fbfuse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse FBFUSE beam configuration: %s, %s", filename, err)
+	}
+
+	return beams, nil
+}
+
+func parse_fbfuse(raw []byte) ([]Beam, error) {
+	var list []fbfuse_beam
+
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return convert_fbfuse(list)
+	}
+
+	var entries map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"beams", "coherent_beams"} {
+		if nested, ok := entries[key]; ok {
+			return parse_fbfuse(nested)
+		}
+	}
+
+	names := make([]string, 0, len(entries))
+	for name := range entries {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	list = make([]fbfuse_beam, 0, len(names))
+
+	for _, name := range names {
+		var target string
+
+		if err := json.Unmarshal(entries[name], &target); err == nil {
+			item, err := parse_target(target)
+			if err != nil {
+				return nil, fmt.Errorf("beam %s: %s", name, err)
+			}
+
+			item.Name = name
+			list = append(list, item)
+			continue
+		}
+
+		var item fbfuse_beam
+
+		if err := json.Unmarshal(entries[name], &item); err != nil {
+			return nil, fmt.Errorf("beam %s: %s", name, err)
+		}
+
+		if item.Name == "" {
+			item.Name = name
+		}
+
+		list = append(list, item)
+	}
+
+	return convert_fbfuse(list)
+}
+
+// Split a katpoint radec target string into its coordinates.
+func parse_target(target string) (fbfuse_beam, error) {
+	var item fbfuse_beam
+
+	fields := strings.Split(target, ",")
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+
+	// the name is optional
+	for i, field := range fields {
+		if field == "radec" && i+2 < len(fields) {
+			ra, _ := json.Marshal(fields[i+1])
+			dec, _ := json.Marshal(fields[i+2])
+
+			item.RA = ra
+			item.Dec = dec
+
+			return item, nil
+		}
+	}
+
+	return item, fmt.Errorf("Invalid radec target: %q", target)
+}
+
+// Convert the beam entries to beams in decimal degrees.
+func convert_fbfuse(list []fbfuse_beam) ([]Beam, error) {
+	beams := make([]Beam, 0, len(list))
+
+	for i, item := range list {
+		ra, err := parse_json_angle(item.RA, true)
+		if err != nil {
+			return nil, fmt.Errorf("beam %s: ra: %s", item.Name, err)
+		}
+
+		dec, err := parse_json_angle(item.Dec, false)
+		if err != nil {
+			return nil, fmt.Errorf("beam %s: dec: %s", item.Name, err)
+		}
+
+		name := item.Name
+		if name == "" {
+			name = BeamName(i)
+		}
+
+		beam := Beam{Nr: i, Name: name, X: ra, Y: dec}
+
+		// the shape is only used if complete
+		if item.SemiMajor != nil && item.SemiMinor != nil && item.PA != nil {
+			beam.SemiMajor, beam.SemiMinor, beam.PA = *item.SemiMajor, *item.SemiMinor, *item.PA
+		}
+
+		if item.Weight != nil {
+			if *item.Weight < 0 {
+				return nil, fmt.Errorf("beam %s: negative weight", name)
+			}
+
+			beam.Weight = *item.Weight
+		}
+
+		beams = append(beams, beam)
+	}
+
+	return beams, nil
+}
+
+// WriteFBFUSE writes the beams as FBFUSE beam configuration that
+// LoadFBFUSE consumes: a list of objects with the beam name and position in
+// decimal degrees, and the beam shape and priority weight of the beams that
+// have them. Dummy beams are left out.
+func WriteFBFUSE(w io.Writer, beams []Beam) error {
+	list := make([]fbfuse_beam, 0, len(beams))
+
+	for _, beam := range beams {
+		if beam.Dummy {
+			continue
+		}
+
+		ra, _ := json.Marshal(beam.X)
+		dec, _ := json.Marshal(beam.Y)
+
+		item := fbfuse_beam{Name: beam.Name, RA: ra, Dec: dec}
+
+		if beam.SemiMajor > 0 && beam.SemiMinor > 0 {
+			item.SemiMajor, item.SemiMinor, item.PA = &beam.SemiMajor, &beam.SemiMinor, &beam.PA
+		}
+
+		if beam.Weight != 0 {
+			item.Weight = &beam.Weight
+		}
+
+		list = append(list, item)
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(list)
+}
+
+// Parse an angle given as JSON number in degrees or as string. Strings are
+// either decimal degrees or sexagesimal, where sexagesimal RA is in hours.
+func parse_json_angle(raw json.RawMessage, hours bool) (float64, error) {
+	if len(raw) == 0 {
+		return 0, fmt.Errorf("missing value")
+	}
+
+	var value float64
+
+	if err := json.Unmarshal(raw, &value); err == nil {
+		return value, nil
+	}
+
+	var text string
+
+	if err := json.Unmarshal(raw, &text); err != nil {
+		return 0, err
+	}
+
+	return parse_angle(text, hours)
+}
+
+// Parse an angle given in decimal degrees or as sexagesimal string. A
+// sexagesimal value in hours is converted to degrees.
+func parse_angle(text string, hours bool) (float64, error) {
+	if !strings.Contains(text, ":") {
+		return strconv.ParseFloat(strings.TrimSpace(text), 64)
+	}
+
+	value, err := parse_sexagesimal(text)
+	if err != nil {
+		return 0, err
+	}
+
+	if hours {
+		value *= 15
+	}
+
+	return value, nil
+}
+
+// Convert a sexagesimal string (dd:mm:ss.s) to decimal units.
+func parse_sexagesimal(text string) (float64, error) {
+	text = strings.TrimSpace(text)
+
+	sign := 1.0
+	if strings.HasPrefix(text, "-") {
+		sign = -1
+		text = text[1:]
+	} else if strings.HasPrefix(text, "+") {
+		text = text[1:]
+	}
+
+	parts := strings.Split(text, ":")
+	if len(parts) > 3 {
+		return 0, fmt.Errorf("Invalid sexagesimal value: %q", text)
+	}
+
+	var value float64
+
+	for i, part := range parts {
+		v, err := strconv.ParseFloat(part, 64)
+		if err != nil {
+			return 0, fmt.Errorf("Invalid sexagesimal value: %q", text)
+		}
+
+		value += v / math.Pow(60, float64(i))
+	}
+
+	return sign * value, nil
+}
@@ -0,0 +1,483 @@
+package beampack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// The size of a FITS block and of a header card.
+const (
+	fits_block = 2880
+	fits_card  = 80
+)
+
+// FITSSignature is the start of every FITS file.
+const FITSSignature = "SIMPLE  ="
+
+// A FITS header data unit.
+type fits_hdu struct {
+	keywords map[string]string
+	data     []byte
+}
+
+// Get an integer keyword, or the default if it is missing.
+func (h fits_hdu) get_int(key string, def int) (int, error) {
+	value, ok := h.keywords[key]
+	if !ok {
+		return def, nil
+	}
+
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value of %s: %s", key, value)
+	}
+
+	return n, nil
+}
+
+// Get a float keyword, or the default if it is missing.
+func (h fits_hdu) get_float(key string, def float64) (float64, error) {
+	value, ok := h.keywords[key]
+	if !ok {
+		return def, nil
+	}
+
+	v, err := strconv.ParseFloat(strings.Replace(value, "D", "E", 1), 64)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid value of %s: %s", key, value)
+	}
+
+	return v, nil
+}
+
+// Parse the value of a header card, without the comment. String values
+// are unquoted.
+func parse_card_value(text string) string {
+	text = strings.TrimSpace(text)
+
+	if strings.HasPrefix(text, "'") {
+		var b strings.Builder
+
+		for i := 1; i < len(text); i++ {
+			if text[i] == '\'' {
+				// a doubled quote is an escaped quote
+				if i+1 < len(text) && text[i+1] == '\'' {
+					b.WriteByte('\'')
+					i++
+					continue
+				}
+
+				break
+			}
+
+			b.WriteByte(text[i])
+		}
+
+		return strings.TrimRight(b.String(), " ")
+	}
+
+	value, _, _ := strings.Cut(text, "/")
+
+	return strings.TrimSpace(value)
+}
+
+// The valid numbers of bits per data value, negative for floating point
+// values.
+var fits_bitpix = []int{8, 16, 32, 64, -32, -64}
+
+// Get the size of the data of an HDU in bytes from its header, which is
+// checked for valid numbers. Sizes beyond the limit are not computed, as
+// they are truncated anyway.
+func get_fits_data_size(hdu fits_hdu, limit int) (int, error) {
+	bitpix, err := hdu.get_int("BITPIX", 8)
+	if err != nil {
+		return 0, err
+	}
+
+	if !slices.Contains(fits_bitpix, bitpix) {
+		return 0, fmt.Errorf("Invalid value of BITPIX: %d", bitpix)
+	}
+
+	naxis, err := hdu.get_int("NAXIS", 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if naxis < 0 || naxis > 999 {
+		return 0, fmt.Errorf("Invalid value of NAXIS: %d", naxis)
+	}
+
+	if naxis == 0 {
+		return 0, nil
+	}
+
+	pcount, err := hdu.get_int("PCOUNT", 0)
+	if err != nil {
+		return 0, err
+	}
+
+	if pcount < 0 {
+		return 0, fmt.Errorf("Invalid value of PCOUNT: %d", pcount)
+	}
+
+	gcount, err := hdu.get_int("GCOUNT", 1)
+	if err != nil {
+		return 0, err
+	}
+
+	if gcount < 1 {
+		return 0, fmt.Errorf("Invalid value of GCOUNT: %d", gcount)
+	}
+
+	size := 1
+	for i := 1; i <= naxis; i++ {
+		key := fmt.Sprintf("NAXIS%d", i)
+
+		n, err := hdu.get_int(key, 0)
+		if err != nil {
+			return 0, err
+		}
+
+		if n < 0 {
+			return 0, fmt.Errorf("Invalid value of %s: %d", key, n)
+		}
+
+		if n > 0 && size > limit/n {
+			return limit + 1, nil
+		}
+
+		size *= n
+	}
+
+	if pcount > limit-size {
+		return limit + 1, nil
+	}
+
+	size += pcount
+
+	// the group count is at least one
+	if size > 0 && gcount > limit/size {
+		return limit + 1, nil
+	}
+
+	size *= gcount
+
+	if bitpix < 0 {
+		bitpix = -bitpix
+	}
+
+	if size > limit/(bitpix/8) {
+		return limit + 1, nil
+	}
+
+	return bitpix / 8 * size, nil
+}
+
+// Split FITS data into its header data units.
+func read_fits(raw []byte) ([]fits_hdu, error) {
+	var hdus []fits_hdu
+
+	for pos := 0; pos < len(raw); {
+		hdu := fits_hdu{keywords: make(map[string]string)}
+		end := false
+
+		for !end {
+			if pos+fits_block > len(raw) {
+				return nil, fmt.Errorf("Truncated header of HDU %d", len(hdus))
+			}
+
+			block := raw[pos : pos+fits_block]
+			pos += fits_block
+
+			for c := 0; c < fits_block; c += fits_card {
+				card := string(block[c : c+fits_card])
+				key := strings.TrimSpace(card[:8])
+
+				if key == "END" {
+					end = true
+					break
+				}
+
+				if card[8:10] == "= " {
+					hdu.keywords[key] = parse_card_value(card[10:])
+				}
+			}
+		}
+
+		size, err := get_fits_data_size(hdu, len(raw)-pos)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid header of HDU %d: %s", len(hdus), err)
+		}
+
+		if pos+size > len(raw) {
+			return nil, fmt.Errorf("Truncated data of HDU %d", len(hdus))
+		}
+
+		hdu.data = raw[pos : pos+size]
+		hdus = append(hdus, hdu)
+
+		// the data is padded to full blocks
+		pos += (size + fits_block - 1) / fits_block * fits_block
+	}
+
+	return hdus, nil
+}
+
+// Select the HDU given by number (0 is the primary HDU) or extension name,
+// or the first binary table if none is given.
+func select_hdu(hdus []fits_hdu, selection string) (fits_hdu, error) {
+	if selection == "" {
+		for _, hdu := range hdus {
+			if hdu.keywords["XTENSION"] == "BINTABLE" {
+				return hdu, nil
+			}
+		}
+
+		return fits_hdu{}, fmt.Errorf("No binary table found.")
+	}
+
+	if n, err := strconv.Atoi(selection); err == nil {
+		if n < 0 || n >= len(hdus) {
+			return fits_hdu{}, fmt.Errorf("No such HDU: %d", n)
+		}
+
+		return hdus[n], nil
+	}
+
+	for _, hdu := range hdus {
+		if strings.EqualFold(hdu.keywords["EXTNAME"], selection) {
+			return hdu, nil
+		}
+	}
+
+	return fits_hdu{}, fmt.Errorf("No such HDU: %s", selection)
+}
+
+// A column of a FITS binary table.
+type fits_column struct {
+	name   string
+	kind   byte
+	repeat int
+	offset int
+	scale  float64
+	zero   float64
+}
+
+// The sizes of the FITS binary table column types in bytes.
+var fits_sizes = map[byte]int{
+	'L': 1, 'B': 1, 'I': 2, 'J': 4, 'K': 8, 'A': 1,
+	'E': 4, 'D': 8, 'C': 8, 'M': 16, 'P': 8, 'Q': 16,
+}
+
+// Get the width of a column in bytes.
+func (c fits_column) width() int {
+	if c.kind == 'X' {
+		return (c.repeat + 7) / 8
+	}
+
+	return c.repeat * fits_sizes[c.kind]
+}
+
+// Parse the columns of a binary table.
+func get_fits_columns(hdu fits_hdu) ([]fits_column, error) {
+	nfields, err := hdu.get_int("TFIELDS", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []fits_column
+	offset := 0
+
+	for i := 1; i <= nfields; i++ {
+		form := strings.ToUpper(strings.TrimSpace(hdu.keywords[fmt.Sprintf("TFORM%d", i)]))
+
+		j := 0
+		for j < len(form) && form[j] >= '0' && form[j] <= '9' {
+			j++
+		}
+
+		if j == len(form) {
+			return nil, fmt.Errorf("Invalid format of column %d: %s", i, form)
+		}
+
+		repeat := 1
+		if j > 0 {
+			repeat, _ = strconv.Atoi(form[:j])
+		}
+
+		c := fits_column{
+			name:   strings.TrimSpace(hdu.keywords[fmt.Sprintf("TTYPE%d", i)]),
+			kind:   form[j],
+			repeat: repeat,
+			offset: offset,
+		}
+
+		if _, ok := fits_sizes[c.kind]; !ok && c.kind != 'X' {
+			return nil, fmt.Errorf("Invalid format of column %d: %s", i, form)
+		}
+
+		if c.name == "" {
+			c.name = fmt.Sprintf("col%d", i)
+		}
+
+		if c.scale, err = hdu.get_float(fmt.Sprintf("TSCAL%d", i), 1); err != nil {
+			return nil, err
+		}
+
+		if c.zero, err = hdu.get_float(fmt.Sprintf("TZERO%d", i), 0); err != nil {
+			return nil, err
+		}
+
+		offset += c.width()
+		columns = append(columns, c)
+	}
+
+	return columns, nil
+}
+
+// Format the value of a scalar or string column in a row.
+func (c fits_column) format(row []byte) (string, bool) {
+	cell := row[c.offset : c.offset+c.width()]
+
+	if c.kind == 'A' {
+		text := strings.TrimRight(string(bytes.TrimRight(cell, "\x00")), " ")
+		return strings.Join(strings.Fields(text), "_"), true
+	}
+
+	// only scalar numeric columns can hold coordinates
+	if c.repeat != 1 {
+		return "", false
+	}
+
+	v, ok := c.value(row, 0)
+	if !ok {
+		return "", false
+	}
+
+	return strconv.FormatFloat(v, 'g', -1, 64), true
+}
+
+// Get the scaled value of the element of a numeric column in a row.
+func (c fits_column) value(row []byte, element int) (float64, bool) {
+	size, ok := fits_sizes[c.kind]
+	if !ok || element >= c.repeat {
+		return 0, false
+	}
+
+	cell := row[c.offset+element*size : c.offset+(element+1)*size]
+
+	var v float64
+
+	switch c.kind {
+	case 'B':
+		v = float64(cell[0])
+	case 'I':
+		v = float64(int16(binary.BigEndian.Uint16(cell)))
+	case 'J':
+		v = float64(int32(binary.BigEndian.Uint32(cell)))
+	case 'K':
+		v = float64(int64(binary.BigEndian.Uint64(cell)))
+	case 'E':
+		v = float64(math.Float32frombits(binary.BigEndian.Uint32(cell)))
+	case 'D':
+		v = math.Float64frombits(binary.BigEndian.Uint64(cell))
+	default:
+		return 0, false
+	}
+
+	return c.zero + c.scale*v, true
+}
+
+// Convert the binary table of a FITS file into a tab-separated beam position
+// table with a header row of the column names, which is parsed like any
+// other beam position table. Columns that cannot hold beam positions or
+// names, e.g. vector columns, are left out.
+func fits_to_table(raw []byte, selection string) ([]byte, error) {
+	hdus, err := read_fits(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	hdu, err := select_hdu(hdus, selection)
+	if err != nil {
+		return nil, err
+	}
+
+	if hdu.keywords["XTENSION"] != "BINTABLE" {
+		return nil, fmt.Errorf("Not a binary table: HDU %s", selection)
+	}
+
+	width, err := hdu.get_int("NAXIS1", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	nrows, err := hdu.get_int("NAXIS2", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if width > 0 && nrows > len(hdu.data)/width {
+		return nil, fmt.Errorf("The rows do not fit into the data: %d rows of %d bytes, %d bytes", nrows, width, len(hdu.data))
+	}
+
+	columns, err := get_fits_columns(hdu)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) > 0 {
+		last := columns[len(columns)-1]
+		if last.offset+last.width() > width {
+			return nil, fmt.Errorf("The columns do not fit into the rows: %d, %d", last.offset+last.width(), width)
+		}
+	}
+
+	// the columns that can be converted
+	row := make([]byte, width)
+
+	var usable []fits_column
+	var names []string
+
+	for _, c := range columns {
+		if _, ok := c.format(row); ok {
+			usable = append(usable, c)
+			names = append(names, strings.Join(strings.Fields(c.name), "_"))
+		}
+	}
+
+	var b bytes.Buffer
+	b.WriteString(strings.Join(names, "\t") + "\n")
+
+	fields := make([]string, len(usable))
+
+	for r := 0; r < nrows; r++ {
+		row := hdu.data[r*width : (r+1)*width]
+
+		for i, c := range usable {
+			fields[i], _ = c.format(row)
+		}
+
+		b.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+
+	return b.Bytes(), nil
+}
+
+// Parse the beam positions from a FITS binary table.
+func parse_fits(raw []byte, name string, opts LoadOptions) ([]Beam, error) {
+	table, err := fits_to_table(raw, opts.HDU)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read FITS table: %s, %s", name, err)
+	}
+
+	opts.Delimiter = '\t'
+	opts.Header = "parse"
+
+	return read_data(bytes.NewReader(table), name, opts)
+}
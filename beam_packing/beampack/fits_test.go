@@ -0,0 +1,161 @@
+package beampack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"testing"
+)
+
+// Format a FITS header card with the key and value.
+func get_test_card(key string, value any) string {
+	var text string
+
+	switch v := value.(type) {
+	case string:
+		text = fmt.Sprintf("%-8s= '%-8s'", key, v)
+	case bool:
+		text = fmt.Sprintf("%-8s= %20s", key, map[bool]string{true: "T", false: "F"}[v])
+	default:
+		text = fmt.Sprintf("%-8s= %20v", key, v)
+	}
+
+	return fmt.Sprintf("%-80.80s", text)
+}
+
+// Pad FITS test data to full blocks with the fill byte.
+func pad_test_fits(b *bytes.Buffer, fill byte) {
+	if rem := b.Len() % fits_block; rem != 0 {
+		b.Write(bytes.Repeat([]byte{fill}, fits_block-rem))
+	}
+}
+
+// Write a FITS file with a beam table of a string name, a double RA, a
+// scaled integer Dec and a vector column, which cannot hold positions. The
+// values of the given keywords of the table header are replaced.
+func get_test_fits(t *testing.T, header map[string]any) []byte {
+	t.Helper()
+
+	var b bytes.Buffer
+
+	for _, card := range [][2]any{{"SIMPLE", true}, {"BITPIX", 8}, {"NAXIS", 0}, {"EXTEND", true}} {
+		b.WriteString(get_test_card(card[0].(string), card[1]))
+	}
+
+	b.WriteString(fmt.Sprintf("%-80s", "END"))
+	pad_test_fits(&b, ' ')
+
+	rows := []struct {
+		name string
+		ra   float64
+		dec  int32
+	}{
+		{"cfbf00000", 134.0696, -300000},
+		{"cfbf00001", 134.0796, -300100},
+	}
+
+	for _, card := range [][2]any{
+		{"XTENSION", "BINTABLE"}, {"BITPIX", 8}, {"NAXIS", 2},
+		{"NAXIS1", 10 + 8 + 4 + 16}, {"NAXIS2", len(rows)}, {"PCOUNT", 0}, {"GCOUNT", 1},
+		{"TFIELDS", 4}, {"EXTNAME", "BEAMS"},
+		{"TTYPE1", "NAME"}, {"TFORM1", "10A"},
+		{"TTYPE2", "RA"}, {"TFORM2", "D"},
+		{"TTYPE3", "DEC"}, {"TFORM3", "J"}, {"TSCAL3", "1E-4"},
+		{"TTYPE4", "OFFSET"}, {"TFORM4", "2D"},
+	} {
+		if value, ok := header[card[0].(string)]; ok {
+			card[1] = value
+		}
+
+		b.WriteString(get_test_card(card[0].(string), card[1]))
+	}
+
+	b.WriteString(fmt.Sprintf("%-80s", "END"))
+	pad_test_fits(&b, ' ')
+
+	for _, r := range rows {
+		b.WriteString(fmt.Sprintf("%-10s", r.name))
+		binary.Write(&b, binary.BigEndian, math.Float64bits(r.ra))
+		binary.Write(&b, binary.BigEndian, r.dec)
+		binary.Write(&b, binary.BigEndian, [2]float64{1, 2})
+	}
+
+	pad_test_fits(&b, 0)
+
+	return b.Bytes()
+}
+
+func TestParseFITS(t *testing.T) {
+	raw := get_test_fits(t, nil)
+
+	for _, hdu := range []string{"", "1", "BEAMS"} {
+		beams, err := Parse(raw, "test.fits", LoadOptions{HDU: hdu})
+		if err != nil {
+			t.Fatalf("HDU %q: %s", hdu, err)
+		}
+
+		if len(beams) != 2 {
+			t.Fatalf("HDU %q: %d beams, want 2", hdu, len(beams))
+		}
+
+		b := beams[1]
+		if b.Name != "cfbf00001" || b.X != 134.0796 || math.Abs(b.Y+30.01) > 1e-9 {
+			t.Errorf("HDU %q: wrong beam: %+v", hdu, b)
+		}
+	}
+}
+
+func TestParseFITSInvalid(t *testing.T) {
+	raw := get_test_fits(t, nil)
+
+	if _, err := Parse(raw, "test.fits", LoadOptions{HDU: "0"}); err == nil {
+		t.Error("no error for the primary HDU")
+	}
+
+	if _, err := Parse(raw, "test.fits", LoadOptions{HDU: "2"}); err == nil {
+		t.Error("no error for a missing HDU")
+	}
+
+	if _, err := Parse(raw[:len(raw)-fits_block], "test.fits", LoadOptions{}); err == nil {
+		t.Error("no error for truncated data")
+	}
+}
+
+// Invalid numbers in the table header are rejected instead of reading
+// beyond the data.
+func TestParseFITSInvalidHeader(t *testing.T) {
+	cases := []map[string]any{
+		{"BITPIX": 0},
+		{"BITPIX": 12},
+		{"NAXIS": -1},
+		{"NAXIS": 1},
+		{"NAXIS1": -16},
+		{"NAXIS2": -1},
+		{"NAXIS2": 100},
+		{"NAXIS2": int64(1) << 60},
+		{"PCOUNT": -1},
+		{"GCOUNT": 0},
+	}
+
+	for _, header := range cases {
+		if beams, err := Parse(get_test_fits(t, header), "test.fits", LoadOptions{}); err == nil {
+			t.Errorf("%v: no error, %d beams", header, len(beams))
+		}
+	}
+}
+
+func TestParseCardValue(t *testing.T) {
+	cases := map[string]string{
+		"'BINTABLE'           / type":  "BINTABLE",
+		"'it''s  '":                    "it's",
+		"                  42 / count": "42",
+		"1.5D-3":                       "1.5D-3",
+	}
+
+	for text, want := range cases {
+		if got := parse_card_value(text); got != want {
+			t.Errorf("%q: got %q, want %q", text, got, want)
+		}
+	}
+}
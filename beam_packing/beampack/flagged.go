@@ -0,0 +1,112 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// FlaggedBeam is a beam that was dropped before packing because it is dead
+// or flagged for RFI.
+type FlaggedBeam struct {
+	Beam   Beam
+	Reason string
+}
+
+// The reason of beams flagged without one.
+const default_reason = "flagged"
+
+// LoadFlagged loads the dead or RFI-flagged beams from file. Every line
+// holds a beam, given by name or by number, optionally followed by the
+// reason it is flagged. Empty lines and lines starting with # are ignored.
+// The beams are returned by number together with their reasons.
+func LoadFlagged(filename string, beams []Beam) (map[int]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	flagged := make(map[int]string)
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		field, reason, _ := strings.Cut(strings.ReplaceAll(line, "\t", " "), " ")
+		field = strings.TrimSuffix(field, ",")
+
+		i, ok := lookup(field)
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown beam: %s", filename, nr, field)
+		}
+
+		reason = strings.TrimSpace(reason)
+		if reason == "" {
+			reason = default_reason
+		}
+
+		flagged[beams[i].Nr] = reason
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read flagged beams: %s", err)
+	}
+
+	return flagged, nil
+}
+
+// ParseFlagged parses a comma-separated list of dead or RFI-flagged beams,
+// given by name or by number. The beams are returned by number.
+func ParseFlagged(list string, beams []Beam) (map[int]string, error) {
+	lookup := get_beam_lookup(beams)
+
+	flagged := make(map[int]string)
+
+	for _, field := range strings.Split(list, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+
+		i, ok := lookup(field)
+		if !ok {
+			return nil, fmt.Errorf("Unknown beam: %s", field)
+		}
+
+		flagged[beams[i].Nr] = default_reason
+	}
+
+	return flagged, nil
+}
+
+// Drop the flagged beams, given by number, and return the remaining beams
+// and the flagged ones.
+func drop_flagged(beams []Beam, flagged map[int]string) ([]Beam, []FlaggedBeam) {
+	if len(flagged) == 0 {
+		return beams, nil
+	}
+
+	var kept []Beam
+	var dropped []FlaggedBeam
+
+	for _, beam := range beams {
+		if reason, ok := flagged[beam.Nr]; ok {
+			dropped = append(dropped, FlaggedBeam{beam, reason})
+		} else {
+			kept = append(kept, beam)
+		}
+	}
+
+	return kept, dropped
+}
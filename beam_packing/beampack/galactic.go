@@ -0,0 +1,173 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+)
+
+// Frames lists the available coordinate frames of the beam positions.
+var Frames = []string{"equatorial", "galactic"}
+
+// The rotation from J2000 equatorial to Galactic coordinates.
+var galactic_rotation = rotation{
+	{-0.0548755604162154, -0.8734370902348850, -0.4838350155487132},
+	{+0.4941094278755837, -0.4448296299600112, +0.7469822444972189},
+	{-0.8676661490190047, -0.1980763734312015, +0.4559837761750669},
+}
+
+// Rotate a position in degrees into another frame. The longitude is in
+// [0, 360).
+func rotate_position(r rotation, lon, lat float64) (float64, float64) {
+	const deg = math.Pi / 180
+
+	sinlon, coslon := math.Sincos(lon * deg)
+	sinlat, coslat := math.Sincos(lat * deg)
+
+	v := r.apply([3]float64{coslon * coslat, sinlon * coslat, sinlat})
+
+	lon = math.Mod(math.Atan2(v[1], v[0])/deg+360, 360)
+	lat = math.Atan2(v[2], math.Hypot(v[0], v[1])) / deg
+
+	return lon, lat
+}
+
+// EquatorialToGalactic converts J2000 RA and Dec in degrees to Galactic
+// longitude and latitude in degrees.
+func EquatorialToGalactic(ra, dec float64) (float64, float64) {
+	return rotate_position(galactic_rotation, ra, dec)
+}
+
+// GalacticToEquatorial converts Galactic longitude and latitude in degrees
+// to J2000 RA and Dec in degrees.
+func GalacticToEquatorial(l, b float64) (float64, float64) {
+	return rotate_position(galactic_rotation.transpose(), l, b)
+}
+
+// Get the conversion of positions between two frames.
+func get_frame_conversion(from, to string) (func(x, y float64) (float64, float64), error) {
+	for _, frame := range []string{from, to} {
+		if frame != "" && frame != "equatorial" && frame != "galactic" {
+			return nil, fmt.Errorf("Unknown coordinate frame: %s", frame)
+		}
+	}
+
+	if from == "" {
+		from = "equatorial"
+	}
+
+	if to == "" {
+		to = "equatorial"
+	}
+
+	switch {
+	case from == to:
+		return nil, nil
+	case to == "galactic":
+		return EquatorialToGalactic, nil
+	default:
+		return GalacticToEquatorial, nil
+	}
+}
+
+// Keep the longitudes of positions that straddle zero contiguous, e.g. of
+// a tiling around the Galactic centre, by moving the ones beyond 180 below
+// zero.
+func unwrap_longitudes(lons []*float64) {
+	var low, high bool
+
+	for _, lon := range lons {
+		low = low || *lon < 90
+		high = high || *lon > 270
+	}
+
+	if !low || !high {
+		return
+	}
+
+	for _, lon := range lons {
+		if *lon > 180 {
+			*lon -= 360
+		}
+	}
+}
+
+// ConvertFrame converts the positions of the beams between the equatorial
+// J2000 and the Galactic frame. The dummy beams are converted as well, as
+// they lie at the centroids of their bunches.
+func ConvertFrame(beams []Beam, from, to string) error {
+	convert, err := get_frame_conversion(from, to)
+	if err != nil || convert == nil {
+		return err
+	}
+
+	lons := make([]*float64, len(beams))
+
+	for i := range beams {
+		beams[i].X, beams[i].Y = convert(beams[i].X, beams[i].Y)
+		lons[i] = &beams[i].X
+	}
+
+	unwrap_longitudes(lons)
+
+	return nil
+}
+
+// ToFrame returns a copy of the packing with the beam positions in the
+// frame, equatorial or galactic, e.g. for writing a packing of beams given
+// in Galactic coordinates in the same frame. The packing itself is in
+// equatorial coordinates.
+func ToFrame(p *Packing, frame string) (*Packing, error) {
+	convert, err := get_frame_conversion(p.Frame, frame)
+	if err != nil || convert == nil {
+		return p, err
+	}
+
+	result := *p
+	result.Frame = frame
+	if frame == "equatorial" {
+		result.Frame = ""
+	}
+
+	var lons []*float64
+
+	move := func(beam *Beam) {
+		beam.X, beam.Y = convert(beam.X, beam.Y)
+		lons = append(lons, &beam.X)
+	}
+
+	result.Bunches = make([]Bunch, len(p.Bunches))
+	for i, b := range p.Bunches {
+		result.Bunches[i] = b
+		result.Bunches[i].Beams = append([]Beam{}, b.Beams...)
+
+		for k := range result.Bunches[i].Beams {
+			move(&result.Bunches[i].Beams[k])
+		}
+	}
+
+	result.Excluded = append([]Beam{}, p.Excluded...)
+	for k := range result.Excluded {
+		move(&result.Excluded[k])
+	}
+
+	result.Masked = append([]Masked{}, p.Masked...)
+	for k := range result.Masked {
+		move(&result.Masked[k].Beam)
+	}
+
+	result.Flagged = append([]FlaggedBeam{}, p.Flagged...)
+	for k := range result.Flagged {
+		move(&result.Flagged[k].Beam)
+	}
+
+	if p.Tangent != nil {
+		t := *p.Tangent
+		t.RA, t.Dec = convert(t.RA, t.Dec)
+		lons = append(lons, &t.RA)
+		result.Tangent = &t
+	}
+
+	unwrap_longitudes(lons)
+
+	return &result, nil
+}
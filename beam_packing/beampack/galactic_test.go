@@ -0,0 +1,114 @@
+package beampack
+
+import (
+	"math"
+	"testing"
+)
+
+func TestEquatorialToGalactic(t *testing.T) {
+	cases := []struct {
+		name    string
+		ra, dec float64
+		l, b    float64
+	}{
+		{"centre", 266.40510, -28.93618, 0, 0},
+		{"pole", 192.85948, 27.12825, 0, 90},
+		{"anticentre", 86.40510, 28.93618, 180, 0},
+	}
+
+	for _, c := range cases {
+		l, b := EquatorialToGalactic(c.ra, c.dec)
+		if math.Abs(math.Remainder(l-c.l, 360))*math.Cos(b*math.Pi/180) > 1e-4 || math.Abs(b-c.b) > 1e-4 {
+			t.Errorf("%s: wrong position: %.6f, %.6f", c.name, l, b)
+		}
+
+		if l < 0 || l >= 360 {
+			t.Errorf("%s: longitude out of range: %g", c.name, l)
+		}
+	}
+
+	for _, p := range [][2]float64{{0, 0}, {134.0696, -30}, {359.5, 60.25}, {200, -89}} {
+		l, b := EquatorialToGalactic(p[0], p[1])
+		ra, dec := GalacticToEquatorial(l, b)
+
+		if math.Abs(math.Remainder(ra-p[0], 360)) > 1e-9 || math.Abs(dec-p[1]) > 1e-9 {
+			t.Errorf("wrong position converted back: %g, %g: %.9f, %.9f", p[0], p[1], ra, dec)
+		}
+	}
+}
+
+// A tiling around the Galactic centre keeps contiguous longitudes, and
+// converts back to the equatorial positions.
+func TestConvertFrame(t *testing.T) {
+	beams := get_test_tiling(t, 30)
+	for i := range beams {
+		beams[i].X += 266.40510 - 134.0696
+		beams[i].Y += -28.93618 + 30
+	}
+
+	converted := append([]Beam{}, beams...)
+	if err := ConvertFrame(converted, "equatorial", "galactic"); err != nil {
+		t.Fatal(err)
+	}
+
+	var low, high bool
+	for _, beam := range converted {
+		low = low || beam.X < 0
+		high = high || beam.X > 0
+		if math.Abs(beam.X) > 1 || math.Abs(beam.Y) > 1 {
+			t.Fatalf("wrong Galactic position: %g, %g", beam.X, beam.Y)
+		}
+	}
+
+	if !low || !high {
+		t.Errorf("the tiling does not straddle zero longitude")
+	}
+
+	if err := ConvertFrame(converted, "galactic", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, beam := range converted {
+		if math.Abs(beam.X-beams[i].X) > 1e-9 || math.Abs(beam.Y-beams[i].Y) > 1e-9 {
+			t.Errorf("wrong position converted back: %+v, %+v", beam, beams[i])
+		}
+	}
+
+	if err := ConvertFrame(converted, "equatorial", "ecliptic"); err == nil {
+		t.Error("no error for an unknown frame")
+	}
+}
+
+// The packing is converted as a copy, and returned as it is when it is in
+// the frame already.
+func TestToFrame(t *testing.T) {
+	beams := get_test_beams([2]float64{266.4, -28.9}, [2]float64{266.5, -29})
+	p := &Packing{Bunches: []Bunch{{ID: 0, Beams: beams}}, Tangent: &Tangent{RA: 266.45, Dec: -28.95}}
+
+	q, err := ToFrame(p, "galactic")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q.Frame != "galactic" || p.Frame != "" || p.Bunches[0].Beams[0].X != 266.4 || p.Tangent.RA != 266.45 {
+		t.Fatalf("wrong frames: %q, %q", q.Frame, p.Frame)
+	}
+
+	l, b := EquatorialToGalactic(266.4, -28.9)
+	if got := q.Bunches[0].Beams[0]; got.X != l || got.Y != b {
+		t.Errorf("wrong Galactic position: %g, %g", got.X, got.Y)
+	}
+
+	r, err := ToFrame(q, "equatorial")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if r.Frame != "" || math.Abs(r.Bunches[0].Beams[1].X-266.5) > 1e-9 || math.Abs(r.Tangent.Dec+28.95) > 1e-9 {
+		t.Errorf("wrong packing converted back: %q, %+v, %+v", r.Frame, r.Bunches[0].Beams[1], r.Tangent)
+	}
+
+	if same, err := ToFrame(p, ""); err != nil || same != p {
+		t.Errorf("packing copied into its own frame: %v", err)
+	}
+}
@@ -0,0 +1,279 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// FitnessFunc rates a packing for the genetic optimizer. Lower values are
+// better.
+type FitnessFunc func(p *Packing, dist DistanceFunc) float64
+
+// Fitnesses lists the available fitness functions of the genetic optimizer.
+var Fitnesses = []string{"cost", "maxsep"}
+
+// GetFitness looks up a fitness function by name: cost is the weighted sum
+// of intra-bunch pairwise distances that the annealing optimizer minimises,
+// maxsep the sum of the maximum intra-bunch separations.
+func GetFitness(name string) (FitnessFunc, error) {
+	switch name {
+	case "", "cost":
+		return Cost, nil
+	case "maxsep":
+		return MaxSepSum, nil
+	default:
+		return nil, fmt.Errorf("Unknown fitness function: %s", name)
+	}
+}
+
+// MaxSepSum computes the sum of the maximum intra-bunch separations of the
+// packing.
+func MaxSepSum(p *Packing, dist DistanceFunc) float64 {
+	var sum float64
+
+	for _, b := range p.Bunches {
+		var sep float64
+
+		for i := range b.Beams {
+			for j := i + 1; j < len(b.Beams); j++ {
+				sep = math.Max(sep, dist(b.Beams[i].X, b.Beams[i].Y, b.Beams[j].X, b.Beams[j].Y))
+			}
+		}
+
+		sum += sep
+	}
+
+	return sum
+}
+
+// GAOptions configure the genetic optimizer.
+type GAOptions struct {
+	// Number of individuals per generation, defaults to 50.
+	Population int
+	// Number of generations, defaults to 200.
+	Generations int
+	// Fitness function, defaults to Cost.
+	Fitness FitnessFunc
+	// Progress reporting and runtime limit, if given.
+	Monitor *Monitor
+}
+
+// An individual of the genetic optimizer: the bunch index of every beam and
+// its fitness.
+type individual struct {
+	group   []int
+	fitness float64
+}
+
+// Evolve refines a packing using a genetic algorithm. The individuals are
+// assignments of the beams to the bunches of the packing. Children combine
+// a random half of the bunches of one parent with the assignments of the
+// other parent, which are repaired to keep the bunch sizes, and are mutated
+// by swapping neighbouring beams between bunches. The parents are selected
+// in tournaments and the best individuals survive into the next generation.
+// The best packing found is returned. Pinned beams stay in their bunches.
+func Evolve(p *Packing, opts GAOptions, dist DistanceFunc, rng *rand.Rand) *Packing {
+	const elites = 2
+	const tournament = 3
+
+	population := opts.Population
+	if population <= 0 {
+		population = 50
+	}
+
+	generations := opts.Generations
+	if generations <= 0 {
+		generations = 200
+	}
+
+	fitness := opts.Fitness
+	if fitness == nil {
+		fitness = Cost
+	}
+
+	beams, group := flatten_packing(p)
+	ngroups := len(p.Bunches)
+	n := len(beams)
+
+	result := *p
+
+	if n < 2 || ngroups < 2 {
+		result.set_groups(regroup(beams, group, ngroups))
+		keep_bunches(&result, p)
+		return &result
+	}
+
+	// projected packings are refined on the tangent plane
+	work := beams
+	if p.Tangent != nil {
+		if projected, err := project_beams(beams, *p.Tangent); err == nil {
+			work = projected
+		}
+	}
+
+	sizes := make([]int, ngroups)
+	for _, g := range group {
+		sizes[g]++
+	}
+
+	neighbours := get_neighbours(work, get_nneighbours(sizes), dist)
+	fixed := get_fixed(beams, p.Pinned)
+
+	evaluate := func(genome []int) individual {
+		var q Packing
+		q.set_groups(regroup(work, genome, ngroups))
+		return individual{genome, fitness(&q, dist)}
+	}
+
+	// swap a random beam with a neighbour in another bunch
+	mutate := func(genome []int) {
+		for tries := 0; tries < 100; tries++ {
+			a := rng.Intn(n)
+			b := neighbours[a][rng.Intn(len(neighbours[a]))]
+
+			if genome[a] != genome[b] && !fixed[a] && !fixed[b] {
+				genome[a], genome[b] = genome[b], genome[a]
+				return
+			}
+		}
+	}
+
+	crossover := func(a, b []int) []int {
+		chosen := make([]bool, ngroups)
+		for g := range chosen {
+			chosen[g] = rng.Intn(2) == 0
+		}
+
+		child := make([]int, n)
+		fill := make([]int, ngroups)
+
+		for i := range child {
+			child[i] = -1
+			if chosen[a[i]] {
+				child[i] = a[i]
+				fill[a[i]]++
+			}
+		}
+
+		// the fixed beams are in the same bunch in all individuals, so
+		// they always fit
+		var left []int
+
+		for i, g := range b {
+			if child[i] >= 0 {
+				continue
+			}
+
+			if !chosen[g] && fill[g] < sizes[g] {
+				child[i] = g
+				fill[g]++
+			} else {
+				left = append(left, i)
+			}
+		}
+
+		// move the remaining beams into the closest bunch with space
+		sums := make([][3]float64, ngroups)
+		for i, g := range child {
+			if g >= 0 {
+				sums[g][0] += work[i].X
+				sums[g][1] += work[i].Y
+				sums[g][2]++
+			}
+		}
+
+		for _, i := range left {
+			best := -1
+			var bestdist float64
+
+			for g := range sizes {
+				if fill[g] >= sizes[g] {
+					continue
+				}
+
+				d := 0.0
+				if sums[g][2] > 0 {
+					d = dist(work[i].X, work[i].Y, sums[g][0]/sums[g][2], sums[g][1]/sums[g][2])
+				}
+
+				if best < 0 || d < bestdist {
+					best, bestdist = g, d
+				}
+			}
+
+			child[i] = best
+			fill[best]++
+		}
+
+		return child
+	}
+
+	// the initial population consists of the packing and perturbed copies
+	pop := make([]individual, population)
+	pop[0] = evaluate(append([]int(nil), group...))
+
+	for k := 1; k < population; k++ {
+		genome := append([]int(nil), group...)
+		for s := rng.Intn(max(n/4, 1)) + 1; s > 0; s-- {
+			mutate(genome)
+		}
+
+		pop[k] = evaluate(genome)
+	}
+
+	byfitness := func(pop []individual) {
+		sort.SliceStable(pop, func(i, j int) bool {
+			return pop[i].fitness < pop[j].fitness
+		})
+	}
+
+	selected := func() []int {
+		winner := pop[rng.Intn(population)]
+		for k := 1; k < tournament; k++ {
+			if other := pop[rng.Intn(population)]; other.fitness < winner.fitness {
+				winner = other
+			}
+		}
+
+		return winner.group
+	}
+
+	byfitness(pop)
+	opts.Monitor.begin()
+
+	for gen := 0; gen < generations; gen++ {
+		if opts.Monitor.update(gen, generations, pop[0].fitness, 0) {
+			break
+		}
+
+		next := make([]individual, 0, population)
+		next = append(next, pop[:min(elites, population)]...)
+
+		for len(next) < population {
+			child := crossover(selected(), selected())
+
+			for rng.Float64() < 0.5 {
+				mutate(child)
+			}
+
+			next = append(next, evaluate(child))
+		}
+
+		pop = next
+		byfitness(pop)
+	}
+
+	groups := regroup(work, pop[0].group, ngroups)
+	rank_groups(groups, dist)
+
+	if p.Tangent != nil {
+		restore_beams(groups, beams)
+	}
+
+	result.set_groups(groups)
+	keep_bunches(&result, p)
+
+	return &result
+}
@@ -0,0 +1,201 @@
+package beampack
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// Edge connects two neighbouring beams of a graph, given by their index in
+// the graph beams.
+type Edge struct {
+	A    int
+	B    int
+	Dist float64
+}
+
+// Graph is the adjacency graph of the beams, in which beams within a
+// maximum separation of each other are connected.
+type Graph struct {
+	// The maximum separation of neighbouring beams.
+	MaxSep float64
+	Beams  []Beam
+	// The bunch ID of every beam.
+	Bunch []int
+	Edges []Edge
+}
+
+// Get the squared distance in the embedding that corresponds to the metric
+// distance r.
+func get_embedded_radius2(dist DistanceFunc, r float64) float64 {
+	if is_angular(dist) {
+		const deg = math.Pi / 180.0
+		chord := 2 * math.Sin(math.Min(r, 180)*deg/2)
+		return chord * chord
+	}
+
+	return r * r
+}
+
+// Get the median separation of the beams from their nearest neighbour.
+func get_median_separation(beams []Beam, dist DistanceFunc) float64 {
+	if len(beams) < 2 {
+		return 0
+	}
+
+	neighbours := get_neighbours(beams, 1, dist)
+	seps := make([]float64, len(beams))
+
+	for i, beam := range beams {
+		j := neighbours[i][0]
+		seps[i] = dist(beam.X, beam.Y, beams[j].X, beams[j].Y)
+	}
+
+	sort.Float64s(seps)
+
+	return seps[len(seps)/2]
+}
+
+// Adjacency computes the adjacency graph of the packed coherent beams. Beams
+// whose separation is at most maxsep are connected. A non-positive maxsep
+// selects 1.5 times the median nearest-neighbour separation, which connects
+// the adjacent beams of a regular tiling.
+func Adjacency(p *Packing, maxsep float64, dist DistanceFunc) *Graph {
+	g := &Graph{}
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			// the incoherent and dummy beams have no meaningful sky position
+			if beam.Incoherent || beam.Dummy {
+				continue
+			}
+
+			g.Beams = append(g.Beams, beam)
+			g.Bunch = append(g.Bunch, b.ID)
+		}
+	}
+
+	if maxsep <= 0 {
+		maxsep = 1.5 * get_median_separation(g.Beams, dist)
+	}
+
+	g.MaxSep = maxsep
+
+	embed := get_embedding(dist)
+
+	var tree *kdtree
+	var r2 float64
+
+	if embed != nil {
+		tree = new_kdtree(g.Beams, embed)
+		r2 = get_embedded_radius2(dist, maxsep)*(1+1e-9) + 1e-12
+	}
+
+	for i, a := range g.Beams {
+		var candidates []int
+
+		if tree != nil {
+			candidates = tree.within(i, r2)
+		} else {
+			for j := range g.Beams {
+				if j != i {
+					candidates = append(candidates, j)
+				}
+			}
+		}
+
+		for _, j := range candidates {
+			if j <= i {
+				continue
+			}
+
+			b := g.Beams[j]
+			d := dist(a.X, a.Y, b.X, b.Y)
+
+			if d <= maxsep {
+				g.Edges = append(g.Edges, Edge{A: i, B: j, Dist: d})
+			}
+		}
+	}
+
+	return g
+}
+
+// GraphFormats lists the output formats of WriteGraph.
+var GraphFormats = []string{"dot", "gv", "graphml"}
+
+// WriteGraph writes the adjacency graph in one of the graph formats: dot or
+// gv for Graphviz, or graphml.
+func WriteGraph(w io.Writer, g *Graph, format string) error {
+	switch format {
+	case "dot", "gv":
+		return write_dot(w, g)
+	case "graphml":
+		return write_graphml(w, g)
+	}
+
+	return fmt.Errorf("Unknown graph format: %s", format)
+}
+
+// Write the graph in Graphviz DOT format.
+func write_dot(w io.Writer, g *Graph) error {
+	fmt.Fprintf(w, "graph beams {\n")
+	fmt.Fprintf(w, "  // maximum separation: %.6f\n", g.MaxSep)
+
+	for i, beam := range g.Beams {
+		fmt.Fprintf(w, "  %q [beam=%d, bunch=%d, x=%.6f, y=%.6f];\n",
+			beam.key(), beam.Nr, g.Bunch[i], beam.X, beam.Y)
+	}
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "  %q -- %q [dist=%.6f];\n", g.Beams[e.A].key(), g.Beams[e.B].key(), e.Dist)
+	}
+
+	_, err := fmt.Fprintf(w, "}\n")
+
+	return err
+}
+
+// Escape a string for use in XML.
+func xml_escape(text string) string {
+	var sb strings.Builder
+	xml.EscapeText(&sb, []byte(text))
+
+	return sb.String()
+}
+
+// Write the graph in GraphML format.
+func write_graphml(w io.Writer, g *Graph) error {
+	fmt.Fprintf(w, "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n")
+	fmt.Fprintf(w, "<graphml xmlns=\"http://graphml.graphdrawing.org/xmlns\">\n")
+	fmt.Fprintf(w, "  <key id=\"beam\" for=\"node\" attr.name=\"beam\" attr.type=\"int\"/>\n")
+	fmt.Fprintf(w, "  <key id=\"bunch\" for=\"node\" attr.name=\"bunch\" attr.type=\"int\"/>\n")
+	fmt.Fprintf(w, "  <key id=\"x\" for=\"node\" attr.name=\"x\" attr.type=\"double\"/>\n")
+	fmt.Fprintf(w, "  <key id=\"y\" for=\"node\" attr.name=\"y\" attr.type=\"double\"/>\n")
+	fmt.Fprintf(w, "  <key id=\"dist\" for=\"edge\" attr.name=\"dist\" attr.type=\"double\"/>\n")
+	fmt.Fprintf(w, "  <graph id=\"beams\" edgedefault=\"undirected\">\n")
+
+	for i, beam := range g.Beams {
+		fmt.Fprintf(w, "    <node id=\"%s\">\n", xml_escape(beam.key()))
+		fmt.Fprintf(w, "      <data key=\"beam\">%d</data>\n", beam.Nr)
+		fmt.Fprintf(w, "      <data key=\"bunch\">%d</data>\n", g.Bunch[i])
+		fmt.Fprintf(w, "      <data key=\"x\">%.6f</data>\n", beam.X)
+		fmt.Fprintf(w, "      <data key=\"y\">%.6f</data>\n", beam.Y)
+		fmt.Fprintf(w, "    </node>\n")
+	}
+
+	for _, e := range g.Edges {
+		fmt.Fprintf(w, "    <edge source=\"%s\" target=\"%s\">\n",
+			xml_escape(g.Beams[e.A].key()), xml_escape(g.Beams[e.B].key()))
+		fmt.Fprintf(w, "      <data key=\"dist\">%.6f</data>\n", e.Dist)
+		fmt.Fprintf(w, "    </edge>\n")
+	}
+
+	fmt.Fprintf(w, "  </graph>\n")
+	_, err := fmt.Fprintf(w, "</graphml>\n")
+
+	return err
+}
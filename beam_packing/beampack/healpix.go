@@ -0,0 +1,664 @@
+package beampack
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// HEALPixOrderings lists the HEALPix pixel orderings.
+var HEALPixOrderings = []string{"ring", "nested"}
+
+// The largest supported HEALPix resolution parameter.
+const healpix_max_nside = 1 << 29
+
+// The value of the HEALPix maps for pixels without data.
+const healpix_unseen = -1.6375e30
+
+// The row and column in the tiling of the base pixels, for the nested
+// scheme.
+var (
+	healpix_jrll = [12]int64{2, 2, 2, 2, 3, 3, 3, 3, 4, 4, 4, 4}
+	healpix_jpll = [12]int64{1, 3, 5, 7, 0, 2, 4, 6, 1, 3, 5, 7}
+)
+
+// HEALPix is a HEALPix pixelisation of the sphere with resolution parameter
+// NSide, a power of two, in the ring or nested ordering.
+type HEALPix struct {
+	NSide  int64
+	Nested bool
+	order  int
+}
+
+// NewHEALPix creates the pixelisation with the resolution parameter.
+func NewHEALPix(nside int64, nested bool) (HEALPix, error) {
+	if nside < 1 || nside > healpix_max_nside || nside&(nside-1) != 0 {
+		return HEALPix{}, fmt.Errorf("Invalid HEALPix resolution, must be a power of two up to %d: %d", healpix_max_nside, nside)
+	}
+
+	return HEALPix{NSide: nside, Nested: nested, order: bits.TrailingZeros64(uint64(nside))}, nil
+}
+
+// NPix returns the number of pixels.
+func (h HEALPix) NPix() int64 {
+	return 12 * h.NSide * h.NSide
+}
+
+// Resolution returns the mean pixel spacing in degrees.
+func (h HEALPix) Resolution() float64 {
+	return math.Sqrt(4*math.Pi/float64(h.NPix())) * 180 / math.Pi
+}
+
+// Ordering returns the name of the pixel ordering.
+func (h HEALPix) Ordering() string {
+	if h.Nested {
+		return "nested"
+	}
+
+	return "ring"
+}
+
+// Interleave the bits of the coordinates of a pixel in its base pixel.
+func healpix_spread(v int64) int64 {
+	var r int64
+
+	for i := 0; v != 0; i++ {
+		r |= (v & 1) << (2 * i)
+		v >>= 1
+	}
+
+	return r
+}
+
+// Extract the even bits of a nested pixel index.
+func healpix_compress(v int64) int64 {
+	var r int64
+
+	for i := 0; v != 0; i++ {
+		r |= (v & 1) << i
+		v >>= 2
+	}
+
+	return r
+}
+
+// Get the nested pixel index of a pixel given by its coordinates in its
+// base pixel.
+func (h HEALPix) xyf2nest(ix, iy int64, face int) int64 {
+	return int64(face)<<(2*h.order) + healpix_spread(ix) + healpix_spread(iy)<<1
+}
+
+// Pixel returns the index of the pixel that contains the position, RA and
+// Dec in degrees.
+func (h HEALPix) Pixel(ra, dec float64) int64 {
+	const deg = math.Pi / 180.0
+
+	n := h.NSide
+
+	z := math.Sin(dec * deg)
+	za := math.Abs(z)
+
+	// the longitude in units of 90 degrees
+	tt := math.Mod(ra/90, 4)
+	if tt < 0 {
+		tt += 4
+	}
+
+	if za <= 2.0/3 {
+		// the equatorial region, with the indices of the ascending and
+		// descending edge lines
+		temp1 := float64(n) * (0.5 + tt)
+		temp2 := float64(n) * z * 0.75
+
+		jp := int64(temp1 - temp2)
+		jm := int64(temp1 + temp2)
+
+		if h.Nested {
+			ifp, ifm := jp>>h.order, jm>>h.order
+
+			face := int(ifm + 8)
+			switch {
+			case ifp == ifm:
+				face = int(ifp | 4)
+			case ifp < ifm:
+				face = int(ifp)
+			}
+
+			return h.xyf2nest(jm&(n-1), n-(jp&(n-1))-1, face)
+		}
+
+		nl4 := 4 * n
+		ir := n + 1 + jp - jm
+		kshift := 1 - ir&1
+
+		ip := ((jp + jm - n + kshift + 1 + 2*nl4) >> 1) & (nl4 - 1)
+
+		return 2*n*(n-1) + (ir-1)*nl4 + ip
+	}
+
+	// the polar caps
+	ntt := min(int64(tt), 3)
+	tp := tt - float64(ntt)
+
+	tmp := float64(n) * math.Sqrt(3*(1-za))
+	if za >= 0.99 {
+		// more accurate near the poles
+		tmp = float64(n) * math.Cos(dec*deg) / math.Sqrt((1+za)/3)
+	}
+
+	jp := int64(tp * tmp)
+	jm := int64((1 - tp) * tmp)
+
+	if h.Nested {
+		jp, jm = min(jp, n-1), min(jm, n-1)
+
+		if z >= 0 {
+			return h.xyf2nest(n-jm-1, n-jp-1, int(ntt))
+		}
+
+		return h.xyf2nest(jp, jm, int(ntt)+8)
+	}
+
+	ir := jp + jm + 1
+	ip := min(int64(tt*float64(ir)), 4*ir-1)
+
+	if z > 0 {
+		return 2*ir*(ir-1) + ip
+	}
+
+	return h.NPix() - 2*ir*(ir+1) + ip
+}
+
+// Get the z coordinate and longitude in radians of the centre of a pixel
+// of the ring scheme.
+func (h HEALPix) ring_centre(pix int64) (float64, float64) {
+	n := h.NSide
+	npix := h.NPix()
+	ncap := 2 * n * (n - 1)
+
+	fact2 := 4 / float64(npix)
+	fact1 := 2 * float64(n) * fact2
+
+	switch {
+	case pix < ncap:
+		// the ring counted from the north pole, corrected for the
+		// rounding of the square root
+		iring := (1 + int64(math.Sqrt(float64(1+2*pix)))) >> 1
+		for 2*iring*(iring-1) > pix {
+			iring--
+		}
+
+		for 2*iring*(iring+1) <= pix {
+			iring++
+		}
+
+		iphi := pix + 1 - 2*iring*(iring-1)
+
+		return 1 - float64(iring*iring)*fact2, (float64(iphi) - 0.5) * math.Pi / 2 / float64(iring)
+
+	case pix < npix-ncap:
+		ip := pix - ncap
+		tmp := ip / (4 * n)
+
+		iring := tmp + n
+		iphi := ip - 4*n*tmp + 1
+
+		fodd := 0.5
+		if (iring+n)&1 != 0 {
+			fodd = 1
+		}
+
+		return float64(2*n-iring) * fact1, (float64(iphi) - fodd) * math.Pi * 0.75 * fact1
+	}
+
+	ip := npix - pix
+
+	// the ring counted from the south pole
+	iring := (1 + int64(math.Sqrt(float64(2*ip-1)))) >> 1
+	for 2*iring*(iring-1) >= ip {
+		iring--
+	}
+
+	for 2*iring*(iring+1) < ip {
+		iring++
+	}
+
+	iphi := 4*iring + 1 - (ip - 2*iring*(iring-1))
+
+	return float64(iring*iring)*fact2 - 1, (float64(iphi) - 0.5) * math.Pi / 2 / float64(iring)
+}
+
+// Get the z coordinate and longitude in radians of the centre of a pixel
+// of the nested scheme.
+func (h HEALPix) nest_centre(pix int64) (float64, float64) {
+	n := h.NSide
+	npix := h.NPix()
+
+	fact2 := 4 / float64(npix)
+	fact1 := 2 * float64(n) * fact2
+
+	face := pix >> (2 * h.order)
+	sub := pix & (n*n - 1)
+	ix, iy := healpix_compress(sub), healpix_compress(sub>>1)
+
+	jr := healpix_jrll[face]<<h.order - ix - iy - 1
+
+	var nr int64
+	var z float64
+
+	switch {
+	case jr < n:
+		nr = jr
+		z = 1 - float64(nr*nr)*fact2
+	case jr > 3*n:
+		nr = 4*n - jr
+		z = float64(nr*nr)*fact2 - 1
+	default:
+		nr = n
+		z = float64(2*n-jr) * fact1
+	}
+
+	tmp := healpix_jpll[face]*nr + ix - iy
+	if tmp < 0 {
+		tmp += 8 * nr
+	}
+
+	if nr == n {
+		return z, 0.75 * math.Pi / 2 * float64(tmp) * fact1
+	}
+
+	return z, 0.5 * math.Pi / 2 * float64(tmp) / float64(nr)
+}
+
+// Centre returns the position of the centre of the pixel, RA and Dec in
+// degrees.
+func (h HEALPix) Centre(pix int64) (float64, float64) {
+	const deg = math.Pi / 180.0
+
+	var z, phi float64
+
+	if h.Nested {
+		z, phi = h.nest_centre(pix)
+	} else {
+		z, phi = h.ring_centre(pix)
+	}
+
+	return math.Mod(phi/deg, 360), math.Asin(max(-1, min(1, z))) / deg
+}
+
+// HEALPixMap is a partial HEALPix map of the sky coverage with the values
+// of the covered pixels.
+type HEALPixMap struct {
+	HEALPix
+	Values map[int64]float64
+}
+
+// NewHEALPixMap creates an empty map.
+func NewHEALPixMap(h HEALPix) *HEALPixMap {
+	return &HEALPixMap{HEALPix: h, Values: make(map[int64]float64)}
+}
+
+// FootprintOptions configure the rasterisation of the beam footprints.
+type FootprintOptions struct {
+	// The shape of the beams that have none, as semi-axes at half power in
+	// degrees and position angle in degrees.
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+	// The value added to the covered pixels.
+	Value float64
+}
+
+// Footprint returns the pixels covered by the half-power footprints of the
+// coherent beams, ordered by index. A pixel is covered if its centre lies
+// within the footprint of a beam, or if it contains the centre of a beam,
+// so that beams narrower than the pixels are not lost. The beam positions
+// are interpreted as RA and Dec in degrees.
+func (h HEALPix) Footprint(beams []Beam, opts FootprintOptions) ([]int64, error) {
+	const deg = math.Pi / 180.0
+
+	covered := make(map[int64]bool)
+	res := h.Resolution()
+
+	for _, beam := range beams {
+		if beam.Incoherent || beam.Dummy {
+			continue
+		}
+
+		a, b, pa := opts.SemiMajor, opts.SemiMinor, opts.PA
+		if beam.SemiMajor > 0 && beam.SemiMinor > 0 {
+			a, b, pa = beam.SemiMajor, beam.SemiMinor, beam.PA
+		}
+
+		if a <= 0 || b <= 0 {
+			return nil, fmt.Errorf("No beam shape for beam: %s", beam.key())
+		}
+
+		covered[h.Pixel(beam.X, beam.Y)] = true
+
+		// sample the footprint on the tangent plane at the beam finely
+		// enough to hit every pixel whose centre is within it
+		step := min(res/4, b/2)
+		n := int(math.Ceil(a / step))
+
+		t := Tangent{RA: beam.X, Dec: beam.Y}
+		sinpa, cospa := math.Sincos(pa * deg)
+
+		checked := make(map[int64]bool)
+
+		for i := -n; i <= n; i++ {
+			for j := -n; j <= n; j++ {
+				x, y := float64(i)*step, float64(j)*step
+
+				// the offsets along the minor and major axes
+				u := x*cospa - y*sinpa
+				v := x*sinpa + y*cospa
+
+				if math.Hypot(u/b, v/a) > 1 {
+					continue
+				}
+
+				pix := h.Pixel(t.Deproject(x, y))
+				if checked[pix] {
+					continue
+				}
+
+				checked[pix] = true
+
+				ra, dec := h.Centre(pix)
+				if _, w := get_beam_offset(beam, ra, dec, a, b, pa); w <= 0.5 {
+					covered[pix] = true
+				}
+			}
+		}
+	}
+
+	pixels := make([]int64, 0, len(covered))
+	for pix := range covered {
+		pixels = append(pixels, pix)
+	}
+
+	sort.Slice(pixels, func(i, j int) bool {
+		return pixels[i] < pixels[j]
+	})
+
+	return pixels, nil
+}
+
+// AddPointing adds the value of the options to the pixels covered by the
+// beams of a pointing, once per pixel however many beams cover it. It
+// returns the number of covered pixels.
+func (m *HEALPixMap) AddPointing(beams []Beam, opts FootprintOptions) (int, error) {
+	pixels, err := m.Footprint(beams, opts)
+	if err != nil {
+		return 0, err
+	}
+
+	value := opts.Value
+	if value == 0 {
+		value = 1
+	}
+
+	for _, pix := range pixels {
+		m.Values[pix] += value
+	}
+
+	return len(pixels), nil
+}
+
+// Area returns the area of the covered pixels in square degrees.
+func (m *HEALPixMap) Area() float64 {
+	return float64(len(m.Values)) * 4 * math.Pi / float64(m.NPix()) * math.Pow(180/math.Pi, 2)
+}
+
+// LoadHEALPixMap reads a HEALPix map from a FITS file, either a partial map
+// with explicit PIXEL and SIGNAL columns, as written by WriteHEALPixMap, or
+// a full-sky map with the values of all pixels in the first column. Pixels
+// without data or with zero value are left out.
+func LoadHEALPixMap(filename string) (*HEALPixMap, error) {
+	raw, err := read_input(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	m, err := parse_healpix(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read HEALPix map: %s, %s", filename, err)
+	}
+
+	return m, nil
+}
+
+func parse_healpix(raw []byte) (*HEALPixMap, error) {
+	hdus, err := read_fits(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	hdu, err := select_hdu(hdus, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if pixtype := hdu.keywords["PIXTYPE"]; !strings.EqualFold(pixtype, "HEALPIX") {
+		return nil, fmt.Errorf("Not a HEALPix map: PIXTYPE %q", pixtype)
+	}
+
+	nside, err := hdu.get_int("NSIDE", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	var nested bool
+
+	switch ordering := strings.ToUpper(hdu.keywords["ORDERING"]); ordering {
+	case "RING":
+	case "NESTED", "NEST":
+		nested = true
+	default:
+		return nil, fmt.Errorf("Unknown pixel ordering: %q", ordering)
+	}
+
+	h, err := NewHEALPix(int64(nside), nested)
+	if err != nil {
+		return nil, err
+	}
+
+	width, err := hdu.get_int("NAXIS1", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	nrows, err := hdu.get_int("NAXIS2", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	columns, err := get_fits_columns(hdu)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(columns) == 0 {
+		return nil, fmt.Errorf("No map columns")
+	}
+
+	if last := columns[len(columns)-1]; last.offset+last.width() > width {
+		return nil, fmt.Errorf("The columns do not fit into the rows: %d, %d", last.offset+last.width(), width)
+	}
+
+	m := NewHEALPixMap(h)
+
+	add := func(pix int64, v float64) error {
+		if pix < 0 || pix >= h.NPix() {
+			return fmt.Errorf("Invalid pixel: %d", pix)
+		}
+
+		if v != 0 && !math.IsNaN(v) && v > healpix_unseen/2 {
+			m.Values[pix] += v
+		}
+
+		return nil
+	}
+
+	if strings.EqualFold(hdu.keywords["INDXSCHM"], "EXPLICIT") {
+		var pixcol, valcol *fits_column
+
+		for i := range columns {
+			switch {
+			case strings.EqualFold(columns[i].name, "PIXEL"):
+				pixcol = &columns[i]
+			case valcol == nil:
+				valcol = &columns[i]
+			}
+		}
+
+		if pixcol == nil || valcol == nil {
+			return nil, fmt.Errorf("No PIXEL and value columns in partial map")
+		}
+
+		for r := 0; r < nrows; r++ {
+			row := hdu.data[r*width : (r+1)*width]
+
+			pix, ok1 := pixcol.value(row, 0)
+			v, ok2 := valcol.value(row, 0)
+
+			if !ok1 || !ok2 {
+				return nil, fmt.Errorf("Unsupported map column format")
+			}
+
+			if err := add(int64(pix), v); err != nil {
+				return nil, err
+			}
+		}
+
+		return m, nil
+	}
+
+	// the values of all pixels in order, possibly several per row
+	c := columns[0]
+	if int64(nrows*c.repeat) != h.NPix() {
+		return nil, fmt.Errorf("The map has %d values for %d pixels", nrows*c.repeat, h.NPix())
+	}
+
+	for r := 0; r < nrows; r++ {
+		row := hdu.data[r*width : (r+1)*width]
+
+		for k := 0; k < c.repeat; k++ {
+			v, ok := c.value(row, k)
+			if !ok {
+				return nil, fmt.Errorf("Unsupported map column format")
+			}
+
+			if err := add(int64(r*c.repeat+k), v); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	return m, nil
+}
+
+// Format a FITS header card.
+func fits_card_text(key string, value any, comment string) string {
+	var text string
+
+	switch v := value.(type) {
+	case string:
+		text = fmt.Sprintf("%-8s= %-20s", key, "'"+fmt.Sprintf("%-8s", strings.ReplaceAll(v, "'", "''"))+"'")
+	case float64:
+		text = fmt.Sprintf("%-8s= %20.1f", key, v)
+	case bool:
+		text = fmt.Sprintf("%-8s= %20s", key, map[bool]string{true: "T", false: "F"}[v])
+	default:
+		text = fmt.Sprintf("%-8s= %20v", key, v)
+	}
+
+	if comment != "" {
+		text += " / " + comment
+	}
+
+	return fmt.Sprintf("%-80.80s", text)
+}
+
+// Pad FITS data to full blocks with the fill byte.
+func fits_pad(b *bytes.Buffer, fill byte) {
+	if rem := b.Len() % fits_block; rem != 0 {
+		b.Write(bytes.Repeat([]byte{fill}, fits_block-rem))
+	}
+}
+
+// WriteHEALPixMap writes the map as partial HEALPix map in FITS format, a
+// binary table of the covered pixels with explicit PIXEL and SIGNAL
+// columns in celestial coordinates, as read by healpy and other HEALPix
+// software.
+func WriteHEALPixMap(w io.Writer, m *HEALPixMap) error {
+	pixels := make([]int64, 0, len(m.Values))
+	for pix := range m.Values {
+		pixels = append(pixels, pix)
+	}
+
+	sort.Slice(pixels, func(i, j int) bool {
+		return pixels[i] < pixels[j]
+	})
+
+	var b bytes.Buffer
+
+	for _, card := range [][3]any{
+		{"SIMPLE", true, "conforms to FITS standard"},
+		{"BITPIX", 8, ""},
+		{"NAXIS", 0, ""},
+		{"EXTEND", true, ""},
+	} {
+		b.WriteString(fits_card_text(card[0].(string), card[1], card[2].(string)))
+	}
+
+	b.WriteString(fmt.Sprintf("%-80s", "END"))
+	fits_pad(&b, ' ')
+
+	for _, card := range [][3]any{
+		{"XTENSION", "BINTABLE", "binary table extension"},
+		{"BITPIX", 8, ""},
+		{"NAXIS", 2, ""},
+		{"NAXIS1", 16, "bytes per row"},
+		{"NAXIS2", len(pixels), "number of covered pixels"},
+		{"PCOUNT", 0, ""},
+		{"GCOUNT", 1, ""},
+		{"TFIELDS", 2, ""},
+		{"TTYPE1", "PIXEL", ""},
+		{"TFORM1", "1K", ""},
+		{"TTYPE2", "SIGNAL", ""},
+		{"TFORM2", "1D", ""},
+		{"TUNIT2", "pointings", ""},
+		{"EXTNAME", "COVERAGE", ""},
+		{"PIXTYPE", "HEALPIX", "HEALPix pixelisation"},
+		{"ORDERING", strings.ToUpper(m.Ordering()), "pixel ordering scheme"},
+		{"COORDSYS", "C", "celestial (equatorial) coordinates"},
+		{"EQUINOX", 2000.0, ""},
+		{"NSIDE", m.NSide, "resolution parameter"},
+		{"FIRSTPIX", 0, ""},
+		{"LASTPIX", m.NPix() - 1, ""},
+		{"INDXSCHM", "EXPLICIT", "indexing: explicit pixel numbers"},
+		{"OBJECT", "PARTIAL", "sky coverage"},
+	} {
+		b.WriteString(fits_card_text(card[0].(string), card[1], card[2].(string)))
+	}
+
+	b.WriteString(fmt.Sprintf("%-80s", "END"))
+	fits_pad(&b, ' ')
+
+	for _, pix := range pixels {
+		binary.Write(&b, binary.BigEndian, pix)
+		binary.Write(&b, binary.BigEndian, m.Values[pix])
+	}
+
+	fits_pad(&b, 0)
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
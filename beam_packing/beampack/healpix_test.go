@@ -0,0 +1,138 @@
+package beampack
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+func get_test_healpix(t *testing.T, nside int64, nested bool) HEALPix {
+	t.Helper()
+
+	h, err := NewHEALPix(nside, nested)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return h
+}
+
+func TestHEALPixGeometry(t *testing.T) {
+	h := get_test_healpix(t, 4096, false)
+
+	if h.NPix() != 201326592 {
+		t.Errorf("wrong number of pixels: %d", h.NPix())
+	}
+
+	if math.Abs(h.Resolution()*60-0.8588) > 1e-3 {
+		t.Errorf("wrong resolution: %g arcmin", h.Resolution()*60)
+	}
+
+	if _, err := NewHEALPix(3, false); err == nil {
+		t.Error("no error for an nside that is no power of two")
+	}
+}
+
+func TestHEALPixCentre(t *testing.T) {
+	// the equatorial base pixel of nside 1 is centred on RA and Dec zero
+	for _, nested := range []bool{false, true} {
+		h := get_test_healpix(t, 1, nested)
+
+		if ra, dec := h.Centre(4); math.Abs(ra) > 1e-12 || math.Abs(dec) > 1e-12 {
+			t.Errorf("%s: wrong centre of pixel 4: %g, %g", h.Ordering(), ra, dec)
+		}
+
+		if pix := h.Pixel(10, 5); pix != 4 {
+			t.Errorf("%s: wrong pixel: %d", h.Ordering(), pix)
+		}
+	}
+}
+
+// Every pixel contains its own centre, and the centres of the nested
+// pixels are those of the ring pixels.
+func TestHEALPixRoundTrip(t *testing.T) {
+	for _, nside := range []int64{1, 2, 8, 32} {
+		ring := get_test_healpix(t, nside, false)
+		nest := get_test_healpix(t, nside, true)
+
+		seen := make(map[int64]bool)
+
+		for pix := int64(0); pix < ring.NPix(); pix++ {
+			ra, dec := ring.Centre(pix)
+			if got := ring.Pixel(ra, dec); got != pix {
+				t.Fatalf("nside %d: ring pixel %d has the centre of %d", nside, pix, got)
+			}
+
+			ra, dec = nest.Centre(pix)
+			if got := nest.Pixel(ra, dec); got != pix {
+				t.Fatalf("nside %d: nested pixel %d has the centre of %d", nside, pix, got)
+			}
+
+			q := ring.Pixel(ra, dec)
+			if rra, rdec := ring.Centre(q); math.Abs(rra-ra) > 1e-9 || math.Abs(rdec-dec) > 1e-9 {
+				t.Fatalf("nside %d: nested pixel %d is not ring pixel %d", nside, pix, q)
+			}
+
+			if seen[q] {
+				t.Fatalf("nside %d: ring pixel %d is several nested pixels", nside, q)
+			}
+
+			seen[q] = true
+		}
+	}
+}
+
+func TestHEALPixFootprint(t *testing.T) {
+	h := get_test_healpix(t, 1024, true)
+
+	// a beam of 0.2 deg radius covers an area of about 0.126 square degrees
+	beams := []Beam{{X: 134.0696, Y: -30, SemiMajor: 0.2, SemiMinor: 0.2}}
+
+	m := NewHEALPixMap(h)
+
+	n, err := m.AddPointing(beams, FootprintOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if n == 0 || math.Abs(m.Area()-math.Pi*0.04)/(math.Pi*0.04) > 0.1 {
+		t.Errorf("wrong footprint: %d pixels, %g square degrees", n, m.Area())
+	}
+
+	if _, err := m.AddPointing([]Beam{{X: 10, Y: 10}}, FootprintOptions{}); err == nil {
+		t.Error("no error for a beam without shape")
+	}
+}
+
+func TestHEALPixMapRoundTrip(t *testing.T) {
+	for _, nested := range []bool{false, true} {
+		m := NewHEALPixMap(get_test_healpix(t, 256, nested))
+		m.Values[0] = 1
+		m.Values[12345] = 2.5
+		m.Values[m.NPix()-1] = 3
+
+		var b bytes.Buffer
+		if err := WriteHEALPixMap(&b, m); err != nil {
+			t.Fatal(err)
+		}
+
+		if b.Len()%fits_block != 0 {
+			t.Errorf("%s: the FITS file is not padded: %d", m.Ordering(), b.Len())
+		}
+
+		got, err := parse_healpix(b.Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got.NSide != 256 || got.Nested != nested || len(got.Values) != 3 {
+			t.Fatalf("%s: wrong map: nside %d, %s, %d pixels", m.Ordering(), got.NSide, got.Ordering(), len(got.Values))
+		}
+
+		for pix, v := range m.Values {
+			if got.Values[pix] != v {
+				t.Errorf("%s: wrong value of pixel %d: %g", m.Ordering(), pix, got.Values[pix])
+			}
+		}
+	}
+}
@@ -0,0 +1,99 @@
+package beampack
+
+import (
+	"math"
+	"sort"
+)
+
+// Compute the position of the cell (x, y) along the Hilbert curve that fills
+// an n x n grid, where n is a power of two.
+func get_hilbert_index(n, x, y uint64) uint64 {
+	var d uint64
+
+	for s := n / 2; s > 0; s /= 2 {
+		var rx, ry uint64
+
+		if x&s > 0 {
+			rx = 1
+		}
+		if y&s > 0 {
+			ry = 1
+		}
+
+		d += s * s * ((3 * rx) ^ ry)
+
+		// rotate the quadrant
+		if ry == 0 {
+			if rx == 1 {
+				x = s - 1 - x
+				y = s - 1 - y
+			}
+			x, y = y, x
+		}
+	}
+
+	return d
+}
+
+// Pack the beams by sorting them along a Hilbert curve over the bounding box
+// of the tiling and chopping the ordering into consecutive groups of the
+// given sizes.
+func pack_hilbert(data []Beam, sizes []int) [][]Beam {
+	const order = 16
+	const n = 1 << order
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	xmin, xmax := data[0].X, data[0].X
+	ymin, ymax := data[0].Y, data[0].Y
+
+	for _, beam := range data {
+		xmin = math.Min(xmin, beam.X)
+		xmax = math.Max(xmax, beam.X)
+		ymin = math.Min(ymin, beam.Y)
+		ymax = math.Max(ymax, beam.Y)
+	}
+
+	// use a square box so that the curve does not distort the tiling
+	size := math.Max(xmax-xmin, ymax-ymin)
+	if size == 0 {
+		size = 1
+	}
+
+	type entry struct {
+		beam  Beam
+		index uint64
+	}
+
+	work := make([]entry, len(data))
+
+	for i, beam := range data {
+		gx := uint64(math.Min((beam.X-xmin)/size*n, n-1))
+		gy := uint64(math.Min((beam.Y-ymin)/size*n, n-1))
+		work[i] = entry{beam, get_hilbert_index(n, gx, gy)}
+	}
+
+	sort.SliceStable(work, func(i, j int) bool {
+		return work[i].index < work[j].index
+	})
+
+	var groups [][]Beam
+
+	start := 0
+
+	for _, size := range sizes {
+		end := min(start+size, len(work))
+
+		group := make([]Beam, 0, end-start)
+		for _, e := range work[start:end] {
+			group = append(group, e.beam)
+		}
+
+		groups = append(groups, group)
+		start = end
+	}
+
+	return groups
+}
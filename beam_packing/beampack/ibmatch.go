@@ -0,0 +1,167 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+)
+
+// The labels of the coherent and incoherent beam detections.
+const (
+	// A coherent beam detection with an incoherent beam counterpart, or an
+	// incoherent beam detection with a coherent beam counterpart.
+	LabelConfirmed = "confirmed"
+	// A coherent beam detection without an incoherent beam counterpart,
+	// e.g. a faint source or RFI local to the coherent beam.
+	LabelCBOnly = "cb-only"
+	// An incoherent beam detection without a coherent beam counterpart,
+	// e.g. a bright source outside the coherent beam tiling.
+	LabelIBOnly = "ib-only"
+)
+
+// IBMatchOptions configure the coherent and incoherent beam matching.
+type IBMatchOptions struct {
+	// The time window in seconds within which the detections match.
+	Window float64
+	// The DM tolerance of matching detections, zero for no DM criterion.
+	DMTol float64
+}
+
+// Labelled is a coherent or incoherent beam detection with its match label
+// and its nearest counterpart in the other beam type.
+type Labelled struct {
+	Candidate
+	Incoherent bool   `json:"incoherent"`
+	Label      string `json:"label"`
+	// The time offset in seconds, the DM offset and the S/N of the nearest
+	// counterpart, zero if there is none.
+	DT       float64 `json:"dt,omitempty"`
+	DDM      float64 `json:"ddm,omitempty"`
+	MatchSNR float64 `json:"match_snr,omitempty"`
+}
+
+// MatchIncoherent matches the coherent beam detections against the
+// incoherent beam detections in time and DM and labels every coherent
+// detection as confirmed in the incoherent beam or coherent-only, and every
+// incoherent detection as confirmed or incoherent-only. The counterpart of
+// a detection is the matching detection nearest in time. The result is
+// sorted by MJD.
+func MatchIncoherent(cb, ib []Candidate, opts IBMatchOptions) []Labelled {
+	result := make([]Labelled, 0, len(cb)+len(ib))
+
+	for _, c := range cb {
+		result = append(result, Labelled{Candidate: c, Label: LabelCBOnly})
+	}
+
+	for _, c := range ib {
+		result = append(result, Labelled{Candidate: c, Incoherent: true, Label: LabelIBOnly})
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].MJD < result[j].MJD
+	})
+
+	// the detections are sorted in time, so that the candidate counterparts
+	// of every detection are within a contiguous range around it
+	for i := range result {
+		a := &result[i]
+		best := math.Inf(1)
+
+		check := func(k int) bool {
+			b := &result[k]
+
+			dt := (b.MJD - a.MJD) * 86400
+			if math.Abs(dt) > opts.Window {
+				return false
+			}
+
+			if b.Incoherent == a.Incoherent {
+				return true
+			}
+
+			ddm := b.DM - a.DM
+			if opts.DMTol > 0 && math.Abs(ddm) > opts.DMTol {
+				return true
+			}
+
+			a.Label = LabelConfirmed
+
+			if math.Abs(dt) < best {
+				best = math.Abs(dt)
+				a.DT, a.DDM, a.MatchSNR = dt, ddm, b.SNR
+			}
+
+			return true
+		}
+
+		for k := i - 1; k >= 0; k-- {
+			if !check(k) {
+				break
+			}
+		}
+
+		for k := i + 1; k < len(result); k++ {
+			if !check(k) {
+				break
+			}
+		}
+	}
+
+	return result
+}
+
+// WriteLabelled writes the labelled detections to w in the requested format:
+// text, json or csv.
+func WriteLabelled(w io.Writer, cands []Labelled, format string) error {
+	switch format {
+	case "text":
+		for _, l := range cands {
+			_, err := fmt.Fprintf(w, "MJD: %.8f, DM: %.3f, width: %.3f, S/N: %.2f, beam: %d, incoherent: %t, label: %s, dt: %.4f, ddm: %.3f, match S/N: %.2f\n",
+				l.MJD, l.DM, l.Width, l.SNR, l.Beam, l.Incoherent, l.Label, l.DT, l.DDM, l.MatchSNR)
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(cands)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"mjd", "dm", "width", "snr", "beam", "incoherent", "label", "dt", "ddm", "match_snr"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, l := range cands {
+			writer.Write([]string{
+				format_float(l.MJD),
+				format_float(l.DM),
+				format_float(l.Width),
+				format_float(l.SNR),
+				strconv.Itoa(l.Beam),
+				strconv.FormatBool(l.Incoherent),
+				l.Label,
+				format_float(l.DT),
+				format_float(l.DDM),
+				format_float(l.MatchSNR),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
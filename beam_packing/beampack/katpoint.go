@@ -0,0 +1,108 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+)
+
+// TargetFormats lists the katpoint target description output formats: the
+// bunch centroids, or the bunch centroids followed by the beams.
+var TargetFormats = []string{"katpoint", "katpoint-beams"}
+
+// Format an angle in degrees as sexagesimal string, in hours with two
+// decimal places of seconds or in degrees with one.
+func format_sexagesimal(value float64, hours bool) string {
+	sign := ""
+	if value < 0 {
+		sign = "-"
+		value = -value
+	}
+
+	scale := 10.0
+	if hours {
+		value /= 15
+		scale = 100
+	}
+
+	// round to the precision of the seconds first, so that they never
+	// round up to 60
+	total := math.Round(value * 3600 * scale)
+	units := int64(total)
+
+	per := int64(3600 * scale)
+	d := units / per
+	units -= d * per
+
+	m := units / int64(60*scale)
+	units -= m * int64(60*scale)
+
+	s := float64(units) / scale
+
+	if hours {
+		return fmt.Sprintf("%s%d:%02d:%05.2f", sign, d, m, s)
+	}
+
+	return fmt.Sprintf("%s%d:%02d:%04.1f", sign, d, m, s)
+}
+
+// Get the katpoint target description of a position in degrees.
+func get_target(name string, ra, dec float64) string {
+	ra = math.Mod(ra, 360)
+	if ra < 0 {
+		ra += 360
+	}
+
+	return fmt.Sprintf("%s, radec, %s, %s", name, format_sexagesimal(ra, true), format_sexagesimal(dec, false))
+}
+
+// WriteTargets writes the bunch centroids of the packing as katpoint target
+// description strings, one per line, named after the bunch IDs with the
+// processing node as alias. With beams, every bunch is followed by its
+// beams. The beam positions are interpreted as RA and Dec in degrees, and
+// the incoherent and dummy beams, which have no position, are left out.
+// The provenance is written as comment lines, which katpoint catalogues
+// ignore.
+func WriteTargets(w io.Writer, p *Packing, beams bool) error {
+	if p.Provenance != nil {
+		fmt.Fprintf(w, "# method: %s\n", p.Method)
+		fmt.Fprintf(w, "# seed: %d\n", p.Seed)
+		write_provenance(w, p.Provenance)
+	}
+
+	for _, b := range p.Bunches {
+		var coherent []Beam
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				coherent = append(coherent, beam)
+			}
+		}
+
+		if len(coherent) == 0 {
+			continue
+		}
+
+		name := fmt.Sprintf("bunch%03d", b.ID)
+		if b.Node != "" {
+			name += " | " + b.Node
+		}
+
+		x, y := p.get_centre(coherent)
+
+		if _, err := fmt.Fprintln(w, get_target(name, x, y)); err != nil {
+			return err
+		}
+
+		if !beams {
+			continue
+		}
+
+		for _, beam := range coherent {
+			if _, err := fmt.Fprintln(w, get_target(beam.key(), beam.X, beam.Y)); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,295 @@
+package beampack
+
+import (
+	"math"
+	"reflect"
+	"sort"
+)
+
+// An embedding maps a beam position to Cartesian coordinates in which the
+// Euclidean distance orders beam pairs the same way as a distance metric.
+type embedding func(x, y float64) [3]float64
+
+// Get the embedding for the built-in metrics, or nil if the metric is
+// unknown. The great-circle separation is monotonic in the chord length
+// between unit vectors.
+func get_embedding(dist DistanceFunc) embedding {
+	switch {
+	case reflect_equal(dist, Euclidean):
+		return func(x, y float64) [3]float64 {
+			return [3]float64{x, y, 0}
+		}
+
+	case is_angular(dist):
+		return func(x, y float64) [3]float64 {
+			const deg = math.Pi / 180.0
+			return [3]float64{
+				math.Cos(y*deg) * math.Cos(x*deg),
+				math.Cos(y*deg) * math.Sin(x*deg),
+				math.Sin(y * deg),
+			}
+		}
+	}
+
+	return nil
+}
+
+// Check whether the distance function is the angular metric, computed or
+// looked up in a distance matrix.
+func is_angular(dist DistanceFunc) bool {
+	return reflect_equal(dist, Angular) || reflect_equal(dist, cached_angular(nil))
+}
+
+// Check whether two distance functions are the same function.
+func reflect_equal(a, b DistanceFunc) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+// A kdnode is a node of the KD-tree, splitting the points at the given axis.
+type kdnode struct {
+	point int
+	axis  int
+	left  *kdnode
+	right *kdnode
+}
+
+// A kdtree is a spatial index for nearest-neighbour and radius queries on
+// the beam positions. Points can be removed from it, after which they are
+// no longer returned by queries.
+type kdtree struct {
+	points  [][3]float64
+	root    *kdnode
+	removed []bool
+}
+
+// Build a KD-tree over the embedded beam positions.
+func new_kdtree(beams []Beam, embed embedding) *kdtree {
+	points := make([][3]float64, len(beams))
+
+	for i, beam := range beams {
+		points[i] = embed(beam.X, beam.Y)
+	}
+
+	return new_kdtree_points(points)
+}
+
+// Build a KD-tree over the given embedded points.
+func new_kdtree_points(points [][3]float64) *kdtree {
+	t := &kdtree{
+		points:  points,
+		removed: make([]bool, len(points)),
+	}
+
+	index := make([]int, len(points))
+	for i := range index {
+		index[i] = i
+	}
+
+	t.root = t.build(index, 0)
+
+	return t
+}
+
+func (t *kdtree) build(index []int, depth int) *kdnode {
+	if len(index) == 0 {
+		return nil
+	}
+
+	axis := depth % 3
+
+	sort.Slice(index, func(i, j int) bool {
+		return t.points[index[i]][axis] < t.points[index[j]][axis]
+	})
+
+	mid := len(index) / 2
+
+	return &kdnode{
+		point: index[mid],
+		axis:  axis,
+		left:  t.build(index[:mid], depth+1),
+		right: t.build(index[mid+1:], depth+1),
+	}
+}
+
+// Mark a point as removed.
+func (t *kdtree) remove(i int) {
+	t.removed[i] = true
+}
+
+// Squared Euclidean distance between two points.
+func (t *kdtree) dist2(a, b [3]float64) float64 {
+	dx, dy, dz := a[0]-b[0], a[1]-b[1], a[2]-b[2]
+	return dx*dx + dy*dy + dz*dz
+}
+
+// A neighbour found by a query, with its distance under the metric and its
+// squared embedded distance.
+type neighbour struct {
+	point int
+	dist  float64
+	dist2 float64
+}
+
+// Order neighbours by distance, breaking ties by point index.
+func (a neighbour) less(b neighbour) bool {
+	if a.dist != b.dist {
+		return a.dist < b.dist
+	}
+	return a.point < b.point
+}
+
+// Find the k points closest to point i that have not been removed, including
+// i itself. The candidates are ranked by the metric distance dist(j) from
+// point i, so that the result agrees with a linear search. The neighbours
+// are sorted by increasing distance.
+func (t *kdtree) nearest(i int, k int, dist func(j int) float64) []neighbour {
+	return t.nearest_to(t.points[i], k, dist)
+}
+
+// Find the k points closest to the embedded target position that have not
+// been removed, ranked by the metric distance dist(j) from the target.
+func (t *kdtree) nearest_to(target [3]float64, k int, dist func(j int) float64) []neighbour {
+	best := make([]neighbour, 0, k+1)
+	t.search_nearest(t.root, target, k, dist, &best)
+
+	return best
+}
+
+// The largest squared embedded distance among the neighbours found so far,
+// with some slack for rounding differences between the metric and the
+// embedding.
+func get_bound(best []neighbour) float64 {
+	var bound float64
+
+	for _, n := range best {
+		bound = math.Max(bound, n.dist2)
+	}
+
+	return bound*(1+1e-9) + 1e-300
+}
+
+func (t *kdtree) search_nearest(node *kdnode, target [3]float64, k int, dist func(j int) float64, best *[]neighbour) {
+	if node == nil || k <= 0 {
+		return
+	}
+
+	if !t.removed[node.point] {
+		n := neighbour{node.point, dist(node.point), t.dist2(t.points[node.point], target)}
+
+		if len(*best) < k || n.less((*best)[len(*best)-1]) {
+			// insert in sorted order
+			pos := sort.Search(len(*best), func(j int) bool {
+				return n.less((*best)[j])
+			})
+
+			*best = append(*best, neighbour{})
+			copy((*best)[pos+1:], (*best)[pos:])
+			(*best)[pos] = n
+
+			if len(*best) > k {
+				*best = (*best)[:k]
+			}
+		}
+	}
+
+	diff := target[node.axis] - t.points[node.point][node.axis]
+
+	near, far := node.left, node.right
+	if diff > 0 {
+		near, far = far, near
+	}
+
+	t.search_nearest(near, target, k, dist, best)
+
+	if len(*best) < k || diff*diff <= get_bound(*best) {
+		t.search_nearest(far, target, k, dist, best)
+	}
+}
+
+// Find all points within the squared embedded distance r2 of point i that
+// have not been removed, excluding i itself.
+func (t *kdtree) within(i int, r2 float64) []int {
+	var result []int
+	t.search_within(t.root, i, r2, &result)
+
+	sort.Ints(result)
+
+	return result
+}
+
+func (t *kdtree) search_within(node *kdnode, i int, r2 float64, result *[]int) {
+	if node == nil {
+		return
+	}
+
+	target := t.points[i]
+
+	if node.point != i && !t.removed[node.point] && t.dist2(t.points[node.point], target) <= r2 {
+		*result = append(*result, node.point)
+	}
+
+	diff := target[node.axis] - t.points[node.point][node.axis]
+
+	if diff <= 0 || diff*diff <= r2 {
+		t.search_within(node.left, i, r2, result)
+	}
+
+	if diff >= 0 || diff*diff <= r2 {
+		t.search_within(node.right, i, r2, result)
+	}
+}
+
+// Get the k nearest other beams of every beam, sorted by increasing
+// distance. A KD-tree is used for the built-in metrics, otherwise the
+// neighbours are found by a linear search.
+func get_neighbours(data []Beam, k int, dist DistanceFunc) [][]int {
+	k = min(k, len(data)-1)
+	result := make([][]int, len(data))
+
+	if k <= 0 {
+		return result
+	}
+
+	// all neighbour lists share one backing array
+	flat := make([]int, 0, len(data)*k)
+
+	embed := get_embedding(dist)
+
+	var tree *kdtree
+	if embed != nil {
+		tree = new_kdtree(data, embed)
+	}
+
+	for i, beam := range data {
+		var found []neighbour
+
+		metric := func(j int) float64 {
+			return dist(beam.X, beam.Y, data[j].X, data[j].Y)
+		}
+
+		if tree != nil {
+			found = tree.nearest(i, k+1, metric)
+		} else {
+			found = make([]neighbour, 0, len(data))
+			for j := range data {
+				found = append(found, neighbour{point: j, dist: metric(j)})
+			}
+
+			sort.Slice(found, func(a, b int) bool {
+				return found[a].less(found[b])
+			})
+		}
+
+		start := len(flat)
+
+		for _, n := range found {
+			if n.point != i && len(flat)-start < k {
+				flat = append(flat, n.point)
+			}
+		}
+
+		result[i] = flat[start:len(flat):len(flat)]
+	}
+
+	return result
+}
@@ -0,0 +1,289 @@
+package beampack
+
+import (
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// Choose the initial k-means centroids using the k-means++ seeding.
+func get_kmeans_seeds(data []Beam, k int, dist DistanceFunc, rng *rand.Rand) [][2]float64 {
+	centres := make([][2]float64, 0, k)
+
+	first := data[rng.Intn(len(data))]
+	centres = append(centres, [2]float64{first.X, first.Y})
+
+	// the distance of every beam to its closest centre so far
+	closest := make([]float64, len(data))
+	for i := range closest {
+		closest[i] = math.Inf(1)
+	}
+
+	weights := make([]float64, len(data))
+
+	for len(centres) < k {
+		var total float64
+
+		c := centres[len(centres)-1]
+
+		for i, beam := range data {
+			closest[i] = math.Min(closest[i], dist(c[0], c[1], beam.X, beam.Y))
+			weights[i] = closest[i] * closest[i]
+			total += weights[i]
+		}
+
+		// all remaining beams coincide with a centre
+		if total == 0 {
+			beam := data[rng.Intn(len(data))]
+			centres = append(centres, [2]float64{beam.X, beam.Y})
+			continue
+		}
+
+		target := rng.Float64() * total
+		chosen := len(data) - 1
+
+		for i, w := range weights {
+			target -= w
+			if target <= 0 {
+				chosen = i
+				break
+			}
+		}
+
+		centres = append(centres, [2]float64{data[chosen].X, data[chosen].Y})
+	}
+
+	return centres
+}
+
+// A candidate assignment of a beam to a cluster centre.
+type assignment struct {
+	beam   int
+	centre int
+	dist   float64
+}
+
+// Get the candidate assignments of every beam to its ncand closest centres.
+// The centres are looked up in a KD-tree for the built-in metrics.
+func get_assignments(data []Beam, centres [][2]float64, ncand int, dist DistanceFunc, pairs []assignment) []assignment {
+	pairs = pairs[:0]
+	embed := get_embedding(dist)
+
+	if embed == nil || ncand >= len(centres) {
+		for i, beam := range data {
+			for j, c := range centres {
+				pairs = append(pairs, assignment{i, j, dist(c[0], c[1], beam.X, beam.Y)})
+			}
+		}
+
+		return pairs
+	}
+
+	points := make([][3]float64, len(centres))
+	for j, c := range centres {
+		points[j] = embed(c[0], c[1])
+	}
+
+	tree := new_kdtree_points(points)
+
+	for i, beam := range data {
+		found := tree.nearest_to(embed(beam.X, beam.Y), ncand, func(j int) float64 {
+			return dist(centres[j][0], centres[j][1], beam.X, beam.Y)
+		})
+
+		for _, n := range found {
+			pairs = append(pairs, assignment{i, n.point, n.dist})
+		}
+	}
+
+	return pairs
+}
+
+// Assign the beams to the closest centre that still has capacity left. The
+// beam-centre pairs are processed in order of increasing distance, which
+// yields clusters of the given sizes. Only the ncand closest centres of
+// every beam are considered at first, any beams left over go to the closest
+// centre with capacity left.
+func assign_constrained(data []Beam, centres [][2]float64, sizes []int, ncand int, dist DistanceFunc, pairs []assignment) ([]int, []assignment) {
+	pairs = get_assignments(data, centres, ncand, dist, pairs)
+
+	sort.Slice(pairs, func(i, j int) bool {
+		if pairs[i].dist != pairs[j].dist {
+			return pairs[i].dist < pairs[j].dist
+		}
+
+		if pairs[i].beam != pairs[j].beam {
+			return pairs[i].beam < pairs[j].beam
+		}
+
+		return pairs[i].centre < pairs[j].centre
+	})
+
+	assigned := make([]int, len(data))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+
+	load := make([]int, len(centres))
+	left := len(data)
+
+	for _, p := range pairs {
+		if left == 0 {
+			break
+		}
+
+		if assigned[p.beam] >= 0 || load[p.centre] >= sizes[p.centre] {
+			continue
+		}
+
+		assigned[p.beam] = p.centre
+		load[p.centre]++
+		left--
+	}
+
+	for i, beam := range data {
+		if assigned[i] >= 0 {
+			continue
+		}
+
+		best := -1
+		bestdist := math.Inf(1)
+
+		for j, c := range centres {
+			d := dist(c[0], c[1], beam.X, beam.Y)
+
+			if load[j] < sizes[j] && d < bestdist {
+				best, bestdist = j, d
+			}
+		}
+
+		assigned[i] = best
+		load[best]++
+	}
+
+	return assigned, pairs
+}
+
+// Improve a constrained assignment by swapping pairs of neighbouring beams
+// between clusters whenever that reduces their summed distance to the
+// centres.
+func refine_assignment(data []Beam, centres [][2]float64, assigned []int, neighbours [][]int, dist DistanceFunc) {
+	const maxpass = 20
+
+	for pass := 0; pass < maxpass; pass++ {
+		swapped := false
+
+		for i := range data {
+			for _, j := range neighbours[i] {
+				ci, cj := assigned[i], assigned[j]
+				if ci == cj {
+					continue
+				}
+
+				now := dist(centres[ci][0], centres[ci][1], data[i].X, data[i].Y) +
+					dist(centres[cj][0], centres[cj][1], data[j].X, data[j].Y)
+				alt := dist(centres[cj][0], centres[cj][1], data[i].X, data[i].Y) +
+					dist(centres[ci][0], centres[ci][1], data[j].X, data[j].Y)
+
+				if alt < now-1e-12 {
+					assigned[i], assigned[j] = cj, ci
+					swapped = true
+				}
+			}
+		}
+
+		if !swapped {
+			break
+		}
+	}
+}
+
+// Pack the beams using a size-constrained k-means clustering with k-means++
+// initialisation. The clusters have the given sizes.
+func pack_kmeans(data []Beam, sizes []int, dist DistanceFunc, rng *rand.Rand) [][]Beam {
+	const maxiter = 100
+
+	if len(data) == 0 {
+		return nil
+	}
+
+	k := len(sizes)
+	centres := get_kmeans_seeds(data, k, dist, rng)
+
+	// the candidate centres and swap partners are limited to nearby ones
+	ncand := get_nneighbours(sizes)
+	neighbours := get_neighbours(data, ncand, dist)
+
+	var assigned, current []int
+	var pairs []assignment
+
+	for iter := 0; iter < maxiter; iter++ {
+		current, pairs = assign_constrained(data, centres, sizes, ncand, dist, pairs)
+		refine_assignment(data, centres, current, neighbours, dist)
+
+		changed := false
+		if assigned == nil {
+			changed = true
+		} else {
+			for i := range current {
+				if current[i] != assigned[i] {
+					changed = true
+					break
+				}
+			}
+		}
+
+		assigned = current
+
+		if !changed {
+			break
+		}
+
+		// update the centroids
+		sums := make([][3]float64, k)
+		for i, beam := range data {
+			c := assigned[i]
+			sums[c][0] += beam.X
+			sums[c][1] += beam.Y
+			sums[c][2]++
+		}
+
+		for c := range centres {
+			if sums[c][2] > 0 {
+				centres[c] = [2]float64{sums[c][0] / sums[c][2], sums[c][1] / sums[c][2]}
+			}
+		}
+	}
+
+	// number the bunches in order of centroid x
+	order := make([]int, k)
+	for c := range order {
+		order[c] = c
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return centres[order[i]][0] < centres[order[j]][0]
+	})
+
+	members := make([][]candidate, k)
+	for i, beam := range data {
+		c := assigned[i]
+		d := dist(centres[c][0], centres[c][1], beam.X, beam.Y)
+		members[c] = append(members[c], candidate{beam, d})
+	}
+
+	groups := make([][]Beam, 0, k)
+
+	for _, c := range order {
+		sort_candidates(members[c])
+
+		group := make([]Beam, len(members[c]))
+		for i, m := range members[c] {
+			group[i] = m.beam
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups
+}
@@ -0,0 +1,567 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Detection is the S/N of a detection in a beam.
+type Detection struct {
+	Beam Beam
+	SN   float64
+}
+
+// LoadDetections reads the per-beam S/N of a detection from file. Every line
+// holds a beam, given by name or by number, and its S/N, separated by a
+// comma or whitespace. Empty lines and lines starting with # are ignored.
+func LoadDetections(filename string, beams []Beam) ([]Detection, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	var dets []Detection
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a beam and its S/N", filename, nr)
+		}
+
+		i, ok := lookup(fields[0])
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: unknown beam: %s", filename, nr, fields[0])
+		}
+
+		if beams[i].Incoherent {
+			return nil, fmt.Errorf("%s:%d: the incoherent beam has no position: %s", filename, nr, fields[0])
+		}
+
+		sn, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid S/N: %s", filename, nr, fields[1])
+		}
+
+		dets = append(dets, Detection{beams[i], sn})
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read detections: %s", err)
+	}
+
+	return dets, nil
+}
+
+// LocalizeOptions configure the localization.
+type LocalizeOptions struct {
+	// The beam shape of the beams without shape: semi-major and semi-minor
+	// axes at half power and position angle in degrees.
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+	// Number of grid points along each axis, defaults to 201.
+	Grid int
+	// Tangent point, if the beam positions are RA and Dec and the source is
+	// to be localized on the tangent plane.
+	Tangent *Tangent
+	// The other beams of the tiling. The ones near the detections that are
+	// not detected count as non-detections with zero S/N, which constrains
+	// the position when only few beams are detected.
+	Beams []Beam
+	// The primary beam, if the amplitude is to be corrected for its
+	// attenuation at the position of the source.
+	PrimaryBeam *PrimaryBeam
+}
+
+// ConfidenceLevels are the confidence levels of the localization regions
+// and the corresponding increase of chi-squared for two parameters.
+var ConfidenceLevels = [][2]float64{{0.683, 2.30}, {0.954, 6.18}, {0.997, 11.83}}
+
+// ConfidenceRegion is the region that contains the source at a confidence
+// level: its bounding box, its area on the localization plane and its
+// contour lines.
+type ConfidenceRegion struct {
+	Level    float64        `json:"level"`
+	DChi2    float64        `json:"dchi2"`
+	XMin     float64        `json:"xmin"`
+	XMax     float64        `json:"xmax"`
+	YMin     float64        `json:"ymin"`
+	YMax     float64        `json:"ymax"`
+	Area     float64        `json:"area"`
+	Contours [][][2]float64 `json:"contours"`
+}
+
+// Localization is the maximum-likelihood position of a source detected in
+// several beams.
+type Localization struct {
+	X float64 `json:"x"`
+	Y float64 `json:"y"`
+	// The fitted on-axis S/N of the source.
+	Amplitude float64 `json:"amplitude"`
+	Chi2      float64 `json:"chi2"`
+	// The number of beams in the fit, including the non-detections.
+	NBeams  int                `json:"nbeams"`
+	Regions []ConfidenceRegion `json:"regions"`
+	// The primary beam response at the position and the amplitude
+	// corrected for it, if the primary beam is known.
+	Sensitivity float64 `json:"sensitivity,omitempty"`
+	Corrected   float64 `json:"corrected_amplitude,omitempty"`
+
+	// The localization grid on the plane and the increase of chi-squared
+	// over its minimum at every grid point, indexed by y and x.
+	GridX []float64   `json:"-"`
+	GridY []float64   `json:"-"`
+	DChi2 [][]float64 `json:"-"`
+	// Convert positions on the localization plane to output positions.
+	to_output func(x, y float64) (float64, float64)
+}
+
+// Position returns the output position of a point on the localization
+// plane, which is RA and Dec for localizations on the tangent plane.
+func (l *Localization) Position(x, y float64) (float64, float64) {
+	return l.to_output(x, y)
+}
+
+// Localize computes the maximum-likelihood sky position of a source from
+// its S/N in several beams, SeeKAT-style. The response of every beam is
+// modelled as elliptical Gaussian with the beam shape, or the default shape
+// of the beams without one, that drops to half power at the semi-axes. The
+// S/N are fitted with a source of free amplitude at every point of a grid
+// around the brightest detections, assuming unit noise, and the point with
+// the least chi-squared is the localization. The confidence regions are the ones in
+// which chi-squared increases by less than the threshold of the confidence
+// level.
+func Localize(dets []Detection, opts LocalizeOptions) (*Localization, error) {
+	if len(dets) == 0 {
+		return nil, fmt.Errorf("No detections given.")
+	}
+
+	n := opts.Grid
+	if n <= 0 {
+		n = 201
+	}
+
+	if n < 3 {
+		return nil, fmt.Errorf("The localization grid is too small: %d", n)
+	}
+
+	// the detected beams, followed by the candidate non-detections
+	beams := make([]Beam, len(dets))
+	sn := make([]float64, len(dets))
+
+	detected := make(map[string]bool)
+
+	for i, d := range dets {
+		beams[i] = d.Beam
+		sn[i] = d.SN
+		detected[d.Beam.key()] = true
+	}
+
+	for _, beam := range opts.Beams {
+		if !beam.Incoherent && !beam.Dummy && !detected[beam.key()] {
+			beams = append(beams, beam)
+			sn = append(sn, 0)
+		}
+	}
+
+	loc := &Localization{
+		to_output: func(x, y float64) (float64, float64) { return x, y },
+	}
+
+	if opts.Tangent != nil {
+		projected, err := project_beams(beams, *opts.Tangent)
+		if err != nil {
+			return nil, err
+		}
+
+		beams = projected
+		loc.to_output = opts.Tangent.Deproject
+	}
+
+	// the response of every beam as function of the position
+	type response struct {
+		x, y  float64
+		a     float64
+		dist  DistanceFunc
+		sn    float64
+		value float64
+	}
+
+	responses := make([]response, len(beams))
+	var size, peak float64
+
+	for i, beam := range beams {
+		a, b, pa := beam.SemiMajor, beam.SemiMinor, beam.PA
+		if a <= 0 || b <= 0 {
+			a, b, pa = opts.SemiMajor, opts.SemiMinor, opts.PA
+		}
+
+		if a <= 0 || b <= 0 {
+			return nil, fmt.Errorf("The beam shape must be positive: %g, %g", a, b)
+		}
+
+		responses[i] = response{x: beam.X, y: beam.Y, a: a, dist: Elliptical(a, b, pa), sn: sn[i]}
+		size = math.Max(size, a)
+		peak = math.Max(peak, sn[i])
+	}
+
+	if peak <= 0 {
+		return nil, fmt.Errorf("No detection with positive S/N.")
+	}
+
+	// the grid about the S/N-weighted centroid of the beams with at least
+	// half the peak S/N, which the source must be close to
+	var cx, cy, sum float64
+	for _, r := range responses {
+		if r.sn >= peak/2 {
+			cx += r.sn * r.x
+			cy += r.sn * r.y
+			sum += r.sn
+		}
+	}
+
+	cx /= sum
+	cy /= sum
+
+	half := 2 * size
+	for _, r := range responses {
+		if r.sn >= peak/2 {
+			half = math.Max(half, math.Max(math.Abs(r.x-cx), math.Abs(r.y-cy))+2*size)
+		}
+	}
+
+	// only the non-detections near the grid constrain the position
+	var used []response
+	for k, r := range responses {
+		if k < len(dets) || (math.Abs(r.x-cx) < half+2*size && math.Abs(r.y-cy) < half+2*size) {
+			used = append(used, r)
+		}
+	}
+
+	responses = used
+	loc.NBeams = len(responses)
+
+	step := 2 * half / float64(n-1)
+
+	loc.GridX = make([]float64, n)
+	loc.GridY = make([]float64, n)
+
+	for i := 0; i < n; i++ {
+		loc.GridX[i] = cx - half + float64(i)*step
+		loc.GridY[i] = cy - half + float64(i)*step
+	}
+
+	chi2 := make([][]float64, n)
+	best := math.Inf(1)
+	var bx, by int
+
+	for j, y := range loc.GridY {
+		chi2[j] = make([]float64, n)
+
+		for i, x := range loc.GridX {
+			// the best amplitude is linear in the S/N
+			var sr, rr float64
+
+			for k := range responses {
+				r := &responses[k]
+				d := r.dist(r.x, r.y, x, y) / r.a
+				r.value = math.Exp(-math.Ln2 * d * d)
+
+				sr += r.sn * r.value
+				rr += r.value * r.value
+			}
+
+			amplitude := 0.0
+			if rr > 0 {
+				amplitude = math.Max(sr/rr, 0)
+			}
+
+			var c float64
+			for _, r := range responses {
+				c += (r.sn - amplitude*r.value) * (r.sn - amplitude*r.value)
+			}
+
+			chi2[j][i] = c
+
+			if c < best {
+				best, bx, by = c, i, j
+				loc.Amplitude = amplitude
+			}
+		}
+	}
+
+	loc.Chi2 = best
+	loc.X, loc.Y = loc.to_output(loc.GridX[bx], loc.GridY[by])
+
+	// the primary beam attenuates the source equally in all beams, so it
+	// only scales the amplitude, but does not change the position
+	if opts.PrimaryBeam != nil {
+		loc.Sensitivity = opts.PrimaryBeam.Sensitivity(loc.X, loc.Y)
+		loc.Corrected = loc.Amplitude / max(loc.Sensitivity, min_sensitivity)
+	}
+
+	loc.DChi2 = chi2
+	for j := range chi2 {
+		for i := range chi2[j] {
+			chi2[j][i] -= best
+		}
+	}
+
+	for _, level := range ConfidenceLevels {
+		region := ConfidenceRegion{
+			Level: level[0],
+			DChi2: level[1],
+			XMin:  math.Inf(1),
+			XMax:  math.Inf(-1),
+			YMin:  math.Inf(1),
+			YMax:  math.Inf(-1),
+		}
+
+		for j, y := range loc.GridY {
+			for i, x := range loc.GridX {
+				if chi2[j][i] > level[1] {
+					continue
+				}
+
+				region.Area += step * step
+
+				ox, oy := loc.to_output(x, y)
+				region.XMin = math.Min(region.XMin, ox)
+				region.XMax = math.Max(region.XMax, ox)
+				region.YMin = math.Min(region.YMin, oy)
+				region.YMax = math.Max(region.YMax, oy)
+			}
+		}
+
+		for _, line := range get_contours(loc.GridX, loc.GridY, chi2, level[1]) {
+			for k, p := range line {
+				line[k][0], line[k][1] = loc.to_output(p[0], p[1])
+			}
+
+			region.Contours = append(region.Contours, line)
+		}
+
+		loc.Regions = append(loc.Regions, region)
+	}
+
+	return loc, nil
+}
+
+// An edge of a grid cell: the horizontal or vertical edge that starts at a
+// grid point.
+type grid_edge struct {
+	vertical bool
+	i, j     int
+}
+
+// Get the contour lines of the field at the level using marching squares.
+// The field is indexed by y and x.
+func get_contours(xs, ys []float64, field [][]float64, level float64) [][][2]float64 {
+	points := make(map[grid_edge][2]float64)
+
+	// the crossing of the level on an edge, if any
+	crossing := func(e grid_edge) bool {
+		i2, j2 := e.i+1, e.j
+		if e.vertical {
+			i2, j2 = e.i, e.j+1
+		}
+
+		a, b := field[e.j][e.i], field[j2][i2]
+		if (a < level) == (b < level) {
+			return false
+		}
+
+		t := (level - a) / (b - a)
+		points[e] = [2]float64{
+			xs[e.i] + t*(xs[i2]-xs[e.i]),
+			ys[e.j] + t*(ys[j2]-ys[e.j]),
+		}
+
+		return true
+	}
+
+	var segments [][2]grid_edge
+
+	for j := 0; j+1 < len(ys); j++ {
+		for i := 0; i+1 < len(xs); i++ {
+			bottom := grid_edge{false, i, j}
+			top := grid_edge{false, i, j + 1}
+			left := grid_edge{true, i, j}
+			right := grid_edge{true, i + 1, j}
+
+			var edges []grid_edge
+			for _, e := range []grid_edge{bottom, right, top, left} {
+				if crossing(e) {
+					edges = append(edges, e)
+				}
+			}
+
+			switch len(edges) {
+			case 2:
+				segments = append(segments, [2]grid_edge{edges[0], edges[1]})
+			case 4:
+				// a saddle point, resolved by the mean of the corners
+				centre := (field[j][i] + field[j][i+1] + field[j+1][i] + field[j+1][i+1]) / 4
+
+				if (centre < level) == (field[j][i] < level) {
+					segments = append(segments, [2]grid_edge{bottom, right}, [2]grid_edge{top, left})
+				} else {
+					segments = append(segments, [2]grid_edge{left, bottom}, [2]grid_edge{right, top})
+				}
+			}
+		}
+	}
+
+	// join the segments that share an edge into lines
+	ends := make(map[grid_edge][]int)
+	for k, s := range segments {
+		ends[s[0]] = append(ends[s[0]], k)
+		ends[s[1]] = append(ends[s[1]], k)
+	}
+
+	used := make([]bool, len(segments))
+
+	extend := func(line []grid_edge) []grid_edge {
+		for {
+			last := line[len(line)-1]
+
+			next := -1
+			for _, k := range ends[last] {
+				if !used[k] {
+					next = k
+					break
+				}
+			}
+
+			if next < 0 {
+				return line
+			}
+
+			used[next] = true
+
+			other := segments[next][0]
+			if other == last {
+				other = segments[next][1]
+			}
+
+			line = append(line, other)
+		}
+	}
+
+	var lines [][][2]float64
+
+	for k, s := range segments {
+		if used[k] {
+			continue
+		}
+
+		used[k] = true
+
+		line := extend([]grid_edge{s[0], s[1]})
+		for a, b := 0, len(line)-1; a < b; a, b = a+1, b-1 {
+			line[a], line[b] = line[b], line[a]
+		}
+
+		line = extend(line)
+
+		contour := make([][2]float64, len(line))
+		for i, e := range line {
+			contour[i] = points[e]
+		}
+
+		lines = append(lines, contour)
+	}
+
+	return lines
+}
+
+// WriteLocalization writes the localization to w as text or JSON. The text
+// output lists the position and fit and the bounding box and area of every
+// confidence region, and the contour lines as comment lines.
+func WriteLocalization(w io.Writer, loc *Localization, format string) error {
+	switch format {
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(loc)
+
+	case "text":
+		fmt.Fprintf(w, "Position: %.6f %.6f, amplitude: %.2f, chi2: %.3f, beams: %d",
+			loc.X, loc.Y, loc.Amplitude, loc.Chi2, loc.NBeams)
+
+		if loc.Sensitivity != 0 {
+			fmt.Fprintf(w, ", sensitivity: %.4f, corrected amplitude: %.2f", loc.Sensitivity, loc.Corrected)
+		}
+
+		fmt.Fprintln(w)
+
+		for _, r := range loc.Regions {
+			fmt.Fprintf(w, "Region: %.1f%%, x: %.6f .. %.6f, y: %.6f .. %.6f, area: %.4g\n",
+				100*r.Level, r.XMin, r.XMax, r.YMin, r.YMax, r.Area)
+		}
+
+		for _, r := range loc.Regions {
+			for _, line := range r.Contours {
+				points := make([]string, len(line))
+				for i, p := range line {
+					points[i] = fmt.Sprintf("%.6f %.6f", p[0], p[1])
+				}
+
+				if _, err := fmt.Fprintf(w, "# contour: %.1f%%, %s\n", 100*r.Level, strings.Join(points, "; ")); err != nil {
+					return err
+				}
+			}
+		}
+
+		return nil
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+}
+
+// WriteLikelihoodMap writes the increase of chi-squared over the
+// localization grid to w, one grid point per line with its output position.
+// The rows of the grid are separated by empty lines, as gnuplot expects.
+func WriteLikelihoodMap(w io.Writer, loc *Localization) error {
+	fmt.Fprintf(w, "# x y dchi2\n")
+
+	for j, y := range loc.GridY {
+		if j > 0 {
+			fmt.Fprintln(w)
+		}
+
+		for i, x := range loc.GridX {
+			ox, oy := loc.to_output(x, y)
+
+			if _, err := fmt.Fprintf(w, "%.6f %.6f %.4f\n", ox, oy, loc.DChi2[j][i]); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
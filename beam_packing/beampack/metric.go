@@ -0,0 +1,213 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"sync"
+)
+
+// A DistanceFunc computes the separation between two beam positions.
+type DistanceFunc func(x1, y1, x2, y2 float64) float64
+
+// Metric is a distance or cost function between two beam positions, which
+// allows callers to supply custom costs to the packing methods and
+// optimizers, e.g. a weighted combination of metrics. The costs must be
+// non-negative and symmetric. Metrics are converted with AsDistance and can
+// be registered by name with RegisterMetric.
+type Metric interface {
+	Distance(x1, y1, x2, y2 float64) float64
+}
+
+// Distance implements the Metric interface.
+func (f DistanceFunc) Distance(x1, y1, x2, y2 float64) float64 {
+	return f(x1, y1, x2, y2)
+}
+
+// AsDistance converts a metric into the distance function used by the
+// packing methods. The built-in metrics keep their identity, so that the
+// KD-tree lookups still apply to them.
+func AsDistance(m Metric) DistanceFunc {
+	if f, ok := m.(DistanceFunc); ok {
+		return f
+	}
+
+	return m.Distance
+}
+
+// MetricTerm is a metric with its weight in a WeightedMetric.
+type MetricTerm struct {
+	Weight float64
+	Metric Metric
+}
+
+// WeightedMetric is the weighted sum of several metrics.
+type WeightedMetric []MetricTerm
+
+// Distance implements the Metric interface.
+func (w WeightedMetric) Distance(x1, y1, x2, y2 float64) float64 {
+	var d float64
+
+	for _, term := range w {
+		d += term.Weight * term.Metric.Distance(x1, y1, x2, y2)
+	}
+
+	return d
+}
+
+// The registered metrics by name.
+var (
+	metrics_mu sync.RWMutex
+	metrics    = map[string]Metric{
+		"euclidean": DistanceFunc(Euclidean),
+		"angular":   DistanceFunc(Angular),
+	}
+)
+
+// RegisterMetric registers a metric by name, so that it can be looked up
+// with GetMetric, e.g. from the command line.
+func RegisterMetric(name string, m Metric) error {
+	metrics_mu.Lock()
+	defer metrics_mu.Unlock()
+
+	if name == "" || m == nil {
+		return fmt.Errorf("A metric needs a name.")
+	}
+
+	if _, ok := metrics[name]; ok {
+		return fmt.Errorf("Metric already registered: %s", name)
+	}
+
+	metrics[name] = m
+
+	return nil
+}
+
+// MetricNames lists the names of the registered metrics in alphabetical
+// order.
+func MetricNames() []string {
+	metrics_mu.RLock()
+	defer metrics_mu.RUnlock()
+
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	return names
+}
+
+// Euclidean distance in the plane of the input coordinates.
+func Euclidean(x1, y1, x2, y2 float64) float64 {
+	return math.Hypot(x2-x1, y2-y1)
+}
+
+// Angular computes the great-circle separation in degrees using the
+// haversine formula. The coordinates are interpreted as longitude (x) and
+// latitude (y) in degrees, e.g. RA and Dec.
+func Angular(x1, y1, x2, y2 float64) float64 {
+	const deg = math.Pi / 180.0
+
+	dlon := (x2 - x1) * deg
+	dlat := (y2 - y1) * deg
+
+	a := math.Pow(math.Sin(dlat/2), 2) + math.Cos(y1*deg)*math.Cos(y2*deg)*math.Pow(math.Sin(dlon/2), 2)
+	a = math.Min(1.0, a)
+
+	return 2 * math.Asin(math.Sqrt(a)) / deg
+}
+
+// Elliptical returns a Mahalanobis-like distance for elliptical beams with
+// the given semi-major and semi-minor axes and position angle in degrees,
+// measured from +y towards +x (north through east). Offsets along the minor
+// axis are stretched by the axis ratio, so that the distance is the offset
+// along the major axis that spans the same number of beam widths. Packings
+// using it follow the actual beam geometry.
+func Elliptical(semimajor, semiminor, pa float64) DistanceFunc {
+	const deg = math.Pi / 180.0
+
+	sin, cos := math.Sincos(pa * deg)
+	ratio := semimajor / semiminor
+
+	return func(x1, y1, x2, y2 float64) float64 {
+		dx := x2 - x1
+		dy := y2 - y1
+
+		// offsets along the major and minor axes
+		u := dx*sin + dy*cos
+		v := dx*cos - dy*sin
+
+		return math.Hypot(u, v*ratio)
+	}
+}
+
+// MeanShape computes the shape of the mean beam covariance of the beams
+// that have a shape, i.e. positive semi-axes. It returns false if no beam
+// has a shape.
+func MeanShape(beams []Beam) (float64, float64, float64, bool) {
+	var sxx, sxy, syy float64
+	var n int
+
+	for _, beam := range beams {
+		if beam.SemiMajor <= 0 || beam.SemiMinor <= 0 {
+			continue
+		}
+
+		xx, xy, yy := get_covariance(beam.SemiMajor, beam.SemiMinor, beam.PA)
+		sxx += xx
+		sxy += xy
+		syy += yy
+		n++
+	}
+
+	if n == 0 {
+		return 0, 0, 0, false
+	}
+
+	a, b, pa := get_shape(sxx/float64(n), sxy/float64(n), syy/float64(n))
+
+	return a, b, pa, true
+}
+
+// Get the covariance of a beam with the given semi-axes and position angle
+// in degrees. The covariance is a^2 m m^T + b^2 n n^T with the unit vectors
+// m and n along the major and minor axes.
+func get_covariance(semimajor, semiminor, pa float64) (float64, float64, float64) {
+	const deg = math.Pi / 180.0
+
+	sin, cos := math.Sincos(pa * deg)
+	a2 := semimajor * semimajor
+	b2 := semiminor * semiminor
+
+	return a2*sin*sin + b2*cos*cos, (a2 - b2) * sin * cos, a2*cos*cos + b2*sin*sin
+}
+
+// Get the semi-axes and position angle in degrees of a beam covariance.
+func get_shape(sxx, sxy, syy float64) (float64, float64, float64) {
+	const deg = math.Pi / 180.0
+
+	// the eigenvalues and the major axis orientation
+	mean := (sxx + syy) / 2
+	diff := math.Hypot((sxx-syy)/2, sxy)
+	theta := math.Atan2(2*sxy, sxx-syy) / 2
+
+	pa := math.Mod(90-theta/deg+360, 180)
+
+	return math.Sqrt(mean + diff), math.Sqrt(math.Max(mean-diff, 0)), pa
+}
+
+// GetMetric looks up a registered distance metric by name. The elliptical
+// metric depends on the beam shape and is created with Elliptical instead.
+func GetMetric(name string) (DistanceFunc, error) {
+	metrics_mu.RLock()
+	m, ok := metrics[name]
+	metrics_mu.RUnlock()
+
+	if !ok {
+		return nil, fmt.Errorf("Unknown distance metric: %s", name)
+	}
+
+	return AsDistance(m), nil
+}
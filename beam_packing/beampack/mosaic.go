@@ -0,0 +1,56 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+)
+
+// Pointing is the beam configuration of one pointing of a mosaic.
+type Pointing struct {
+	Name  string
+	Beams []Beam
+	// Boresight of the pointing. If given, the beam positions are offsets
+	// from it, otherwise they are absolute positions.
+	Boresight *Tangent
+}
+
+// Merge combines the beams of several pointings into one set for packing.
+// Beam offsets are moved to the boresight of their pointing, where the x
+// offsets are scaled by 1/cos(Dec) of the boresight. The beams are
+// renumbered consecutively and record their originating pointing.
+func Merge(pointings []Pointing) ([]Beam, error) {
+	const deg = math.Pi / 180.0
+
+	var merged []Beam
+	names := make(map[string]bool)
+
+	for _, pt := range pointings {
+		if names[pt.Name] {
+			return nil, fmt.Errorf("Duplicate pointing name: %s", pt.Name)
+		}
+
+		names[pt.Name] = true
+
+		var scale float64
+		if pt.Boresight != nil {
+			scale = math.Cos(pt.Boresight.Dec * deg)
+			if scale <= 0 {
+				return nil, fmt.Errorf("Boresight at the pole: %s", pt.Name)
+			}
+		}
+
+		for _, beam := range pt.Beams {
+			if pt.Boresight != nil && !beam.Incoherent {
+				beam.X = pt.Boresight.RA + beam.X/scale
+				beam.Y = pt.Boresight.Dec + beam.Y
+			}
+
+			beam.Nr = len(merged)
+			beam.Pointing = pt.Name
+
+			merged = append(merged, beam)
+		}
+	}
+
+	return merged, nil
+}
@@ -0,0 +1,213 @@
+package beampack
+
+import (
+	"math"
+	"sort"
+)
+
+// Get the minimum spanning tree of the beams with Prim's algorithm as the
+// parent of every beam, where the first beam is the root and has parent -1,
+// and the lengths of the edges to the parents.
+func get_spanning_tree(data []Beam, dist DistanceFunc) ([]int, []float64) {
+	n := len(data)
+
+	parent := make([]int, n)
+	length := make([]float64, n)
+	done := make([]bool, n)
+
+	for i := range parent {
+		parent[i] = -1
+		length[i] = math.Inf(1)
+	}
+
+	if n == 0 {
+		return parent, length
+	}
+
+	length[0] = 0
+
+	for range data {
+		next := -1
+		for i := range data {
+			if !done[i] && (next < 0 || length[i] < length[next]) {
+				next = i
+			}
+		}
+
+		done[next] = true
+
+		for i := range data {
+			if done[i] {
+				continue
+			}
+
+			if d := dist(data[next].X, data[next].Y, data[i].X, data[i].Y); d < length[i] {
+				parent[i], length[i] = next, d
+			}
+		}
+	}
+
+	length[0] = 0
+
+	return parent, length
+}
+
+// Get the beam at the end of the longest path through the tree from the
+// beam, measured along the edges.
+func get_tree_end(adjacent [][]int, edge func(a, b int) float64, start int) int {
+	pathlen := make([]float64, len(adjacent))
+	visited := make([]bool, len(adjacent))
+
+	end := start
+	stack := []int{start}
+	visited[start] = true
+
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if pathlen[v] > pathlen[end] {
+			end = v
+		}
+
+		for _, c := range adjacent[v] {
+			if !visited[c] {
+				visited[c] = true
+				pathlen[c] = pathlen[v] + edge(v, c)
+				stack = append(stack, c)
+			}
+		}
+	}
+
+	return end
+}
+
+// Pack the beams by cutting their minimum spanning tree: the tree is rooted
+// at one end of its longest path and the groups of the given sizes are cut
+// off bottom-up, as soon as the subtree below a beam holds enough beams.
+// The groups follow the chains of close neighbours through the tiling, so
+// that gaps from flagged beams and irregular tilings are not bridged.
+func pack_mst(data []Beam, sizes []int, dist DistanceFunc) [][]Beam {
+	n := len(data)
+	groups := make([][]Beam, len(sizes))
+
+	if n == 0 {
+		return groups
+	}
+
+	parent, length := get_spanning_tree(data, dist)
+
+	// the undirected tree, where the length of an edge is stored with the
+	// child beam
+	adjacent := make([][]int, n)
+	for i, p := range parent {
+		if p >= 0 {
+			adjacent[i] = append(adjacent[i], p)
+			adjacent[p] = append(adjacent[p], i)
+		}
+	}
+
+	edge := func(a, b int) float64 {
+		if parent[a] == b {
+			return length[a]
+		}
+		return length[b]
+	}
+
+	// root the tree at an end of its longest path, so that the cutting
+	// starts at the edge of the tiling
+	root := get_tree_end(adjacent, edge, get_tree_end(adjacent, edge, 0))
+
+	// the children of every beam and the post-order of the rooted tree
+	children := make([][]int, n)
+	order := make([]int, 0, n)
+	visited := make([]bool, n)
+
+	stack := []int{root}
+	visited[root] = true
+
+	for len(stack) > 0 {
+		v := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		order = append(order, v)
+
+		for _, c := range adjacent[v] {
+			if !visited[c] {
+				visited[c] = true
+				children[v] = append(children[v], c)
+				stack = append(stack, c)
+			}
+		}
+	}
+
+	// the number of beams left in the subtree of every beam
+	size := make([]int, n)
+
+	// take k of the beams left in the subtree of beam v, all of them if k
+	// is its size, or otherwise whole subtrees of its children, the largest
+	// first, and the rest from the subtree of one of the others, so that the
+	// beams that are left stay connected to v
+	var take func(v, k int, group []Beam) []Beam
+	take = func(v, k int, group []Beam) []Beam {
+		size[v] -= k
+
+		if size[v] == 0 {
+			group = append(group, data[v])
+			k--
+		}
+
+		list := make([]int, 0, len(children[v]))
+		for _, c := range children[v] {
+			if size[c] > 0 {
+				list = append(list, c)
+			}
+		}
+
+		sort.SliceStable(list, func(i, j int) bool {
+			return size[list[i]] > size[list[j]]
+		})
+
+		var rest []int
+
+		for _, c := range list {
+			if k == 0 {
+				break
+			}
+
+			if size[c] <= k {
+				k -= size[c]
+				group = take(c, size[c], group)
+			} else {
+				rest = append(rest, c)
+			}
+		}
+
+		if k > 0 {
+			// the smallest subtree that holds the rest
+			group = take(rest[len(rest)-1], k, group)
+		}
+
+		return group
+	}
+
+	g := 0
+
+	for i := len(order) - 1; i >= 0; i-- {
+		v := order[i]
+
+		size[v] = 1
+		for _, c := range children[v] {
+			size[v] += size[c]
+		}
+
+		for g < len(sizes) && size[v] >= sizes[g] {
+			if sizes[g] > 0 {
+				groups[g] = take(v, sizes[g], make([]Beam, 0, sizes[g]))
+			}
+
+			g++
+		}
+	}
+
+	return groups
+}
@@ -0,0 +1,143 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/netip"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Parse a multicast group, given as spead://ADDRESS:PORT, ADDRESS:PORT or
+// ADDRESS with the default port, into its canonical form spead://ADDRESS:PORT.
+func parse_multicast(field string, port int) (string, error) {
+	field = strings.TrimPrefix(field, "spead://")
+
+	var addr netip.Addr
+	var err error
+
+	if ap, perr := netip.ParseAddrPort(field); perr == nil {
+		addr, port = ap.Addr(), int(ap.Port())
+	} else if addr, err = netip.ParseAddr(field); err != nil {
+		return "", fmt.Errorf("invalid multicast group: %s", field)
+	}
+
+	if !addr.Is4() || !addr.IsMulticast() {
+		return "", fmt.Errorf("not a multicast address: %s", addr)
+	}
+
+	return fmt.Sprintf("spead://%s:%d", addr, port), nil
+}
+
+// Compare two canonical multicast groups by address and port.
+func compare_multicast(a, b string) int {
+	pa, erra := netip.ParseAddrPort(strings.TrimPrefix(a, "spead://"))
+	pb, errb := netip.ParseAddrPort(strings.TrimPrefix(b, "spead://"))
+
+	if erra != nil || errb != nil {
+		return strings.Compare(a, b)
+	}
+
+	return pa.Compare(pb)
+}
+
+// Sort the multicast groups and drop the duplicates.
+func sort_multicast(groups []string) []string {
+	slices.SortFunc(groups, compare_multicast)
+	return slices.Compact(groups)
+}
+
+// LoadMulticast loads the multicast groups the beams are received from,
+// e.g. as exported from the FBFUSE configuration. Every line holds a beam,
+// given by name or by number, and its group, given as spead://ADDRESS:PORT,
+// ADDRESS:PORT or ADDRESS with the default port, separated by a comma or
+// whitespace. Several beams can share a group. Empty lines and lines
+// starting with # are ignored, and so are beams that are not among the
+// given ones, so that the full mapping of all beams can be used. The groups
+// are returned by beam number.
+func LoadMulticast(filename string, beams []Beam, port int) (map[int]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	groups := make(map[int]string)
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: expected a beam and its multicast group", filename, nr)
+		}
+
+		group, err := parse_multicast(fields[1], port)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %s", filename, nr, err)
+		}
+
+		if i, ok := lookup(fields[0]); ok {
+			groups[beams[i].Nr] = group
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read multicast groups: %s", err)
+	}
+
+	return groups, nil
+}
+
+// WriteMulticastGroups writes the multicast groups every node must
+// subscribe to, followed by those of every bunch, one per line.
+func WriteMulticastGroups(w io.Writer, configs []NodeConfig) error {
+	fmt.Fprintf(w, "# %-16s %-12s %s\n", "node", "bunches", "groups")
+
+	for _, cfg := range configs {
+		ids := make([]string, len(cfg.Bunches))
+		for i, b := range cfg.Bunches {
+			ids[i] = strconv.Itoa(b.ID)
+		}
+
+		node := cfg.Node
+		if node == "" {
+			node = "-"
+		}
+
+		fmt.Fprintf(w, "  %-16s %-12s %s\n", node, strings.Join(ids, ","), strings.Join(cfg.Groups, ","))
+	}
+
+	fmt.Fprintf(w, "\n# %-16s %-12s %s\n", "node", "bunch", "groups")
+
+	for _, cfg := range configs {
+		node := cfg.Node
+		if node == "" {
+			node = "-"
+		}
+
+		for _, b := range cfg.Bunches {
+			if _, err := fmt.Fprintf(w, "  %-16s %-12d %s\n", node, b.ID, strings.Join(b.Groups, ",")); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
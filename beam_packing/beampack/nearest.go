@@ -0,0 +1,216 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// NearestOptions configure the nearest beam lookup.
+type NearestOptions struct {
+	// The number of beams to report per position, at least one.
+	N int
+	// The shape of the beams that have none, as semi-axes at half power in
+	// degrees and position angle in degrees. Without shape, the beams are
+	// circular with half the median separation of the beams from their
+	// nearest neighbour as radius.
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+}
+
+// Nearest is a coherent beam near a sky position.
+type Nearest struct {
+	Source Source `json:"source"`
+	// The rank of the beam by separation, starting at one for the nearest.
+	Rank  int    `json:"rank"`
+	Beam  string `json:"beam"`
+	Bunch int    `json:"bunch"`
+	Node  string `json:"node,omitempty"`
+	// The separation of the position from the beam centre in degrees and
+	// in beam widths, i.e. in units of the full width at half power of the
+	// beam in the direction of the position. Positions within the
+	// half-power contour are less than half a beam width away.
+	Sep    float64 `json:"sep"`
+	Widths float64 `json:"widths"`
+}
+
+// ParseSource parses a sky position given as RA,Dec in decimal degrees or
+// sexagesimal, where sexagesimal RA is in hours, optionally preceded by a
+// name and an equals sign, e.g. J0437-4715=04:37:15.9,-47:15:09. The name
+// defaults to the position.
+func ParseSource(text string) (Source, error) {
+	name, position, found := strings.Cut(text, "=")
+	if !found {
+		name, position = text, text
+	}
+
+	ra, dec, found := strings.Cut(position, ",")
+	if !found {
+		return Source{}, fmt.Errorf("Invalid position, expected RA,Dec: %s", text)
+	}
+
+	var src Source
+	var err error
+
+	src.Name = strings.TrimSpace(name)
+
+	if src.RA, err = parse_angle(ra, true); err != nil {
+		return Source{}, fmt.Errorf("Invalid RA: %s, %s", text, err)
+	}
+
+	if src.Dec, err = parse_angle(dec, false); err != nil {
+		return Source{}, fmt.Errorf("Invalid Dec: %s, %s", text, err)
+	}
+
+	if src.Dec < -90 || src.Dec > 90 {
+		return Source{}, fmt.Errorf("Invalid Dec: %s", text)
+	}
+
+	return src, nil
+}
+
+// Get the separation of a position from the beam centre in degrees and in
+// beam widths along the direction of the position.
+func get_beam_offset(beam Beam, ra, dec float64, a, b, pa float64) (float64, float64) {
+	const deg = math.Pi / 180.0
+
+	sep := Angular(beam.X, beam.Y, ra, dec)
+
+	// the bearing of the position from the beam centre, east of north
+	sindec1, cosdec1 := math.Sincos(beam.Y * deg)
+	sindec2, cosdec2 := math.Sincos(dec * deg)
+	sindra, cosdra := math.Sincos((ra - beam.X) * deg)
+
+	theta := math.Atan2(sindra*cosdec2, cosdec1*sindec2-sindec1*cosdec2*cosdra)
+
+	// the offsets along the major and minor axes
+	v := sep * math.Cos(theta-pa*deg)
+	u := sep * math.Sin(theta-pa*deg)
+
+	return sep, math.Hypot(u/b, v/a) / 2
+}
+
+// NearestBeams finds the coherent beams of the packing nearest to each of
+// the sky positions, in beam widths, so that the first beam is the one with
+// the highest response at the position for beams of different shapes. The
+// beam positions are interpreted as RA and Dec in degrees. The result is
+// ordered by source and rank.
+func NearestBeams(p *Packing, sources []Source, opts NearestOptions) []Nearest {
+	var beams []Beam
+	var bunches []Bunch
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+				bunches = append(bunches, b)
+			}
+		}
+	}
+
+	a, b, pa := opts.SemiMajor, opts.SemiMinor, opts.PA
+	if a <= 0 || b <= 0 {
+		a = get_median_separation(beams, Angular) / 2
+		b, pa = a, 0
+	}
+
+	n := min(max(opts.N, 1), len(beams))
+
+	var result []Nearest
+
+	for _, src := range sources {
+		list := make([]Nearest, len(beams))
+
+		for i, beam := range beams {
+			ba, bb, bpa := a, b, pa
+			if beam.SemiMajor > 0 && beam.SemiMinor > 0 {
+				ba, bb, bpa = beam.SemiMajor, beam.SemiMinor, beam.PA
+			}
+
+			sep, widths := get_beam_offset(beam, src.RA, src.Dec, ba, bb, bpa)
+
+			list[i] = Nearest{
+				Source: src,
+				Beam:   beam.Name,
+				Bunch:  bunches[i].ID,
+				Node:   bunches[i].Node,
+				Sep:    sep,
+				Widths: widths,
+			}
+		}
+
+		sort.SliceStable(list, func(i, j int) bool {
+			return list[i].Widths < list[j].Widths
+		})
+
+		for i := range list[:n] {
+			list[i].Rank = i + 1
+		}
+
+		result = append(result, list[:n]...)
+	}
+
+	return result
+}
+
+// WriteNearest writes the nearest beams of the positions to w in the
+// requested format: text, json or csv.
+func WriteNearest(w io.Writer, list []Nearest, format string) error {
+	switch format {
+	case "text":
+		for _, l := range list {
+			fmt.Fprintf(w, "Source: %s, RA: %.6f, Dec: %.6f, rank: %d, beam: %s, bunch: %d, separation: %.6f, widths: %.3f",
+				l.Source.Name, l.Source.RA, l.Source.Dec, l.Rank, l.Beam, l.Bunch, l.Sep, l.Widths)
+
+			if l.Node != "" {
+				fmt.Fprintf(w, ", node: %s", l.Node)
+			}
+
+			if _, err := fmt.Fprintln(w); err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(list)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"source", "ra", "dec", "rank", "beam", "bunch", "node", "sep", "widths"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, l := range list {
+			writer.Write([]string{
+				l.Source.Name,
+				format_float(l.Source.RA),
+				format_float(l.Source.Dec),
+				strconv.Itoa(l.Rank),
+				l.Beam,
+				strconv.Itoa(l.Bunch),
+				l.Node,
+				format_float(l.Sep),
+				format_float(l.Widths),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,149 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Node is a TUSE processing node.
+type Node struct {
+	Name string
+	// Maximum number of bunches the node processes.
+	Capacity int
+	// Offline nodes do not get any bunches assigned.
+	Offline bool
+	// The candidate rate the node keeps up with, in the units of the beam
+	// rates. Zero means unknown.
+	Throughput float64
+}
+
+// LoadNodes reads the list of processing nodes from file. Each line holds
+// the node name, optionally followed by its capacity in bunches, its
+// candidate throughput as throughput=R and the keyword "offline". Nodes
+// without capacity get the default capacity.
+func LoadNodes(filename string, capacity int) ([]Node, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	defer f.Close()
+
+	var nodes []Node
+	nr := 0
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		nr++
+		fields := strings.Fields(scanner.Text())
+
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		node := Node{Name: fields[0], Capacity: capacity}
+
+		for _, field := range fields[1:] {
+			if strings.EqualFold(field, "offline") {
+				node.Offline = true
+				continue
+			}
+
+			if value, ok := strings.CutPrefix(field, "throughput="); ok {
+				t, err := strconv.ParseFloat(value, 64)
+				if err != nil || t < 0 {
+					return nil, fmt.Errorf("%s:%d: invalid node throughput: %q", filename, nr, value)
+				}
+
+				node.Throughput = t
+				continue
+			}
+
+			c, err := strconv.Atoi(field)
+			if err != nil || c < 0 {
+				return nil, fmt.Errorf("%s:%d: invalid node capacity: %q", filename, nr, field)
+			}
+
+			node.Capacity = c
+		}
+
+		nodes = append(nodes, node)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read nodes: %s", err)
+	}
+
+	return nodes, nil
+}
+
+// Assign the bunches to the processing nodes in order, filling every online
+// node up to its capacity before moving on to the next one. Consecutive
+// bunches are spatially close for all packing methods, so that neighbouring
+// bunches end up on the same node. Bunches that are already pinned to a node
+// keep it and count against its capacity.
+func Assign(p *Packing, nodes []Node) error {
+	order := make([]int, len(p.Bunches))
+	for i := range order {
+		order[i] = i
+	}
+
+	return assign(p, nodes, order)
+}
+
+// Assign the bunches to the processing nodes in the order of the bunch
+// indices.
+func assign(p *Packing, nodes []Node, order []int) error {
+	load := make(map[string]int)
+
+	for _, b := range p.Bunches {
+		if b.Node != "" {
+			load[b.Node]++
+		}
+	}
+
+	var total, free int
+
+	for _, node := range nodes {
+		if !node.Offline {
+			total += max(node.Capacity-load[node.Name], 0)
+		}
+	}
+
+	for _, b := range p.Bunches {
+		if b.Node == "" {
+			free++
+		}
+	}
+
+	if total < free {
+		return unsatisfiable("Insufficient node capacity: %d bunches, %d slots", free, total)
+	}
+
+	i := 0
+
+	for _, node := range nodes {
+		if node.Offline {
+			continue
+		}
+
+		for n := load[node.Name]; n < node.Capacity; n++ {
+			for i < len(order) && p.Bunches[order[i]].Node != "" {
+				i++
+			}
+
+			if i == len(order) {
+				return nil
+			}
+
+			p.Bunches[order[i]].Node = node.Name
+			i++
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,412 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Record is the machine-readable output of a single packed beam.
+type Record struct {
+	Beam  int     `json:"beam"`
+	Name  string  `json:"name"`
+	X     float64 `json:"x"`
+	Y     float64 `json:"y"`
+	Bunch int     `json:"bunch"`
+	Rank  int     `json:"rank"`
+	Node  string  `json:"node,omitempty"`
+	// The originating pointing in mosaic packings.
+	Pointing string `json:"pointing,omitempty"`
+	// The relative sensitivity of the beam, if known.
+	Sensitivity float64 `json:"sensitivity,omitempty"`
+}
+
+func (r Record) key() string {
+	return get_beam_key(r.Pointing, r.Name)
+}
+
+// BunchRecord is the machine-readable geometry of a single bunch: the
+// centroid, the convex hull vertices in counter-clockwise order and the
+// bounding circle.
+type BunchRecord struct {
+	ID       int          `json:"id"`
+	Node     string       `json:"node,omitempty"`
+	Centroid [2]float64   `json:"centroid"`
+	Hull     [][2]float64 `json:"hull"`
+	Circle   Circle       `json:"circle"`
+	Tags     []Tag        `json:"tags,omitempty"`
+}
+
+// MaskedRecord is a beam that was dropped because it lies within a masked
+// region.
+type MaskedRecord struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Region string  `json:"region"`
+}
+
+// FlaggedRecord is a beam that was dropped because it is dead or flagged for
+// RFI.
+type FlaggedRecord struct {
+	Name   string  `json:"name"`
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Reason string  `json:"reason"`
+}
+
+// Metadata describes how a packing was computed.
+type Metadata struct {
+	Method string `json:"method"`
+	Seed   int64  `json:"seed"`
+	// Tangent point of the projection, if the beams were packed on the
+	// tangent plane.
+	Tangent *Tangent `json:"tangent,omitempty"`
+	// The policy applied to the remaining beams and the number of dummy
+	// beams added.
+	Remainder string `json:"remainder,omitempty"`
+	Dummies   int    `json:"dummies,omitempty"`
+	// How the packing was produced, if known.
+	Provenance *Provenance `json:"provenance,omitempty"`
+	// The coordinate frame of the positions, equatorial if empty.
+	Frame string `json:"frame,omitempty"`
+	// The sub-tilings and outliers of the beam layout, if they were looked
+	// for.
+	Layout *LayoutRecord `json:"layout,omitempty"`
+}
+
+// LayoutRecord describes the sub-tilings and outliers of the beam layout.
+type LayoutRecord struct {
+	// The numbers of beams of the sub-tilings and the names of the
+	// outliers.
+	SubTilings []int    `json:"subtilings"`
+	Outliers   []string `json:"outliers,omitempty"`
+	Eps        float64  `json:"eps"`
+	// Whether the sub-tilings were packed independently, and whether the
+	// layout needs review otherwise.
+	Split  bool `json:"split"`
+	Review bool `json:"review"`
+}
+
+// Output is the machine-readable output of a packing.
+type Output struct {
+	Metadata Metadata        `json:"metadata"`
+	Beams    []Record        `json:"beams"`
+	Bunches  []BunchRecord   `json:"bunches"`
+	Excluded []string        `json:"excluded,omitempty"`
+	Masked   []MaskedRecord  `json:"masked,omitempty"`
+	Flagged  []FlaggedRecord `json:"flagged,omitempty"`
+}
+
+// Formats lists the available output formats.
+var Formats = []string{"text", "json", "csv"}
+
+// StreamFormats lists the streaming output formats of packings, which write
+// the beam assignments incrementally.
+var StreamFormats = []string{"jsonl"}
+
+// Records converts the packing to output records, ordered by bunch and rank.
+func Records(p *Packing) []Record {
+	records := make([]Record, 0, p.NBeams())
+
+	for _, b := range p.Bunches {
+		for rank, beam := range b.Beams {
+			records = append(records, get_record(b, rank, beam))
+		}
+	}
+
+	return records
+}
+
+// Get the output record of a beam of a bunch.
+func get_record(b Bunch, rank int, beam Beam) Record {
+	return Record{
+		Beam:  beam.Nr,
+		Name:  beam.Name,
+		X:     beam.X,
+		Y:     beam.Y,
+		Bunch: b.ID,
+		Rank:  rank,
+		Node:  b.Node,
+
+		Pointing:    beam.Pointing,
+		Sensitivity: beam.Sensitivity,
+	}
+}
+
+// StreamRecord is a beam assignment in the JSON Lines output. It also
+// identifies the packing, so that the outputs of several packings can be
+// concatenated.
+type StreamRecord struct {
+	Record
+	Method string `json:"method"`
+	Seed   int64  `json:"seed"`
+	// The first input of the packing, if known.
+	Input string `json:"input,omitempty"`
+	// The coordinate frame of the position, equatorial if empty.
+	Frame string `json:"frame,omitempty"`
+}
+
+// WriteStream writes the beam assignments of the packing as JSON Lines, one
+// record per beam, ordered by bunch and rank. The records are flushed after
+// every bunch, so that consumers can start processing before the whole
+// packing is written.
+func WriteStream(w io.Writer, p *Packing) error {
+	out := bufio.NewWriter(w)
+	enc := json.NewEncoder(out)
+
+	rec := StreamRecord{Method: p.Method, Seed: p.Seed, Frame: p.Frame}
+	if p.Provenance != nil && len(p.Provenance.Inputs) > 0 {
+		rec.Input = p.Provenance.Inputs[0].Name
+	}
+
+	for _, b := range p.Bunches {
+		for rank, beam := range b.Beams {
+			rec.Record = get_record(b, rank, beam)
+
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+
+		if err := out.Flush(); err != nil {
+			return err
+		}
+	}
+
+	return out.Flush()
+}
+
+// BunchRecords computes the geometry of the bunches. The bounding circle
+// radius is measured with the distance metric, which defaults to Euclidean.
+func BunchRecords(p *Packing, dist DistanceFunc) []BunchRecord {
+	if dist == nil {
+		dist = Euclidean
+	}
+
+	records := make([]BunchRecord, 0, len(p.Bunches))
+
+	for _, b := range p.Bunches {
+		rec := BunchRecord{
+			ID:     b.ID,
+			Node:   b.Node,
+			Hull:   get_convex_hull(b.Beams),
+			Circle: p.get_bounding_circle(b.Beams, dist),
+			Tags:   b.Tags,
+		}
+
+		if len(b.Beams) > 0 {
+			rec.Centroid[0], rec.Centroid[1] = p.get_centre(b.Beams)
+		}
+
+		records = append(records, rec)
+	}
+
+	return records
+}
+
+// GetMetadata returns the metadata of the packing.
+func GetMetadata(p *Packing) Metadata {
+	meta := Metadata{Method: p.Method, Seed: p.Seed, Tangent: p.Tangent, Remainder: p.Remainder, Provenance: p.Provenance, Frame: p.Frame}
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if beam.Dummy {
+				meta.Dummies++
+			}
+		}
+	}
+
+	if l := p.Layout; l != nil {
+		meta.Layout = &LayoutRecord{SubTilings: []int{}, Eps: l.Eps, Split: l.Split, Review: !l.Split && l.NeedsReview()}
+
+		for _, members := range l.SubTilings {
+			meta.Layout.SubTilings = append(meta.Layout.SubTilings, len(members))
+		}
+
+		for _, beam := range l.Outliers {
+			meta.Layout.Outliers = append(meta.Layout.Outliers, beam.Name)
+		}
+	}
+
+	return meta
+}
+
+// Write the beam packing to w in the requested format: text, json or csv,
+// jsonl, or one of the katpoint target formats. The packing metadata and the
+// bunch geometry are written as JSON objects or as comment lines, but not in
+// the JSON Lines stream. The metric is used for the bounding circle radii.
+func Write(w io.Writer, p *Packing, format string, dist DistanceFunc) error {
+	switch format {
+	case "katpoint":
+		return WriteTargets(w, p, false)
+	case "katpoint-beams":
+		return WriteTargets(w, p, true)
+	case "jsonl":
+		return WriteStream(w, p)
+	}
+
+	records := Records(p)
+	meta := GetMetadata(p)
+	bunches := BunchRecords(p, dist)
+
+	var excluded []string
+	for _, beam := range p.Excluded {
+		excluded = append(excluded, beam.Name)
+	}
+
+	var masked []MaskedRecord
+	for _, m := range p.Masked {
+		masked = append(masked, MaskedRecord{m.Beam.Name, m.Beam.X, m.Beam.Y, m.Region.String()})
+	}
+
+	var flagged []FlaggedRecord
+	for _, f := range p.Flagged {
+		flagged = append(flagged, FlaggedRecord{f.Beam.Name, f.Beam.X, f.Beam.Y, f.Reason})
+	}
+
+	switch format {
+	case "text", "csv":
+		fmt.Fprintf(w, "# method: %s\n", meta.Method)
+		fmt.Fprintf(w, "# seed: %d\n", meta.Seed)
+
+		if meta.Provenance != nil {
+			write_provenance(w, meta.Provenance)
+		}
+
+		if meta.Remainder != "" {
+			fmt.Fprintf(w, "# remainder: %s, dummies: %d\n", meta.Remainder, meta.Dummies)
+		}
+
+		if meta.Frame != "" {
+			fmt.Fprintf(w, "# frame: %s\n", meta.Frame)
+		}
+
+		if meta.Tangent != nil {
+			fmt.Fprintf(w, "# tangent: %.6f %.6f\n", meta.Tangent.RA, meta.Tangent.Dec)
+		}
+
+		if len(excluded) > 0 {
+			fmt.Fprintf(w, "# excluded: %s\n", strings.Join(excluded, ","))
+		}
+
+		if l := meta.Layout; l != nil {
+			var sizes []string
+			for _, n := range l.SubTilings {
+				sizes = append(sizes, strconv.Itoa(n))
+			}
+
+			fmt.Fprintf(w, "# layout: sub-tilings: %s, outliers: %s, eps: %.6f, split: %t, review: %t\n",
+				strings.Join(sizes, " "), strings.Join(l.Outliers, ","), l.Eps, l.Split, l.Review)
+		}
+
+		for _, m := range masked {
+			fmt.Fprintf(w, "# masked: %s, x: %.6f, y: %.6f, region: %s\n", m.Name, m.X, m.Y, m.Region)
+		}
+
+		for _, f := range flagged {
+			fmt.Fprintf(w, "# flagged: %s, x: %.6f, y: %.6f, reason: %s\n", f.Name, f.X, f.Y, f.Reason)
+		}
+
+		for _, b := range bunches {
+			var hull []string
+			for _, v := range b.Hull {
+				hull = append(hull, fmt.Sprintf("%.6f %.6f", v[0], v[1]))
+			}
+
+			fmt.Fprintf(w, "# bunch: %d, centroid: %.6f %.6f, circle: %.6f %.6f %.6f, hull: %s\n",
+				b.ID, b.Centroid[0], b.Centroid[1], b.Circle.X, b.Circle.Y, b.Circle.Radius, strings.Join(hull, "; "))
+		}
+
+		for _, b := range bunches {
+			for _, t := range b.Tags {
+				fmt.Fprintf(w, "# tag: bunch: %d, source: %s, kind: %s, beam: %s, separation: %.6f\n", b.ID, t.Source, t.Kind, t.Beam, t.Sep)
+			}
+		}
+	}
+
+	switch format {
+	case "text":
+		for _, rec := range records {
+			_, err := fmt.Fprintf(w, "Beam: %d, name: %s, group: %d, rank: %d, x: %.6f, y: %.6f",
+				rec.Beam, rec.Name, rec.Bunch, rec.Rank, rec.X, rec.Y)
+			if err == nil && rec.Node != "" {
+				_, err = fmt.Fprintf(w, ", node: %s", rec.Node)
+			}
+			if err == nil && rec.Pointing != "" {
+				_, err = fmt.Fprintf(w, ", pointing: %s", rec.Pointing)
+			}
+			if err == nil && rec.Sensitivity != 0 {
+				_, err = fmt.Fprintf(w, ", sensitivity: %.4f", rec.Sensitivity)
+			}
+			if err == nil {
+				_, err = fmt.Fprintln(w)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(Output{Metadata: meta, Beams: records, Bunches: bunches, Excluded: excluded, Masked: masked, Flagged: flagged})
+
+	case "csv":
+		writer := csv.NewWriter(w)
+
+		// the pointing column is only written for mosaic packings, and the
+		// sensitivity column if the sensitivities are known
+		mosaic, sensitivity := false, false
+		for _, rec := range records {
+			mosaic = mosaic || rec.Pointing != ""
+			sensitivity = sensitivity || rec.Sensitivity != 0
+		}
+
+		header := []string{"beam", "name", "x", "y", "bunch", "rank", "node"}
+		if mosaic {
+			header = append(header, "pointing")
+		}
+
+		if sensitivity {
+			header = append(header, "sensitivity")
+		}
+
+		writer.Write(header)
+
+		for _, rec := range records {
+			row := []string{
+				strconv.Itoa(rec.Beam),
+				rec.Name,
+				strconv.FormatFloat(rec.X, 'g', -1, 64),
+				strconv.FormatFloat(rec.Y, 'g', -1, 64),
+				strconv.Itoa(rec.Bunch),
+				strconv.Itoa(rec.Rank),
+				rec.Node,
+			}
+
+			if mosaic {
+				row = append(row, rec.Pointing)
+			}
+
+			if sensitivity {
+				row = append(row, strconv.FormatFloat(rec.Sensitivity, 'g', -1, 64))
+			}
+
+			writer.Write(row)
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,244 @@
+package beampack
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+)
+
+// The number of integration steps across a beam for its overlap areas.
+const overlap_steps = 2000
+
+// OverlapOptions configure the overlap matrix.
+type OverlapOptions struct {
+	// The shape of the beams that have none, as semi-axes at half power in
+	// degrees and position angle in degrees.
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+	// Overlaps of smaller fractions of both beams are left out.
+	MinFraction float64
+}
+
+// Overlap is the overlap of the half-power footprints of two beams, an entry
+// of the sparse overlap matrix.
+type Overlap struct {
+	// The indices of the beams in the list of beams of the matrix.
+	I int `json:"i"`
+	J int `json:"j"`
+	// The separation of the beam centres in degrees and the overlap area in
+	// square degrees.
+	Sep  float64 `json:"sep"`
+	Area float64 `json:"area"`
+	// The overlap area as fraction of the areas of the first and second
+	// beam.
+	FractionI float64 `json:"fraction_i"`
+	FractionJ float64 `json:"fraction_j"`
+}
+
+// OverlapMatrix is the sparse matrix of the overlap fractions of the
+// coherent beams, with one entry per overlapping pair.
+type OverlapMatrix struct {
+	Beams    []string  `json:"beams"`
+	Overlaps []Overlap `json:"overlaps"`
+}
+
+// Get the offset of the second position from the first on the tangent plane
+// at the first, east and north in degrees.
+func get_tangent_offset(x1, y1, x2, y2 float64) (float64, float64) {
+	const deg = math.Pi / 180.0
+
+	sep := Angular(x1, y1, x2, y2)
+
+	sindec1, cosdec1 := math.Sincos(y1 * deg)
+	sindec2, cosdec2 := math.Sincos(y2 * deg)
+	sindra, cosdra := math.Sincos((x2 - x1) * deg)
+
+	theta := math.Atan2(sindra*cosdec2, cosdec1*sindec2-sindec1*cosdec2*cosdra)
+
+	return sep * math.Sin(theta), sep * math.Cos(theta)
+}
+
+// Get the fraction of the area of the ellipse with covariance a centred on
+// the origin that is covered by the ellipse with covariance b centred at
+// (cx, cy). The covariances are given as xx, xy and yy elements. The first
+// ellipse is mapped onto the unit disk, where the second one becomes an
+// ellipse whose chords are intersected with the ones of the disk.
+func get_overlap_fraction(a, b [3]float64, cx, cy float64) float64 {
+	// the Cholesky factor L of a, so that x = L u maps the unit disk onto
+	// the first ellipse
+	l11 := math.Sqrt(a[0])
+	l21 := a[1] / l11
+	l22 := math.Sqrt(math.Max(a[2]-l21*l21, 0))
+
+	// the inverse of b
+	det := b[0]*b[2] - b[1]*b[1]
+	i11, i12, i22 := b[2]/det, -b[1]/det, b[0]/det
+
+	// the second ellipse in disk coordinates: u^T M u - 2 g^T u + k <= 1,
+	// with M = L^T B^-1 L, g = L^T B^-1 c and k = c^T B^-1 c
+	m11 := l11*l11*i11 + 2*l11*l21*i12 + l21*l21*i22
+	m12 := l11*l22*i12 + l21*l22*i22
+	m22 := l22 * l22 * i22
+
+	h1, h2 := i11*cx+i12*cy, i12*cx+i22*cy
+	g1, g2 := l11*h1+l21*h2, l22*h2
+	k := cx*h1 + cy*h2
+
+	var area float64
+	step := 2.0 / overlap_steps
+
+	for s := 0; s < overlap_steps; s++ {
+		x := -1 + (float64(s)+0.5)*step
+
+		half := math.Sqrt(1 - x*x)
+
+		// the chord of the second ellipse at x, from the roots of
+		// m22 y^2 + 2 (m12 x - g2) y + (m11 x^2 - 2 g1 x + k - 1)
+		p := m12*x - g2
+		q := m11*x*x - 2*g1*x + k - 1
+
+		disc := p*p - m22*q
+		if disc <= 0 {
+			continue
+		}
+
+		root := math.Sqrt(disc)
+		lo := math.Max((-p-root)/m22, -half)
+		hi := math.Min((-p+root)/m22, half)
+
+		if hi > lo {
+			area += (hi - lo) * step
+		}
+	}
+
+	return math.Min(area/math.Pi, 1)
+}
+
+// Overlaps computes the sparse matrix of the overlaps of the half-power
+// footprints of the coherent beams, as ellipses with the beam shapes on the
+// tangent plane at the first beam of every pair. The beam positions are
+// interpreted as RA and Dec in degrees. Only pairs whose footprints overlap
+// are listed, with i < j, ordered by i and j.
+func Overlaps(beams []Beam, opts OverlapOptions) (*OverlapMatrix, error) {
+	m := &OverlapMatrix{Beams: []string{}, Overlaps: []Overlap{}}
+
+	var list []Beam
+	var shapes [][3]float64
+	var majors, areas []float64
+
+	for _, beam := range beams {
+		if beam.Incoherent || beam.Dummy {
+			continue
+		}
+
+		a, b, pa := opts.SemiMajor, opts.SemiMinor, opts.PA
+		if beam.SemiMajor > 0 && beam.SemiMinor > 0 {
+			a, b, pa = beam.SemiMajor, beam.SemiMinor, beam.PA
+		}
+
+		if a <= 0 || b <= 0 {
+			return nil, fmt.Errorf("No beam shape for beam: %s", beam.key())
+		}
+
+		xx, xy, yy := get_covariance(a, b, pa)
+
+		list = append(list, beam)
+		shapes = append(shapes, [3]float64{xx, xy, yy})
+		majors = append(majors, a)
+		areas = append(areas, math.Pi*a*b)
+
+		m.Beams = append(m.Beams, beam.key())
+	}
+
+	for i, a := range list {
+		for j := i + 1; j < len(list); j++ {
+			b := list[j]
+
+			sep := Angular(a.X, a.Y, b.X, b.Y)
+			if sep >= majors[i]+majors[j] {
+				continue
+			}
+
+			cx, cy := get_tangent_offset(a.X, a.Y, b.X, b.Y)
+
+			fi := get_overlap_fraction(shapes[i], shapes[j], cx, cy)
+			if fi <= 0 {
+				continue
+			}
+
+			area := fi * areas[i]
+			fj := math.Min(area/areas[j], 1)
+
+			if max(fi, fj) < opts.MinFraction {
+				continue
+			}
+
+			m.Overlaps = append(m.Overlaps, Overlap{I: i, J: j, Sep: sep, Area: area, FractionI: fi, FractionJ: fj})
+		}
+	}
+
+	return m, nil
+}
+
+// WriteOverlaps writes the overlap matrix in the format: as Matrix Market
+// coordinate file of the overlap fractions for text, where the entry in row
+// i and column j is the fraction of beam i covered by beam j and the rows
+// and columns are numbered from one in the order of the beams listed in the
+// comments, or as the list of overlapping pairs for json and csv.
+func WriteOverlaps(w io.Writer, m *OverlapMatrix, format string) error {
+	switch format {
+	case "text":
+		fmt.Fprintf(w, "%%%%MatrixMarket matrix coordinate real general\n")
+		fmt.Fprintf(w, "%% fraction of the half-power footprint of the row beam covered by the column beam\n")
+
+		for i, name := range m.Beams {
+			fmt.Fprintf(w, "%% beam %d: %s\n", i+1, name)
+		}
+
+		fmt.Fprintf(w, "%d %d %d\n", len(m.Beams), len(m.Beams), 2*len(m.Overlaps))
+
+		for _, o := range m.Overlaps {
+			fmt.Fprintf(w, "%d %d %.6g\n", o.I+1, o.J+1, o.FractionI)
+			if _, err := fmt.Fprintf(w, "%d %d %.6g\n", o.J+1, o.I+1, o.FractionJ); err != nil {
+				return err
+			}
+		}
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(m)
+
+	case "csv":
+		writer := csv.NewWriter(w)
+		writer.Write([]string{"beam_i", "beam_j", "sep", "area", "fraction_i", "fraction_j"})
+
+		format_float := func(v float64) string {
+			return strconv.FormatFloat(v, 'g', -1, 64)
+		}
+
+		for _, o := range m.Overlaps {
+			writer.Write([]string{
+				m.Beams[o.I],
+				m.Beams[o.J],
+				format_float(o.Sep),
+				format_float(o.Area),
+				format_float(o.FractionI),
+				format_float(o.FractionJ),
+			})
+		}
+
+		writer.Flush()
+		return writer.Error()
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+
+	return nil
+}
@@ -0,0 +1,590 @@
+package beampack
+
+import (
+	"fmt"
+	"math/rand"
+	"slices"
+	"sort"
+)
+
+// Bunch is a group of neighbouring beams that get processed on the same
+// compute node. The beams are ordered by their rank within the bunch.
+type Bunch struct {
+	ID    int
+	Beams []Beam
+	// The processing node, if assigned.
+	Node string
+	// The known sources within the beams, if tagged.
+	Tags []Tag
+}
+
+// Packing is the assignment of beams to bunches.
+type Packing struct {
+	Method string
+	// Seed of the random number generator used for stochastic methods.
+	Seed    int64
+	Bunches []Bunch
+	// Beams that were excluded from the packing.
+	Excluded []Beam
+	// Beams that were dropped because they lie within a masked region.
+	Masked []Masked
+	// Beams that were dropped because they are dead or flagged for RFI.
+	Flagged []FlaggedBeam
+	// Tangent point of the projection the beams were packed in, if any.
+	Tangent *Tangent
+	// The policy applied when the number of beams is not divisible by the
+	// bunch size.
+	Remainder string
+	// Groups of beam numbers that are packed into the same bunch and that
+	// the optimizers must not separate.
+	Pinned [][]int
+	// The assignment of a previous packing that the annealing optimizer
+	// should change as little as possible, if any.
+	Previous *Previous
+	// How the packing was produced, if known.
+	Provenance *Provenance
+	// The coordinate frame of the beam positions, equatorial if empty.
+	Frame string
+	// The sub-tilings and outliers of the beam layout, if they were looked
+	// for.
+	Layout *Layout
+}
+
+// Options configure the packing.
+type Options struct {
+	// Only consider that many beams in x order for packing. Zero means all
+	// beams.
+	NBeams int
+	// Number of beams to pack into a bunch.
+	Bunch int
+	// If positive, partition the beams into that many spatially compact
+	// groups of approximately equal size instead of bunches of Bunch beams.
+	NGroups int
+	// Packing method: greedy, kmeans, hilbert, partition or mst.
+	Method string
+	// Distance metric, defaults to Euclidean.
+	Metric DistanceFunc
+	// Seed of the random number generator for stochastic methods. It is
+	// recorded in the packing.
+	Seed int64
+	// Random number generator for stochastic methods. If nil, one is
+	// created from the seed.
+	Rand *rand.Rand
+	// Incoherent beam handling: bunch (default) appends the incoherent
+	// beams as their own bunch, pin additionally assigns that bunch to
+	// IncoherentNode and exclude drops them from the packing.
+	Incoherent string
+	// The processing node for the incoherent beams with the pin policy.
+	IncoherentNode string
+	// Projection of the beam positions before packing: none (default) or
+	// gnomonic. The gnomonic projection maps RA and Dec onto the tangent
+	// plane about the boresight, where distances are undistorted even at
+	// high declination.
+	Projection string
+	// Tangent point of the projection. If nil, the mean beam direction is
+	// used.
+	Boresight *Tangent
+	// Groups of beam numbers that must be packed into the same bunch. The
+	// beams are moved into a common bunch after packing and stay there
+	// during optimization.
+	Pinned [][]int
+	// Masked sky regions. The coherent beams within them are dropped
+	// before packing.
+	Regions []Region
+	// Handling of the remaining beams when their number is not divisible
+	// by the bunch size: smaller (default) packs them into one smaller
+	// bunch, pad fills that bunch up with dummy placeholder beams at its
+	// centroid, balance spreads them so that the bunch sizes differ by at
+	// most one and abort fails.
+	Remainder string
+	// Dead or RFI-flagged beams, by number, and the reasons they are
+	// flagged. They are dropped before packing.
+	Flagged map[int]string
+	// If beams were flagged, apply the balance policy instead of smaller,
+	// so that no bunch is left with only a few beams.
+	Rebalance bool
+	// Handling of layouts with several disconnected sub-tilings or with
+	// outliers, which are found by density-based clustering before packing:
+	// none (default) does not look for them, split packs every sub-tiling
+	// independently, with the outliers joining the nearest one, and review
+	// packs the beams as usual and records the layout for an operator to
+	// review.
+	SubTilings string
+	SubTiling  SubTilingOptions
+}
+
+// RemainderPolicies lists the available policies for the remaining beams.
+var RemainderPolicies = []string{"smaller", "pad", "balance", "abort"}
+
+// IncoherentPolicies lists the available incoherent beam policies.
+var IncoherentPolicies = []string{"bunch", "pin", "exclude"}
+
+// Methods lists the available packing methods.
+var Methods = []string{"greedy", "kmeans", "hilbert", "partition", "mst"}
+
+// NBeams returns the total number of beams in the packing.
+func (p *Packing) NBeams() int {
+	var n int
+
+	for _, b := range p.Bunches {
+		n += len(b.Beams)
+	}
+
+	return n
+}
+
+// Set the bunches from the given groups of beams, numbering them
+// consecutively.
+func (p *Packing) set_groups(groups [][]Beam) {
+	p.Bunches = nil
+
+	for _, members := range groups {
+		if len(members) == 0 {
+			continue
+		}
+
+		p.Bunches = append(p.Bunches, Bunch{ID: len(p.Bunches), Beams: members})
+	}
+}
+
+// Compute the sizes of the groups for n beams. Either all groups hold bunch
+// beams, except for a smaller last one, or the beams are split into ngroups
+// groups whose sizes differ by at most one.
+func get_group_sizes(n int, bunch int, ngroups int) []int {
+	var sizes []int
+
+	if ngroups > 0 {
+		size := n / ngroups
+		extra := n % ngroups
+
+		for i := 0; i < ngroups; i++ {
+			if i < extra {
+				sizes = append(sizes, size+1)
+			} else {
+				sizes = append(sizes, size)
+			}
+		}
+
+		return sizes
+	}
+
+	for n > 0 {
+		size := min(bunch, n)
+		sizes = append(sizes, size)
+		n -= size
+	}
+
+	return sizes
+}
+
+// Pack maps the on-sky beams to multicast addresses/compute nodes. The
+// beams are packed into bunches of opts.Bunch beams each, or into
+// opts.NGroups groups, using the requested method.
+func Pack(beams []Beam, opts Options) (*Packing, error) {
+	if opts.NBeams < 0 || (opts.Bunch <= 0 && opts.NGroups <= 0) {
+		return nil, fmt.Errorf("The number of beams and the bunch size must be positive: %d, %d", opts.NBeams, opts.Bunch)
+	}
+
+	dist := opts.Metric
+	if dist == nil {
+		dist = Euclidean
+	}
+
+	rng := opts.Rand
+	if rng == nil {
+		rng = rand.New(rand.NewSource(opts.Seed))
+	}
+
+	policy := opts.Incoherent
+	if policy == "" {
+		policy = "bunch"
+	}
+
+	if policy == "pin" && opts.IncoherentNode == "" {
+		return nil, fmt.Errorf("No node given to pin the incoherent beam to.")
+	}
+
+	beams, masked := Mask(beams, opts.Regions, dist)
+
+	// the incoherent beams do not take part in the spatial packing
+	var coherent, incoherent []Beam
+
+	for _, beam := range beams {
+		if beam.Incoherent {
+			incoherent = append(incoherent, beam)
+		} else {
+			coherent = append(coherent, beam)
+		}
+	}
+
+	data := select_beams(coherent, opts.NBeams)
+
+	// the flagged beams are dropped from the selected ones, so that they are
+	// not replaced by other beams
+	data, flagged := drop_flagged(data, opts.Flagged)
+	incoherent, flagged_ib := drop_flagged(incoherent, opts.Flagged)
+	flagged = append(flagged, flagged_ib...)
+
+	var tangent *Tangent
+	original := data
+
+	switch opts.Projection {
+	case "", "none":
+	case "gnomonic":
+		t := GetBoresight(data)
+		if opts.Boresight != nil {
+			t = *opts.Boresight
+		}
+
+		projected, err := project_beams(data, t)
+		if err != nil {
+			return nil, err
+		}
+
+		tangent = &t
+		data = projected
+	default:
+		return nil, fmt.Errorf("Unknown projection: %s", opts.Projection)
+	}
+
+	if opts.NGroups > len(data) {
+		return nil, unsatisfiable("More groups than beams requested: %d, %d", opts.NGroups, len(data))
+	}
+
+	remainder := opts.Remainder
+	if remainder == "" {
+		remainder = "smaller"
+	}
+
+	if !slices.Contains(RemainderPolicies, remainder) {
+		return nil, fmt.Errorf("Unknown remainder policy: %s", remainder)
+	}
+
+	if remainder == "smaller" && opts.Rebalance && len(flagged) > 0 {
+		remainder = "balance"
+	}
+
+	// the policy only applies to bunches of fixed size
+	if opts.NGroups > 0 {
+		remainder = ""
+	} else if remainder == "abort" && len(data)%opts.Bunch != 0 {
+		return nil, unsatisfiable("The number of beams is not divisible by the bunch size: %d, %d", len(data), opts.Bunch)
+	}
+
+	// the sizes of the groups of n beams, or of ngroups groups
+	get_sizes := func(n, ngroups int) []int {
+		sizes := get_group_sizes(n, opts.Bunch, ngroups)
+
+		// as many bunches as needed for bunches of at most Bunch beams,
+		// whose sizes differ by at most one
+		if remainder == "balance" && n > 0 {
+			sizes = get_group_sizes(n, 0, (n+opts.Bunch-1)/opts.Bunch)
+		}
+
+		return sizes
+	}
+
+	var layout *Layout
+
+	switch opts.SubTilings {
+	case "", "none":
+	case "split", "review":
+		layout = FindSubTilings(data, dist, opts.SubTiling)
+		layout.Split = opts.SubTilings == "split" && len(layout.SubTilings) > 1
+	default:
+		return nil, fmt.Errorf("Unknown sub-tiling policy: %s", opts.SubTilings)
+	}
+
+	var groups [][]Beam
+
+	if layout != nil && layout.Split {
+		parts := split_subtilings(data, dist, layout)
+
+		var counts []int
+		if opts.NGroups > 0 {
+			var err error
+			if counts, err = split_ngroups(parts, opts.NGroups); err != nil {
+				return nil, err
+			}
+		}
+
+		for i, part := range parts {
+			if remainder == "abort" && len(part)%opts.Bunch != 0 {
+				return nil, unsatisfiable("The number of beams of a sub-tiling is not divisible by the bunch size: %d, %d", len(part), opts.Bunch)
+			}
+
+			ngroups := 0
+			if counts != nil {
+				ngroups = counts[i]
+			}
+
+			g, err := pack_method(opts.Method, part, get_sizes(len(part), ngroups), dist, rng)
+			if err != nil {
+				return nil, err
+			}
+
+			groups = append(groups, g...)
+		}
+	} else {
+		var err error
+		if groups, err = pack_method(opts.Method, data, get_sizes(len(data), opts.NGroups), dist, rng); err != nil {
+			return nil, err
+		}
+	}
+
+	if len(opts.Pinned) > 0 {
+		if err := apply_constraints(groups, opts.Pinned, dist); err != nil {
+			return nil, err
+		}
+	}
+
+	if tangent != nil {
+		restore_beams(groups, original)
+	}
+
+	if remainder == "pad" {
+		pad_groups(groups, opts.Bunch, beams)
+	}
+
+	p := &Packing{
+		Method:    opts.Method,
+		Seed:      opts.Seed,
+		Tangent:   tangent,
+		Remainder: remainder,
+		Pinned:    opts.Pinned,
+		Masked:    masked,
+		Flagged:   flagged,
+		Layout:    layout,
+	}
+
+	p.set_groups(groups)
+
+	if len(incoherent) > 0 {
+		switch policy {
+		case "bunch", "pin":
+			b := Bunch{ID: len(p.Bunches), Beams: incoherent}
+			if policy == "pin" {
+				b.Node = opts.IncoherentNode
+			}
+
+			p.Bunches = append(p.Bunches, b)
+
+		case "exclude":
+			p.Excluded = append(p.Excluded, incoherent...)
+
+		default:
+			return nil, fmt.Errorf("Unknown incoherent beam policy: %s", policy)
+		}
+	}
+
+	return p, nil
+}
+
+// Pack the beams with the method into groups of the given sizes.
+func pack_method(method string, data []Beam, sizes []int, dist DistanceFunc, rng *rand.Rand) ([][]Beam, error) {
+	switch method {
+	case "greedy":
+		return pack_greedy(data, sizes, dist), nil
+	case "kmeans":
+		return pack_kmeans(data, sizes, dist, rng), nil
+	case "hilbert":
+		return pack_hilbert(data, sizes), nil
+	case "partition":
+		return pack_partition(data, sizes, dist), nil
+	case "mst":
+		return pack_mst(data, sizes, dist), nil
+	default:
+		return nil, fmt.Errorf("Unknown packing method: %s", method)
+	}
+}
+
+// Fill the groups that are smaller than the bunch size up with dummy beams
+// at their centroid. The dummy beams are numbered after the beams.
+func pad_groups(groups [][]Beam, bunch int, beams []Beam) {
+	next := 0
+	for _, beam := range beams {
+		next = max(next, beam.Nr+1)
+	}
+
+	var ndummy int
+
+	for i, members := range groups {
+		if len(members) == 0 {
+			continue
+		}
+
+		cx, cy := get_centroid(members)
+
+		for len(groups[i]) < bunch {
+			dummy := Beam{
+				Nr:    next,
+				Name:  fmt.Sprintf("%s%05d", DummyPrefix, ndummy),
+				X:     cx,
+				Y:     cy,
+				Dummy: true,
+			}
+
+			groups[i] = append(groups[i], dummy)
+			next++
+			ndummy++
+		}
+	}
+}
+
+// Select the beams to consider for packing. Only the first nbeams beams in
+// x order are kept, or all beams if nbeams is zero.
+func select_beams(beams []Beam, nbeams int) []Beam {
+	data := make([]Beam, len(beams))
+	copy(data, beams)
+
+	sort.SliceStable(data, func(i, j int) bool {
+		return data[i].X < data[j].X
+	})
+
+	// only consider that many beams
+	if nbeams > 0 && len(data) >= nbeams {
+		data = data[0:nbeams]
+	}
+
+	return data
+}
+
+// A beam together with its distance to a reference position.
+type candidate struct {
+	beam Beam
+	dist float64
+}
+
+// Sort the candidates by increasing distance.
+func sort_candidates(work []candidate) {
+	sort.SliceStable(work, func(i, j int) bool {
+		return work[i].dist < work[j].dist
+	})
+}
+
+// Pack the beams using a greedy nearest-neighbour algorithm. Starting from
+// the remaining beam with the lowest x, the closest beams are grouped, where
+// the groups have the given sizes. The data must be sorted by x. The
+// neighbours are looked up in a KD-tree for the built-in metrics.
+func pack_greedy(data []Beam, sizes []int, dist DistanceFunc) [][]Beam {
+	embed := get_embedding(dist)
+	if embed == nil {
+		return pack_greedy_naive(data, sizes, dist)
+	}
+
+	tree := new_kdtree(data, embed)
+
+	var groups [][]Beam
+
+	first := 0
+
+	for _, size := range sizes {
+		// the remaining beam with the lowest x
+		for first < len(data) && tree.removed[first] {
+			first++
+		}
+
+		if first == len(data) {
+			break
+		}
+
+		seed := data[first]
+
+		found := tree.nearest(first, size, func(j int) float64 {
+			return dist(seed.X, seed.Y, data[j].X, data[j].Y)
+		})
+
+		members := make([]Beam, len(found))
+		for i, n := range found {
+			members[i] = data[n.point]
+			tree.remove(n.point)
+		}
+
+		groups = append(groups, members)
+	}
+
+	return groups
+}
+
+// Pack the beams using the greedy nearest-neighbour algorithm with a linear
+// search for the neighbours. This works with any distance metric.
+func pack_greedy_naive(data []Beam, sizes []int, dist DistanceFunc) [][]Beam {
+	removed := make([]bool, len(data))
+	first := 0
+
+	var groups [][]Beam
+
+	for _, size := range sizes {
+		// the remaining beam with the lowest x
+		for first < len(data) && removed[first] {
+			first++
+		}
+
+		if first == len(data) {
+			break
+		}
+
+		seed := data[first]
+
+		var work []neighbour
+
+		for i, beam := range data {
+			if !removed[i] {
+				work = append(work, neighbour{point: i, dist: dist(seed.X, seed.Y, beam.X, beam.Y)})
+			}
+		}
+
+		sort.Slice(work, func(i, j int) bool {
+			return work[i].less(work[j])
+		})
+
+		// pick the closest `size` beams
+		n := min(size, len(work))
+
+		members := make([]Beam, n)
+		for i, c := range work[0:n] {
+			members[i] = data[c.point]
+			removed[c.point] = true
+		}
+
+		groups = append(groups, members)
+	}
+
+	return groups
+}
+
+// Order the beams of every group by their distance from the group centroid.
+func rank_groups(groups [][]Beam, dist DistanceFunc) {
+	for _, members := range groups {
+		if len(members) == 0 {
+			continue
+		}
+
+		cx, cy := get_centroid(members)
+
+		work := make([]candidate, len(members))
+		for i, beam := range members {
+			work[i] = candidate{beam, dist(cx, cy, beam.X, beam.Y)}
+		}
+
+		sort_candidates(work)
+
+		for i, c := range work {
+			members[i] = c.beam
+		}
+	}
+}
+
+// Compute the centroid of the beam positions.
+func get_centroid(beams []Beam) (float64, float64) {
+	var cx, cy float64
+
+	for _, beam := range beams {
+		cx += beam.X
+		cy += beam.Y
+	}
+
+	n := float64(len(beams))
+
+	return cx / n, cy / n
+}
@@ -0,0 +1,159 @@
+package beampack
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+// Get a hexagonal tiling of n beams like the MeerKAT coherent beams.
+func get_test_tiling(tb testing.TB, n int) []Beam {
+	tb.Helper()
+
+	beams, err := Tile(TileOptions{
+		X0:        134.0696,
+		Y0:        -30.0,
+		SemiMajor: 0.01,
+		SemiMinor: 0.006,
+		PA:        30,
+		Overlap:   0.25,
+		NBeams:    n,
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return beams
+}
+
+func TestPack(t *testing.T) {
+	beams := get_test_tiling(t, 100)
+
+	for _, m := range Methods {
+		p, err := Pack(beams, Options{Bunch: 6, Method: m, Seed: 1})
+		if err != nil {
+			t.Errorf("%s: %s", m, err)
+			continue
+		}
+
+		// every beam is packed exactly once, into bunches of at most six
+		seen := make(map[int]bool)
+
+		for _, b := range p.Bunches {
+			if len(b.Beams) == 0 || len(b.Beams) > 6 {
+				t.Errorf("%s: bunch %d has %d beams", m, b.ID, len(b.Beams))
+			}
+
+			for _, beam := range b.Beams {
+				if seen[beam.Nr] {
+					t.Errorf("%s: beam %d is packed twice", m, beam.Nr)
+				}
+
+				seen[beam.Nr] = true
+			}
+		}
+
+		if len(seen) != len(beams) {
+			t.Errorf("%s: %d of %d beams packed", m, len(seen), len(beams))
+		}
+
+		if len(p.Bunches) != 17 {
+			t.Errorf("%s: %d bunches, want 17", m, len(p.Bunches))
+		}
+	}
+}
+
+func TestPackGroups(t *testing.T) {
+	beams := get_test_tiling(t, 100)
+
+	p, err := Pack(beams, Options{NGroups: 7, Method: "partition"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(p.Bunches) != 7 {
+		t.Fatalf("%d groups, want 7", len(p.Bunches))
+	}
+
+	for _, b := range p.Bunches {
+		if len(b.Beams) != 14 && len(b.Beams) != 15 {
+			t.Errorf("group %d has %d beams", b.ID, len(b.Beams))
+		}
+	}
+}
+
+func TestPackInvalid(t *testing.T) {
+	beams := get_test_tiling(t, 10)
+
+	if _, err := Pack(beams, Options{Bunch: 0}); err == nil {
+		t.Error("no error for a bunch size of zero")
+	}
+
+	if _, err := Pack(beams, Options{Bunch: 6, Method: "unknown"}); err == nil {
+		t.Error("no error for an unknown method")
+	}
+}
+
+func TestAnneal(t *testing.T) {
+	beams := get_test_tiling(t, 100)
+
+	p, err := Pack(beams, Options{Bunch: 6, Method: "hilbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	before := Score(p, Euclidean).TotDist
+	after := Score(Anneal(p, 20000, Euclidean, rand.New(rand.NewSource(1))), Euclidean).TotDist
+
+	if after > before {
+		t.Errorf("annealing increased the total distance: %g, %g", before, after)
+	}
+}
+
+func BenchmarkPack(b *testing.B) {
+	for _, n := range []int{64, 480, 1024} {
+		beams := get_test_tiling(b, n)
+
+		for _, m := range Methods {
+			b.Run(fmt.Sprintf("%s/%d", m, n), func(b *testing.B) {
+				for i := 0; i < b.N; i++ {
+					if _, err := Pack(beams, Options{Bunch: 6, Method: m, Seed: 1}); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkAnneal(b *testing.B) {
+	for _, n := range []int{64, 480} {
+		beams := get_test_tiling(b, n)
+
+		p, err := Pack(beams, Options{Bunch: 6, Method: "greedy"})
+		if err != nil {
+			b.Fatal(err)
+		}
+
+		b.Run(fmt.Sprintf("%d", n), func(b *testing.B) {
+			rng := rand.New(rand.NewSource(1))
+
+			for i := 0; i < b.N; i++ {
+				Anneal(p, 1000, Euclidean, rng)
+			}
+		})
+	}
+}
+
+func BenchmarkScore(b *testing.B) {
+	beams := get_test_tiling(b, 1024)
+
+	p, err := Pack(beams, Options{Bunch: 6, Method: "kmeans", Seed: 1})
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		Score(p, Euclidean)
+	}
+}
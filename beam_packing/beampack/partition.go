@@ -0,0 +1,276 @@
+package beampack
+
+import (
+	"math"
+	"sort"
+)
+
+// A weighted edge of the partitioning graph.
+type link struct {
+	to     int
+	weight float64
+}
+
+// Get the weighted adjacency lists of the beams. Neighbouring beams are
+// connected as in the adjacency graph, with weights that decrease with their
+// separation, so that cutting the links between close beams costs most.
+func get_links(data []Beam, dist DistanceFunc) [][]link {
+	p := &Packing{Bunches: []Bunch{{Beams: data}}}
+	g := Adjacency(p, 0, dist)
+
+	links := make([][]link, len(data))
+
+	for _, e := range g.Edges {
+		w := 1.0
+		if e.Dist > 0 {
+			w = g.MaxSep / e.Dist
+		}
+
+		links[e.A] = append(links[e.A], link{e.B, w})
+		links[e.B] = append(links[e.B], link{e.A, w})
+	}
+
+	return links
+}
+
+// Split the beams along their principal axis, so that the first n of them
+// end up in the first part.
+func split_principal(data []Beam, part []int, n int) ([]int, []int) {
+	var cx, cy float64
+	for _, i := range part {
+		cx += data[i].X
+		cy += data[i].Y
+	}
+
+	cx /= float64(len(part))
+	cy /= float64(len(part))
+
+	var sxx, syy, sxy float64
+	for _, i := range part {
+		dx, dy := data[i].X-cx, data[i].Y-cy
+		sxx += dx * dx
+		syy += dy * dy
+		sxy += dx * dy
+	}
+
+	angle := 0.5 * math.Atan2(2*sxy, sxx-syy)
+	ux, uy := math.Cos(angle), math.Sin(angle)
+
+	order := append([]int(nil), part...)
+
+	sort.SliceStable(order, func(i, j int) bool {
+		a, b := data[order[i]], data[order[j]]
+		return (a.X-cx)*ux+(a.Y-cy)*uy < (b.X-cx)*ux+(b.Y-cy)*uy
+	})
+
+	return order[:n], order[n:]
+}
+
+// Get the weight of the link to beam j.
+func link_weight(links []link, j int) float64 {
+	for _, l := range links {
+		if l.to == j {
+			return l.weight
+		}
+	}
+
+	return 0
+}
+
+// Get the unlocked beams of a part ordered by decreasing gain.
+func get_unlocked(part []int, gain []float64, locked []bool) []int {
+	var order []int
+	for _, i := range part {
+		if !locked[i] {
+			order = append(order, i)
+		}
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return gain[order[i]] > gain[order[j]]
+	})
+
+	return order
+}
+
+// Refine a bisection with Kernighan-Lin passes. Every pass tentatively swaps
+// the pair of unlocked beams with the largest reduction of the cut weight
+// until all beams of the smaller part are locked, and then keeps the prefix
+// of swaps with the largest total reduction. The part sizes never change.
+func refine_bisection(links [][]link, a, b []int) ([]int, []int) {
+	const maxpasses = 20
+
+	// the part of every beam, or -1 for the beams of other parts
+	side := make([]int, len(links))
+	for i := range side {
+		side[i] = -1
+	}
+	for _, i := range a {
+		side[i] = 0
+	}
+	for _, i := range b {
+		side[i] = 1
+	}
+
+	for pass := 0; pass < maxpasses; pass++ {
+		// the external minus the internal link weight of every beam
+		gain := make([]float64, len(links))
+
+		for i, s := range side {
+			if s < 0 {
+				continue
+			}
+
+			for _, l := range links[i] {
+				if t := side[l.to]; t >= 0 {
+					if t == s {
+						gain[i] -= l.weight
+					} else {
+						gain[i] += l.weight
+					}
+				}
+			}
+		}
+
+		locked := make([]bool, len(links))
+
+		var swaps [][2]int
+		var total, best float64
+		nbest := 0
+
+		for len(swaps) < min(len(a), len(b)) {
+			ua := get_unlocked(a, gain, locked)
+			ub := get_unlocked(b, gain, locked)
+
+			// the pair with the largest gain, counting the link between
+			// the two beams, which never increases the gain
+			pa, pb := -1, -1
+			var pgain float64
+
+			for _, i := range ua {
+				if pa >= 0 && gain[i]+gain[ub[0]] <= pgain {
+					break
+				}
+
+				for _, j := range ub {
+					if pa >= 0 && gain[i]+gain[j] <= pgain {
+						break
+					}
+
+					g := gain[i] + gain[j] - 2*link_weight(links[i], j)
+					if pa < 0 || g > pgain {
+						pa, pb, pgain = i, j, g
+					}
+				}
+			}
+
+			locked[pa] = true
+			locked[pb] = true
+			swaps = append(swaps, [2]int{pa, pb})
+
+			total += pgain
+			if total > best+1e-9 {
+				best, nbest = total, len(swaps)
+			}
+
+			// update the gains of the neighbours as if the pair had
+			// been swapped
+			side[pa], side[pb] = 1, 0
+
+			for _, moved := range swaps[len(swaps)-1] {
+				for _, l := range links[moved] {
+					t := side[l.to]
+					if t < 0 || locked[l.to] {
+						continue
+					}
+
+					if t == side[moved] {
+						gain[l.to] -= 2 * l.weight
+					} else {
+						gain[l.to] += 2 * l.weight
+					}
+				}
+			}
+		}
+
+		// undo the swaps after the best prefix
+		for _, s := range swaps[nbest:] {
+			side[s[0]], side[s[1]] = 0, 1
+		}
+
+		if nbest == 0 {
+			break
+		}
+
+		all := append(append([]int(nil), a...), b...)
+		a, b = nil, nil
+
+		for _, i := range all {
+			if side[i] == 0 {
+				a = append(a, i)
+			} else {
+				b = append(b, i)
+			}
+		}
+	}
+
+	return a, b
+}
+
+// Recursively bisect the beams into parts of the given sizes.
+func bisect(data []Beam, links [][]link, part []int, sizes []int) [][]int {
+	if len(sizes) == 1 {
+		return [][]int{part}
+	}
+
+	k := len(sizes) / 2
+
+	var n int
+	for _, size := range sizes[:k] {
+		n += size
+	}
+
+	a, b := split_principal(data, part, n)
+	a, b = refine_bisection(links, a, b)
+
+	return append(bisect(data, links, a, sizes[:k]), bisect(data, links, b, sizes[k:])...)
+}
+
+// Pack the beams by balanced graph partitioning: the adjacency graph of the
+// beams is recursively bisected along the principal axis of the beam
+// positions, and every bisection is refined by Kernighan-Lin passes that
+// minimise the weight of the cut links. This splits the tiling into
+// spatially coherent groups of the given sizes and follows the shape of
+// elongated tilings.
+func pack_partition(data []Beam, sizes []int, dist DistanceFunc) [][]Beam {
+	if len(data) == 0 {
+		return nil
+	}
+
+	links := get_links(data, dist)
+
+	part := make([]int, len(data))
+	for i := range part {
+		part[i] = i
+	}
+
+	parts := bisect(data, links, part, sizes)
+
+	groups := make([][]Beam, len(parts))
+	for g, members := range parts {
+		for _, i := range members {
+			groups[g] = append(groups[g], data[i])
+		}
+	}
+
+	// number the bunches in order of centroid x
+	sort.SliceStable(groups, func(i, j int) bool {
+		xi, _ := get_centroid(groups[i])
+		xj, _ := get_centroid(groups[j])
+		return xi < xj
+	})
+
+	rank_groups(groups, dist)
+
+	return groups
+}
@@ -0,0 +1,188 @@
+package beampack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/netip"
+	"sort"
+	"strings"
+)
+
+// PipelineOptions configure the search pipeline configuration.
+type PipelineOptions struct {
+	// First multicast group address. Bunch i is sent to the address i
+	// groups after it.
+	MulticastBase string
+	// Port of the multicast groups.
+	Port int
+	// The multicast groups the beams are received from by beam number, as
+	// loaded with LoadMulticast, instead of one group per bunch.
+	Groups map[int]string
+}
+
+// BunchConfig is the configuration of one bunch of beams in the search
+// pipeline.
+type BunchConfig struct {
+	ID int
+	// The multicast group of the bunch, unless the beams are received from
+	// the groups of the beam mapping.
+	Multicast string
+	// The multicast groups the beams of the bunch are received from.
+	Groups  []string
+	Beams   []string
+	BeamIDs []int
+}
+
+// NodeConfig is the search pipeline configuration of one processing node.
+// Bunches without node get a configuration of their own with an empty node
+// name.
+type NodeConfig struct {
+	Node    string
+	Bunches []BunchConfig
+	// The multicast groups the node must subscribe to.
+	Groups []string
+}
+
+// Get the multicast address of the group offset groups after the base.
+func get_multicast(base netip.Addr, offset int, port int) (string, error) {
+	raw := base.As4()
+	value := binary.BigEndian.Uint32(raw[:]) + uint32(offset)
+	binary.BigEndian.PutUint32(raw[:], value)
+
+	addr := netip.AddrFrom4(raw)
+	if !addr.IsMulticast() {
+		return "", fmt.Errorf("Not a multicast address: %s", addr)
+	}
+
+	return fmt.Sprintf("spead://%s:%d", addr, port), nil
+}
+
+// PipelineConfigs computes the per-node search pipeline configurations of
+// the packing: the bunch IDs, multicast groups and beam lists. The nodes
+// are ordered by name and the bunches by ID. Dummy beams are left out of
+// the beam lists. With the beam mapping of the options, the groups of the
+// bunches and nodes are those of their beams, and every coherent beam must
+// have one.
+func PipelineConfigs(p *Packing, opts PipelineOptions) ([]NodeConfig, error) {
+	base, err := netip.ParseAddr(opts.MulticastBase)
+	if err != nil || !base.Is4() {
+		return nil, fmt.Errorf("Invalid multicast base address: %s", opts.MulticastBase)
+	}
+
+	var configs []NodeConfig
+	bynode := make(map[string]int)
+
+	for _, b := range p.Bunches {
+		bc := BunchConfig{ID: b.ID}
+
+		if opts.Groups == nil {
+			addr, err := get_multicast(base, b.ID, opts.Port)
+			if err != nil {
+				return nil, err
+			}
+
+			bc.Multicast = addr
+			bc.Groups = []string{addr}
+		}
+
+		for _, beam := range b.Beams {
+			if beam.Dummy {
+				continue
+			}
+
+			bc.Beams = append(bc.Beams, beam.key())
+			bc.BeamIDs = append(bc.BeamIDs, beam.Nr)
+
+			if opts.Groups == nil {
+				continue
+			}
+
+			if group, ok := opts.Groups[beam.Nr]; ok {
+				bc.Groups = append(bc.Groups, group)
+			} else if !beam.Incoherent {
+				return nil, fmt.Errorf("No multicast group for beam: %s", beam.key())
+			}
+		}
+
+		bc.Groups = sort_multicast(bc.Groups)
+
+		i, ok := bynode[b.Node]
+		if !ok || b.Node == "" {
+			i = len(configs)
+			configs = append(configs, NodeConfig{Node: b.Node})
+
+			if b.Node != "" {
+				bynode[b.Node] = i
+			}
+		}
+
+		configs[i].Bunches = append(configs[i].Bunches, bc)
+		configs[i].Groups = append(configs[i].Groups, bc.Groups...)
+	}
+
+	for i := range configs {
+		configs[i].Groups = sort_multicast(configs[i].Groups)
+	}
+
+	sort.SliceStable(configs, func(i, j int) bool {
+		return configs[i].Node < configs[j].Node
+	})
+
+	return configs, nil
+}
+
+// Join integers as YAML flow sequence.
+func join_ints(values []int) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprint(v)
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// WritePipelineConfig writes the search pipeline configuration of a node as
+// YAML.
+func WritePipelineConfig(w io.Writer, cfg NodeConfig) error {
+	fmt.Fprintf(w, "# single-pulse search configuration\n")
+	fmt.Fprintf(w, "node: %q\n", cfg.Node)
+	fmt.Fprintf(w, "nbeams: %d\n", count_beams(cfg))
+	fmt.Fprintf(w, "multicast_groups: %s\n", join_strings(cfg.Groups))
+	fmt.Fprintf(w, "bunches:\n")
+
+	for _, b := range cfg.Bunches {
+		fmt.Fprintf(w, "  - id: %d\n", b.ID)
+		if b.Multicast != "" {
+			fmt.Fprintf(w, "    multicast: %q\n", b.Multicast)
+		} else {
+			fmt.Fprintf(w, "    multicast_groups: %s\n", join_strings(b.Groups))
+		}
+		fmt.Fprintf(w, "    beams: %s\n", join_strings(b.Beams))
+		if _, err := fmt.Fprintf(w, "    beam_ids: %s\n", join_ints(b.BeamIDs)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Join strings as YAML flow sequence of quoted strings.
+func join_strings(values []string) string {
+	parts := make([]string, len(values))
+	for i, v := range values {
+		parts[i] = fmt.Sprintf("%q", v)
+	}
+
+	return "[" + strings.Join(parts, ", ") + "]"
+}
+
+// Count the beams of a node configuration.
+func count_beams(cfg NodeConfig) int {
+	var n int
+	for _, b := range cfg.Bunches {
+		n += len(b.Beams)
+	}
+
+	return n
+}
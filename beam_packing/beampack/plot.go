@@ -0,0 +1,223 @@
+package beampack
+
+import (
+	"fmt"
+	"html"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// The matplotlib default colour cycle.
+var plot_colors = []color.RGBA{
+	{0x1f, 0x77, 0xb4, 0xff},
+	{0xff, 0x7f, 0x0e, 0xff},
+	{0x2c, 0xa0, 0x2c, 0xff},
+	{0xd6, 0x27, 0x28, 0xff},
+	{0x94, 0x67, 0xbd, 0xff},
+	{0x8c, 0x56, 0x4b, 0xff},
+	{0xe3, 0x77, 0xc2, 0xff},
+	{0x7f, 0x7f, 0x7f, 0xff},
+	{0xbc, 0xbd, 0x22, 0xff},
+	{0x17, 0xbe, 0xcf, 0xff},
+}
+
+// PlotColor returns the colour of the bunch with the index in the plots,
+// as hex RGB string.
+func PlotColor(i int) string {
+	c := plot_colors[i%len(plot_colors)]
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
+// A plot_canvas maps beam coordinates to image pixels.
+type plot_canvas struct {
+	size   int
+	margin int
+	xmin   float64
+	ymin   float64
+	scale  float64
+}
+
+// Set up the canvas so that all beams fit with equal aspect.
+func get_canvas(beams []Beam, size int) plot_canvas {
+	canvas := plot_canvas{size: size, margin: size / 20, scale: 1}
+
+	if len(beams) == 0 {
+		return canvas
+	}
+
+	xmin, xmax := beams[0].X, beams[0].X
+	ymin, ymax := beams[0].Y, beams[0].Y
+
+	for _, beam := range beams {
+		xmin = math.Min(xmin, beam.X)
+		xmax = math.Max(xmax, beam.X)
+		ymin = math.Min(ymin, beam.Y)
+		ymax = math.Max(ymax, beam.Y)
+	}
+
+	extent := math.Max(xmax-xmin, ymax-ymin)
+	if extent == 0 {
+		extent = 1
+	}
+
+	canvas.scale = float64(size-2*canvas.margin) / extent
+
+	// centre the tiling on the canvas
+	canvas.xmin = (xmin+xmax)/2 - extent/2
+	canvas.ymin = (ymin+ymax)/2 - extent/2
+
+	return canvas
+}
+
+// Convert beam coordinates to pixel coordinates. The y axis points up.
+func (c plot_canvas) to_pixel(x, y float64) (float64, float64) {
+	px := float64(c.margin) + (x-c.xmin)*c.scale
+	py := float64(c.size-c.margin) - (y-c.ymin)*c.scale
+
+	return px, py
+}
+
+// PlotFormats lists the output formats of Plot.
+var PlotFormats = []string{"svg", "png"}
+
+// Plot the beam positions coloured by bunch, with the bunch hulls outlined,
+// in one of the plot formats.
+func Plot(w io.Writer, p *Packing, format string) error {
+	const size = 800
+
+	beams, _ := flatten_packing(p)
+	canvas := get_canvas(beams, size)
+
+	switch format {
+	case "svg":
+		return plot_svg(w, p.Bunches, canvas)
+	case "png":
+		return plot_png(w, p.Bunches, canvas)
+	}
+
+	return fmt.Errorf("Unknown plot format: %s", format)
+}
+
+// PlotSVG renders the packing as SVG of the size in pixels, like Plot.
+func PlotSVG(w io.Writer, p *Packing, size int) error {
+	beams, _ := flatten_packing(p)
+	return plot_svg(w, p.Bunches, get_canvas(beams, size))
+}
+
+// Render the packing as SVG. The text and attribute values are escaped, as
+// the beam names come from the input files or the requests of the service.
+func plot_svg(w io.Writer, bunches []Bunch, canvas plot_canvas) error {
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		canvas.size, canvas.size, canvas.size, canvas.size)
+	fmt.Fprintf(w, "<rect width=\"100%%\" height=\"100%%\" fill=\"white\"/>\n")
+
+	for i, b := range bunches {
+		hex := html.EscapeString(PlotColor(i))
+
+		fmt.Fprintf(w, "<g id=\"bunch%d\">\n", b.ID)
+
+		hull := get_convex_hull(b.Beams)
+
+		if len(hull) > 1 {
+			var points []string
+			for _, v := range hull {
+				px, py := canvas.to_pixel(v[0], v[1])
+				points = append(points, fmt.Sprintf("%.2f,%.2f", px, py))
+			}
+
+			fmt.Fprintf(w, "<polygon points=\"%s\" fill=\"%s\" fill-opacity=\"0.2\" stroke=\"%s\" stroke-width=\"1\"/>\n",
+				strings.Join(points, " "), hex, hex)
+		}
+
+		for _, beam := range b.Beams {
+			px, py := canvas.to_pixel(beam.X, beam.Y)
+
+			fmt.Fprintf(w, "<path d=\"M%.2f %.2fL%.2f %.2fM%.2f %.2fL%.2f %.2f\" stroke=\"%s\" stroke-width=\"1.5\"><title>%s: %d</title></path>\n",
+				px-3, py-3, px+3, py+3, px-3, py+3, px+3, py-3, hex, html.EscapeString(beam.Name), b.ID)
+		}
+
+		fmt.Fprintf(w, "</g>\n")
+	}
+
+	_, err := fmt.Fprintf(w, "</svg>\n")
+
+	return err
+}
+
+// Draw a line between two pixels using Bresenham's algorithm.
+func draw_line(img *image.RGBA, x0, y0, x1, y1 int, c color.RGBA) {
+	dx := x1 - x0
+	if dx < 0 {
+		dx = -dx
+	}
+
+	dy := y1 - y0
+	if dy > 0 {
+		dy = -dy
+	}
+
+	sx, sy := 1, 1
+	if x0 > x1 {
+		sx = -1
+	}
+	if y0 > y1 {
+		sy = -1
+	}
+
+	e := dx + dy
+
+	for {
+		img.SetRGBA(x0, y0, c)
+
+		if x0 == x1 && y0 == y1 {
+			break
+		}
+
+		e2 := 2 * e
+
+		if e2 >= dy {
+			e += dy
+			x0 += sx
+		}
+		if e2 <= dx {
+			e += dx
+			y0 += sy
+		}
+	}
+}
+
+// Render the packing as PNG.
+func plot_png(w io.Writer, bunches []Bunch, canvas plot_canvas) error {
+	img := image.NewRGBA(image.Rect(0, 0, canvas.size, canvas.size))
+
+	for i := range img.Pix {
+		img.Pix[i] = 0xff
+	}
+
+	for i, b := range bunches {
+		c := plot_colors[i%len(plot_colors)]
+
+		hull := get_convex_hull(b.Beams)
+
+		for j := range hull {
+			k := (j + 1) % len(hull)
+			x0, y0 := canvas.to_pixel(hull[j][0], hull[j][1])
+			x1, y1 := canvas.to_pixel(hull[k][0], hull[k][1])
+			draw_line(img, int(x0), int(y0), int(x1), int(y1), c)
+		}
+
+		for _, beam := range b.Beams {
+			fx, fy := canvas.to_pixel(beam.X, beam.Y)
+			px, py := int(fx), int(fy)
+
+			draw_line(img, px-3, py-3, px+3, py+3, c)
+			draw_line(img, px-3, py+3, px+3, py-3, c)
+		}
+	}
+
+	return png.Encode(w, img)
+}
@@ -0,0 +1,54 @@
+package beampack
+
+import (
+	"bytes"
+	"encoding/xml"
+	"io"
+	"strings"
+	"testing"
+)
+
+// The beam names are escaped, so that the SVG is well-formed and cannot
+// carry markup from the input.
+func TestPlotSVGEscape(t *testing.T) {
+	beams := get_test_beams([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{0, 1})
+	beams[0].Name = `<script>alert("x")</script>`
+	beams[1].Name = `a&b"c'd`
+
+	var b bytes.Buffer
+	if err := PlotSVG(&b, &Packing{Bunches: []Bunch{{ID: 0, Beams: beams}}}, 100); err != nil {
+		t.Fatal(err)
+	}
+
+	svg := b.String()
+
+	if strings.Contains(svg, "<script") {
+		t.Fatalf("unescaped beam name: %s", svg)
+	}
+
+	var titles []string
+
+	d := xml.NewDecoder(strings.NewReader(svg))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("invalid SVG: %s", err)
+		}
+
+		if start, ok := tok.(xml.StartElement); ok && start.Name.Local == "title" {
+			var title string
+			if err := d.DecodeElement(&title, &start); err != nil {
+				t.Fatal(err)
+			}
+
+			titles = append(titles, title)
+		}
+	}
+
+	want := []string{beams[0].Name + ": 0", beams[1].Name + ": 0", ": 0"}
+	if strings.Join(titles, "\n") != strings.Join(want, "\n") {
+		t.Errorf("wrong titles: %q", titles)
+	}
+}
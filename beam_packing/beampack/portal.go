@@ -0,0 +1,164 @@
+package beampack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultBeamSensors matches the names of the FBFUSE coherent beam position
+// sensors, whose values are katpoint target strings.
+const DefaultBeamSensors = `fbfuse_\d+_fbfmc_array_\d+_coherent_beam_cfbf\d+`
+
+// PortalOptions configure the beam position queries to the MeerKAT portal.
+type PortalOptions struct {
+	// Base URL of the sensor query proxy of the portal, see FetchBeams.
+	URL string
+	// Access token, sent as bearer token if given.
+	Token string
+	// Regular expression that matches the beam position sensor names,
+	// defaults to DefaultBeamSensors.
+	Sensors string
+	// HTTP client, defaults to one with a timeout of 30 s.
+	Client *http.Client
+}
+
+// A sensor value returned by the portal.
+type portal_sensor struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+// The beam name at the end of a sensor name, e.g. cfbf00012.
+var sensor_beam = regexp.MustCompile(`[ci]fbf\d+$`)
+
+// FetchBeams queries the portal for the coherent beam positions of a
+// schedule block (kind sb) or capture block (kind cb). It requests the
+// values of the beam position sensors from the sensor query endpoint,
+//
+//	GET <url>/api/sensors?name=<regexp>&sb_id=<id>   (or cb_id=<id>)
+//
+// which returns a JSON list of objects with the sensor name and value, or an
+// object with that list under "data". The beams are named after the end of
+// the sensor names and ordered by name.
+//
+// The katportal web API has no such endpoint: its sensor values are only
+// available per sensor, from the websocket subscriptions and the katstore
+// history queries. The endpoint is served by a site-local proxy in front of
+// the portal, which looks up the sensors of the block, e.g. with
+// katportalclient, and returns their last values during the block. The
+// response format is that of testdata/portal_sensors.json.
+func FetchBeams(kind, id string, opts PortalOptions) ([]Beam, error) {
+	if opts.URL == "" {
+		return nil, fmt.Errorf("No portal URL given.")
+	}
+
+	var param string
+
+	switch kind {
+	case "sb":
+		param = "sb_id"
+	case "cb":
+		param = "cb_id"
+	default:
+		return nil, fmt.Errorf("Unknown block kind: %s", kind)
+	}
+
+	sensors := opts.Sensors
+	if sensors == "" {
+		sensors = DefaultBeamSensors
+	}
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 30 * time.Second}
+	}
+
+	query := url.Values{}
+	query.Set("name", sensors)
+	query.Set(param, id)
+
+	address := strings.TrimSuffix(opts.URL, "/") + "/api/sensors?" + query.Encode()
+
+	req, err := http.NewRequest(http.MethodGet, address, nil)
+	if err != nil {
+		return nil, fmt.Errorf("Could not create portal request: %s", err)
+	}
+
+	req.Header.Set("Accept", "application/json")
+	if opts.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+opts.Token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Could not query portal: %s", err)
+	}
+	defer resp.Body.Close()
+
+	raw, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read portal response: %s", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Portal query failed: %s, %s", resp.Status, strings.TrimSpace(string(raw)))
+	}
+
+	values, err := parse_portal(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse portal response: %s", err)
+	}
+
+	if len(values) == 0 {
+		return nil, fmt.Errorf("No beam positions found for %s %s", kind, id)
+	}
+
+	sort.Slice(values, func(i, j int) bool {
+		return values[i].Name < values[j].Name
+	})
+
+	list := make([]fbfuse_beam, 0, len(values))
+
+	for _, v := range values {
+		name := sensor_beam.FindString(v.Name)
+		if name == "" {
+			name = v.Name
+		}
+
+		item, err := parse_target(v.Value)
+		if err != nil {
+			return nil, fmt.Errorf("sensor %s: %s", v.Name, err)
+		}
+
+		item.Name = name
+		list = append(list, item)
+	}
+
+	return convert_fbfuse(list)
+}
+
+// Parse the sensor values of a portal response.
+func parse_portal(raw []byte) ([]portal_sensor, error) {
+	var values []portal_sensor
+
+	if err := json.Unmarshal(raw, &values); err == nil {
+		return values, nil
+	}
+
+	var wrapped struct {
+		Data []portal_sensor `json:"data"`
+	}
+
+	if err := json.Unmarshal(raw, &wrapped); err != nil {
+		return nil, err
+	}
+
+	return wrapped.Data, nil
+}
@@ -0,0 +1,99 @@
+package beampack
+
+import (
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+)
+
+// Serve the fixture of the sensor query proxy and check the query.
+func get_test_portal(t *testing.T, kind string) *httptest.Server {
+	t.Helper()
+
+	raw, err := os.ReadFile("testdata/portal_sensors.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+
+		switch {
+		case r.URL.Path != "/api/sensors":
+			http.NotFound(w, r)
+		case r.Header.Get("Authorization") != "Bearer secret":
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+		case q.Get("name") != DefaultBeamSensors || q.Get(kind+"_id") != "20261014-0001":
+			http.Error(w, "wrong query: "+r.URL.RawQuery, http.StatusBadRequest)
+		default:
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(raw)
+		}
+	}))
+}
+
+func TestFetchBeams(t *testing.T) {
+	for _, kind := range []string{"sb", "cb"} {
+		srv := get_test_portal(t, kind)
+		defer srv.Close()
+
+		beams, err := FetchBeams(kind, "20261014-0001", PortalOptions{URL: srv.URL + "/", Token: "secret"})
+		if err != nil {
+			t.Fatalf("%s: %s", kind, err)
+		}
+
+		if len(beams) != 3 {
+			t.Fatalf("%s: %d beams, want 3", kind, len(beams))
+		}
+
+		for i, name := range []string{"cfbf00000", "cfbf00001", "cfbf00002"} {
+			if beams[i].Name != name {
+				t.Errorf("%s: beam %d is %s, want %s", kind, i, beams[i].Name, name)
+			}
+		}
+
+		if b := beams[0]; math.Abs(b.X-134.0696) > 1e-4 || b.Y != -30 {
+			t.Errorf("%s: wrong position: %g, %g", kind, b.X, b.Y)
+		}
+
+		if b := beams[2]; b.X != 134.0662 || b.Y != -29.98 {
+			t.Errorf("%s: wrong position: %g, %g", kind, b.X, b.Y)
+		}
+	}
+}
+
+func TestFetchBeamsErrors(t *testing.T) {
+	srv := get_test_portal(t, "sb")
+	defer srv.Close()
+
+	if _, err := FetchBeams("sb", "20261014-0001", PortalOptions{URL: srv.URL}); err == nil || !strings.Contains(err.Error(), "401") {
+		t.Errorf("no error without token: %v", err)
+	}
+
+	if _, err := FetchBeams("xb", "1", PortalOptions{URL: srv.URL}); err == nil {
+		t.Error("no error for an unknown block kind")
+	}
+
+	if _, err := FetchBeams("sb", "1", PortalOptions{}); err == nil {
+		t.Error("no error without portal URL")
+	}
+}
+
+func TestParsePortal(t *testing.T) {
+	for _, raw := range []string{
+		`[{"name": "a", "value": "radec, 1, 2"}]`,
+		`{"data": [{"name": "a", "value": "radec, 1, 2"}]}`,
+	} {
+		values, err := parse_portal([]byte(raw))
+		if err != nil || len(values) != 1 || values[0].Name != "a" || values[0].Value != "radec, 1, 2" {
+			t.Errorf("%s: wrong values: %v, %v", raw, values, err)
+		}
+	}
+
+	if _, err := parse_portal([]byte(`{"data": 1}`)); err == nil {
+		t.Error("no error for an invalid response")
+	}
+}
@@ -0,0 +1,280 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Equinox is the reference frame of equatorial coordinates: the mean
+// equator and equinox of a Julian epoch, e.g. J2000, the true equator and
+// equinox, which include the nutation, or the apparent place, which
+// additionally includes the annual aberration. The conversions follow the
+// IAU 1976 precession and the leading terms of the IAU 1980 nutation, which
+// are accurate to about an arcsecond within a few decades of J2000, well
+// below the width of a beam.
+type Equinox struct {
+	// The Julian epoch, e.g. 2000 for J2000.
+	Epoch float64
+	// Whether the frame is the true rather than the mean equator and
+	// equinox of the epoch.
+	True bool
+	// Whether the coordinates are apparent, i.e. include the annual
+	// aberration.
+	Apparent bool
+}
+
+// J2000 is the mean equator and equinox of J2000.0.
+var J2000 = Equinox{Epoch: 2000}
+
+func (e Equinox) String() string {
+	switch {
+	case e.Apparent:
+		return fmt.Sprintf("apparent J%.4f", e.Epoch)
+	case e.True:
+		return fmt.Sprintf("true J%.4f", e.Epoch)
+	}
+
+	return "J" + strconv.FormatFloat(e.Epoch, 'f', -1, 64)
+}
+
+// Get the number of days since J2000.0.
+func get_j2000_days(t time.Time) float64 {
+	return float64(t.UTC().UnixNano())/86400e9 - 10957.5
+}
+
+// JulianEpoch returns the Julian epoch of a time, e.g. 2024.33.
+func JulianEpoch(t time.Time) float64 {
+	return 2000 + get_j2000_days(t)/365.25
+}
+
+// ParseEquinox parses an equinox: J2000, a Julian epoch such as J2024.5 or
+// 2024.5, or mean, date or apparent for the mean, true or apparent equator
+// and equinox at the time of the observation.
+func ParseEquinox(text string, t time.Time) (Equinox, error) {
+	switch strings.ToLower(strings.TrimSpace(text)) {
+	case "", "j2000", "fk5":
+		return J2000, nil
+	case "mean":
+		return Equinox{Epoch: JulianEpoch(t)}, nil
+	case "date", "true":
+		return Equinox{Epoch: JulianEpoch(t), True: true}, nil
+	case "apparent":
+		return Equinox{Epoch: JulianEpoch(t), True: true, Apparent: true}, nil
+	}
+
+	epoch, err := strconv.ParseFloat(strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(text)), "J"), 64)
+	if err != nil || epoch < 1800 || epoch > 2200 {
+		return Equinox{}, fmt.Errorf("Invalid equinox: %s", text)
+	}
+
+	return Equinox{Epoch: epoch}, nil
+}
+
+// A rotation matrix.
+type rotation [3][3]float64
+
+// Get the rotation of the coordinate frame by the angle in radians about
+// the axis: 0 for x, 1 for y and 2 for z.
+func get_rotation(axis int, angle float64) rotation {
+	s, c := math.Sincos(angle)
+	i, j := (axis+1)%3, (axis+2)%3
+
+	var r rotation
+	r[axis][axis] = 1
+	r[i][i], r[i][j] = c, s
+	r[j][i], r[j][j] = -s, c
+
+	return r
+}
+
+func (a rotation) mul(b rotation) rotation {
+	var r rotation
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			for k := 0; k < 3; k++ {
+				r[i][j] += a[i][k] * b[k][j]
+			}
+		}
+	}
+
+	return r
+}
+
+func (a rotation) transpose() rotation {
+	var r rotation
+
+	for i := 0; i < 3; i++ {
+		for j := 0; j < 3; j++ {
+			r[i][j] = a[j][i]
+		}
+	}
+
+	return r
+}
+
+func (a rotation) apply(v [3]float64) [3]float64 {
+	var r [3]float64
+
+	for i := 0; i < 3; i++ {
+		r[i] = a[i][0]*v[0] + a[i][1]*v[1] + a[i][2]*v[2]
+	}
+
+	return r
+}
+
+// Get the Julian centuries since J2000.0 of a Julian epoch.
+func get_centuries(epoch float64) float64 {
+	return (epoch - 2000) / 100
+}
+
+// Get the mean obliquity of the ecliptic in radians.
+func get_obliquity(t float64) float64 {
+	const arcsec = math.Pi / 180 / 3600
+	return (84381.448 - 46.8150*t - 0.00059*t*t + 0.001813*t*t*t) * arcsec
+}
+
+// Get the IAU 1976 precession matrix from the mean equator and equinox of
+// J2000 to the ones of the epoch.
+func get_precession(epoch float64) rotation {
+	const arcsec = math.Pi / 180 / 3600
+
+	t := get_centuries(epoch)
+
+	zeta := (2306.2181 + (0.30188+0.017998*t)*t) * t * arcsec
+	z := (2306.2181 + (1.09468+0.018203*t)*t) * t * arcsec
+	theta := (2004.3109 - (0.42665+0.041833*t)*t) * t * arcsec
+
+	return get_rotation(2, -z).mul(get_rotation(1, theta)).mul(get_rotation(2, -zeta))
+}
+
+// Get the nutation matrix from the mean to the true equator and equinox of
+// the epoch, from the leading terms of the IAU 1980 nutation, which are
+// accurate to about half an arcsecond.
+func get_nutation(epoch float64) rotation {
+	const deg = math.Pi / 180
+	const arcsec = deg / 3600
+
+	t := get_centuries(epoch)
+
+	// the longitude of the ascending node of the Moon and the mean
+	// longitudes of the Sun and the Moon
+	omega := (125.04452 - 1934.136261*t) * deg
+	ls := (280.4665 + 36000.7698*t) * deg
+	lm := (218.3165 + 481267.8813*t) * deg
+
+	dpsi := (-17.20*math.Sin(omega) - 1.32*math.Sin(2*ls) - 0.23*math.Sin(2*lm) + 0.21*math.Sin(2*omega)) * arcsec
+	deps := (9.20*math.Cos(omega) + 0.57*math.Cos(2*ls) + 0.10*math.Cos(2*lm) - 0.09*math.Cos(2*omega)) * arcsec
+
+	eps := get_obliquity(t)
+
+	return get_rotation(0, -(eps + deps)).mul(get_rotation(2, -dpsi)).mul(get_rotation(0, eps))
+}
+
+// Get the annual aberration of a position in radians, in RA and Dec, from
+// the low-precision solar longitude, which is accurate to about 0.1
+// arcseconds.
+func get_aberration(ra, dec, epoch float64) (float64, float64) {
+	const deg = math.Pi / 180
+	const kappa = 20.49552 * deg / 3600
+
+	t := get_centuries(epoch)
+
+	l0 := 280.46646 + 36000.76983*t
+	m := (357.52911 + 35999.05029*t) * deg
+	c := (1.914602-0.004817*t)*math.Sin(m) + 0.019993*math.Sin(2*m) + 0.000289*math.Sin(3*m)
+
+	sun := (l0 + c) * deg
+	e := 0.016708634 - 0.000042037*t
+	peri := (102.93735 + 1.71946*t) * deg
+	eps := get_obliquity(t)
+
+	sinra, cosra := math.Sincos(ra)
+	sindec, cosdec := math.Sincos(dec)
+	sinsun, cossun := math.Sincos(sun)
+	sinperi, cosperi := math.Sincos(peri)
+	coseps, taneps := math.Cos(eps), math.Tan(eps)
+
+	dra := (-kappa*(cosra*cossun*coseps+sinra*sinsun) + e*kappa*(cosra*cosperi*coseps+sinra*sinperi)) / cosdec
+	ddec := -kappa*(cossun*coseps*(taneps*cosdec-sinra*sindec)+cosra*sindec*sinsun) +
+		e*kappa*(cosperi*coseps*(taneps*cosdec-sinra*sindec)+cosra*sindec*sinperi)
+
+	return dra, ddec
+}
+
+// Get the rotation from the mean equator and equinox of J2000 to the frame
+// of the equinox, without the aberration.
+func (e Equinox) from_j2000() rotation {
+	r := get_precession(e.Epoch)
+
+	if e.True || e.Apparent {
+		r = get_nutation(e.Epoch).mul(r)
+	}
+
+	return r
+}
+
+// Convert converts RA and Dec in degrees between the frames of two
+// equinoxes, e.g. from the apparent place at the time of an observation to
+// J2000.
+func Convert(ra, dec float64, from, to Equinox) (float64, float64) {
+	if from == to {
+		return ra, dec
+	}
+
+	const deg = math.Pi / 180
+
+	a, d := ra*deg, dec*deg
+
+	// the aberration is removed iteratively, as it is a function of the
+	// position without it
+	if from.Apparent {
+		a0, d0 := a, d
+
+		for i := 0; i < 3; i++ {
+			da, dd := get_aberration(a, d, from.Epoch)
+			a, d = a0-da, d0-dd
+		}
+	}
+
+	sina, cosa := math.Sincos(a)
+	sind, cosd := math.Sincos(d)
+	v := [3]float64{cosa * cosd, sina * cosd, sind}
+
+	r := to.from_j2000().mul(from.from_j2000().transpose())
+	v = r.apply(v)
+
+	a = math.Atan2(v[1], v[0])
+	d = math.Atan2(v[2], math.Hypot(v[0], v[1]))
+
+	if to.Apparent {
+		da, dd := get_aberration(a, d, to.Epoch)
+		a, d = a+da, d+dd
+	}
+
+	// the RA stays in the range of the input, so that tilings across RA
+	// zero remain contiguous
+	return ra + math.Remainder(a/deg-ra, 360), d / deg
+}
+
+// ConvertBeams converts the positions of the beams, in RA and Dec in
+// degrees, between the frames of two equinoxes. The dummy beams are left
+// as they are.
+func ConvertBeams(beams []Beam, from, to Equinox) {
+	for i, beam := range beams {
+		if !beam.Dummy {
+			beams[i].X, beams[i].Y = Convert(beam.X, beam.Y, from, to)
+		}
+	}
+}
+
+// ConvertSources converts the positions of the sources between the frames
+// of two equinoxes.
+func ConvertSources(sources []Source, from, to Equinox) {
+	for i, s := range sources {
+		sources[i].RA, sources[i].Dec = Convert(s.RA, s.Dec, from, to)
+	}
+}
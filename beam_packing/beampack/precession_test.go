@@ -0,0 +1,90 @@
+package beampack
+
+import (
+	"math"
+	"testing"
+	"time"
+)
+
+// The position of theta Persei from Meeus, Astronomical Algorithms,
+// examples 21.b and 23.a, without its proper motion.
+func TestConvert(t *testing.T) {
+	const arcsec = 1.0 / 3600
+
+	epoch := 2000 + (2462088.69-2451545)/365.25
+	ra, dec := 41.049942, 49.228467
+
+	cases := []struct {
+		name    string
+		to      Equinox
+		ra, dec float64
+		tol     float64
+	}{
+		{"mean", Equinox{Epoch: epoch}, (2 + 46/60.0 + 10.343/3600) * 15, 49 + 20/60.0 + 57.12/3600, 0.1 * arcsec},
+		{"apparent", Equinox{Epoch: epoch, True: true, Apparent: true}, (2 + 46/60.0 + 13.402/3600) * 15, 49 + 21/60.0 + 10.03/3600, 0.5 * arcsec},
+	}
+
+	for _, c := range cases {
+		a, d := Convert(ra, dec, J2000, c.to)
+		if math.Abs(a-c.ra)*math.Cos(dec*math.Pi/180) > c.tol || math.Abs(d-c.dec) > c.tol {
+			t.Errorf("%s: wrong position: %.6f, %.6f", c.name, a, d)
+		}
+
+		a, d = Convert(a, d, c.to, J2000)
+		if math.Abs(a-ra) > 1e-9 || math.Abs(d-dec) > 1e-9 {
+			t.Errorf("%s: wrong position converted back: %.9f, %.9f", c.name, a, d)
+		}
+	}
+}
+
+// The RA stays in the range of the input, and the dummy beams are not
+// moved.
+func TestConvertBeams(t *testing.T) {
+	beams := []Beam{{X: 359.999, Y: -30}, {X: 0.001, Y: -30}, {X: 359.999, Y: -30, Dummy: true}}
+
+	ConvertBeams(beams, J2000, Equinox{Epoch: 2025})
+
+	if math.Abs(beams[0].X-beams[1].X-359.998) > 1e-4 || beams[1].X < 0 {
+		t.Errorf("wrong RA: %g, %g", beams[0].X, beams[1].X)
+	}
+
+	if beams[0].X == 359.999 || beams[2].X != 359.999 || beams[2].Y != -30 {
+		t.Errorf("wrong beams: %+v", beams)
+	}
+}
+
+func TestParseEquinox(t *testing.T) {
+	obs := time.Date(2024, 7, 2, 12, 0, 0, 0, time.UTC)
+	now := JulianEpoch(obs)
+
+	if math.Abs(now-2024.5) > 0.01 {
+		t.Fatalf("wrong Julian epoch: %g", now)
+	}
+
+	cases := []struct {
+		text string
+		want Equinox
+	}{
+		{"", J2000},
+		{"J2000", J2000},
+		{"fk5", J2000},
+		{"J2024.5", Equinox{Epoch: 2024.5}},
+		{"1950", Equinox{Epoch: 1950}},
+		{"mean", Equinox{Epoch: now}},
+		{"date", Equinox{Epoch: now, True: true}},
+		{"apparent", Equinox{Epoch: now, True: true, Apparent: true}},
+	}
+
+	for _, c := range cases {
+		e, err := ParseEquinox(c.text, obs)
+		if err != nil || e != c.want {
+			t.Errorf("%q: wrong equinox: %v, %v", c.text, e, err)
+		}
+	}
+
+	for _, text := range []string{"B1950", "J1700", "tomorrow"} {
+		if _, err := ParseEquinox(text, obs); err == nil {
+			t.Errorf("%q: no error", text)
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package beampack
+
+import (
+	"math"
+	"sort"
+)
+
+// PrimaryBeamModels lists the available models of the primary beam.
+var PrimaryBeamModels = []string{"gaussian", "cosine"}
+
+// The least sensitivity that scales the beam weights, so that beams in the
+// nulls of the primary beam keep a small, but non-zero weight.
+const min_sensitivity = 1e-3
+
+// PrimaryBeam models the attenuation of the primary beam of the dishes with
+// the offset from the pointing centre.
+type PrimaryBeam struct {
+	// The model: gaussian or cosine, the cosine-tapered aperture
+	// illumination that describes the MeerKAT primary beam well out to the
+	// first null (Mauch et al. 2020).
+	Model string
+	// Full width at half maximum in degrees.
+	FWHM float64
+	// The pointing centre.
+	Centre Tangent
+}
+
+// Response returns the power response of the primary beam at an offset in
+// degrees from the pointing centre, relative to the one on axis.
+func (pb PrimaryBeam) Response(offset float64) float64 {
+	x := offset / pb.FWHM
+
+	switch pb.Model {
+	case "cosine":
+		r := 1.18896 * x
+
+		// the removable singularity at the first sidelobe
+		d := 1 - 4*r*r
+		if math.Abs(d) < 1e-9 {
+			return math.Pi * math.Pi / 16
+		}
+
+		v := math.Cos(math.Pi*r) / d
+		return v * v
+	default:
+		return math.Exp(-4 * math.Ln2 * x * x)
+	}
+}
+
+// Sensitivity returns the response of the primary beam at a position in RA
+// and Dec in degrees.
+func (pb PrimaryBeam) Sensitivity(x, y float64) float64 {
+	return pb.Response(Angular(pb.Centre.RA, pb.Centre.Dec, x, y))
+}
+
+// SetSensitivity sets the relative sensitivity of the coherent beams from
+// the primary beam response at their positions. The incoherent and dummy
+// beams are left as they are.
+func SetSensitivity(beams []Beam, pb PrimaryBeam) {
+	for i, beam := range beams {
+		if !beam.Incoherent && !beam.Dummy {
+			beams[i].Sensitivity = pb.Sensitivity(beam.X, beam.Y)
+		}
+	}
+}
+
+// WeightBySensitivity scales the priority weights of the beams by their
+// sensitivity, so that the packing cost favours compact bunches of the
+// sensitive central beams over the ones of the edge beams.
+func WeightBySensitivity(beams []Beam) {
+	for i, beam := range beams {
+		if beam.Sensitivity == 0 {
+			continue
+		}
+
+		beams[i].Weight = beam.weight() * max(beam.Sensitivity, min_sensitivity)
+	}
+}
+
+// Get the relative sensitivity of the beam. Zero means unknown, which is
+// treated as full sensitivity.
+func (b Beam) sensitivity() float64 {
+	if b.Sensitivity == 0 {
+		return 1
+	}
+
+	return b.Sensitivity
+}
+
+// Get the mean sensitivity of the beams of a bunch, without the dummy
+// beams.
+func get_mean_sensitivity(b Bunch) float64 {
+	var sum float64
+	var n int
+
+	for _, beam := range b.Beams {
+		if !beam.Dummy {
+			sum += beam.sensitivity()
+			n++
+		}
+	}
+
+	if n == 0 {
+		return 0
+	}
+
+	return sum / float64(n)
+}
+
+// AssignBySensitivity assigns the bunches to the processing nodes like
+// Assign, but in the order of decreasing mean sensitivity, so that the
+// bunches of the low-sensitivity edge beams share the last nodes of the
+// list.
+func AssignBySensitivity(p *Packing, nodes []Node) error {
+	order := make([]int, len(p.Bunches))
+	sensitivity := make([]float64, len(p.Bunches))
+
+	for i, b := range p.Bunches {
+		order[i] = i
+		sensitivity[i] = get_mean_sensitivity(b)
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return sensitivity[order[i]] > sensitivity[order[j]]
+	})
+
+	return assign(p, nodes, order)
+}
@@ -0,0 +1,124 @@
+package beampack
+
+import (
+	"time"
+)
+
+// Progress is the state of a running optimizer.
+type Progress struct {
+	// The current and the total number of iterations or generations.
+	Iteration int
+	Total     int
+	// The best cost or fitness found so far.
+	Best float64
+	// The current annealing temperature, zero for the genetic optimizer.
+	Temperature float64
+	Elapsed     time.Duration
+	// The estimated remaining runtime.
+	Remaining time.Duration
+}
+
+// Monitor reports the progress of the optimizers in regular intervals and
+// stops them after a maximum runtime, in which case the best packing found
+// so far is returned.
+type Monitor struct {
+	// Called with the progress every Interval, if given.
+	Progress func(Progress)
+	Interval time.Duration
+	// Maximum runtime of the optimizer, unlimited if zero.
+	MaxRuntime time.Duration
+	// Set when the optimizer was stopped at the maximum runtime.
+	Stopped bool
+	// Called with the state of the annealing optimizer at most every
+	// CheckpointInterval, when it stops at the maximum runtime and when it
+	// finishes, if given. The checkpoint is only valid during the call.
+	// Checkpoints do not change the results of the optimizer.
+	Checkpoint         func(*Checkpoint)
+	CheckpointInterval time.Duration
+	// The checkpoint of an interrupted run of the annealing optimizer to
+	// resume from, if given. It must have been checked against the packing.
+	Resume *Checkpoint
+
+	start      time.Time
+	last       time.Time
+	checkpoint time.Time
+}
+
+// Start the clock of the monitor.
+func (m *Monitor) begin() {
+	if m == nil {
+		return
+	}
+
+	m.start = time.Now()
+	m.last = m.start
+	m.checkpoint = m.start
+	m.Stopped = false
+}
+
+// Report the progress if due and check whether the optimizer has to stop.
+func (m *Monitor) update(iter, total int, best, temp float64) bool {
+	if m == nil || (m.Progress == nil && m.MaxRuntime <= 0) {
+		return false
+	}
+
+	now := time.Now()
+	elapsed := now.Sub(m.start)
+
+	if m.MaxRuntime > 0 && elapsed >= m.MaxRuntime {
+		m.Stopped = true
+		return true
+	}
+
+	if m.Progress != nil && m.Interval > 0 && now.Sub(m.last) >= m.Interval {
+		m.last = now
+
+		var remaining time.Duration
+		if iter > 0 {
+			remaining = time.Duration(float64(elapsed) * float64(total-iter) / float64(iter))
+		}
+
+		if m.MaxRuntime > 0 {
+			remaining = min(remaining, m.MaxRuntime-elapsed)
+		}
+
+		m.Progress(Progress{
+			Iteration:   iter,
+			Total:       total,
+			Best:        best,
+			Temperature: temp,
+			Elapsed:     elapsed,
+			Remaining:   remaining,
+		})
+	}
+
+	return false
+}
+
+// Get the checkpoint to resume from, if any.
+func (m *Monitor) resume() *Checkpoint {
+	if m == nil {
+		return nil
+	}
+
+	return m.Resume
+}
+
+// Check whether the optimizer state is checkpointed.
+func (m *Monitor) checkpointing() bool {
+	return m != nil && m.Checkpoint != nil
+}
+
+// Pass the checkpoint on if due or if forced.
+func (m *Monitor) save(c *Checkpoint, force bool) {
+	if !m.checkpointing() {
+		return
+	}
+
+	now := time.Now()
+
+	if force || now.Sub(m.checkpoint) >= m.CheckpointInterval {
+		m.checkpoint = now
+		m.Checkpoint(c)
+	}
+}
@@ -0,0 +1,156 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+)
+
+// Tangent is the tangent point of a gnomonic projection, in degrees.
+type Tangent struct {
+	RA  float64 `json:"ra"`
+	Dec float64 `json:"dec"`
+}
+
+// Projections lists the available projections.
+var Projections = []string{"none", "gnomonic"}
+
+// GetBoresight computes the mean direction of the beams on the sphere. The
+// beam positions are RA and Dec in degrees.
+func GetBoresight(beams []Beam) Tangent {
+	const deg = math.Pi / 180.0
+
+	var sx, sy, sz float64
+
+	for _, beam := range beams {
+		sra, cra := math.Sincos(beam.X * deg)
+		sdec, cdec := math.Sincos(beam.Y * deg)
+
+		sx += cdec * cra
+		sy += cdec * sra
+		sz += sdec
+	}
+
+	ra := math.Atan2(sy, sx) / deg
+	if ra < 0 {
+		ra += 360
+	}
+
+	dec := math.Atan2(sz, math.Hypot(sx, sy)) / deg
+
+	return Tangent{RA: ra, Dec: dec}
+}
+
+// Project a position onto the tangent plane about the tangent point using
+// the gnomonic projection. The standard coordinates are in degrees, where x
+// increases with RA. It returns false for positions that are 90 degrees or
+// more away from the tangent point.
+func (t Tangent) Project(ra, dec float64) (float64, float64, bool) {
+	const deg = math.Pi / 180.0
+
+	sdec0, cdec0 := math.Sincos(t.Dec * deg)
+	sdec, cdec := math.Sincos(dec * deg)
+	sdra, cdra := math.Sincos((ra - t.RA) * deg)
+
+	cosc := sdec0*sdec + cdec0*cdec*cdra
+	if cosc <= 0 {
+		return 0, 0, false
+	}
+
+	x := cdec * sdra / cosc
+	y := (cdec0*sdec - sdec0*cdec*cdra) / cosc
+
+	return x / deg, y / deg, true
+}
+
+// Deproject standard coordinates on the tangent plane to RA and Dec in
+// degrees.
+func (t Tangent) Deproject(x, y float64) (float64, float64) {
+	const deg = math.Pi / 180.0
+
+	x *= deg
+	y *= deg
+
+	sdec0, cdec0 := math.Sincos(t.Dec * deg)
+
+	ra := t.RA + math.Atan2(x, cdec0-y*sdec0)/deg
+	dec := math.Atan2(sdec0+y*cdec0, math.Hypot(x, cdec0-y*sdec0)) / deg
+
+	ra = math.Mod(ra, 360)
+	if ra < 0 {
+		ra += 360
+	}
+
+	return ra, dec
+}
+
+// Project the beams onto the tangent plane.
+func project_beams(beams []Beam, t Tangent) ([]Beam, error) {
+	result := make([]Beam, len(beams))
+
+	for i, beam := range beams {
+		x, y, ok := t.Project(beam.X, beam.Y)
+		if !ok {
+			return nil, fmt.Errorf("Beam too far from the tangent point: %s, %.6f %.6f", beam.Name, beam.X, beam.Y)
+		}
+
+		beam.X, beam.Y = x, y
+		result[i] = beam
+	}
+
+	return result, nil
+}
+
+// Restore the original positions of the projected beams in the groups. The
+// beams are identified by their numbers, which are unique.
+func restore_beams(groups [][]Beam, beams []Beam) {
+	original := make(map[int]Beam, len(beams))
+	for _, beam := range beams {
+		original[beam.Nr] = beam
+	}
+
+	for _, members := range groups {
+		for i, beam := range members {
+			members[i] = original[beam.Nr]
+		}
+	}
+}
+
+// Get the centre of the beams. For projected packings, the centroid is
+// computed on the tangent plane and deprojected.
+func (p *Packing) get_centre(beams []Beam) (float64, float64) {
+	if p.Tangent == nil {
+		return get_centroid(beams)
+	}
+
+	projected, err := project_beams(beams, *p.Tangent)
+	if err != nil {
+		return get_centroid(beams)
+	}
+
+	x, y := get_centroid(projected)
+
+	return p.Tangent.Deproject(x, y)
+}
+
+// Get the bounding circle of the beams. For projected packings, the centre
+// of the minimal enclosing circle is found on the tangent plane.
+func (p *Packing) get_bounding_circle(beams []Beam, dist DistanceFunc) Circle {
+	if p.Tangent == nil {
+		return get_bounding_circle(beams, dist)
+	}
+
+	projected, err := project_beams(beams, *p.Tangent)
+	if err != nil {
+		return get_bounding_circle(beams, dist)
+	}
+
+	c := get_enclosing_circle(projected)
+	c.X, c.Y = p.Tangent.Deproject(c.X, c.Y)
+	c.Radius = 0
+
+	for _, beam := range beams {
+		c.Radius = math.Max(c.Radius, dist(c.X, c.Y, beam.X, beam.Y))
+	}
+
+	return c
+}
@@ -0,0 +1,384 @@
+package beampack
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"time"
+)
+
+// A minimal implementation of the protocol buffers wire format for the
+// messages of proto/beampack.proto, which avoids a dependency on the
+// protobuf runtime. Unknown fields are skipped, as the protobuf runtime
+// does.
+
+// The wire types of the protocol buffers encoding.
+const (
+	pb_varint  = 0
+	pb_fixed64 = 1
+	pb_bytes   = 2
+	pb_fixed32 = 5
+)
+
+// A protocol buffers message being encoded. Fields with zero values are
+// left out, as for proto3 fields without explicit presence.
+type pb_message []byte
+
+func (m *pb_message) tag(num, wire int) {
+	*m = binary.AppendUvarint(*m, uint64(num)<<3|uint64(wire))
+}
+
+func (m *pb_message) uint(num int, v uint64) {
+	if v != 0 {
+		m.tag(num, pb_varint)
+		*m = binary.AppendUvarint(*m, v)
+	}
+}
+
+func (m *pb_message) int(num int, v int64) {
+	m.uint(num, uint64(v))
+}
+
+func (m *pb_message) bool(num int, v bool) {
+	if v {
+		m.uint(num, 1)
+	}
+}
+
+func (m *pb_message) double(num int, v float64) {
+	if v != 0 {
+		m.tag(num, pb_fixed64)
+		*m = binary.LittleEndian.AppendUint64(*m, math.Float64bits(v))
+	}
+}
+
+func (m *pb_message) bytes(num int, v []byte) {
+	m.tag(num, pb_bytes)
+	*m = binary.AppendUvarint(*m, uint64(len(v)))
+	*m = append(*m, v...)
+}
+
+func (m *pb_message) string(num int, v string) {
+	if v != "" {
+		m.bytes(num, []byte(v))
+	}
+}
+
+// A field of a decoded protocol buffers message, with the value of varint
+// and fixed fields or the data of length-delimited fields.
+type pb_field struct {
+	num   int
+	wire  int
+	value uint64
+	data  []byte
+}
+
+// Split a protocol buffers message into its fields.
+func pb_fields(data []byte) ([]pb_field, error) {
+	var fields []pb_field
+
+	for len(data) > 0 {
+		key, n := binary.Uvarint(data)
+		if n <= 0 {
+			return nil, fmt.Errorf("invalid field key")
+		}
+
+		data = data[n:]
+		f := pb_field{num: int(key >> 3), wire: int(key & 7)}
+
+		switch f.wire {
+		case pb_varint:
+			if f.value, n = binary.Uvarint(data); n <= 0 {
+				return nil, fmt.Errorf("field %d: invalid varint", f.num)
+			}
+
+		case pb_fixed64:
+			if n = 8; len(data) < n {
+				return nil, fmt.Errorf("field %d: truncated", f.num)
+			}
+
+			f.value = binary.LittleEndian.Uint64(data)
+
+		case pb_fixed32:
+			if n = 4; len(data) < n {
+				return nil, fmt.Errorf("field %d: truncated", f.num)
+			}
+
+			f.value = uint64(binary.LittleEndian.Uint32(data))
+
+		case pb_bytes:
+			size, k := binary.Uvarint(data)
+			if k <= 0 || size > uint64(len(data)-k) {
+				return nil, fmt.Errorf("field %d: truncated", f.num)
+			}
+
+			f.data = data[k : k+int(size)]
+			n = k + int(size)
+
+		default:
+			return nil, fmt.Errorf("field %d: unsupported wire type: %d", f.num, f.wire)
+		}
+
+		if f.num == 0 {
+			return nil, fmt.Errorf("invalid field number: 0")
+		}
+
+		data = data[n:]
+		fields = append(fields, f)
+	}
+
+	return fields, nil
+}
+
+// Check the wire type of a field.
+func (f pb_field) expect(wire int) error {
+	if f.wire != wire {
+		return fmt.Errorf("field %d: unexpected wire type: %d", f.num, f.wire)
+	}
+
+	return nil
+}
+
+func (f pb_field) double() (float64, error) {
+	return math.Float64frombits(f.value), f.expect(pb_fixed64)
+}
+
+func (f pb_field) string() (string, error) {
+	return string(f.data), f.expect(pb_bytes)
+}
+
+// PackRequest is a packing request of the gRPC service. Settings that are
+// not given are nil.
+type PackRequest struct {
+	Beams      []Beam
+	Method     *string
+	Bunch      *int
+	NGroups    *int
+	NBeams     *int
+	Metric     *string
+	Optimize   *string
+	Iterations *int
+	Seed       *int64
+	// The groups of beams, by name or number, that must be packed into the
+	// same bunch.
+	Pinned [][]string
+}
+
+// Decode a beam message.
+func decode_pb_beam(data []byte, nr int) (Beam, error) {
+	beam := Beam{Nr: nr}
+
+	fields, err := pb_fields(data)
+	if err != nil {
+		return beam, err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			beam.Name, err = f.string()
+		case 2:
+			beam.X, err = f.double()
+		case 3:
+			beam.Y, err = f.double()
+		case 4:
+			beam.Incoherent, err = f.value != 0, f.expect(pb_varint)
+		}
+
+		if err != nil {
+			return beam, err
+		}
+	}
+
+	if beam.Name == "" {
+		beam.Name = BeamName(nr)
+	}
+
+	return beam, nil
+}
+
+// Decode the pinned groups of a constraints message.
+func decode_pb_constraints(data []byte) ([][]string, error) {
+	fields, err := pb_fields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var groups [][]string
+
+	for _, f := range fields {
+		if f.num != 1 {
+			continue
+		}
+
+		if err := f.expect(pb_bytes); err != nil {
+			return nil, err
+		}
+
+		members, err := pb_fields(f.data)
+		if err != nil {
+			return nil, err
+		}
+
+		var group []string
+
+		for _, m := range members {
+			if m.num == 1 {
+				name, err := m.string()
+				if err != nil {
+					return nil, err
+				}
+
+				group = append(group, name)
+			}
+		}
+
+		groups = append(groups, group)
+	}
+
+	return groups, nil
+}
+
+// DecodePackRequest decodes a PackRequest message of proto/beampack.proto.
+// Beams without name are named after their number.
+func DecodePackRequest(data []byte) (*PackRequest, error) {
+	fields, err := pb_fields(data)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &PackRequest{}
+
+	get_int := func(f pb_field) (*int, error) {
+		v := int(int32(f.value))
+		return &v, f.expect(pb_varint)
+	}
+
+	get_string := func(f pb_field) (*string, error) {
+		v, err := f.string()
+		return &v, err
+	}
+
+	for _, f := range fields {
+		switch f.num {
+		case 1:
+			if err = f.expect(pb_bytes); err == nil {
+				var beam Beam
+				if beam, err = decode_pb_beam(f.data, len(req.Beams)); err == nil {
+					req.Beams = append(req.Beams, beam)
+				}
+			}
+		case 2:
+			req.Method, err = get_string(f)
+		case 3:
+			req.Bunch, err = get_int(f)
+		case 4:
+			req.NGroups, err = get_int(f)
+		case 5:
+			req.NBeams, err = get_int(f)
+		case 6:
+			req.Metric, err = get_string(f)
+		case 7:
+			req.Optimize, err = get_string(f)
+		case 8:
+			req.Iterations, err = get_int(f)
+		case 9:
+			seed := int64(f.value)
+			req.Seed, err = &seed, f.expect(pb_varint)
+		case 10:
+			if err = f.expect(pb_bytes); err == nil {
+				var groups [][]string
+				if groups, err = decode_pb_constraints(f.data); err == nil {
+					req.Pinned = append(req.Pinned, groups...)
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return req, nil
+}
+
+// Encode a beam message.
+func encode_pb_beam(beam Beam) []byte {
+	var m pb_message
+
+	m.string(1, beam.key())
+	m.double(2, beam.X)
+	m.double(3, beam.Y)
+	m.bool(4, beam.Incoherent)
+	m.int(5, int64(beam.Nr))
+	m.bool(6, beam.Dummy)
+
+	return m
+}
+
+// EncodePacking encodes the packing as Packing message of
+// proto/beampack.proto, with the geometry and separations of the bunches
+// computed with the metric.
+func EncodePacking(p *Packing, dist DistanceFunc) []byte {
+	var m pb_message
+
+	m.string(1, p.Method)
+	m.int(2, p.Seed)
+
+	report := Score(p, dist)
+
+	for i, b := range p.Bunches {
+		var bm pb_message
+
+		bm.int(1, int64(b.ID))
+		bm.string(2, b.Node)
+
+		for _, beam := range b.Beams {
+			bm.bytes(3, encode_pb_beam(beam))
+		}
+
+		if i < len(report.Bunches) {
+			stats := report.Bunches[i]
+
+			var circle pb_message
+			circle.double(1, stats.Circle.X)
+			circle.double(2, stats.Circle.Y)
+			circle.double(3, stats.Circle.Radius)
+
+			bm.double(4, stats.CX)
+			bm.double(5, stats.CY)
+			bm.bytes(6, circle)
+			bm.double(7, stats.MaxSep)
+			bm.double(8, stats.MeanSep)
+		}
+
+		m.bytes(3, bm)
+	}
+
+	for _, beam := range p.Excluded {
+		m.bytes(4, []byte(beam.key()))
+	}
+
+	m.string(5, p.Remainder)
+
+	if prov := p.Provenance; prov != nil {
+		var pm pb_message
+
+		pm.string(1, prov.Version)
+		pm.string(2, prov.Created.Format(time.RFC3339Nano))
+
+		for _, in := range prov.Inputs {
+			var im pb_message
+			im.string(1, in.Name)
+			im.string(2, in.SHA256)
+
+			pm.bytes(3, im)
+		}
+
+		pm.string(4, prov.Parameters)
+
+		m.bytes(6, pm)
+	}
+
+	return m
+}
@@ -0,0 +1,137 @@
+package beampack
+
+import (
+	"bytes"
+	"math"
+	"testing"
+)
+
+// A PackRequest as encoded by the protobuf runtime, with a field of a newer
+// version of the message that is skipped.
+var test_pack_request = []byte{
+	// beams { name: "a" x: 1.0 y: -2.0 }
+	0x0a, 0x15,
+	0x0a, 0x01, 'a',
+	0x11, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xf0, 0x3f,
+	0x19, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0xc0,
+	// beams { incoherent: true }
+	0x0a, 0x02, 0x20, 0x01,
+	// method: "kmeans"
+	0x12, 0x06, 'k', 'm', 'e', 'a', 'n', 's',
+	// bunch: 6
+	0x18, 0x06,
+	// nbeams: -1, sign-extended to ten bytes
+	0x28, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01,
+	// seed: 300
+	0x48, 0xac, 0x02,
+	// an unknown fixed32 field 15
+	0x7d, 0x01, 0x02, 0x03, 0x04,
+	// constraints { pinned { beams: "a" beams: "1" } }
+	0x52, 0x08, 0x0a, 0x06, 0x0a, 0x01, 'a', 0x0a, 0x01, '1',
+}
+
+func TestDecodePackRequest(t *testing.T) {
+	req, err := DecodePackRequest(test_pack_request)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(req.Beams) != 2 {
+		t.Fatalf("%d beams, want 2", len(req.Beams))
+	}
+
+	if b := req.Beams[0]; b.Name != "a" || b.X != 1 || b.Y != -2 || b.Incoherent {
+		t.Errorf("wrong first beam: %+v", b)
+	}
+
+	if b := req.Beams[1]; b.Name != "cfbf00001" || b.Nr != 1 || !b.Incoherent {
+		t.Errorf("wrong second beam: %+v", b)
+	}
+
+	if req.Method == nil || *req.Method != "kmeans" || req.Bunch == nil || *req.Bunch != 6 {
+		t.Errorf("wrong settings: %v, %v", req.Method, req.Bunch)
+	}
+
+	if req.NBeams == nil || *req.NBeams != -1 || req.Seed == nil || *req.Seed != 300 {
+		t.Errorf("wrong settings: %v, %v", req.NBeams, req.Seed)
+	}
+
+	if req.NGroups != nil || req.Metric != nil {
+		t.Errorf("settings that were not given are set: %v, %v", req.NGroups, req.Metric)
+	}
+
+	if len(req.Pinned) != 1 || len(req.Pinned[0]) != 2 || req.Pinned[0][1] != "1" {
+		t.Errorf("wrong pinned groups: %v", req.Pinned)
+	}
+}
+
+func TestDecodePackRequestInvalid(t *testing.T) {
+	cases := map[string][]byte{
+		"truncated":  test_pack_request[:len(test_pack_request)-1],
+		"wire type":  {0x12, 0x06},
+		"bad string": {0x10, 0x01},
+		"field zero": {0x00, 0x01},
+		"group":      {0x0b},
+	}
+
+	for name, data := range cases {
+		if _, err := DecodePackRequest(data); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestEncodePacking(t *testing.T) {
+	p := &Packing{
+		Method: "greedy",
+		Seed:   -1,
+		Bunches: []Bunch{
+			{ID: 0, Node: "n1", Beams: []Beam{{Nr: 0, Name: "a", X: 1, Y: 2}, {Nr: 1, Name: "b", X: 3, Y: 2}}},
+		},
+	}
+
+	data := EncodePacking(p, Euclidean)
+
+	// the encoding starts with method and seed, the seed sign-extended
+	want := []byte{0x0a, 0x06, 'g', 'r', 'e', 'e', 'd', 'y', 0x10, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0x01}
+	if !bytes.HasPrefix(data, want) {
+		t.Fatalf("wrong encoding: %x", data)
+	}
+
+	fields, err := pb_fields(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(fields) != 3 || fields[2].num != 3 {
+		t.Fatalf("wrong fields: %+v", fields)
+	}
+
+	bunch, err := pb_fields(fields[2].data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var nbeams int
+	var cx, maxsep float64
+
+	for _, f := range bunch {
+		switch f.num {
+		case 3:
+			beam, err := decode_pb_beam(f.data, nbeams)
+			if err != nil || beam.Name != []string{"a", "b"}[nbeams] {
+				t.Errorf("wrong beam: %+v, %v", beam, err)
+			}
+
+			nbeams++
+		case 4:
+			cx, _ = f.double()
+		case 7:
+			maxsep, _ = f.double()
+		}
+	}
+
+	if nbeams != 2 || cx != 2 || math.Abs(maxsep-2) > 1e-12 {
+		t.Errorf("wrong bunch: %d beams, centroid %g, max separation %g", nbeams, cx, maxsep)
+	}
+}
@@ -0,0 +1,69 @@
+package beampack
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// InputFile is an input of a packing and its SHA-256 hash, if known.
+type InputFile struct {
+	Name   string `json:"name"`
+	SHA256 string `json:"sha256,omitempty"`
+}
+
+// Provenance records how a packing was produced, so that the assignment of
+// the beams to the nodes can be reproduced for archival candidates. The
+// method and seed are part of the packing itself.
+type Provenance struct {
+	Version string      `json:"version"`
+	Created time.Time   `json:"created"`
+	Inputs  []InputFile `json:"inputs,omitempty"`
+	// The packing parameters as name=value pairs.
+	Parameters string `json:"parameters,omitempty"`
+}
+
+// HashFile computes the hex SHA-256 hash of a file as stored, i.e. of
+// compressed files before decompression.
+func HashFile(filename string) (string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// HashData computes the hex SHA-256 hash of input data.
+func HashData(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Write the provenance as comment lines.
+func write_provenance(w io.Writer, prov *Provenance) {
+	fmt.Fprintf(w, "# version: %s\n", prov.Version)
+	fmt.Fprintf(w, "# created: %s\n", prov.Created.UTC().Format(time.RFC3339))
+
+	for _, in := range prov.Inputs {
+		if in.SHA256 != "" {
+			fmt.Fprintf(w, "# input: %s, sha256: %s\n", in.Name, in.SHA256)
+		} else {
+			fmt.Fprintf(w, "# input: %s\n", in.Name)
+		}
+	}
+
+	if prov.Parameters != "" {
+		fmt.Fprintf(w, "# parameters: %s\n", strings.ReplaceAll(prov.Parameters, "\n", " "))
+	}
+}
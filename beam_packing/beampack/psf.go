@@ -0,0 +1,92 @@
+package beampack
+
+import (
+	"fmt"
+	"math"
+)
+
+// PSF is the estimated shape of the coherent beam: the semi-major and
+// semi-minor axes at half power in degrees and the position angle of the
+// major axis in degrees, measured from north through east.
+type PSF struct {
+	SemiMajor float64
+	SemiMinor float64
+	PA        float64
+}
+
+// EstimatePSF estimates the shape of the coherent beam of the antennas at
+// the site for a pointing at the declination in degrees, the hour angle in
+// hours and the observing frequency in MHz. The antenna offsets are
+// projected onto the plane of the sky, and the tied-array beam is modelled
+// as Gaussian with the curvature of its main lobe, whose covariance is the
+// inverse of the one of the projected offsets in wavelengths times
+// ln(2)/(4 pi^2). This follows the change of the beam with the array
+// configuration and hour angle without a full simulation of the beam.
+func EstimatePSF(ants []Antenna, site Site, dec, ha, freq float64) (PSF, error) {
+	if len(ants) < 2 {
+		return PSF{}, fmt.Errorf("At least two antennas are required: %d", len(ants))
+	}
+
+	if freq <= 0 {
+		return PSF{}, fmt.Errorf("The observing frequency must be positive: %g", freq)
+	}
+
+	const deg = math.Pi / 180.0
+
+	wavelength := speed_of_light / (freq * 1e6)
+
+	sinlat, coslat := math.Sincos(site.Latitude * deg)
+	sindec, cosdec := math.Sincos(dec * deg)
+	sinha, cosha := math.Sincos(ha * 15 * deg)
+
+	us := make([]float64, len(ants))
+	vs := make([]float64, len(ants))
+	var mu, mv float64
+
+	for i, ant := range ants {
+		// the equatorial components of the offset, with x towards the
+		// meridian at the equator, y towards the east and z towards the
+		// pole
+		x := -ant.North*sinlat + ant.Up*coslat
+		y := ant.East
+		z := ant.North*coslat + ant.Up*sinlat
+
+		us[i] = (sinha*x + cosha*y) / wavelength
+		vs[i] = (-sindec*cosha*x + sindec*sinha*y + cosdec*z) / wavelength
+
+		mu += us[i]
+		mv += vs[i]
+	}
+
+	n := float64(len(ants))
+	mu /= n
+	mv /= n
+
+	var cuu, cuv, cvv float64
+
+	for i := range us {
+		du, dv := us[i]-mu, vs[i]-mv
+
+		cuu += du * du
+		cuv += du * dv
+		cvv += dv * dv
+	}
+
+	cuu /= n
+	cuv /= n
+	cvv /= n
+
+	// a line of antennas does not constrain the beam across it
+	det := cuu*cvv - cuv*cuv
+	if det <= 1e-9*(cuu+cvv)*(cuu+cvv) {
+		return PSF{}, fmt.Errorf("The projected array is degenerate.")
+	}
+
+	// the covariance of the beam in square degrees
+	scale := math.Ln2 / (4 * math.Pi * math.Pi) / det / (deg * deg)
+
+	var psf PSF
+	psf.SemiMajor, psf.SemiMinor, psf.PA = get_shape(scale*cvv, -scale*cuv, scale*cuu)
+
+	return psf, nil
+}
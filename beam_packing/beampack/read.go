@@ -0,0 +1,341 @@
+package beampack
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ReadPacking reads the beam records from a packing output file in any of
+// the output formats. The format is determined from the file extension:
+// .json, .csv or text otherwise. The positions of packings written in the
+// Galactic frame are converted back to equatorial coordinates.
+func ReadPacking(filename string) ([]Record, error) {
+	f, err := open_input(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	var records []Record
+	var frame string
+
+	switch strings.ToLower(filepath.Ext(strip_compression(filename))) {
+	case ".json":
+		records, frame, err = read_json_packing(f)
+	case ".csv":
+		records, frame, err = read_csv_packing(f)
+	case ".jsonl":
+		records, frame, err = read_jsonl_packing(f)
+	default:
+		records, frame, err = read_text_packing(f)
+	}
+
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	if err == nil {
+		err = convert_records(records, frame)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("Could not read packing: %s, %s", filename, err)
+	}
+
+	return records, nil
+}
+
+// Convert the positions of the records from the frame to equatorial
+// coordinates.
+func convert_records(records []Record, frame string) error {
+	convert, err := get_frame_conversion(frame, "equatorial")
+	if err != nil || convert == nil {
+		return err
+	}
+
+	lons := make([]*float64, len(records))
+
+	for i := range records {
+		records[i].X, records[i].Y = convert(records[i].X, records[i].Y)
+		lons[i] = &records[i].X
+	}
+
+	unwrap_longitudes(lons)
+
+	return nil
+}
+
+// Get the coordinate frame from a comment line of the output, if it holds
+// one.
+func get_comment_frame(line string, frame *string) {
+	if value, ok := strings.CutPrefix(line, "# frame: "); ok {
+		*frame = strings.TrimSpace(value)
+	}
+}
+
+func read_json_packing(r io.Reader) ([]Record, string, error) {
+	var output Output
+
+	if err := json.NewDecoder(r).Decode(&output); err != nil {
+		return nil, "", err
+	}
+
+	return output.Beams, output.Metadata.Frame, nil
+}
+
+// Read the records of a JSON Lines stream. The records of concatenated
+// streams must be in the same frame.
+func read_jsonl_packing(r io.Reader) ([]Record, string, error) {
+	var records []Record
+	var frame string
+
+	dec := json.NewDecoder(r)
+
+	for {
+		var rec StreamRecord
+
+		err := dec.Decode(&rec)
+		if err == io.EOF {
+			break
+		}
+
+		if err != nil {
+			return nil, "", err
+		}
+
+		if len(records) > 0 && rec.Frame != frame {
+			return nil, "", fmt.Errorf("Records in different frames: %q, %q", frame, rec.Frame)
+		}
+
+		records = append(records, rec.Record)
+		frame = rec.Frame
+	}
+
+	return records, frame, nil
+}
+
+// Skip the comment lines at the start of the output, and keep the
+// coordinate frame.
+type comment_reader struct {
+	scanner *bufio.Scanner
+	buffer  []byte
+	frame   string
+}
+
+func (c *comment_reader) Read(p []byte) (int, error) {
+	for len(c.buffer) == 0 {
+		if !c.scanner.Scan() {
+			if err := c.scanner.Err(); err != nil {
+				return 0, err
+			}
+
+			return 0, io.EOF
+		}
+
+		line := c.scanner.Text()
+		if strings.HasPrefix(line, "#") {
+			get_comment_frame(line, &c.frame)
+			continue
+		}
+
+		c.buffer = append([]byte(line), '\n')
+	}
+
+	n := copy(p, c.buffer)
+	c.buffer = c.buffer[n:]
+
+	return n, nil
+}
+
+func read_csv_packing(r io.Reader) ([]Record, string, error) {
+	comments := &comment_reader{scanner: bufio.NewScanner(r)}
+	reader := csv.NewReader(comments)
+	reader.FieldsPerRecord = -1
+
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if len(rows) == 0 {
+		return nil, comments.frame, nil
+	}
+
+	cols := make(map[string]int)
+	for i, name := range rows[0] {
+		cols[name] = i
+	}
+
+	for _, name := range []string{"beam", "name", "x", "y", "bunch", "rank"} {
+		if _, ok := cols[name]; !ok {
+			return nil, "", fmt.Errorf("Missing column: %s", name)
+		}
+	}
+
+	var records []Record
+
+	for nr, row := range rows[1:] {
+		get := func(name string) string {
+			i, ok := cols[name]
+			if !ok || i >= len(row) {
+				return ""
+			}
+
+			return row[i]
+		}
+
+		rec, err := parse_record(get)
+		if err != nil {
+			return nil, "", fmt.Errorf("row %d: %s", nr+1, err)
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, comments.frame, nil
+}
+
+func read_text_packing(r io.Reader) ([]Record, string, error) {
+	var records []Record
+	var frame string
+
+	scanner := bufio.NewScanner(r)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "#") {
+			get_comment_frame(line, &frame)
+			continue
+		}
+
+		if line == "" {
+			continue
+		}
+
+		// the lines consist of key: value pairs
+		fields := make(map[string]string)
+
+		for _, part := range strings.Split(line, ", ") {
+			key, value, ok := strings.Cut(part, ": ")
+			if !ok {
+				return nil, "", fmt.Errorf("line %d: invalid field: %s", nr, part)
+			}
+
+			fields[strings.ToLower(key)] = value
+		}
+
+		rec, err := parse_record(func(name string) string {
+			if name == "bunch" {
+				name = "group"
+			}
+
+			return fields[name]
+		})
+		if err != nil {
+			return nil, "", fmt.Errorf("line %d: %s", nr, err)
+		}
+
+		records = append(records, rec)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, "", err
+	}
+
+	return records, frame, nil
+}
+
+// Parse a record from its named fields.
+func parse_record(get func(name string) string) (Record, error) {
+	var rec Record
+	var err error
+
+	parse_int := func(name string) int {
+		var v int
+		if err == nil {
+			v, err = strconv.Atoi(get(name))
+		}
+
+		return v
+	}
+
+	parse_float := func(name string) float64 {
+		var v float64
+		if err == nil {
+			v, err = strconv.ParseFloat(get(name), 64)
+		}
+
+		return v
+	}
+
+	rec.Beam = parse_int("beam")
+	rec.Bunch = parse_int("bunch")
+	rec.Rank = parse_int("rank")
+	rec.X = parse_float("x")
+	rec.Y = parse_float("y")
+	rec.Name = get("name")
+	rec.Node = get("node")
+	rec.Pointing = get("pointing")
+
+	if get("sensitivity") != "" {
+		rec.Sensitivity = parse_float("sensitivity")
+	}
+
+	if rec.Name == "" {
+		rec.Name = BeamName(rec.Beam)
+	}
+
+	return rec, err
+}
+
+// FromRecords rebuilds a packing from its output records. The bunches are
+// ordered by ID and the beams by rank.
+func FromRecords(records []Record) *Packing {
+	byid := make(map[int]*Bunch)
+	var ids []int
+
+	sorted := make([]Record, len(records))
+	copy(sorted, records)
+
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Rank < sorted[j].Rank
+	})
+
+	for _, rec := range sorted {
+		b, ok := byid[rec.Bunch]
+		if !ok {
+			b = &Bunch{ID: rec.Bunch, Node: rec.Node}
+			byid[rec.Bunch] = b
+			ids = append(ids, rec.Bunch)
+		}
+
+		b.Beams = append(b.Beams, Beam{
+			Nr:          rec.Beam,
+			Name:        rec.Name,
+			X:           rec.X,
+			Y:           rec.Y,
+			Incoherent:  strings.HasPrefix(rec.Name, IncoherentPrefix),
+			Pointing:    rec.Pointing,
+			Sensitivity: rec.Sensitivity,
+			Dummy:       strings.HasPrefix(rec.Name, DummyPrefix),
+		})
+	}
+
+	sort.Ints(ids)
+
+	p := &Packing{}
+	for _, id := range ids {
+		p.Bunches = append(p.Bunches, *byid[id])
+	}
+
+	return p
+}
@@ -0,0 +1,195 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// Region is a masked sky region, either a circle or a polygon. Beams
+// within a region are dropped before packing.
+type Region struct {
+	// Shape of the region: circle or polygon.
+	Shape string
+	// Centre and radius of a circle. The radius is measured with the
+	// distance metric.
+	Circle Circle
+	// Vertices of a polygon in the input coordinates.
+	Vertices [][2]float64
+}
+
+// Masked is a beam that was dropped because it lies within a region.
+type Masked struct {
+	Beam   Beam
+	Region Region
+}
+
+func (r Region) String() string {
+	switch r.Shape {
+	case "circle":
+		return fmt.Sprintf("circle %.6f %.6f %.6f", r.Circle.X, r.Circle.Y, r.Circle.Radius)
+	default:
+		var parts []string
+		for _, v := range r.Vertices {
+			parts = append(parts, fmt.Sprintf("%.6f %.6f", v[0], v[1]))
+		}
+
+		return "polygon " + strings.Join(parts, " ")
+	}
+}
+
+// Contains checks whether the position lies within the region.
+func (r Region) Contains(x, y float64, dist DistanceFunc) bool {
+	switch r.Shape {
+	case "circle":
+		return dist(r.Circle.X, r.Circle.Y, x, y) <= r.Circle.Radius
+	case "polygon":
+		return in_polygon(r.Vertices, x, y)
+	}
+
+	return false
+}
+
+// Check whether the point lies within the polygon using the even-odd rule.
+func in_polygon(vertices [][2]float64, x, y float64) bool {
+	inside := false
+
+	for i, j := 0, len(vertices)-1; i < len(vertices); j, i = i, i+1 {
+		a, b := vertices[i], vertices[j]
+
+		if (a[1] > y) != (b[1] > y) && x < (b[0]-a[0])*(y-a[1])/(b[1]-a[1])+a[0] {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+// LoadRegions loads the masked sky regions from file. Every line holds one
+// region, either `circle X Y RADIUS` or `polygon X1 Y1 X2 Y2 X3 Y3 ...`.
+// The values can also be separated by commas and enclosed in parentheses as
+// in DS9 region files, e.g. circle(83.63,22.01,0.05). Empty lines, lines
+// starting with # and DS9 coordinate system lines are ignored.
+func LoadRegions(filename string) ([]Region, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	return read_regions(f, filename)
+}
+
+// Read the masked sky regions.
+func read_regions(r io.Reader, filename string) ([]Region, error) {
+	var regions []Region
+
+	scanner := bufio.NewScanner(r)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		// strip trailing comments such as DS9 region properties and the
+		// coordinate system prefix
+		line, _, _ = strings.Cut(line, "#")
+		if i := strings.LastIndex(line, ";"); i >= 0 {
+			line = line[i+1:]
+		}
+
+		line = strings.TrimSpace(line)
+
+		if line == "" {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ' ' || r == '\t' || r == ',' || r == '(' || r == ')'
+		})
+
+		shape := strings.ToLower(fields[0])
+
+		switch shape {
+		case "fk5", "icrs", "j2000", "image", "physical", "global":
+			continue
+		}
+
+		values := make([]float64, len(fields)-1)
+
+		for i, field := range fields[1:] {
+			v, err := strconv.ParseFloat(field, 64)
+			if err != nil {
+				return nil, fmt.Errorf("%s:%d: invalid value: %s", filename, nr, field)
+			}
+
+			values[i] = v
+		}
+
+		region := Region{Shape: shape}
+
+		switch shape {
+		case "circle":
+			if len(values) != 3 || values[2] < 0 {
+				return nil, fmt.Errorf("%s:%d: a circle needs a centre and a radius", filename, nr)
+			}
+
+			region.Circle = Circle{X: values[0], Y: values[1], Radius: values[2]}
+
+		case "polygon":
+			if len(values) < 6 || len(values)%2 != 0 {
+				return nil, fmt.Errorf("%s:%d: a polygon needs at least three vertices", filename, nr)
+			}
+
+			for i := 0; i < len(values); i += 2 {
+				region.Vertices = append(region.Vertices, [2]float64{values[i], values[i+1]})
+			}
+
+		default:
+			return nil, fmt.Errorf("%s:%d: unknown region shape: %s", filename, nr, fields[0])
+		}
+
+		regions = append(regions, region)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read regions: %s", err)
+	}
+
+	return regions, nil
+}
+
+// Mask drops the coherent beams that lie within any of the regions. It
+// returns the remaining beams and the dropped ones together with the first
+// region that contains them.
+func Mask(beams []Beam, regions []Region, dist DistanceFunc) ([]Beam, []Masked) {
+	if dist == nil {
+		dist = Euclidean
+	}
+
+	var kept []Beam
+	var masked []Masked
+
+	for _, beam := range beams {
+		dropped := false
+
+		if !beam.Incoherent {
+			for _, region := range regions {
+				if region.Contains(beam.X, beam.Y, dist) {
+					masked = append(masked, Masked{Beam: beam, Region: region})
+					dropped = true
+					break
+				}
+			}
+		}
+
+		if !dropped {
+			kept = append(kept, beam)
+		}
+	}
+
+	return kept, masked
+}
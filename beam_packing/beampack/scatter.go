@@ -0,0 +1,88 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// The symbols of the bunches in the text scatter plot, cycled by bunch ID.
+const scatter_symbols = "0123456789abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Scatter draws the coherent beam positions of the packing as text, width
+// characters wide. Every beam is shown by the symbol of its bunch, cycled
+// by bunch ID. With a highlighted bunch ID of zero or more, its beams are
+// shown as ● and all other beams as ·. The x axis increases to the right,
+// the y axis upwards, and the character cells are assumed to be twice as
+// high as wide.
+func Scatter(w io.Writer, p *Packing, width int, highlight int) error {
+	var beams []Beam
+	var ids []int
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+				ids = append(ids, b.ID)
+			}
+		}
+	}
+
+	if len(beams) == 0 || width < 2 {
+		return nil
+	}
+
+	xmin, xmax := beams[0].X, beams[0].X
+	ymin, ymax := beams[0].Y, beams[0].Y
+
+	for _, beam := range beams {
+		xmin = math.Min(xmin, beam.X)
+		xmax = math.Max(xmax, beam.X)
+		ymin = math.Min(ymin, beam.Y)
+		ymax = math.Max(ymax, beam.Y)
+	}
+
+	extent := math.Max(xmax-xmin, ymax-ymin)
+	if extent == 0 {
+		extent = 1
+	}
+
+	scale := float64(width-1) / extent
+	height := int((ymax-ymin)*scale/2) + 1
+
+	grid := make([][]rune, height)
+	for i := range grid {
+		grid[i] = []rune(strings.Repeat(" ", width))
+	}
+
+	for i, beam := range beams {
+		col := int(math.Round((beam.X - xmin) * scale))
+		row := height - 1 - int(math.Round((beam.Y-ymin)*scale/2))
+
+		col = min(max(col, 0), width-1)
+		row = min(max(row, 0), height-1)
+
+		var symbol rune
+		switch {
+		case highlight < 0:
+			symbol = rune(scatter_symbols[ids[i]%len(scatter_symbols)])
+		case ids[i] == highlight:
+			symbol = '●'
+		case grid[row][col] == '●':
+			continue
+		default:
+			symbol = '·'
+		}
+
+		grid[row][col] = symbol
+	}
+
+	for _, line := range grid {
+		if _, err := fmt.Fprintln(w, strings.TrimRight(string(line), " ")); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
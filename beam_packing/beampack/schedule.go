@@ -0,0 +1,417 @@
+package beampack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ScheduleBlock holds the observation metadata of a schedule block.
+type ScheduleBlock struct {
+	ID          string `json:"id"`
+	Proposal    string `json:"proposal,omitempty"`
+	Owner       string `json:"owner,omitempty"`
+	Description string `json:"description,omitempty"`
+	// The names of the targets, the first of which is the one the beams are
+	// formed on, and its position in degrees, if given.
+	Targets []string `json:"targets,omitempty"`
+	RA      *float64 `json:"ra,omitempty"`
+	Dec     *float64 `json:"dec,omitempty"`
+	Start   string   `json:"start,omitempty"`
+	// The duration of the observation in seconds.
+	Duration  float64  `json:"duration,omitempty"`
+	Antennas  []string `json:"antennas,omitempty"`
+	Band      string   `json:"band,omitempty"`
+	Frequency float64  `json:"frequency,omitempty"`
+}
+
+// The keys that the fields of the schedule block are looked up under, in
+// order, covering both the keys of the observation planning tool and the
+// short forms.
+var schedule_keys = map[string][]string{
+	"id":          {"id_code", "sb_id", "schedule_block", "id"},
+	"proposal":    {"proposal_id", "proposal"},
+	"owner":       {"owner", "pi"},
+	"description": {"description"},
+	"targets":     {"targets", "target"},
+	"start":       {"actual_start_time", "desired_start_time", "start_time", "start"},
+	"duration":    {"duration", "expected_duration_seconds", "duration_seconds"},
+	"antennas":    {"antennas", "antennas_alloc", "antenna_spec", "ants"},
+	"band":        {"band", "bands", "receiver_band"},
+	"frequency":   {"centre_frequency", "center_frequency", "frequency", "freq"},
+}
+
+// Get the raw value of a field of the schedule block, if given.
+func get_schedule_field(entries map[string]json.RawMessage, field string) (json.RawMessage, bool) {
+	for _, key := range schedule_keys[field] {
+		if raw, ok := entries[key]; ok && string(raw) != "null" {
+			return raw, true
+		}
+	}
+
+	return nil, false
+}
+
+// Decode a JSON string or number as text.
+func decode_text(raw json.RawMessage) (string, error) {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text, nil
+	}
+
+	var number json.Number
+	if err := json.Unmarshal(raw, &number); err != nil {
+		return "", fmt.Errorf("expected string or number: %s", raw)
+	}
+
+	return number.String(), nil
+}
+
+// Decode a JSON list of strings or a comma-separated string.
+func decode_list(raw json.RawMessage) ([]string, error) {
+	var list []string
+	if err := json.Unmarshal(raw, &list); err == nil {
+		return list, nil
+	}
+
+	text, err := decode_text(raw)
+	if err != nil {
+		return nil, err
+	}
+
+	var values []string
+	for _, field := range strings.Split(text, ",") {
+		if field = strings.TrimSpace(field); field != "" {
+			values = append(values, field)
+		}
+	}
+
+	return values, nil
+}
+
+// Decode a duration given as number of seconds, or as string, either
+// decimal seconds or a Go duration like 2h30m.
+func decode_duration(raw json.RawMessage) (float64, error) {
+	text, err := decode_text(raw)
+	if err != nil {
+		return 0, err
+	}
+
+	if value, err := strconv.ParseFloat(text, 64); err == nil {
+		return value, nil
+	}
+
+	d, err := time.ParseDuration(text)
+	if err != nil {
+		return 0, fmt.Errorf("invalid duration: %s", text)
+	}
+
+	return d.Seconds(), nil
+}
+
+// Decode the targets, given as katpoint target string, as object with name,
+// ra and dec, or as a list of either. The position of the first target is
+// returned if it has one.
+func decode_targets(raw json.RawMessage) ([]string, *fbfuse_beam, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(raw, &list); err != nil {
+		list = []json.RawMessage{raw}
+	}
+
+	var names []string
+	var first *fbfuse_beam
+
+	for i, entry := range list {
+		var item fbfuse_beam
+		var target string
+
+		if err := json.Unmarshal(entry, &target); err == nil {
+			item.Name = strings.TrimSpace(strings.Split(target, ",")[0])
+
+			if strings.Contains(target, "radec") {
+				pos, err := parse_target(target)
+				if err != nil {
+					return nil, nil, err
+				}
+
+				if item.Name == "radec" {
+					item.Name = ""
+				}
+
+				item.RA, item.Dec = pos.RA, pos.Dec
+			}
+		} else if err := json.Unmarshal(entry, &item); err != nil {
+			return nil, nil, fmt.Errorf("invalid target: %s", entry)
+		}
+
+		if item.Name == "" {
+			item.Name = fmt.Sprintf("target%d", i)
+		}
+
+		names = append(names, item.Name)
+
+		if i == 0 && len(item.RA) > 0 {
+			first = &item
+		}
+	}
+
+	return names, first, nil
+}
+
+// LoadScheduleBlock reads the observation metadata from a schedule block
+// JSON file, as exported from the observation planning tool: the ID,
+// proposal, targets, start time, duration, antennas and band. The object
+// may be nested under a "schedule_block" or "sb" key. The targets are
+// katpoint target strings or objects with name, ra and dec. Unknown keys
+// are ignored.
+func LoadScheduleBlock(filename string) (*ScheduleBlock, error) {
+	raw, err := read_input(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	sb, err := parse_schedule_block(raw)
+	if err != nil {
+		return nil, fmt.Errorf("Could not parse schedule block: %s, %s", filename, err)
+	}
+
+	return sb, nil
+}
+
+func parse_schedule_block(raw []byte) (*ScheduleBlock, error) {
+	var entries map[string]json.RawMessage
+
+	if err := json.Unmarshal(raw, &entries); err != nil {
+		return nil, err
+	}
+
+	for _, key := range []string{"schedule_block", "sb"} {
+		if nested, ok := entries[key]; ok && strings.HasPrefix(strings.TrimSpace(string(nested)), "{") {
+			return parse_schedule_block(nested)
+		}
+	}
+
+	sb := &ScheduleBlock{}
+
+	texts := map[string]*string{
+		"id":          &sb.ID,
+		"proposal":    &sb.Proposal,
+		"owner":       &sb.Owner,
+		"description": &sb.Description,
+		"start":       &sb.Start,
+	}
+
+	for field, value := range texts {
+		if raw, ok := get_schedule_field(entries, field); ok {
+			text, err := decode_text(raw)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", field, err)
+			}
+
+			*value = text
+		}
+	}
+
+	if raw, ok := get_schedule_field(entries, "targets"); ok {
+		names, first, err := decode_targets(raw)
+		if err != nil {
+			return nil, fmt.Errorf("targets: %s", err)
+		}
+
+		sb.Targets = names
+
+		if first != nil {
+			ra, err := parse_json_angle(first.RA, true)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: ra: %s", first.Name, err)
+			}
+
+			dec, err := parse_json_angle(first.Dec, false)
+			if err != nil {
+				return nil, fmt.Errorf("target %s: dec: %s", first.Name, err)
+			}
+
+			sb.RA, sb.Dec = &ra, &dec
+		}
+	}
+
+	if raw, ok := get_schedule_field(entries, "duration"); ok {
+		value, err := decode_duration(raw)
+		if err != nil {
+			return nil, fmt.Errorf("duration: %s", err)
+		}
+
+		sb.Duration = value
+	}
+
+	if raw, ok := get_schedule_field(entries, "antennas"); ok {
+		list, err := decode_list(raw)
+		if err != nil {
+			return nil, fmt.Errorf("antennas: %s", err)
+		}
+
+		sb.Antennas = list
+	}
+
+	if raw, ok := get_schedule_field(entries, "band"); ok {
+		list, err := decode_list(raw)
+		if err != nil {
+			return nil, fmt.Errorf("band: %s", err)
+		}
+
+		sb.Band = strings.Join(list, ",")
+	}
+
+	if raw, ok := get_schedule_field(entries, "frequency"); ok {
+		if err := json.Unmarshal(raw, &sb.Frequency); err != nil {
+			return nil, fmt.Errorf("frequency: expected number: %s", raw)
+		}
+	}
+
+	return sb, nil
+}
+
+// ObservationSummary is the consolidated record of an observation: the
+// schedule block metadata, the beam layout and the packing of the beams.
+type ObservationSummary struct {
+	ScheduleBlock *ScheduleBlock `json:"schedule_block"`
+	// The numbers of beams, of which incoherent, and the boresight of the
+	// coherent beams.
+	Beams      int     `json:"beams"`
+	Incoherent int     `json:"incoherent"`
+	Boresight  Tangent `json:"boresight"`
+	// The separation of the boresight from the target in degrees, if the
+	// target position is known.
+	TargetSep *float64 `json:"target_sep,omitempty"`
+	Method    string   `json:"method"`
+	Seed      int64    `json:"seed"`
+	Bunches   int      `json:"bunches"`
+	Nodes     int      `json:"nodes"`
+	Dummies   int      `json:"dummies"`
+	Excluded  int      `json:"excluded"`
+	Masked    int      `json:"masked"`
+	Flagged   int      `json:"flagged"`
+	MaxSep    float64  `json:"max_sep"`
+	MeanSep   float64  `json:"mean_sep"`
+	P95Sep    float64  `json:"p95_sep"`
+	// The version, inputs and parameters of the packing.
+	Provenance *Provenance `json:"provenance,omitempty"`
+}
+
+// Summarize consolidates the schedule block metadata and the packing of
+// the observation into one record. The beam positions must be equatorial.
+func Summarize(sb *ScheduleBlock, p *Packing, dist DistanceFunc) ObservationSummary {
+	s := ObservationSummary{
+		ScheduleBlock: sb,
+		Method:        p.Method,
+		Seed:          p.Seed,
+		Bunches:       len(p.Bunches),
+		Excluded:      len(p.Excluded),
+		Masked:        len(p.Masked),
+		Flagged:       len(p.Flagged),
+		Provenance:    p.Provenance,
+	}
+
+	var coherent []Beam
+	nodes := make(map[string]bool)
+
+	for _, b := range p.Bunches {
+		if b.Node != "" {
+			nodes[b.Node] = true
+		}
+
+		for _, beam := range b.Beams {
+			switch {
+			case beam.Dummy:
+				s.Dummies++
+			case beam.Incoherent:
+				s.Beams++
+				s.Incoherent++
+			default:
+				s.Beams++
+				coherent = append(coherent, beam)
+			}
+		}
+	}
+
+	s.Nodes = len(nodes)
+
+	if len(coherent) > 0 {
+		s.Boresight = GetBoresight(coherent)
+
+		if sb.RA != nil && sb.Dec != nil {
+			sep := Angular(*sb.RA, *sb.Dec, s.Boresight.RA, s.Boresight.Dec)
+			s.TargetSep = &sep
+		}
+	}
+
+	report := Score(p, dist)
+	s.MaxSep, s.MeanSep, s.P95Sep = report.MaxSep, report.MeanSep, report.P95Sep
+
+	return s
+}
+
+// WriteSummary writes the observation summary as text or JSON.
+func WriteSummary(w io.Writer, s ObservationSummary, format string) error {
+	switch format {
+	case "text":
+		sb := s.ScheduleBlock
+
+		fmt.Fprintf(w, "Schedule block: %s\n", sb.ID)
+		if sb.Proposal != "" {
+			fmt.Fprintf(w, "Proposal:       %s\n", sb.Proposal)
+		}
+		if sb.Owner != "" {
+			fmt.Fprintf(w, "Owner:          %s\n", sb.Owner)
+		}
+		if sb.Description != "" {
+			fmt.Fprintf(w, "Description:    %s\n", sb.Description)
+		}
+		fmt.Fprintf(w, "Targets:        %s\n", strings.Join(sb.Targets, ", "))
+		if sb.RA != nil && sb.Dec != nil {
+			fmt.Fprintf(w, "Target RA/Dec:  %.6f, %.6f\n", *sb.RA, *sb.Dec)
+		}
+		if sb.Start != "" {
+			fmt.Fprintf(w, "Start:          %s\n", sb.Start)
+		}
+		fmt.Fprintf(w, "Duration:       %.1f s\n", sb.Duration)
+		fmt.Fprintf(w, "Antennas:       %d (%s)\n", len(sb.Antennas), strings.Join(sb.Antennas, ","))
+		fmt.Fprintf(w, "Band:           %s\n", sb.Band)
+		if sb.Frequency != 0 {
+			fmt.Fprintf(w, "Frequency:      %g MHz\n", sb.Frequency)
+		}
+		fmt.Fprintf(w, "Beams:          %d (%d incoherent)\n", s.Beams, s.Incoherent)
+		fmt.Fprintf(w, "Boresight:      %.6f, %.6f\n", s.Boresight.RA, s.Boresight.Dec)
+		if s.TargetSep != nil {
+			fmt.Fprintf(w, "Target sep:     %.6f deg\n", *s.TargetSep)
+		}
+		fmt.Fprintf(w, "Method:         %s\n", s.Method)
+		fmt.Fprintf(w, "Seed:           %d\n", s.Seed)
+		fmt.Fprintf(w, "Bunches:        %d on %d nodes\n", s.Bunches, s.Nodes)
+		fmt.Fprintf(w, "Dropped:        %d excluded, %d masked, %d flagged, %d dummies\n", s.Excluded, s.Masked, s.Flagged, s.Dummies)
+
+		if prov := s.Provenance; prov != nil {
+			fmt.Fprintf(w, "Version:        %s\n", prov.Version)
+			fmt.Fprintf(w, "Created:        %s\n", prov.Created.Format(time.RFC3339))
+			for _, in := range prov.Inputs {
+				fmt.Fprintf(w, "Input:          %s %s\n", in.Name, in.SHA256)
+			}
+			fmt.Fprintf(w, "Parameters:     %s\n", prov.Parameters)
+		}
+
+		_, err := fmt.Fprintf(w, "Separations:    max %.6f, mean %.6f, p95 %.6f\n", s.MaxSep, s.MeanSep, s.P95Sep)
+		return err
+
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+
+		return enc.Encode(s)
+
+	default:
+		return fmt.Errorf("Unknown output format: %s", format)
+	}
+}
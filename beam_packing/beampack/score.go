@@ -0,0 +1,325 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"slices"
+	"sort"
+)
+
+// BunchStats holds the quality metrics of a single bunch.
+type BunchStats struct {
+	ID      int
+	Size    int
+	CX      float64
+	CY      float64
+	MaxSep  float64
+	MeanSep float64
+	Area    float64
+	// The minimal enclosing circle of the bunch.
+	Circle Circle
+}
+
+// Report summarises the quality of a packing.
+type Report struct {
+	Bunches []BunchStats
+	NBeams  int
+	MinSize int
+	MaxSize int
+	MaxSep  float64
+	MeanSep float64
+	// The 95th percentile of the intra-bunch separations of the coherent
+	// beams.
+	P95Sep   float64
+	MeanArea float64
+	StdArea  float64
+	TotDist  float64
+}
+
+// The relative tolerance of the turn test of the convex hull, the sine of
+// the smallest angle at which a point is still considered a corner.
+const hull_tolerance = 1e-10
+
+// Compute the convex hull of the beam positions using the monotone chain
+// algorithm. The hull vertices are returned in counter-clockwise order.
+// Points on the edges of the hull and duplicate points are left out, so
+// that collinear beams yield the two end points and a single position one
+// point. Near-collinear points whose turn is within the rounding of the
+// coordinates count as collinear.
+func get_convex_hull(beams []Beam) [][2]float64 {
+	points := make([][2]float64, len(beams))
+	for i, beam := range beams {
+		points[i] = [2]float64{beam.X, beam.Y}
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if points[i][0] == points[j][0] {
+			return points[i][1] < points[j][1]
+		}
+		return points[i][0] < points[j][0]
+	})
+
+	points = slices.Compact(points)
+
+	if len(points) < 3 {
+		return points
+	}
+
+	// whether o, a and b make a counter-clockwise turn, relative to the
+	// lengths of the two edges
+	turns_left := func(o, a, b [2]float64) bool {
+		ax, ay := a[0]-o[0], a[1]-o[1]
+		bx, by := b[0]-o[0], b[1]-o[1]
+
+		return ax*by-ay*bx > hull_tolerance*math.Hypot(ax, ay)*math.Hypot(bx, by)
+	}
+
+	hull := make([][2]float64, 0, 2*len(points))
+
+	// lower hull
+	for _, p := range points {
+		for len(hull) >= 2 && !turns_left(hull[len(hull)-2], hull[len(hull)-1], p) {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	// upper hull
+	lower := len(hull) + 1
+	for i := len(points) - 2; i >= 0; i-- {
+		p := points[i]
+		for len(hull) >= lower && !turns_left(hull[len(hull)-2], hull[len(hull)-1], p) {
+			hull = hull[:len(hull)-1]
+		}
+		hull = append(hull, p)
+	}
+
+	hull = hull[:len(hull)-1]
+
+	// all points are collinear, so that the upper hull retraces the lower
+	// one back to the first point
+	if len(hull) < 3 {
+		return [][2]float64{points[0], points[len(points)-1]}
+	}
+
+	return hull
+}
+
+// Compute the area of a polygon using the shoelace formula. Degenerate
+// polygons of fewer than three vertices have no area.
+func get_polygon_area(vertices [][2]float64) float64 {
+	if len(vertices) < 3 {
+		return 0
+	}
+
+	var area float64
+
+	// relative to the first vertex, so that the area of small polygons at
+	// large coordinates does not cancel out
+	x0, y0 := vertices[0][0], vertices[0][1]
+
+	for i := range vertices {
+		j := (i + 1) % len(vertices)
+		area += (vertices[i][0]-x0)*(vertices[j][1]-y0) - (vertices[j][0]-x0)*(vertices[i][1]-y0)
+	}
+
+	return math.Abs(area) / 2
+}
+
+// Circle is a circle on the sky, e.g. the minimal enclosing circle of a
+// bunch.
+type Circle struct {
+	X      float64 `json:"x"`
+	Y      float64 `json:"y"`
+	Radius float64 `json:"radius"`
+}
+
+// Get the circle through two points with their midpoint as centre.
+func get_circle2(a, b [2]float64) Circle {
+	x := (a[0] + b[0]) / 2
+	y := (a[1] + b[1]) / 2
+
+	return Circle{x, y, math.Hypot(a[0]-x, a[1]-y)}
+}
+
+// Get the circle through three points. Collinear points yield the circle
+// through the two points furthest apart.
+func get_circle3(a, b, c [2]float64) Circle {
+	bx, by := b[0]-a[0], b[1]-a[1]
+	cx, cy := c[0]-a[0], c[1]-a[1]
+
+	d := 2 * (bx*cy - by*cx)
+
+	if d == 0 {
+		best := get_circle2(a, b)
+		for _, alt := range []Circle{get_circle2(a, c), get_circle2(b, c)} {
+			if alt.Radius > best.Radius {
+				best = alt
+			}
+		}
+
+		return best
+	}
+
+	b2 := bx*bx + by*by
+	c2 := cx*cx + cy*cy
+
+	ux := (cy*b2 - by*c2) / d
+	uy := (bx*c2 - cx*b2) / d
+
+	return Circle{a[0] + ux, a[1] + uy, math.Hypot(ux, uy)}
+}
+
+// Check whether the point lies within the circle, allowing for rounding.
+func (c Circle) contains(p [2]float64) bool {
+	return math.Hypot(p[0]-c.X, p[1]-c.Y) <= c.Radius*(1+1e-12)+1e-15
+}
+
+// Compute the minimal enclosing circle of the beam positions in the plane of
+// the input coordinates, using the incremental algorithm by Welzl. The
+// points are processed in the order of the convex hull vertices, which are
+// the only points that can lie on the circle.
+func get_enclosing_circle(beams []Beam) Circle {
+	points := get_convex_hull(beams)
+
+	if len(points) == 0 {
+		return Circle{}
+	}
+
+	c := Circle{points[0][0], points[0][1], 0}
+
+	for i := 1; i < len(points); i++ {
+		if c.contains(points[i]) {
+			continue
+		}
+
+		c = Circle{points[i][0], points[i][1], 0}
+
+		for j := 0; j < i; j++ {
+			if c.contains(points[j]) {
+				continue
+			}
+
+			c = get_circle2(points[i], points[j])
+
+			for k := 0; k < j; k++ {
+				if !c.contains(points[k]) {
+					c = get_circle3(points[i], points[j], points[k])
+				}
+			}
+		}
+	}
+
+	return c
+}
+
+// Get the bounding circle of the beams. Its centre is that of the minimal
+// enclosing circle in the input coordinates and its radius the largest
+// distance of a beam from the centre, so that it covers all beams with
+// any metric.
+func get_bounding_circle(beams []Beam, dist DistanceFunc) Circle {
+	c := get_enclosing_circle(beams)
+	c.Radius = 0
+
+	for _, beam := range beams {
+		c.Radius = math.Max(c.Radius, dist(c.X, c.Y, beam.X, beam.Y))
+	}
+
+	return c
+}
+
+// Score evaluates the packing using various quality metrics.
+func Score(p *Packing, dist DistanceFunc) Report {
+	var report Report
+
+	report.NBeams = p.NBeams()
+
+	if len(p.Bunches) == 0 {
+		return report
+	}
+
+	report.MinSize = report.NBeams
+
+	var npairs int
+
+	for _, b := range p.Bunches {
+		members := b.Beams
+		stats := BunchStats{ID: b.ID, Size: len(members)}
+
+		if len(members) > 0 {
+			stats.CX, stats.CY = p.get_centre(members)
+		}
+
+		var sum float64
+		var pairs int
+
+		for i := range members {
+			for j := i + 1; j < len(members); j++ {
+				sep := dist(members[i].X, members[i].Y, members[j].X, members[j].Y)
+				stats.MaxSep = math.Max(stats.MaxSep, sep)
+				sum += sep
+				pairs++
+			}
+		}
+
+		if pairs > 0 {
+			stats.MeanSep = sum / float64(pairs)
+		}
+
+		stats.Area = get_polygon_area(get_convex_hull(members))
+		stats.Circle = p.get_bounding_circle(members, dist)
+
+		report.Bunches = append(report.Bunches, stats)
+
+		if stats.Size < report.MinSize {
+			report.MinSize = stats.Size
+		}
+		if stats.Size > report.MaxSize {
+			report.MaxSize = stats.Size
+		}
+
+		report.MaxSep = math.Max(report.MaxSep, stats.MaxSep)
+		report.TotDist += sum
+		report.MeanArea += stats.Area
+		npairs += pairs
+	}
+
+	if npairs > 0 {
+		report.MeanSep = report.TotDist / float64(npairs)
+	}
+
+	report.P95Sep = Percentile(Separations(p, dist), 95)
+
+	n := float64(len(report.Bunches))
+	report.MeanArea /= n
+
+	for _, stats := range report.Bunches {
+		report.StdArea += math.Pow(stats.Area-report.MeanArea, 2)
+	}
+
+	report.StdArea = math.Sqrt(report.StdArea / n)
+
+	return report
+}
+
+// WriteReport writes the packing quality report to w.
+func WriteReport(w io.Writer, report Report) error {
+	fmt.Fprintf(w, "# %5s %4s %12s %12s %12s %12s %12s %12s %12s %12s\n",
+		"bunch", "size", "cx", "cy", "maxsep", "meansep", "area", "circle_x", "circle_y", "radius")
+
+	for _, stats := range report.Bunches {
+		fmt.Fprintf(w, "  %5d %4d %12.6f %12.6f %12.6f %12.6f %12.4e %12.6f %12.6f %12.6f\n",
+			stats.ID, stats.Size, stats.CX, stats.CY, stats.MaxSep, stats.MeanSep, stats.Area,
+			stats.Circle.X, stats.Circle.Y, stats.Circle.Radius)
+	}
+
+	fmt.Fprintf(w, "\n")
+	fmt.Fprintf(w, "Beams: %d, bunches: %d, bunch size: %d - %d\n",
+		report.NBeams, len(report.Bunches), report.MinSize, report.MaxSize)
+	fmt.Fprintf(w, "Maximum separation: %.6f, mean separation: %.6f, 95th percentile: %.6f\n", report.MaxSep, report.MeanSep, report.P95Sep)
+	fmt.Fprintf(w, "Bunch area: %.4e +- %.4e\n", report.MeanArea, report.StdArea)
+	_, err := fmt.Fprintf(w, "Total intra-bunch distance: %.6f\n", report.TotDist)
+
+	return err
+}
@@ -0,0 +1,103 @@
+package beampack
+
+import (
+	"math"
+	"testing"
+)
+
+func get_test_beams(points ...[2]float64) []Beam {
+	beams := make([]Beam, len(points))
+	for i, p := range points {
+		beams[i] = Beam{Nr: i, X: p[0], Y: p[1]}
+	}
+
+	return beams
+}
+
+func TestConvexHull(t *testing.T) {
+	cases := []struct {
+		name   string
+		points [][2]float64
+		nhull  int
+		area   float64
+	}{
+		{"empty", nil, 0, 0},
+		{"single", [][2]float64{{1, 1}}, 1, 0},
+		{"duplicates", [][2]float64{{1, 1}, {1, 1}, {1, 1}}, 1, 0},
+		{"pair", [][2]float64{{1, 1}, {2, 3}, {1, 1}}, 2, 0},
+		{"collinear", [][2]float64{{0, 0}, {2, 2}, {1, 1}, {3, 3}, {1, 1}}, 2, 0},
+		{"square", [][2]float64{{0, 0}, {1, 0}, {1, 1}, {0, 1}, {0.5, 0.5}, {0.5, 0}, {0, 0}}, 4, 1},
+		{"triangle", [][2]float64{{0, 0}, {4, 0}, {0, 3}, {1, 1}}, 3, 6},
+	}
+
+	for _, c := range cases {
+		hull := get_convex_hull(get_test_beams(c.points...))
+
+		if len(hull) != c.nhull {
+			t.Errorf("%s: %d hull vertices, want %d: %v", c.name, len(hull), c.nhull, hull)
+		}
+
+		if area := get_polygon_area(hull); math.Abs(area-c.area) > 1e-12 {
+			t.Errorf("%s: area %g, want %g", c.name, area, c.area)
+		}
+	}
+}
+
+// A row of beams at RA and Dec in degrees, whose positions are collinear up
+// to their rounding, has no area.
+func TestConvexHullNearCollinear(t *testing.T) {
+	var points [][2]float64
+	for i := 0; i < 12; i++ {
+		points = append(points, [2]float64{134.0696 + 0.1*float64(i)/3, -30.0 + 0.07*float64(i)/3})
+	}
+
+	hull := get_convex_hull(get_test_beams(points...))
+
+	if len(hull) != 2 || hull[0] != points[0] || hull[1] != points[len(points)-1] {
+		t.Errorf("wrong hull: %v", hull)
+	}
+
+	if area := get_polygon_area(hull); area != 0 {
+		t.Errorf("area %g, want 0", area)
+	}
+
+	// the hull of a small bunch far from the origin keeps its area
+	beams := get_test_beams([2]float64{134.0696, -30}, [2]float64{134.0796, -30}, [2]float64{134.0796, -29.99}, [2]float64{134.0696, -29.99})
+
+	if area := get_polygon_area(get_convex_hull(beams)); math.Abs(area-1e-4)/1e-4 > 1e-9 {
+		t.Errorf("area %g, want 1e-4", area)
+	}
+}
+
+func TestEnclosingCircle(t *testing.T) {
+	c := get_enclosing_circle(get_test_beams([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{3, 0}))
+	if c.X != 1.5 || c.Y != 0 || c.Radius != 1.5 {
+		t.Errorf("wrong circle of collinear beams: %+v", c)
+	}
+
+	c = get_enclosing_circle(get_test_beams([2]float64{-1, 0}, [2]float64{1, 0}, [2]float64{0, 1}, [2]float64{0, 0.2}))
+	if math.Abs(c.X) > 1e-12 || math.Abs(c.Y) > 1e-12 || math.Abs(c.Radius-1) > 1e-12 {
+		t.Errorf("wrong circle: %+v", c)
+	}
+}
+
+func TestScore(t *testing.T) {
+	p := &Packing{Bunches: []Bunch{
+		{ID: 0, Beams: get_test_beams([2]float64{0, 0}, [2]float64{1, 0}, [2]float64{2, 0})},
+		{ID: 1, Beams: get_test_beams([2]float64{0, 5}, [2]float64{1, 5}, [2]float64{1, 6}, [2]float64{0, 6})},
+	}}
+
+	r := Score(p, Euclidean)
+
+	if r.NBeams != 7 || r.MinSize != 3 || r.MaxSize != 4 {
+		t.Errorf("wrong sizes: %+v", r)
+	}
+
+	if r.Bunches[0].Area != 0 || r.Bunches[1].Area != 1 {
+		t.Errorf("wrong areas: %g, %g", r.Bunches[0].Area, r.Bunches[1].Area)
+	}
+
+	if r.Bunches[0].MaxSep != 2 || math.Abs(r.MaxSep-2) > 1e-12 {
+		t.Errorf("wrong separations: %g, %g", r.Bunches[0].MaxSep, r.MaxSep)
+	}
+}
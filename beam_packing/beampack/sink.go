@@ -0,0 +1,313 @@
+package beampack
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// SinkSchemes lists the URI schemes of the output sinks. Outputs without
+// scheme are local files, and an empty name or - is stdout.
+var SinkSchemes = []string{"file", "s3"}
+
+// S3Options configure the uploads to an S3 compatible object store, e.g.
+// MinIO or AWS S3.
+type S3Options struct {
+	// The endpoint URL, e.g. http://minio.example.org:9000, defaults to the
+	// AWS S3 endpoint of the region. Buckets are addressed by path on the
+	// endpoint.
+	Endpoint string
+	// The region, defaults to us-east-1.
+	Region string
+	// The credentials. The session token is only needed for temporary
+	// credentials.
+	AccessKey    string
+	SecretKey    string
+	SessionToken string
+	// HTTP client, defaults to one with a timeout of 60 s.
+	Client *http.Client
+}
+
+// SinkOptions configure the output sinks.
+type SinkOptions struct {
+	S3 S3Options
+}
+
+// Split an output URI into its scheme and location. Names without a known
+// scheme are local files.
+func split_sink(uri string) (string, string) {
+	scheme, location, ok := strings.Cut(uri, "://")
+	if !ok {
+		return "", uri
+	}
+
+	return strings.ToLower(scheme), location
+}
+
+// LocalPath returns the local file name of an output URI and whether the
+// output is a local file.
+func LocalPath(uri string) (string, bool) {
+	switch scheme, location := split_sink(uri); scheme {
+	case "":
+		return location, uri != "" && uri != "-"
+	case "file":
+		return location, true
+	default:
+		return "", false
+	}
+}
+
+// JoinSink joins an output URI that is a directory or an object prefix with
+// a relative name.
+func JoinSink(base, name string) string {
+	switch scheme, location := split_sink(base); scheme {
+	case "", "file":
+		return filepath.Join(location, name)
+	default:
+		return scheme + "://" + path.Join(location, name)
+	}
+}
+
+// OpenSink opens the output given by the URI for writing: stdout for an
+// empty name or -, a local file for a name without scheme or with the file
+// scheme, e.g. file:///data/packing.json, or an object in an S3 compatible
+// object store for the s3 scheme, e.g. s3://bucket/path/packing.json. The
+// objects are uploaded when the sink is closed, so the error of Close must be
+// checked.
+func OpenSink(uri string, opts SinkOptions) (io.WriteCloser, error) {
+	if uri == "" || uri == "-" {
+		return os.Stdout, nil
+	}
+
+	scheme, location := split_sink(uri)
+
+	switch scheme {
+	case "", "file":
+		f, err := os.Create(location)
+		if err != nil {
+			return nil, fmt.Errorf("Could not create output file: %s, %s", location, err)
+		}
+
+		return f, nil
+
+	case "s3":
+		bucket, key, _ := strings.Cut(location, "/")
+		if bucket == "" || key == "" || strings.HasSuffix(key, "/") {
+			return nil, fmt.Errorf("Invalid object URI, expected s3://bucket/key: %s", uri)
+		}
+
+		if opts.S3.AccessKey == "" || opts.S3.SecretKey == "" {
+			return nil, fmt.Errorf("No object store credentials given: %s", uri)
+		}
+
+		return &s3_writer{bucket: bucket, key: key, opts: opts.S3}, nil
+
+	default:
+		return nil, fmt.Errorf("Unknown output scheme: %s", scheme)
+	}
+}
+
+// An object that is uploaded to the object store when it is closed.
+type s3_writer struct {
+	bucket string
+	key    string
+	opts   S3Options
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *s3_writer) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, fmt.Errorf("Write to closed object: s3://%s/%s", w.bucket, w.key)
+	}
+
+	return w.buf.Write(p)
+}
+
+func (w *s3_writer) Close() error {
+	if w.closed {
+		return nil
+	}
+
+	w.closed = true
+
+	return PutObject(w.bucket, w.key, w.buf.Bytes(), w.opts)
+}
+
+// Escape a string for the canonical request of the signature, where only
+// the unreserved characters are kept and, for paths, the slashes.
+func aws_escape(s string, path bool) string {
+	var b strings.Builder
+
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+
+		switch {
+		case 'A' <= c && c <= 'Z', 'a' <= c && c <= 'z', '0' <= c && c <= '9', c == '-', c == '.', c == '_', c == '~':
+			b.WriteByte(c)
+		case c == '/' && path:
+			b.WriteByte(c)
+		default:
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+
+	return b.String()
+}
+
+// Get the HMAC-SHA256 of the data with the key.
+func hmac_sha256(key []byte, data string) []byte {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(data))
+	return h.Sum(nil)
+}
+
+// Sign the request to the object store with AWS signature version 4. The
+// host, the x-amz headers and the other headers that are set are signed,
+// and the hash of the payload is sent with the request.
+func sign_s3(req *http.Request, payload string, opts S3Options, now time.Time) {
+	region := opts.Region
+	if region == "" {
+		region = "us-east-1"
+	}
+
+	now = now.UTC()
+	amzdate := now.Format("20060102T150405Z")
+	date := now.Format("20060102")
+
+	req.Header.Set("X-Amz-Date", amzdate)
+	req.Header.Set("X-Amz-Content-Sha256", payload)
+
+	if opts.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", opts.SessionToken)
+	}
+
+	// the canonical headers are lowercase, sorted and trimmed
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var canonical strings.Builder
+	for _, name := range names {
+		canonical.WriteString(name + ":" + headers[name] + "\n")
+	}
+
+	signed := strings.Join(names, ";")
+
+	// the query parameters are sorted by name
+	query := req.URL.Query()
+	params := make([]string, 0, len(query))
+
+	for name, values := range query {
+		for _, v := range values {
+			params = append(params, aws_escape(name, false)+"="+aws_escape(v, false))
+		}
+	}
+
+	sort.Strings(params)
+
+	uri := req.URL.EscapedPath()
+	if p, err := url.PathUnescape(uri); err == nil {
+		uri = aws_escape(p, true)
+	}
+
+	if uri == "" {
+		uri = "/"
+	}
+
+	request := strings.Join([]string{req.Method, uri, strings.Join(params, "&"), canonical.String(), signed, payload}, "\n")
+	hash := sha256.Sum256([]byte(request))
+
+	scope := date + "/" + region + "/s3/aws4_request"
+	tosign := "AWS4-HMAC-SHA256\n" + amzdate + "\n" + scope + "\n" + hex.EncodeToString(hash[:])
+
+	key := hmac_sha256([]byte("AWS4"+opts.SecretKey), date)
+	key = hmac_sha256(key, region)
+	key = hmac_sha256(key, "s3")
+	key = hmac_sha256(key, "aws4_request")
+
+	signature := hex.EncodeToString(hmac_sha256(key, tosign))
+
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		opts.AccessKey, scope, signed, signature))
+}
+
+// PutObject uploads the data as object with the key to the bucket of an S3
+// compatible object store,
+//
+//	PUT <endpoint>/<bucket>/<key>
+//
+// signed with AWS signature version 4. The content type is derived from the
+// extension of the key.
+func PutObject(bucket, key string, data []byte, opts S3Options) error {
+	endpoint := opts.Endpoint
+	if endpoint == "" {
+		region := opts.Region
+		if region == "" {
+			region = "us-east-1"
+		}
+
+		endpoint = "https://s3." + region + ".amazonaws.com"
+	}
+
+	u, err := url.Parse(strings.TrimSuffix(endpoint, "/"))
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("Invalid object store endpoint: %s", endpoint)
+	}
+
+	u.Path += "/" + bucket + "/" + key
+	u.RawPath = ""
+
+	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	ctype := mime.TypeByExtension(path.Ext(key))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+
+	req.Header.Set("Content-Type", ctype)
+
+	hash := sha256.Sum256(data)
+	sign_s3(req, hex.EncodeToString(hash[:]), opts, time.Now())
+
+	client := opts.Client
+	if client == nil {
+		client = &http.Client{Timeout: 60 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Could not upload object: s3://%s/%s, %s", bucket, key, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Could not upload object: s3://%s/%s, %s: %s", bucket, key, resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	return nil
+}
@@ -0,0 +1,206 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+)
+
+// SpacingOptions are the settings of the spacing diagnostics.
+type SpacingOptions struct {
+	// Beams closer than this are duplicates, defaults to 1e-6.
+	Tolerance float64
+	// Beams whose nearest neighbour is more than this many times the median
+	// nearest-neighbour separation away are outliers, defaults to 5.
+	OutlierFactor float64
+}
+
+// Duplicate is a pair of beams at the same position, or with the same name.
+type Duplicate struct {
+	A    Beam
+	B    Beam
+	Dist float64
+	// Whether the beams share their name rather than their position.
+	Name bool
+}
+
+// Outlier is a beam far from the rest of the tiling.
+type Outlier struct {
+	Beam Beam
+	// The separation from its nearest neighbour, and relative to the
+	// median nearest-neighbour separation.
+	Dist  float64
+	Ratio float64
+}
+
+// SpacingReport summarises the nearest-neighbour spacing of the coherent
+// beams of a tiling and lists its anomalies.
+type SpacingReport struct {
+	NBeams int
+	// The bounding box of the beam positions.
+	MinX, MaxX float64
+	MinY, MaxY float64
+	// The area of the convex hull of the beams, and the number of beams
+	// per unit area within it.
+	HullArea float64
+	Density  float64
+	// The nearest-neighbour separation of every beam in ascending order,
+	// and its statistics.
+	Separations []float64
+	MinSep      float64
+	MaxSep      float64
+	MeanSep     float64
+	MedianSep   float64
+	StdSep      float64
+	Duplicates  []Duplicate
+	Outliers    []Outlier
+}
+
+// Spacing computes the nearest-neighbour spacing diagnostics of the
+// coherent beams: the distribution of the nearest-neighbour separations,
+// the bounding box and the density of the tiling, and its anomalies, i.e.
+// beams at the same position or with the same name, which FBFUSE emits
+// occasionally, and isolated beams far from the rest of the tiling.
+func Spacing(beams []Beam, dist DistanceFunc, opts SpacingOptions) SpacingReport {
+	if opts.Tolerance <= 0 {
+		opts.Tolerance = 1e-6
+	}
+
+	if opts.OutlierFactor <= 0 {
+		opts.OutlierFactor = 5
+	}
+
+	var coherent []Beam
+	for _, beam := range beams {
+		if !beam.Incoherent && !beam.Dummy {
+			coherent = append(coherent, beam)
+		}
+	}
+
+	r := SpacingReport{NBeams: len(coherent)}
+
+	if len(coherent) == 0 {
+		return r
+	}
+
+	r.MinX, r.MaxX = coherent[0].X, coherent[0].X
+	r.MinY, r.MaxY = coherent[0].Y, coherent[0].Y
+
+	for _, beam := range coherent {
+		r.MinX, r.MaxX = math.Min(r.MinX, beam.X), math.Max(r.MaxX, beam.X)
+		r.MinY, r.MaxY = math.Min(r.MinY, beam.Y), math.Max(r.MaxY, beam.Y)
+	}
+
+	r.HullArea = get_polygon_area(get_convex_hull(coherent))
+	if r.HullArea > 0 {
+		r.Density = float64(len(coherent)) / r.HullArea
+	}
+
+	// the same name is a duplicate wherever the beams are
+	names := make(map[string]int)
+	for i, beam := range coherent {
+		if j, ok := names[beam.Name]; ok && beam.Name != "" {
+			a := coherent[j]
+			r.Duplicates = append(r.Duplicates, Duplicate{A: a, B: beam, Dist: dist(a.X, a.Y, beam.X, beam.Y), Name: true})
+			continue
+		}
+
+		names[beam.Name] = i
+	}
+
+	if len(coherent) < 2 {
+		return r
+	}
+
+	// several beams can share a position, so that more than the nearest
+	// neighbour is needed to find all duplicates
+	neighbours := get_neighbours(coherent, 8, dist)
+	nearest := make([]float64, len(coherent))
+
+	for i, beam := range coherent {
+		for k, j := range neighbours[i] {
+			d := dist(beam.X, beam.Y, coherent[j].X, coherent[j].Y)
+
+			if k == 0 {
+				nearest[i] = d
+			}
+
+			if d > opts.Tolerance {
+				break
+			}
+
+			if i < j {
+				r.Duplicates = append(r.Duplicates, Duplicate{A: beam, B: coherent[j], Dist: d})
+			}
+		}
+	}
+
+	r.Separations = append([]float64{}, nearest...)
+	sort.Float64s(r.Separations)
+
+	r.MinSep = r.Separations[0]
+	r.MaxSep = r.Separations[len(r.Separations)-1]
+	r.MedianSep = Percentile(r.Separations, 50)
+
+	for _, d := range r.Separations {
+		r.MeanSep += d
+	}
+
+	r.MeanSep /= float64(len(r.Separations))
+
+	for _, d := range r.Separations {
+		r.StdSep += (d - r.MeanSep) * (d - r.MeanSep)
+	}
+
+	r.StdSep = math.Sqrt(r.StdSep / float64(len(r.Separations)))
+
+	if r.MedianSep > 0 {
+		for i, beam := range coherent {
+			if ratio := nearest[i] / r.MedianSep; ratio > opts.OutlierFactor {
+				r.Outliers = append(r.Outliers, Outlier{Beam: beam, Dist: nearest[i], Ratio: ratio})
+			}
+		}
+	}
+
+	return r
+}
+
+// WriteSpacing writes the spacing diagnostics to w: the summary, followed
+// by the duplicated beams and the outliers, one per line.
+func WriteSpacing(w io.Writer, r SpacingReport) error {
+	fmt.Fprintf(w, "Beams: %d\n", r.NBeams)
+	fmt.Fprintf(w, "Bounding box: x %.6f to %.6f, y %.6f to %.6f\n", r.MinX, r.MaxX, r.MinY, r.MaxY)
+	fmt.Fprintf(w, "Convex hull area: %.4e, density: %.4e beams per unit area\n", r.HullArea, r.Density)
+	fmt.Fprintf(w, "Nearest-neighbour separation: minimum %.6f, median %.6f, mean %.6f +- %.6f, maximum %.6f\n",
+		r.MinSep, r.MedianSep, r.MeanSep, r.StdSep, r.MaxSep)
+	fmt.Fprintf(w, "Percentiles: p5 %.6f, p95 %.6f, p99 %.6f\n",
+		Percentile(r.Separations, 5), Percentile(r.Separations, 95), Percentile(r.Separations, 99))
+
+	fmt.Fprintf(w, "\nDuplicates: %d\n", len(r.Duplicates))
+
+	if len(r.Duplicates) > 0 {
+		fmt.Fprintf(w, "# %-12s %-12s %10s %s\n", "beam", "beam", "dist", "kind")
+
+		for _, d := range r.Duplicates {
+			kind := "position"
+			if d.Name {
+				kind = "name"
+			}
+
+			fmt.Fprintf(w, "  %-12s %-12s %10.4e %s\n", d.A.key(), d.B.key(), d.Dist, kind)
+		}
+	}
+
+	_, err := fmt.Fprintf(w, "\nOutliers: %d\n", len(r.Outliers))
+
+	if len(r.Outliers) > 0 {
+		fmt.Fprintf(w, "# %-12s %12s %12s %10s %8s\n", "beam", "x", "y", "nn_dist", "ratio")
+
+		for _, o := range r.Outliers {
+			_, err = fmt.Fprintf(w, "  %-12s %12.6f %12.6f %10.6f %8.2f\n", o.Beam.key(), o.Beam.X, o.Beam.Y, o.Dist, o.Ratio)
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,317 @@
+package beampack
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// The tables of a packing database.
+const (
+	packings_sql = "CREATE TABLE IF NOT EXISTS packings(id INTEGER PRIMARY KEY, created TEXT, observation TEXT, input TEXT, method TEXT, seed INTEGER, parameters TEXT, nbeams INTEGER, nbunches INTEGER, version TEXT, input_sha256 TEXT)"
+
+	assignments_sql = "CREATE TABLE IF NOT EXISTS assignments(packing INTEGER, beam INTEGER, name TEXT, pointing TEXT, x REAL, y REAL, bunch INTEGER, rank INTEGER, node TEXT)"
+
+	// the columns of the packings table, in the order of get_stored_packing
+	packings_columns = "id, coalesce(created, ''), coalesce(observation, ''), coalesce(input, ''), coalesce(method, ''), coalesce(seed, 0), coalesce(parameters, ''), coalesce(nbeams, 0), coalesce(nbunches, 0), coalesce(version, ''), coalesce(input_sha256, '')"
+)
+
+// How long to wait for concurrent writers of a packing database, in
+// milliseconds.
+const db_busy_timeout = 60000
+
+// StoredPacking describes a packing in a packing database.
+type StoredPacking struct {
+	ID          int64
+	Created     time.Time
+	Observation string
+	// The input file of the beam positions and its SHA-256 hash.
+	Input     string
+	InputHash string
+	Method    string
+	Seed      int64
+	// The packing parameters as free text, e.g. name=value pairs.
+	Parameters string
+	NBeams     int
+	NBunches   int
+	// The version of the packer.
+	Version string
+}
+
+// PackingQuery selects packings from a packing database.
+type PackingQuery struct {
+	// Only packings of this observation, if given.
+	Observation string
+	// Only the packing in effect at this time, i.e. the latest one created
+	// before it, if given.
+	At time.Time
+}
+
+// Open a packing database. The transactions take the write lock when they
+// begin and wait for the ones of concurrent writers, e.g. of several
+// pipeline processes.
+func open_packing_db(filename string) (*sql.DB, error) {
+	return sql.Open("sqlite", fmt.Sprintf("%s?_pragma=busy_timeout(%d)&_txlock=immediate", filename, db_busy_timeout))
+}
+
+// Check that a database has the tables of a packing database.
+func check_packing_db(db *sql.DB) error {
+	for _, name := range []string{"packings", "assignments"} {
+		var n int
+		if err := db.QueryRow("SELECT count(*) FROM sqlite_master WHERE type = 'table' AND name = ?", name).Scan(&n); err != nil {
+			return err
+		}
+
+		if n == 0 {
+			return fmt.Errorf("Not a packing database, missing table: %s", name)
+		}
+	}
+
+	return nil
+}
+
+// A scanner of a database row.
+type row_scanner interface {
+	Scan(dest ...any) error
+}
+
+// Read a row of the packings table with the packings columns.
+func get_stored_packing(row row_scanner) (StoredPacking, error) {
+	var info StoredPacking
+	var created string
+
+	err := row.Scan(&info.ID, &created, &info.Observation, &info.Input, &info.Method, &info.Seed,
+		&info.Parameters, &info.NBeams, &info.NBunches, &info.Version, &info.InputHash)
+
+	info.Created, _ = time.Parse(time.RFC3339Nano, created)
+
+	return info, err
+}
+
+// StorePacking adds the packing to the SQLite packing database, which is
+// created if it does not exist, and returns the ID of the stored packing.
+// The method and seed default to the ones of the packing, the creation time
+// to now. The other tables and indices of the database are left alone.
+// Concurrent stores, e.g. of several pipeline processes, wait for each
+// other, so that none of the packings get lost.
+func StorePacking(filename string, p *Packing, info StoredPacking) (int64, error) {
+	db, err := open_packing_db(filename)
+	if err != nil {
+		return 0, fmt.Errorf("Could not open packing database: %s, %s", filename, err)
+	}
+	defer db.Close()
+
+	if info.Created.IsZero() {
+		info.Created = time.Now()
+	}
+
+	if info.Method == "" {
+		info.Method = p.Method
+	}
+
+	if info.Seed == 0 {
+		info.Seed = p.Seed
+	}
+
+	records := Records(p)
+
+	info.NBeams = len(records)
+	info.NBunches = len(p.Bunches)
+
+	info.ID, err = store_packing(db, info, records)
+	if err != nil {
+		return 0, fmt.Errorf("Could not write packing database: %s, %s", filename, err)
+	}
+
+	return info.ID, nil
+}
+
+// Store a packing and its assignments in one transaction.
+func store_packing(db *sql.DB, info StoredPacking, records []Record) (int64, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	for _, stmt := range []string{packings_sql, assignments_sql} {
+		if _, err := tx.Exec(stmt); err != nil {
+			return 0, err
+		}
+	}
+
+	res, err := tx.Exec("INSERT INTO packings(created, observation, input, method, seed, parameters, nbeams, nbunches, version, input_sha256) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)",
+		info.Created.UTC().Format(time.RFC3339Nano), info.Observation, info.Input, info.Method, info.Seed,
+		info.Parameters, info.NBeams, info.NBunches, info.Version, info.InputHash)
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+
+	insert, err := tx.Prepare("INSERT INTO assignments(packing, beam, name, pointing, x, y, bunch, rank, node) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return 0, err
+	}
+	defer insert.Close()
+
+	for _, rec := range records {
+		if _, err := insert.Exec(id, rec.Beam, rec.Name, rec.Pointing, rec.X, rec.Y, rec.Bunch, rec.Rank, rec.Node); err != nil {
+			return 0, err
+		}
+	}
+
+	return id, tx.Commit()
+}
+
+// Open an existing packing database for reading. Unlike for storing, a
+// missing database is an error, as it is most likely a wrong file name.
+func open_existing_db(filename string) (*sql.DB, error) {
+	if _, err := os.Stat(filename); err != nil {
+		return nil, err
+	}
+
+	db, err := open_packing_db(filename)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := check_packing_db(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return db, nil
+}
+
+// QueryPackings lists the packings in the packing database that match the
+// query, in order of creation.
+func QueryPackings(filename string, q PackingQuery) ([]StoredPacking, error) {
+	db, err := open_existing_db(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read packing database: %s, %w", filename, err)
+	}
+
+	defer db.Close()
+
+	result, err := query_packings(db, q)
+	if err != nil {
+		return nil, fmt.Errorf("Could not read packing database: %s, %s", filename, err)
+	}
+
+	sort.SliceStable(result, func(i, j int) bool {
+		return result[i].Created.Before(result[j].Created)
+	})
+
+	if !q.At.IsZero() && len(result) > 0 {
+		result = result[len(result)-1:]
+	}
+
+	return result, nil
+}
+
+// Read the packings of the query from the database, in order of their IDs.
+func query_packings(db *sql.DB, q PackingQuery) ([]StoredPacking, error) {
+	rows, err := db.Query("SELECT "+packings_columns+" FROM packings WHERE ? = '' OR observation = ? ORDER BY id", q.Observation, q.Observation)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var result []StoredPacking
+
+	for rows.Next() {
+		info, err := get_stored_packing(rows)
+		if err != nil {
+			return nil, err
+		}
+
+		if !q.At.IsZero() && info.Created.After(q.At) {
+			continue
+		}
+
+		result = append(result, info)
+	}
+
+	return result, rows.Err()
+}
+
+// LoadStoredPacking retrieves a packing by ID from the packing database.
+func LoadStoredPacking(filename string, id int64) (StoredPacking, *Packing, error) {
+	db, err := open_existing_db(filename)
+	if err != nil {
+		return StoredPacking{}, nil, fmt.Errorf("Could not read packing database: %s, %w", filename, err)
+	}
+
+	defer db.Close()
+
+	info, err := get_stored_packing(db.QueryRow("SELECT "+packings_columns+" FROM packings WHERE id = ?", id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return StoredPacking{}, nil, fmt.Errorf("No such packing: %d", id)
+	}
+
+	if err != nil {
+		return StoredPacking{}, nil, fmt.Errorf("Could not read packing database: %s, %s", filename, err)
+	}
+
+	records, err := load_assignments(db, id)
+	if err != nil {
+		return StoredPacking{}, nil, fmt.Errorf("Could not read packing database: %s, %s", filename, err)
+	}
+
+	p := FromRecords(records)
+	p.Method = info.Method
+	p.Seed = info.Seed
+	p.Provenance = &Provenance{
+		Version:    info.Version,
+		Created:    info.Created,
+		Parameters: info.Parameters,
+	}
+
+	// the inputs of mosaic packings are stored as comma-separated lists
+	names := strings.Split(info.Input, ",")
+	hashes := strings.Split(info.InputHash, ",")
+
+	for i, name := range names {
+		in := InputFile{Name: name}
+		if len(hashes) == len(names) {
+			in.SHA256 = hashes[i]
+		}
+
+		p.Provenance.Inputs = append(p.Provenance.Inputs, in)
+	}
+
+	return info, p, nil
+}
+
+// Read the beam assignments of a stored packing, in the order they were
+// stored.
+func load_assignments(db *sql.DB, id int64) ([]Record, error) {
+	rows, err := db.Query("SELECT coalesce(beam, 0), coalesce(name, ''), coalesce(pointing, ''), coalesce(x, 0), coalesce(y, 0), coalesce(bunch, 0), coalesce(rank, 0), coalesce(node, '') FROM assignments WHERE packing = ? ORDER BY rowid", id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []Record
+
+	for rows.Next() {
+		var rec Record
+		if err := rows.Scan(&rec.Beam, &rec.Name, &rec.Pointing, &rec.X, &rec.Y, &rec.Bunch, &rec.Rank, &rec.Node); err != nil {
+			return nil, err
+		}
+
+		records = append(records, rec)
+	}
+
+	return records, rows.Err()
+}
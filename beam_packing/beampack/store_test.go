@@ -0,0 +1,215 @@
+package beampack
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Run the sqlite3 tool on a database, or skip the test if it is not
+// installed.
+func run_sqlite3(t *testing.T, filename string, sql string) string {
+	t.Helper()
+
+	path, err := exec.LookPath("sqlite3")
+	if err != nil {
+		t.Skip("sqlite3 is not installed")
+	}
+
+	out, err := exec.Command(path, "-batch", filename, sql).CombinedOutput()
+	if err != nil {
+		t.Fatalf("sqlite3: %s, %s", err, out)
+	}
+
+	return strings.TrimSpace(string(out))
+}
+
+func get_test_packing(t *testing.T, n int) *Packing {
+	t.Helper()
+
+	p, err := Pack(get_test_tiling(t, n), Options{Bunch: 6, Method: "hilbert"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return p
+}
+
+func TestStorePacking(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "packings.db")
+	p := get_test_packing(t, 50)
+
+	start := time.Date(2026, 10, 14, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		info := StoredPacking{
+			Created:     start.Add(time.Duration(i) * time.Hour),
+			Observation: fmt.Sprintf("obs%d", i%2),
+			Input:       "beams.dat",
+			InputHash:   "abc",
+			Parameters:  "bunch=6",
+			Version:     "test",
+		}
+
+		id, err := StorePacking(filename, p, info)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if id != int64(i+1) {
+			t.Errorf("wrong ID: %d, want %d", id, i+1)
+		}
+	}
+
+	all, err := QueryPackings(filename, PackingQuery{})
+	if err != nil || len(all) != 3 {
+		t.Fatalf("wrong packings: %v, %v", all, err)
+	}
+
+	if all[0].Method != "hilbert" || all[0].NBeams != 50 || all[0].NBunches != 9 || !all[0].Created.Equal(start) {
+		t.Errorf("wrong packing: %+v", all[0])
+	}
+
+	at, err := QueryPackings(filename, PackingQuery{Observation: "obs0", At: start.Add(90 * time.Minute)})
+	if err != nil || len(at) != 1 || at[0].ID != 1 {
+		t.Errorf("wrong packing in effect: %v, %v", at, err)
+	}
+
+	info, q, err := LoadStoredPacking(filename, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if info.Observation != "obs1" || q.NBeams() != 50 || len(q.Bunches) != len(p.Bunches) {
+		t.Errorf("wrong stored packing: %+v", info)
+	}
+
+	for i, b := range p.Bunches {
+		for j, beam := range b.Beams {
+			if got := q.Bunches[i].Beams[j]; got.Nr != beam.Nr || got.X != beam.X || got.Y != beam.Y {
+				t.Fatalf("wrong beam %d of bunch %d: %+v", j, i, got)
+			}
+		}
+	}
+
+	if _, _, err := LoadStoredPacking(filename, 4); err == nil {
+		t.Error("no error for a missing packing")
+	}
+
+	out := run_sqlite3(t, filename, "SELECT count(*), count(DISTINCT packing) FROM assignments;")
+	if out != "150|3" {
+		t.Errorf("wrong assignments for sqlite3: %s", out)
+	}
+}
+
+// Concurrent stores do not lose each other's packings.
+func TestStorePackingConcurrent(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "packings.db")
+	p := get_test_packing(t, 30)
+
+	const n = 9
+
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+
+		go func(i int) {
+			defer wg.Done()
+
+			if _, err := StorePacking(filename, p, StoredPacking{Observation: fmt.Sprintf("obs%d", i)}); err != nil {
+				errs <- err
+			}
+		}(i)
+	}
+
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+
+	all, err := QueryPackings(filename, PackingQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(all) != n {
+		t.Fatalf("%d of %d packings stored", len(all), n)
+	}
+
+	ids := make(map[int64]bool)
+	for _, info := range all {
+		ids[info.ID] = true
+	}
+
+	if len(ids) != n {
+		t.Errorf("duplicate IDs: %v", ids)
+	}
+}
+
+// Storing a packing leaves the other tables and indices of a database that
+// was modified with the sqlite3 tools alone.
+func TestStorePackingKeepsTables(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "packings.db")
+	p := get_test_packing(t, 20)
+
+	if _, err := StorePacking(filename, p, StoredPacking{Observation: "obs0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	run_sqlite3(t, filename, `
+		CREATE INDEX a_packing ON assignments(packing);
+		CREATE TABLE notes(packing INTEGER, t TEXT);
+		INSERT INTO notes VALUES (1, 'good'), (1, NULL);
+		UPDATE packings SET observation = NULL;
+	`)
+
+	if id, err := StorePacking(filename, p, StoredPacking{Observation: "obs1"}); err != nil || id != 2 {
+		t.Fatalf("wrong ID: %d, %v", id, err)
+	}
+
+	out := run_sqlite3(t, filename, "SELECT group_concat(name) FROM (SELECT name FROM sqlite_master ORDER BY name); SELECT count(*) FROM notes; PRAGMA integrity_check;")
+	if out != "a_packing,assignments,notes,packings\n2\nok" {
+		t.Errorf("wrong database: %q", out)
+	}
+
+	all, err := QueryPackings(filename, PackingQuery{})
+	if err != nil || len(all) != 2 || all[0].Observation != "" || all[1].Observation != "obs1" {
+		t.Errorf("wrong packings: %+v, %v", all, err)
+	}
+}
+
+func TestQueryPackingsInvalid(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "other.db")
+	run_sqlite3(t, filename, "CREATE TABLE beams(name TEXT);")
+
+	if _, err := QueryPackings(filename, PackingQuery{}); err == nil {
+		t.Error("no error for a database without packings")
+	}
+
+	missing := filepath.Join(t.TempDir(), "missing.db")
+
+	if _, err := QueryPackings(missing, PackingQuery{}); err == nil {
+		t.Error("no error for a missing database")
+	}
+
+	if _, _, err := LoadStoredPacking(missing, 1); err == nil {
+		t.Error("no error for a missing database")
+	}
+
+	if _, err := os.Stat(missing); err == nil {
+		t.Error("the missing database is created")
+	}
+
+	if _, err := QueryPackings(filepath.Join("testdata", "portal_sensors.json"), PackingQuery{}); err == nil {
+		t.Error("no error for a file that is no database")
+	}
+}
@@ -0,0 +1,222 @@
+package beampack
+
+import (
+	"math"
+	"sort"
+)
+
+// SubTilingPolicies lists the available handlings of layouts with several
+// sub-tilings or outliers.
+var SubTilingPolicies = []string{"none", "split", "review"}
+
+// SubTilingOptions configure the detection of the sub-tilings and outliers
+// of the beam layout.
+type SubTilingOptions struct {
+	// Beams closer than that are neighbours, defaults to 1.5 times the
+	// median nearest-neighbour separation.
+	Eps float64
+	// The minimum number of neighbours, including itself, of a core beam,
+	// defaults to 3.
+	MinPoints int
+}
+
+// Layout is the structure of the beam layout found by density-based
+// clustering: disconnected sub-tilings, as from beamforming on several
+// targets, and outliers that belong to none of them.
+type Layout struct {
+	// The beams of the sub-tilings, the largest first.
+	SubTilings [][]Beam
+	Outliers   []Beam
+	// The linking length used.
+	Eps float64
+	// Whether the sub-tilings were packed independently.
+	Split bool
+}
+
+// NeedsReview reports whether the layout is not a single tiling without
+// outliers.
+func (l *Layout) NeedsReview() bool {
+	return l != nil && (len(l.SubTilings) > 1 || len(l.Outliers) > 0)
+}
+
+// Get the sub-tiling of every beam with DBSCAN, or -1 for the outliers, the
+// number of sub-tilings and the linking length.
+func get_subtiling_labels(beams []Beam, dist DistanceFunc, opts SubTilingOptions) ([]int, int, float64) {
+	eps := opts.Eps
+	if eps <= 0 {
+		eps = 1.5 * get_median_separation(beams, dist)
+	}
+
+	minpoints := opts.MinPoints
+	if minpoints <= 0 {
+		minpoints = 3
+	}
+
+	neighbours := make([][]int, len(beams))
+
+	for i, a := range beams {
+		for j := i + 1; j < len(beams); j++ {
+			if dist(a.X, a.Y, beams[j].X, beams[j].Y) <= eps {
+				neighbours[i] = append(neighbours[i], j)
+				neighbours[j] = append(neighbours[j], i)
+			}
+		}
+	}
+
+	core := make([]bool, len(beams))
+	for i := range core {
+		core[i] = len(neighbours[i])+1 >= minpoints
+	}
+
+	label := make([]int, len(beams))
+	for i := range label {
+		label[i] = -1
+	}
+
+	var n int
+
+	for i := range beams {
+		if !core[i] || label[i] >= 0 {
+			continue
+		}
+
+		label[i] = n
+		stack := []int{i}
+
+		for len(stack) > 0 {
+			cur := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+
+			for _, next := range neighbours[cur] {
+				if label[next] >= 0 {
+					continue
+				}
+
+				label[next] = n
+
+				// border beams do not extend the sub-tiling
+				if core[next] {
+					stack = append(stack, next)
+				}
+			}
+		}
+
+		n++
+	}
+
+	return label, n, eps
+}
+
+// FindSubTilings finds the sub-tilings and outliers of the beam layout with
+// DBSCAN: the beams with at least MinPoints neighbours within Eps are core
+// beams, a sub-tiling is a connected group of core beams and their
+// neighbours, and the other beams are outliers. The beams keep their order
+// within the sub-tilings.
+func FindSubTilings(beams []Beam, dist DistanceFunc, opts SubTilingOptions) *Layout {
+	label, n, eps := get_subtiling_labels(beams, dist, opts)
+
+	l := &Layout{SubTilings: make([][]Beam, n), Eps: eps}
+
+	for i, beam := range beams {
+		if label[i] < 0 {
+			l.Outliers = append(l.Outliers, beam)
+		} else {
+			l.SubTilings[label[i]] = append(l.SubTilings[label[i]], beam)
+		}
+	}
+
+	sort.SliceStable(l.SubTilings, func(i, j int) bool {
+		return len(l.SubTilings[i]) > len(l.SubTilings[j])
+	})
+
+	return l
+}
+
+// Split the beams into the sub-tilings to pack independently. The outliers
+// join the sub-tiling of their nearest beam that is not an outlier. The
+// beams keep their order within the parts.
+func split_subtilings(beams []Beam, dist DistanceFunc, l *Layout) [][]Beam {
+	part := make(map[int]int, len(beams))
+	for p, members := range l.SubTilings {
+		for _, beam := range members {
+			part[beam.Nr] = p
+		}
+	}
+
+	parts := make([][]Beam, len(l.SubTilings))
+
+	for _, beam := range beams {
+		p, ok := part[beam.Nr]
+		if !ok {
+			best := math.Inf(1)
+
+			for q, members := range l.SubTilings {
+				for _, other := range members {
+					if d := dist(beam.X, beam.Y, other.X, other.Y); d < best {
+						best, p = d, q
+					}
+				}
+			}
+		}
+
+		parts[p] = append(parts[p], beam)
+	}
+
+	return parts
+}
+
+// Distribute the groups over the parts in proportion to their numbers of
+// beams by the largest remainder, with at least one group per part.
+func split_ngroups(parts [][]Beam, ngroups int) ([]int, error) {
+	if ngroups < len(parts) {
+		return nil, unsatisfiable("Fewer groups than sub-tilings requested: %d, %d", ngroups, len(parts))
+	}
+
+	var total int
+	for _, members := range parts {
+		total += len(members)
+	}
+
+	counts := make([]int, len(parts))
+	rest := make([]float64, len(parts))
+	left := ngroups
+
+	for p, members := range parts {
+		share := float64(ngroups) * float64(len(members)) / float64(total)
+		counts[p] = max(1, min(int(share), len(members)))
+		rest[p] = share - float64(counts[p])
+		left -= counts[p]
+	}
+
+	for left > 0 {
+		best := -1
+		for p := range parts {
+			if counts[p] < len(parts[p]) && (best < 0 || rest[p] > rest[best]) {
+				best = p
+			}
+		}
+
+		if best < 0 {
+			return nil, unsatisfiable("More groups than beams requested: %d, %d", ngroups, total)
+		}
+
+		counts[best]++
+		rest[best]--
+		left--
+	}
+
+	for left < 0 {
+		best := -1
+		for p := range parts {
+			if counts[p] > 1 && (best < 0 || rest[p] < rest[best]) {
+				best = p
+			}
+		}
+
+		counts[best]--
+		rest[best]++
+		left++
+	}
+
+	return counts, nil
+}
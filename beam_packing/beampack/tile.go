@@ -0,0 +1,226 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"sort"
+	"strings"
+)
+
+// TileOptions configure the hexagonal beam tiling.
+type TileOptions struct {
+	// Boresight position.
+	X0 float64
+	Y0 float64
+	// Beam semi-major and semi-minor axes at half power, in the units of
+	// the output coordinates.
+	SemiMajor float64
+	SemiMinor float64
+	// Position angle of the major axis in degrees, measured from +y
+	// towards +x (north through east).
+	PA float64
+	// Relative power level at which neighbouring beams overlap.
+	Overlap float64
+	// Number of beams to generate.
+	NBeams int
+}
+
+// Tile generates a hexagonal tiling of elliptical beams around the
+// boresight. The beams are ordered by increasing distance from the
+// boresight, starting with the boresight beam. The beam shape is assumed to
+// be Gaussian, so that neighbouring beams overlap at the requested power
+// level. The x offsets are scaled by 1/cos(y0), which makes the offsets
+// true angles on the sky when x and y are RA and Dec in degrees.
+func Tile(opts TileOptions) ([]Beam, error) {
+	if opts.SemiMajor <= 0 || opts.SemiMinor <= 0 {
+		return nil, fmt.Errorf("The beam semi-axes must be positive: %g, %g", opts.SemiMajor, opts.SemiMinor)
+	}
+
+	if opts.Overlap <= 0 || opts.Overlap >= 1 {
+		return nil, fmt.Errorf("The overlap level must be between 0 and 1: %g", opts.Overlap)
+	}
+
+	if opts.NBeams <= 0 {
+		return nil, fmt.Errorf("The number of beams must be positive: %d", opts.NBeams)
+	}
+
+	// radius of the overlap contour in units of the half-power radius
+	radius := math.Sqrt(math.Log(1/opts.Overlap) / math.Log(2))
+	spacing := 2 * radius
+
+	// enough hexagonal rings to hold all beams
+	rings := int(math.Ceil(math.Sqrt(float64(opts.NBeams)/3))) + 1
+
+	type point struct {
+		u, v  float64
+		r     float64
+		angle float64
+	}
+
+	var points []point
+
+	for i := -2 * rings; i <= 2*rings; i++ {
+		for j := -2 * rings; j <= 2*rings; j++ {
+			u := spacing * (float64(i) + 0.5*float64(j))
+			v := spacing * math.Sqrt(3) / 2 * float64(j)
+
+			points = append(points, point{u, v, math.Hypot(u, v), math.Atan2(v, u)})
+		}
+	}
+
+	sort.SliceStable(points, func(i, j int) bool {
+		if math.Abs(points[i].r-points[j].r) > 1e-9 {
+			return points[i].r < points[j].r
+		}
+		return points[i].angle < points[j].angle
+	})
+
+	if len(points) < opts.NBeams {
+		return nil, fmt.Errorf("Could not generate %d beams", opts.NBeams)
+	}
+
+	const deg = math.Pi / 180.0
+
+	pa := opts.PA * deg
+	scale := 1 / math.Cos(opts.Y0*deg)
+
+	beams := make([]Beam, opts.NBeams)
+
+	for i, p := range points[:opts.NBeams] {
+		// stretch the unit circles to the beam ellipse and rotate
+		u := p.u * opts.SemiMinor
+		v := p.v * opts.SemiMajor
+
+		dx := u*math.Cos(pa) + v*math.Sin(pa)
+		dy := -u*math.Sin(pa) + v*math.Cos(pa)
+
+		beams[i] = Beam{
+			Nr:        i,
+			Name:      BeamName(i),
+			X:         opts.X0 + dx*scale,
+			Y:         opts.Y0 + dy,
+			SemiMajor: opts.SemiMajor,
+			SemiMinor: opts.SemiMinor,
+			PA:        opts.PA,
+		}
+	}
+
+	return beams, nil
+}
+
+// SimulateOptions configure the synthetic beam layouts.
+type SimulateOptions struct {
+	TileOptions
+	// Standard deviation of the random beam position offsets, in units of
+	// the beam semi-minor axis.
+	Jitter float64
+	// Fraction of beams that are randomly removed from the tiling.
+	Missing float64
+}
+
+// Simulate generates a realistic synthetic beam layout: a hexagonal tiling
+// of elliptical beams, whose positions are randomly jittered and of which a
+// fraction of beams is missing. The remaining beams are renumbered
+// consecutively.
+func Simulate(opts SimulateOptions, rng *rand.Rand) ([]Beam, error) {
+	if opts.Jitter < 0 {
+		return nil, fmt.Errorf("The jitter must not be negative: %g", opts.Jitter)
+	}
+
+	if opts.Missing < 0 || opts.Missing >= 1 {
+		return nil, fmt.Errorf("The fraction of missing beams must be between 0 and 1: %g", opts.Missing)
+	}
+
+	tiling, err := Tile(opts.TileOptions)
+	if err != nil {
+		return nil, err
+	}
+
+	const deg = math.Pi / 180.0
+
+	scale := 1 / math.Cos(opts.Y0*deg)
+	sigma := opts.Jitter * opts.SemiMinor
+
+	var beams []Beam
+
+	for _, beam := range tiling {
+		if rng.Float64() < opts.Missing {
+			continue
+		}
+
+		beam.X += rng.NormFloat64() * sigma * scale
+		beam.Y += rng.NormFloat64() * sigma
+		beam.Nr = len(beams)
+		beam.Name = BeamName(beam.Nr)
+
+		beams = append(beams, beam)
+	}
+
+	return beams, nil
+}
+
+// WriteDat writes the beams in the tab-separated format that Load consumes,
+// with a header row and the beam names, and the beam shapes and priority
+// weights if any beam has them. Dummy beams are left out.
+func WriteDat(w io.Writer, beams []Beam) error {
+	var shapes, weights bool
+
+	for _, beam := range beams {
+		shapes = shapes || (beam.SemiMajor > 0 && beam.SemiMinor > 0)
+		weights = weights || beam.Weight != 0
+	}
+
+	header := []string{"name", "ra", "dec"}
+	if shapes {
+		header = append(header, "semimajor", "semiminor", "pa")
+	}
+
+	if weights {
+		header = append(header, "weight")
+	}
+
+	if _, err := fmt.Fprintln(w, strings.Join(header, "\t")); err != nil {
+		return err
+	}
+
+	for _, beam := range beams {
+		if beam.Dummy {
+			continue
+		}
+
+		fields := []float64{beam.X, beam.Y}
+		if shapes {
+			fields = append(fields, beam.SemiMajor, beam.SemiMinor, beam.PA)
+		}
+
+		if weights {
+			fields = append(fields, beam.weight())
+		}
+
+		line := beam.Name
+		for _, v := range fields {
+			line += fmt.Sprintf("\t%.18e", v)
+		}
+
+		if _, err := fmt.Fprintln(w, line); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// WriteBeams writes the beam positions to w in the tab-separated format that
+// Load consumes.
+func WriteBeams(w io.Writer, beams []Beam) error {
+	for _, beam := range beams {
+		_, err := fmt.Fprintf(w, "%.18e\t%.18e\n", beam.X, beam.Y)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
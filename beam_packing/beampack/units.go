@@ -0,0 +1,87 @@
+package beampack
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+)
+
+// Units lists the available units of the input coordinates. The positions
+// are converted to degrees when loaded.
+var Units = []string{"auto", "deg", "arcmin", "arcsec", "rad"}
+
+// GetUnitScale returns the factor that converts the units to degrees.
+func GetUnitScale(units string) (float64, error) {
+	switch units {
+	case "", "deg":
+		return 1, nil
+	case "arcmin":
+		return 1 / 60.0, nil
+	case "arcsec":
+		return 1 / 3600.0, nil
+	case "rad":
+		return 180 / math.Pi, nil
+	default:
+		return 0, fmt.Errorf("Unknown units: %s", units)
+	}
+}
+
+// DetectUnits guesses the units of the beam positions from the median
+// separation of the beams from their nearest neighbour. Coherent beam
+// tilings have spacings of a few arcseconds to a few arcminutes, so that
+// spacings below 0.001 indicate radians, up to 0.2 degrees, up to 5
+// arcminutes and larger ones arcseconds.
+func DetectUnits(beams []Beam) string {
+	var coherent []Beam
+
+	for _, beam := range beams {
+		if !beam.Incoherent {
+			coherent = append(coherent, beam)
+		}
+	}
+
+	if len(coherent) < 2 {
+		return "deg"
+	}
+
+	spacing := get_median_separation(coherent, Euclidean)
+
+	switch {
+	case spacing == 0:
+		return "deg"
+	case spacing < 0.001:
+		return "rad"
+	case spacing < 0.2:
+		return "deg"
+	case spacing < 5:
+		return "arcmin"
+	default:
+		return "arcsec"
+	}
+}
+
+// Convert the beam positions and shapes from the units to degrees.
+func convert_units(beams []Beam, units string) error {
+	if units == "auto" {
+		units = DetectUnits(beams)
+		slog.Info("Detected input units", "units", units)
+	}
+
+	scale, err := GetUnitScale(units)
+	if err != nil {
+		return err
+	}
+
+	if scale == 1 {
+		return nil
+	}
+
+	for i := range beams {
+		beams[i].X *= scale
+		beams[i].Y *= scale
+		beams[i].SemiMajor *= scale
+		beams[i].SemiMinor *= scale
+	}
+
+	return nil
+}
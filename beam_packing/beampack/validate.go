@@ -0,0 +1,237 @@
+package beampack
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ValidateOptions configure the validation of a packing.
+type ValidateOptions struct {
+	// Expected number of beams per bunch.
+	Bunch int
+	// If positive, the expected number of groups of approximately equal
+	// size instead of bunches of Bunch beams.
+	NGroups int
+	// The remainder policy: with smaller, one bunch may be smaller than
+	// Bunch, and with balance, the bunch sizes may be smaller than Bunch
+	// but differ by at most one.
+	Remainder string
+	// Maximum radius of the bounding circle of a bunch. Zero means no
+	// limit.
+	MaxRadius float64
+	// Dead or RFI-flagged beams, by number. They must not be assigned.
+	Flagged map[int]string
+	// Maximum difference between the beam positions in the packing and in
+	// the beam position table, defaults to 1e-5 to allow for the rounding
+	// of the text output.
+	Tolerance float64
+}
+
+// Violation is a problem found in a packing.
+type Violation struct {
+	// The kind of problem: duplicate, missing, unknown, flagged, position,
+	// size or radius.
+	Kind    string
+	Message string
+}
+
+// Validation is the result of the validation of a packing.
+type Validation struct {
+	NBeams     int
+	NBunches   int
+	Violations []Violation
+}
+
+// Validate checks an externally supplied packing, given by its records,
+// against the beam positions: every beam but the flagged ones must be
+// assigned exactly once, there must be no unknown beams, the beam positions
+// must match, the bunches must have the expected sizes and, if a maximum
+// radius is given, no bunch may exceed it. The beams are matched by name, qualified by the
+// pointing in mosaic packings. Dummy beams only count towards the bunch
+// sizes.
+func Validate(records []Record, beams []Beam, opts ValidateOptions, dist DistanceFunc) Validation {
+	if dist == nil {
+		dist = Euclidean
+	}
+
+	tolerance := opts.Tolerance
+	if tolerance <= 0 {
+		tolerance = 1e-5
+	}
+
+	var v Validation
+
+	add := func(kind, format string, args ...any) {
+		v.Violations = append(v.Violations, Violation{kind, fmt.Sprintf(format, args...)})
+	}
+
+	bykey := make(map[string]Beam)
+	for _, beam := range beams {
+		bykey[beam.key()] = beam
+	}
+
+	// the bunches every beam is assigned to, in order of appearance
+	assigned := make(map[string][]int)
+	var keys []string
+
+	members := make(map[int][]Beam)
+	sizes := make(map[int]int)
+
+	for _, rec := range records {
+		sizes[rec.Bunch]++
+
+		if strings.HasPrefix(rec.Name, DummyPrefix) {
+			continue
+		}
+
+		key := rec.key()
+		if _, ok := assigned[key]; !ok {
+			keys = append(keys, key)
+		}
+
+		assigned[key] = append(assigned[key], rec.Bunch)
+
+		beam, ok := bykey[key]
+		if !ok {
+			add("unknown", "Beam %s in bunch %d is not in the beam positions.", key, rec.Bunch)
+			continue
+		}
+
+		if len(assigned[key]) == 1 {
+			members[rec.Bunch] = append(members[rec.Bunch], beam)
+
+			if reason, ok := opts.Flagged[beam.Nr]; ok {
+				add("flagged", "Beam %s in bunch %d is excluded, reason: %s.", key, rec.Bunch, reason)
+			}
+		}
+
+		if !beam.Incoherent && (math.Abs(rec.X-beam.X) > tolerance || math.Abs(rec.Y-beam.Y) > tolerance) {
+			add("position", "Beam %s is at %.6f, %.6f in the packing, but at %.6f, %.6f in the beam positions.",
+				key, rec.X, rec.Y, beam.X, beam.Y)
+		}
+	}
+
+	for _, key := range keys {
+		if bunches := assigned[key]; len(bunches) > 1 {
+			ids := make([]string, len(bunches))
+			for i, id := range bunches {
+				ids[i] = strconv.Itoa(id)
+			}
+
+			add("duplicate", "Beam %s is assigned %d times, to bunches %s.", key, len(bunches), strings.Join(ids, ", "))
+		}
+	}
+
+	for _, beam := range beams {
+		if _, ok := opts.Flagged[beam.Nr]; ok {
+			continue
+		}
+
+		if _, ok := assigned[beam.key()]; !ok {
+			add("missing", "Beam %s is not assigned to any bunch.", beam.key())
+		}
+	}
+
+	var ids []int
+	for id := range sizes {
+		ids = append(ids, id)
+	}
+
+	sort.Ints(ids)
+
+	v.NBeams = len(keys)
+	v.NBunches = len(ids)
+
+	// the bunch sizes
+	if opts.NGroups > 0 {
+		if len(ids) != opts.NGroups {
+			add("size", "The packing has %d groups instead of %d.", len(ids), opts.NGroups)
+		}
+
+		smallest, largest := math.MaxInt, 0
+		for _, id := range ids {
+			smallest = min(smallest, sizes[id])
+			largest = max(largest, sizes[id])
+		}
+
+		if len(ids) > 0 && largest-smallest > 1 {
+			add("size", "The group sizes range from %d to %d beams.", smallest, largest)
+		}
+	} else if opts.Bunch > 0 {
+		var smaller []int
+		smallest, largest := math.MaxInt, 0
+
+		for _, id := range ids {
+			smallest = min(smallest, sizes[id])
+			largest = max(largest, sizes[id])
+
+			switch n := sizes[id]; {
+			case n > opts.Bunch:
+				add("size", "Bunch %d has %d beams, more than %d.", id, n, opts.Bunch)
+			case n < opts.Bunch:
+				smaller = append(smaller, id)
+			}
+		}
+
+		// the remaining beams may form one smaller bunch, or be spread over
+		// the bunches with balance
+		if opts.Remainder == "smaller" && len(smaller) == 1 {
+			smaller = nil
+		}
+
+		if opts.Remainder == "balance" {
+			if len(ids) > 0 && largest-smallest > 1 {
+				add("size", "The bunch sizes range from %d to %d beams.", smallest, largest)
+			}
+
+			smaller = nil
+		}
+
+		for _, id := range smaller {
+			add("size", "Bunch %d has %d beams, fewer than %d.", id, sizes[id], opts.Bunch)
+		}
+	}
+
+	if opts.MaxRadius > 0 {
+		for _, id := range ids {
+			var coherent []Beam
+			for _, beam := range members[id] {
+				if !beam.Incoherent {
+					coherent = append(coherent, beam)
+				}
+			}
+
+			if len(coherent) == 0 {
+				continue
+			}
+
+			if c := get_bounding_circle(coherent, dist); c.Radius > opts.MaxRadius {
+				add("radius", "Bunch %d has a radius of %.6f, more than %.6f.", id, c.Radius, opts.MaxRadius)
+			}
+		}
+	}
+
+	return v
+}
+
+// WriteValidation writes the violations found in a packing and a summary
+// to w.
+func WriteValidation(w io.Writer, v Validation) error {
+	for _, violation := range v.Violations {
+		fmt.Fprintf(w, "%s: %s\n", violation.Kind, violation.Message)
+	}
+
+	status := "valid"
+	if len(v.Violations) > 0 {
+		status = "invalid"
+	}
+
+	_, err := fmt.Fprintf(w, "\nBeams: %d, bunches: %d, violations: %d, status: %s\n",
+		v.NBeams, v.NBunches, len(v.Violations), status)
+
+	return err
+}
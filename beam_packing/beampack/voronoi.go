@@ -0,0 +1,331 @@
+package beampack
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strings"
+)
+
+// The number of vertices of the polygon that approximates the circle of
+// maximum radius around a beam.
+const voronoi_vertices = 64
+
+// VoronoiCell is the region that is closer to a beam than to any other
+// beam, i.e. the effective sky responsibility of the beam, limited to a
+// maximum radius around it.
+type VoronoiCell struct {
+	Beam  Beam
+	Bunch int
+	Node  string
+	// The vertices of the cell in counter-clockwise order.
+	Vertices [][2]float64
+	// The area on the tangent plane of projected packings.
+	Area float64
+	// The beams whose cells share an edge with the cell.
+	Neighbours []Beam
+	// Whether the cell is limited by the maximum radius, e.g. at the edge
+	// of the tiling.
+	Bounded bool
+}
+
+// Clip a convex polygon to the half-plane of the points that are closer to
+// p than to q. Every edge starts at its vertex and is labelled with the
+// beam whose bisector it lies on, and the new edge gets label.
+func clip_cell(vertices [][2]float64, labels []int, p, q [2]float64, label int) ([][2]float64, []int) {
+	nx, ny := q[0]-p[0], q[1]-p[1]
+	c := (q[0]*q[0] + q[1]*q[1] - p[0]*p[0] - p[1]*p[1]) / 2
+
+	side := func(v [2]float64) float64 {
+		return nx*v[0] + ny*v[1] - c
+	}
+
+	var result [][2]float64
+	var result_labels []int
+
+	for k, a := range vertices {
+		b := vertices[(k+1)%len(vertices)]
+		sa, sb := side(a), side(b)
+
+		intersect := func() [2]float64 {
+			t := sa / (sa - sb)
+			return [2]float64{a[0] + t*(b[0]-a[0]), a[1] + t*(b[1]-a[1])}
+		}
+
+		switch {
+		case sa <= 0 && sb <= 0:
+			result = append(result, a)
+			result_labels = append(result_labels, labels[k])
+		case sa <= 0:
+			result = append(result, a, intersect())
+			result_labels = append(result_labels, labels[k], label)
+		case sb <= 0:
+			result = append(result, intersect())
+			result_labels = append(result_labels, labels[k])
+		}
+	}
+
+	return result, result_labels
+}
+
+// Compute the Voronoi cell of beam i, starting from the circle of maximum
+// radius. The beams are sorted by increasing distance from beam i, and the
+// clipping stops once they are too far to affect the cell. It returns
+// false if the beams run out before that.
+func get_voronoi_cell(points [][2]float64, i int, others []int, radius float64) ([][2]float64, []int, bool) {
+	p := points[i]
+
+	vertices := make([][2]float64, voronoi_vertices)
+	labels := make([]int, voronoi_vertices)
+
+	for k := range vertices {
+		angle := 2 * math.Pi * float64(k) / voronoi_vertices
+		vertices[k] = [2]float64{p[0] + radius*math.Cos(angle), p[1] + radius*math.Sin(angle)}
+		labels[k] = -1
+	}
+
+	// the farthest vertex bounds the distance of the beams that can still
+	// clip the cell
+	reach := radius
+
+	for _, j := range others {
+		q := points[j]
+		if math.Hypot(q[0]-p[0], q[1]-p[1]) > 2*reach {
+			return vertices, labels, true
+		}
+
+		if q == p {
+			continue
+		}
+
+		vertices, labels = clip_cell(vertices, labels, p, q, j)
+
+		reach = 0
+		for _, v := range vertices {
+			reach = math.Max(reach, math.Hypot(v[0]-p[0], v[1]-p[1]))
+		}
+	}
+
+	return vertices, labels, false
+}
+
+// Voronoi computes the Voronoi cells of the coherent beams of a packing,
+// limited to the radius around every beam. A non-positive radius selects
+// the diameter of a circle with the mean area per beam within the convex
+// hull of the beams, which is about the beam spacing of a regular tiling,
+// so that mostly the cells at the edge of the tiling are limited. The cells of projected
+// packings are computed on the tangent plane and their vertices are
+// deprojected.
+func Voronoi(p *Packing, radius float64) ([]VoronoiCell, error) {
+	var beams []Beam
+	var bunches []int
+	var nodes []string
+
+	for _, b := range p.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+				bunches = append(bunches, b.ID)
+				nodes = append(nodes, b.Node)
+			}
+		}
+	}
+
+	work := beams
+	if p.Tangent != nil {
+		projected, err := project_beams(beams, *p.Tangent)
+		if err != nil {
+			return nil, err
+		}
+
+		work = projected
+	}
+
+	if radius <= 0 && len(work) > 1 {
+		radius = 2 * math.Sqrt(get_polygon_area(get_convex_hull(work))/float64(len(work))/math.Pi)
+
+		// beams on a line have no area
+		if radius == 0 {
+			radius = get_median_separation(work, Euclidean)
+		}
+	}
+
+	if len(work) > 0 && radius <= 0 {
+		return nil, fmt.Errorf("Invalid maximum radius of the Voronoi cells: %g", radius)
+	}
+
+	points := make([][2]float64, len(work))
+	for i, beam := range work {
+		points[i] = [2]float64{beam.X, beam.Y}
+	}
+
+	// the nearest beams usually suffice to bound a cell
+	neighbours := get_neighbours(work, 32, Euclidean)
+	cells := make([]VoronoiCell, len(work))
+
+	for i := range work {
+		vertices, labels, ok := get_voronoi_cell(points, i, neighbours[i], radius)
+
+		if !ok {
+			others := make([]int, 0, len(work)-1)
+			for j := range work {
+				if j != i {
+					others = append(others, j)
+				}
+			}
+
+			dist := func(j int) float64 {
+				return math.Hypot(points[j][0]-points[i][0], points[j][1]-points[i][1])
+			}
+
+			sort.SliceStable(others, func(a, b int) bool {
+				return dist(others[a]) < dist(others[b])
+			})
+
+			vertices, labels, _ = get_voronoi_cell(points, i, others, radius)
+		}
+
+		cell := VoronoiCell{
+			Beam:  beams[i],
+			Bunch: bunches[i],
+			Node:  nodes[i],
+			Area:  get_polygon_area(vertices),
+		}
+
+		seen := make(map[int]bool)
+
+		for _, l := range labels {
+			switch {
+			case l < 0:
+				cell.Bounded = true
+			case !seen[l]:
+				seen[l] = true
+				cell.Neighbours = append(cell.Neighbours, beams[l])
+			}
+		}
+
+		if p.Tangent != nil {
+			for k, v := range vertices {
+				vertices[k][0], vertices[k][1] = p.Tangent.Deproject(v[0], v[1])
+			}
+		}
+
+		cell.Vertices = vertices
+		cells[i] = cell
+	}
+
+	return cells, nil
+}
+
+// VoronoiFormats lists the output formats of WriteVoronoi.
+var VoronoiFormats = []string{"json", "geojson", "svg"}
+
+// WriteVoronoi writes the Voronoi cells of the packing in one of the
+// Voronoi formats: json or geojson for a GeoJSON feature collection of the
+// cell polygons, or svg for an overlay that matches the plot of the packing.
+func WriteVoronoi(w io.Writer, p *Packing, cells []VoronoiCell, format string) error {
+	switch format {
+	case "json", "geojson":
+		return write_voronoi_geojson(w, cells)
+	case "svg":
+		beams, _ := flatten_packing(p)
+
+		// the colours follow the order of the bunches
+		order := make(map[int]int)
+		for i, b := range p.Bunches {
+			order[b.ID] = i
+		}
+
+		return write_voronoi_svg(w, cells, order, get_canvas(beams, 800))
+	}
+
+	return fmt.Errorf("Unknown Voronoi format: %s", format)
+}
+
+// Write the Voronoi cells as GeoJSON feature collection. The polygons are
+// closed, and their coordinates are the beam coordinates, e.g. RA and Dec.
+func write_voronoi_geojson(w io.Writer, cells []VoronoiCell) error {
+	type geometry struct {
+		Type        string         `json:"type"`
+		Coordinates [][][2]float64 `json:"coordinates"`
+	}
+
+	type properties struct {
+		Beam       int      `json:"beam"`
+		Name       string   `json:"name"`
+		X          float64  `json:"x"`
+		Y          float64  `json:"y"`
+		Bunch      int      `json:"bunch"`
+		Node       string   `json:"node,omitempty"`
+		Area       float64  `json:"area"`
+		Bounded    bool     `json:"bounded"`
+		Neighbours []string `json:"neighbours"`
+	}
+
+	type feature struct {
+		Type       string     `json:"type"`
+		Geometry   geometry   `json:"geometry"`
+		Properties properties `json:"properties"`
+	}
+
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: make([]feature, len(cells))}
+
+	for i, c := range cells {
+		ring := append(append([][2]float64{}, c.Vertices...), c.Vertices[0])
+
+		names := make([]string, len(c.Neighbours))
+		for k, beam := range c.Neighbours {
+			names[k] = beam.key()
+		}
+
+		collection.Features[i] = feature{
+			Type:     "Feature",
+			Geometry: geometry{Type: "Polygon", Coordinates: [][][2]float64{ring}},
+			Properties: properties{
+				Beam:       c.Beam.Nr,
+				Name:       c.Beam.Name,
+				X:          c.Beam.X,
+				Y:          c.Beam.Y,
+				Bunch:      c.Bunch,
+				Node:       c.Node,
+				Area:       c.Area,
+				Bounded:    c.Bounded,
+				Neighbours: names,
+			},
+		}
+	}
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+
+	return enc.Encode(collection)
+}
+
+// Write the Voronoi cells as SVG, coloured by bunch like the plot.
+func write_voronoi_svg(w io.Writer, cells []VoronoiCell, order map[int]int, canvas plot_canvas) error {
+	fmt.Fprintf(w, "<svg xmlns=\"http://www.w3.org/2000/svg\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">\n",
+		canvas.size, canvas.size, canvas.size, canvas.size)
+
+	for _, c := range cells {
+		col := plot_colors[order[c.Bunch]%len(plot_colors)]
+		hex := fmt.Sprintf("#%02x%02x%02x", col.R, col.G, col.B)
+
+		var points []string
+		for _, v := range c.Vertices {
+			px, py := canvas.to_pixel(v[0], v[1])
+			points = append(points, fmt.Sprintf("%.2f,%.2f", px, py))
+		}
+
+		fmt.Fprintf(w, "<polygon points=\"%s\" fill=\"%s\" fill-opacity=\"0.15\" stroke=\"#404040\" stroke-width=\"0.5\"><title>%s: %d</title></polygon>\n",
+			strings.Join(points, " "), hex, c.Beam.Name, c.Bunch)
+	}
+
+	_, err := fmt.Fprintf(w, "</svg>\n")
+
+	return err
+}
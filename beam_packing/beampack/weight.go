@@ -0,0 +1,84 @@
+package beampack
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// LoadWeights sets the priority weights of the beams from file. Every line
+// holds a beam, given by name or by number, and its weight, separated by a
+// comma or whitespace. Empty lines and lines starting with # are ignored.
+// The beams that are not listed keep their weights.
+func LoadWeights(filename string, beams []Beam) error {
+	f, err := os.Open(filename)
+	if err != nil {
+		return fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	lookup := get_beam_lookup(beams)
+
+	scanner := bufio.NewScanner(f)
+	nr := 0
+
+	for scanner.Scan() {
+		nr++
+		line := strings.TrimSpace(scanner.Text())
+
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.FieldsFunc(line, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\t'
+		})
+
+		if len(fields) != 2 {
+			return fmt.Errorf("%s:%d: expected a beam and its weight", filename, nr)
+		}
+
+		i, ok := lookup(fields[0])
+		if !ok {
+			return fmt.Errorf("%s:%d: unknown beam: %s", filename, nr, fields[0])
+		}
+
+		w, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || w < 0 {
+			return fmt.Errorf("%s:%d: invalid weight: %s", filename, nr, fields[1])
+		}
+
+		beams[i].Weight = w
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Could not read weights: %s", err)
+	}
+
+	return nil
+}
+
+// Cost computes the packing cost, which is the sum of the intra-bunch
+// pairwise distances weighted by the mean priority weight of the two beams.
+// This is half the sum over the beams of their weighted summed distance to
+// the other members of their bunch, so every beam contributes according to
+// its own weight. Without weights, it equals the total intra-bunch distance
+// of the report.
+func Cost(p *Packing, dist DistanceFunc) float64 {
+	var cost float64
+
+	for _, b := range p.Bunches {
+		members := b.Beams
+
+		for i := range members {
+			for j := i + 1; j < len(members); j++ {
+				w := (members[i].weight() + members[j].weight()) / 2
+				cost += w * dist(members[i].X, members[i].Y, members[j].X, members[j].Y)
+			}
+		}
+	}
+
+	return cost
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Parse a comma-separated list of beam numbers.
+func parse_sizes(text string) ([]int, error) {
+	var sizes []int
+
+	for _, field := range strings.Split(text, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(field))
+		if err != nil || n <= 0 {
+			return nil, fmt.Errorf("Invalid number of beams: %s", field)
+		}
+
+		sizes = append(sizes, n)
+	}
+
+	return sizes, nil
+}
+
+// Time the packing methods and the annealing optimizer on synthetic
+// hexagonal tilings of increasing size.
+func run_bench() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	sizes, err := parse_sizes(*benchsizes)
+	if err != nil {
+		fatal(err)
+	}
+
+	tiling, err := get_tile_options()
+	if err != nil {
+		fatal(err)
+	}
+
+	get_seed()
+
+	fmt.Printf("# %6s %-8s %10s %10s %12s %12s\n", "beams", "method", "pack_ms", "anneal_ms", "totdist", "annealed")
+
+	for _, n := range sizes {
+		tiling.NBeams = n
+
+		beams, err := beampack.Tile(tiling)
+		if err != nil {
+			fatal(err)
+		}
+
+		for _, m := range beampack.Methods {
+			rng := rand.New(rand.NewSource(*seed))
+
+			start := time.Now()
+
+			packing, err := beampack.Pack(beams, beampack.Options{
+				NBeams:  n,
+				Bunch:   *bunch,
+				NGroups: *ngroups,
+				Method:  m,
+				Metric:  dist,
+				Seed:    *seed,
+				Rand:    rng,
+			})
+			if err != nil {
+				fatal(err)
+			}
+
+			packed := time.Since(start)
+
+			start = time.Now()
+			annealed := beampack.Anneal(packing, *iterations, dist, rng)
+			elapsed := time.Since(start)
+
+			fmt.Printf("  %6d %-8s %10.1f %10.1f %12.6f %12.6f\n", n, m,
+				packed.Seconds()*1e3, elapsed.Seconds()*1e3,
+				beampack.Score(packing, dist).TotDist, beampack.Score(annealed, dist).TotDist)
+		}
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Bundle the per-beam candidate files in the directory given as argument
+// into one gzipped tar archive per processing node or bunch of the -packing,
+// or the packing of the -in beams, for the transfer off the nodes. The
+// archives and the manifest of all bundles are written to -bundle-dir.
+func run_bundle() {
+	if cmdline.NArg() != 1 {
+		usagef("One candidate directory is required.")
+	}
+
+	if !slices.Contains(beampack.BundleGroupings, *bundleby) {
+		usagef("Unknown bundle grouping: %s", *bundleby)
+	}
+
+	if *bundledir == "" {
+		usagef("No bundle directory given.")
+	}
+
+	dir := cmdline.Arg(0)
+	if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+		fatal(input_error(fmt.Errorf("Not a candidate directory: %s", dir)))
+	}
+
+	packing := get_lookup_packing()
+
+	m, err := beampack.PlanBundles(packing, dir, *bundleby)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	if len(m.Unassigned) > 0 {
+		slog.Warn("Candidate files of no packed beam are not bundled", "files", len(m.Unassigned), "first", m.Unassigned[0])
+	}
+
+	if local, ok := beampack.LocalPath(*bundledir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			fatalf("Could not create bundle directory: %s, %s", *bundledir, err)
+		}
+	}
+
+	var nfiles int
+
+	for i := range m.Bundles {
+		b := &m.Bundles[i]
+		b.Archive = b.Name + ".tar.gz"
+
+		filename := beampack.JoinSink(*bundledir, b.Archive)
+
+		out, err := create_output(filename)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteBundle(out, b)
+		if cerr := out.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write bundle: %s, %s", filename, err)
+		}
+
+		nfiles += len(b.Files)
+		slog.Debug("Wrote bundle", "file", filename, "files", len(b.Files), "size", b.Size)
+	}
+
+	filename := beampack.JoinSink(*bundledir, "manifest.json")
+
+	out, err := create_output(filename)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteBundleManifest(out, m); err != nil {
+		fatalf("Could not write bundle manifest: %s, %s", filename, err)
+	}
+
+	slog.Info("Bundled candidate files", "dir", *bundledir, "bundles", len(m.Bundles), "files", nfiles, "unassigned", len(m.Unassigned))
+}
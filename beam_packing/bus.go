@@ -0,0 +1,106 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Pack a beam configuration received from the message bus. The reply is
+// the packing in the JSON output format, or an object with the error.
+func pack_message(payload string, dist beampack.DistanceFunc) string {
+	reply := func(err error) string {
+		raw, _ := json.Marshal(map[string]string{"error": err.Error()})
+		return string(raw)
+	}
+
+	opts, err := get_load_options()
+	if err != nil {
+		return reply(err)
+	}
+
+	beams, err := beampack.Parse([]byte(payload), "message", opts)
+	if err != nil {
+		return reply(err)
+	}
+
+	mark_incoherent(beams)
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		return reply(err)
+	}
+
+	packing.Provenance = get_data_provenance("message", []byte(payload))
+
+	var buf bytes.Buffer
+
+	if err := beampack.Write(&buf, packing, "json", dist); err != nil {
+		return reply(err)
+	}
+
+	slog.Info("Packed beams", "beams", packing.NBeams(), "bunches", len(packing.Bunches))
+
+	return buf.String()
+}
+
+// Subscribe to new beam configurations and publish the packings until the
+// connection fails.
+func serve_bus(dist beampack.DistanceFunc) error {
+	sub, err := dial_redis(*redisaddr)
+	if err != nil {
+		return err
+	}
+	defer sub.Close()
+
+	pub, err := dial_redis(*redisaddr)
+	if err != nil {
+		return err
+	}
+	defer pub.Close()
+
+	if err := sub.subscribe(*subchannel); err != nil {
+		return err
+	}
+
+	slog.Info("Subscribed", "channel", *subchannel, "server", *redisaddr)
+
+	for {
+		_, payload, err := sub.next_message()
+		if err != nil {
+			return err
+		}
+
+		slog.Info("Received beam configuration", "bytes", len(payload))
+
+		if err := pub.publish(*pubchannel, pack_message(payload, dist)); err != nil {
+			return err
+		}
+	}
+}
+
+// Run the packer on the message bus. It subscribes to the beam
+// configuration channel on Redis, packs every beam configuration it
+// receives (FBFUSE JSON or beam position table) and publishes the beam to
+// bunch to node map on the packing channel. Lost connections are
+// re-established.
+func run_bus() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	const retry = 5 * time.Second
+
+	for {
+		err := serve_bus(dist)
+		slog.Warn("Message bus connection lost", "error", err, "retry", retry)
+
+		time.Sleep(retry)
+	}
+}
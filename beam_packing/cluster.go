@@ -0,0 +1,86 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Cluster the single-pulse candidates in the files given as arguments into
+// unique events. With a packing file, candidates in adjacent beams are
+// grouped together.
+func run_cluster() {
+	if cmdline.NArg() == 0 {
+		usagef("No candidate files given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	var cands []beampack.Candidate
+
+	for _, filename := range cmdline.Args() {
+		c, err := beampack.LoadCandidates(filename)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		cands = append(cands, c...)
+	}
+
+	opts := beampack.ClusterOptions{
+		Method:    *clustermethod,
+		Time:      *clustertime,
+		DM:        *clusterdm,
+		Width:     *clusterwidth,
+		MinPoints: *minpoints,
+	}
+
+	if *packingfile != "" {
+		records, err := beampack.ReadPacking(*packingfile)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		opts.Graph = beampack.Adjacency(beampack.FromRecords(records), *graphsep, dist)
+	}
+
+	clustered, err := beampack.ClusterCandidates(cands, opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	events := beampack.GetEvents(clustered)
+
+	var nnoise int
+	for _, c := range clustered {
+		if c.Event < 0 {
+			nnoise++
+		}
+	}
+
+	slog.Info("Clustered candidates", "candidates", len(clustered), "events", len(events), "noise", nnoise)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if *members {
+		err = beampack.WriteClustered(out, clustered, *format)
+	} else {
+		err = beampack.WriteEvents(out, events, *format)
+	}
+
+	if err != nil {
+		fatalf("Could not write events: %s", err)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Flag the single-pulse candidates in the files given as arguments that are
+// detected simultaneously in many non-adjacent beams as RFI. The beam
+// adjacency is computed from the beam positions in a packing file.
+func run_coincidence() {
+	if *packingfile == "" {
+		usagef("No packing file given.")
+	}
+
+	if cmdline.NArg() == 0 {
+		usagef("No candidate files given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	records, err := beampack.ReadPacking(*packingfile)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	var cands []beampack.Candidate
+
+	for _, filename := range cmdline.Args() {
+		c, err := beampack.LoadCandidates(filename)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		cands = append(cands, c...)
+	}
+
+	g := beampack.Adjacency(beampack.FromRecords(records), *graphsep, dist)
+
+	flagged := beampack.Coincidence(cands, g, beampack.CoincidenceOptions{
+		Window:    *window,
+		DMTol:     *dmtol,
+		MinBeams:  *minbeams,
+		MaxGroups: *maxgroups,
+	})
+
+	var nrfi int
+	for _, f := range flagged {
+		if f.RFI {
+			nrfi++
+		}
+	}
+
+	slog.Info("Applied coincidence filter", "candidates", len(flagged), "rfi", nrfi)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteFlagged(out, flagged, *format); err != nil {
+		fatalf("Could not write candidates: %s", err)
+	}
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// The flags of the command line, which are the ones of the subcommand if
+// one is given.
+var cmdline = flag.CommandLine
+
+// The flags of all subcommands.
+var common_flags = []string{"config", "verbose", "quiet", "log-format", "s3-endpoint", "s3-region", "cpuprofile", "memprofile"}
+
+// The flags that control how the beam positions are read.
+var input_flags = []string{
+	"in", "delimiter", "header", "lenient", "informat", "hdu", "units", "coords", "xcol", "ycol", "namecol",
+	"equinox", "frame", "start", "primary-beam", "pb-fwhm", "portal", "portal-token", "portal-sensors", "http-timeout", "http-retries", "http-retry-wait", "ib-name", "expect-nbeams",
+}
+
+// The flags that control how the beams are packed.
+var packing_flags = []string{
+	"nbeams", "bunch", "ngroups", "remainder", "method", "metric", "dist-cache", "semimajor", "semiminor", "pa",
+	"antennas", "ants", "freq", "hour-angle", "projection", "boresight", "optimize", "iterations", "population", "generations", "chains", "fitness",
+	"progress", "max-runtime", "seed", "ib-policy", "ib-node", "nodes", "capacity", "offline",
+	"constraints", "regions", "weights", "flagged", "dead", "rebalance", "subtilings", "subtiling-eps", "subtiling-min-points", "rates", "balance", "previous", "churn", "pb-weight", "pb-order", "pareto", "pareto-weights",
+}
+
+// The flags that control the outputs of a packing.
+var output_flags = []string{
+	"out", "format", "out-frame", "report", "separations", "sep-bins", "plot", "graph", "graph-sep", "voronoi", "voronoi-radius",
+	"pipeline", "mcast-base", "mcast-port", "mcast-map", "mcast-groups", "db", "obs-id", "loads",
+	"tag-pulsars", "tag-calibrators", "cat-equinox", "radius",
+}
+
+// The flags of the beam shape estimate from the antenna positions.
+var psf_flags = []string{"antennas", "ants", "freq", "hour-angle", "boresight"}
+
+// The flags of the beam tiling.
+var tile_flags = []string{"out", "nbeams", "boresight", "semimajor", "semiminor", "pa", "overlap", "antennas", "ants", "freq", "hour-angle"}
+
+// The flags of the distance metric.
+var metric_flags = []string{"metric", "dist-cache", "semimajor", "semiminor", "pa", "antennas", "ants", "freq", "hour-angle"}
+
+// A subcommand and the flags it accepts.
+type command struct {
+	name  string
+	args  string
+	usage string
+	run   func()
+	flags [][]string
+}
+
+// Get the subcommands.
+func get_commands() []command {
+	return []command{
+		{"pack", "", "Pack the beams into bunches, or every new file in a watched directory.", run_pack,
+			[][]string{input_flags, packing_flags, output_flags, {"watch", "interval", "pattern", "outdir", "name-template", "checkpoint", "checkpoint-interval", "resume"}}},
+		{"tile", "", "Generate a hexagonal beam tiling.", run_tile,
+			[][]string{tile_flags}},
+		{"coverage", "", "Generate the smallest tiling that covers a fraction of the primary beam.", run_coverage,
+			[][]string{tile_flags, {"primary-beam", "pb-fwhm", "pb-level", "cb-level", "coverage", "coverage-curve"}}},
+		{"psf", "", "Estimate the coherent beam shape from the antenna positions.", run_psf,
+			[][]string{psf_flags, {"out"}}},
+		{"simulate", "", "Generate a synthetic beam layout with jitter and missing beams.", run_simulate,
+			[][]string{tile_flags, {"jitter", "missing", "seed"}}},
+		{"plot", "PACKING", "Plot a packing file to the -out file (svg or png).", run_plot,
+			[][]string{{"out"}}},
+		{"validate", "PACKING", "Check a packing file against the beam positions.", run_validate,
+			[][]string{input_flags, metric_flags, {"bunch", "ngroups", "remainder", "max-radius", "flagged", "dead", "packing", "out"}}},
+		{"diff", "OLD NEW", "Compare two packing files.", run_diff,
+			[][]string{{"out"}}},
+		{"load", "PACKING", "Estimate the processing load of the nodes of a packing file.", run_load,
+			[][]string{{"rates", "nodes", "capacity", "offline", "throughput", "max-utilization", "out"}}},
+		{"stats", "[PACKING]", "Report the quality of a packing file, or the spacing diagnostics of the beams.", run_stats,
+			[][]string{input_flags, metric_flags, {"out", "separations", "sep-bins", "dup-tol", "outlier-factor"}}},
+		{"serve", "", "Run the packer as HTTP service.", run_serve,
+			[][]string{packing_flags, {"listen", "pprof", "dashboard"}}},
+		{"batch", "", "Pack every matching file in a directory.", run_batch,
+			[][]string{input_flags, packing_flags, {"format", "out-frame", "indir", "outdir", "name-template", "pattern", "workers", "summary", "tag-pulsars", "tag-calibrators", "cat-equinox", "radius"}}},
+		{"bench", "", "Benchmark the packing methods on synthetic tilings.", run_bench,
+			[][]string{tile_flags, {"bunch", "ngroups", "iterations", "seed", "bench-sizes"}}},
+		{"mosaic", "POINTING...", "Pack the beams of several pointings together.", run_mosaic,
+			[][]string{input_flags, packing_flags, output_flags}},
+		{"bus", "", "Pack the beam configurations received from the message bus.", run_bus,
+			[][]string{input_flags, packing_flags, {"redis", "subscribe", "publish"}}},
+		{"match", "", "Match a source catalogue against the packed beams.", run_match,
+			[][]string{input_flags, packing_flags, {"catalogue", "cat-equinox", "radius", "out"}}},
+		{"which-beam", "RA,DEC...", "Report the beams nearest to sky positions and their bunches and nodes.", run_which_beam,
+			[][]string{input_flags, packing_flags, {"packing", "catalogue", "cat-equinox", "radius", "nearest", "out", "format"}}},
+		{"crossmatch", "CANDIDATES...", "Annotate single-pulse candidates with their bunches.", run_crossmatch,
+			[][]string{{"packing", "out", "format"}}},
+		{"coincidence", "CANDIDATES...", "Filter multibeam coincident candidates.", run_coincidence,
+			[][]string{{"packing", "out", "format", "window", "dm-tol", "min-beams", "max-groups", "graph-sep"}}},
+		{"ibmatch", "CANDIDATES...", "Match coherent beam candidates against the incoherent beam.", run_ibmatch,
+			[][]string{{"ib-cands", "packing", "window", "dm-tol", "out", "format"}}},
+		{"tui", "", "Inspect and edit a packing interactively.", run_tui,
+			[][]string{input_flags, packing_flags, output_flags, {"tui-width"}}},
+		{"epochs", "", "Evaluate the packing as the beam shape changes during an observation.", run_epochs,
+			[][]string{input_flags, packing_flags, {"out", "format", "out-frame", "start", "duration", "epochs", "degrade", "epoch-dir"}}},
+		{"query", "", "List or output the packings stored in a packing database.", run_query,
+			[][]string{metric_flags, {"db", "obs-id", "query-id", "at", "out", "format", "out-frame"}}},
+		{"localize", "DETECTIONS", "Localize a source from its S/N in several beams.", run_localize,
+			[][]string{input_flags, psf_flags, {"semimajor", "semiminor", "pa", "projection", "boresight", "loc-grid", "loc-map", "loc-nondetections", "out", "format"}}},
+		{"cluster", "CANDIDATES...", "Cluster single-pulse candidates into unique events.", run_cluster,
+			[][]string{metric_flags, {"packing", "graph-sep", "cluster-method", "cluster-time", "cluster-dm", "cluster-width", "min-points", "members", "out", "format"}}},
+		{"filinfo", "FILTERBANK...", "Print the headers of SIGPROC filterbank files.", run_filinfo,
+			[][]string{{"out", "format"}}},
+		{"dm-plan", "PACKING", "Split the DM trials across the bunches and workers of every node.", run_dm_plan,
+			[][]string{{"dm-plan", "dm-workers", "dm-schedules", "out", "format"}}},
+		{"summary", "", "Summarise an observation from its schedule block and packed beams.", run_summary,
+			[][]string{input_flags, packing_flags, {"sb", "max-target-sep", "out", "format"}}},
+		{"drift", "RA,DEC...", "Simulate when targets drift out of their beams during an observation.", run_drift,
+			[][]string{input_flags, packing_flags, {"packing", "catalogue", "cat-equinox", "start", "duration", "drift-step", "out", "format"}}},
+		{"overlap", "", "Compute the fractional overlaps of neighbouring beams as sparse matrix.", run_overlap,
+			[][]string{input_flags, {"semimajor", "semiminor", "pa", "min-overlap", "out", "format"}}},
+		{"convert", "", "Convert beam files between the dat and FBFUSE JSON formats.", run_convert,
+			[][]string{input_flags, {"to", "out"}}},
+		{"bundle", "CANDIDATE_DIR", "Bundle the candidate files into one archive per node or bunch for transfer.", run_bundle,
+			[][]string{input_flags, packing_flags, {"packing", "bundle-dir", "bundle-by"}}},
+		{"healpix", "[POINTING...]", "Rasterise the beam footprints of the pointings onto a HEALPix coverage map.", run_healpix,
+			[][]string{input_flags, {"semimajor", "semiminor", "pa", "nside", "healpix-order", "healpix-add", "out"}}},
+	}
+}
+
+// Look up a subcommand by name.
+func find_command(name string) (command, bool) {
+	for _, cmd := range get_commands() {
+		if cmd.name == name {
+			return cmd, true
+		}
+	}
+
+	return command{}, false
+}
+
+// The name of the program in the usage messages.
+func get_program() string {
+	return filepath.Base(os.Args[0])
+}
+
+// Print the list of subcommands.
+func usage() {
+	w := flag.CommandLine.Output()
+
+	fmt.Fprintf(w, "Usage: %s <command> [flags] [arguments]\n\nCommands:\n", get_program())
+
+	for _, cmd := range get_commands() {
+		fmt.Fprintf(w, "  %-12s %s\n", cmd.name, cmd.usage)
+	}
+
+	fmt.Fprintf(w, "\nRun '%s help <command>' for the flags of a command. Without command, the operation is selected with -mode and all flags are accepted.\n", get_program())
+}
+
+// Get the flag set of a subcommand. The flags are the ones of the command
+// line, so that a subcommand sets the same settings.
+func get_flag_set(cmd command) *flag.FlagSet {
+	fs := flag.NewFlagSet(cmd.name, flag.ExitOnError)
+	seen := make(map[string]bool)
+
+	for _, names := range append([][]string{common_flags}, cmd.flags...) {
+		for _, name := range names {
+			f := flag.Lookup(name)
+			if f == nil {
+				panic("unknown flag: " + name)
+			}
+
+			if !seen[name] {
+				fs.Var(f.Value, f.Name, f.Usage)
+				seen[name] = true
+			}
+		}
+	}
+
+	fs.Usage = func() {
+		w := fs.Output()
+
+		fmt.Fprintf(w, "Usage: %s %s [flags] %s\n\n%s\n\nFlags:\n", get_program(), cmd.name, cmd.args, cmd.usage)
+		fs.PrintDefaults()
+	}
+
+	return fs
+}
+
+// Print the usage of a subcommand, or the list of subcommands.
+func run_help(args []string) {
+	if len(args) == 0 {
+		usage()
+		return
+	}
+
+	cmd, ok := find_command(args[0])
+	if !ok {
+		usagef("Unknown command: %s", args[0])
+	}
+
+	fs := get_flag_set(cmd)
+	fs.SetOutput(os.Stdout)
+	fs.Usage()
+}
+
+// Parse the command line. The first argument selects the subcommand, unless
+// it is a flag, in which case all flags are accepted and the operation is
+// selected with -mode.
+func parse_command_line() {
+	flag.Usage = func() {
+		usage()
+		fmt.Fprintf(flag.CommandLine.Output(), "\nFlags:\n")
+		flag.PrintDefaults()
+	}
+
+	if len(os.Args) < 2 || strings.HasPrefix(os.Args[1], "-") {
+		flag.Parse()
+		return
+	}
+
+	name := os.Args[1]
+
+	if name == "help" {
+		run_help(os.Args[2:])
+		os.Exit(0)
+	}
+
+	cmd, ok := find_command(name)
+	if !ok {
+		fmt.Fprintf(flag.CommandLine.Output(), "Unknown command: %s\n\n", name)
+		usage()
+		os.Exit(exit_usage)
+	}
+
+	cmdline = get_flag_set(cmd)
+	cmdline.Parse(os.Args[2:])
+
+	*mode = name
+}
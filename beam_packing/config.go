@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Strip a trailing comment that is not part of a quoted string.
+func strip_comment(line string) string {
+	quote := rune(0)
+
+	for i, r := range line {
+		switch {
+		case quote != 0 && r == quote:
+			quote = 0
+		case quote == 0 && (r == '"' || r == '\''):
+			quote = r
+		case quote == 0 && r == '#':
+			return line[:i]
+		}
+	}
+
+	return line
+}
+
+// Whether the configuration value is a quoted string.
+func is_quoted(value string) bool {
+	if len(value) < 2 {
+		return false
+	}
+
+	return (value[0] == '"' && value[len(value)-1] == '"') || (value[0] == '\'' && value[len(value)-1] == '\'')
+}
+
+// Convert a scalar or inline array configuration value to its flag value.
+// Arrays are joined with commas.
+func parse_config_value(value string) string {
+	value = strings.TrimSpace(value)
+
+	if strings.HasPrefix(value, "[") && strings.HasSuffix(value, "]") {
+		var items []string
+
+		for _, item := range strings.Split(value[1:len(value)-1], ",") {
+			item = parse_config_value(item)
+			if item != "" {
+				items = append(items, item)
+			}
+		}
+
+		return strings.Join(items, ",")
+	}
+
+	if is_quoted(value) {
+		return value[1 : len(value)-1]
+	}
+
+	return value
+}
+
+// Read the settings from a flat YAML or TOML configuration file. The keys are
+// the command-line flag names. Only a flat subset of both formats is
+// supported: one "key: value" or "key = value" setting per line, with scalar
+// or inline array values. YAML block lists and TOML arrays, which may span
+// several lines, are joined with commas. TOML sections and nested YAML
+// mappings are rejected, as they have no flag equivalent.
+func load_config(filename string) (map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+
+	defer f.Close()
+
+	sep := ":"
+
+	switch strings.ToLower(filepath.Ext(filename)) {
+	case ".toml":
+		sep = "="
+	case ".yaml", ".yml":
+	default:
+		return nil, fmt.Errorf("Unknown configuration file format: %s", filename)
+	}
+
+	settings := make(map[string]string)
+	var list string
+	nr := 0
+
+	// a TOML array that spans several lines
+	var array, arraykey string
+	var arraynr int
+
+	invalid := func(what string, line string) error {
+		return fmt.Errorf("%s:%d: %s: %q", filename, nr, what, line)
+	}
+
+	scanner := bufio.NewScanner(f)
+
+	for scanner.Scan() {
+		nr++
+		raw := strip_comment(scanner.Text())
+		line := strings.TrimSpace(raw)
+
+		if array != "" {
+			array += " " + line
+
+			if strings.HasSuffix(line, "]") {
+				settings[arraykey] = parse_config_value(array)
+				array = ""
+			}
+
+			continue
+		}
+
+		if line == "" || line == "---" {
+			continue
+		}
+
+		if sep == "=" && strings.HasPrefix(line, "[") {
+			return nil, invalid("TOML sections are not supported", line)
+		}
+
+		indented := len(raw) > len(strings.TrimLeft(raw, " \t"))
+
+		// items of a YAML block list
+		if sep == ":" && (line == "-" || strings.HasPrefix(line, "- ")) {
+			item := strings.TrimSpace(line[1:])
+
+			if list == "" {
+				return nil, invalid("list item without setting", line)
+			}
+
+			if strings.HasPrefix(item, "{") || strings.Contains(item, ": ") || strings.HasSuffix(item, ":") {
+				if !is_quoted(item) {
+					return nil, invalid("nested YAML mappings are not supported", line)
+				}
+			}
+
+			item = parse_config_value(item)
+
+			if settings[list] == "" {
+				settings[list] = item
+			} else {
+				settings[list] += "," + item
+			}
+
+			continue
+		}
+
+		if sep == ":" && indented {
+			return nil, invalid("nested YAML mappings are not supported", line)
+		}
+
+		key, value, found := strings.Cut(line, sep)
+		if !found {
+			return nil, invalid("invalid setting", line)
+		}
+
+		key = strings.TrimSpace(key)
+		value = strings.TrimSpace(value)
+
+		if strings.HasPrefix(value, "{") {
+			return nil, invalid("inline tables are not supported", line)
+		}
+
+		if sep == "=" && strings.HasPrefix(value, "[") && !strings.HasSuffix(value, "]") {
+			array, arraykey, arraynr = value, key, nr
+			continue
+		}
+
+		settings[key] = parse_config_value(value)
+
+		list = ""
+		if sep == ":" && value == "" {
+			list = key
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Could not read configuration: %s", err)
+	}
+
+	if array != "" {
+		return nil, fmt.Errorf("%s:%d: unterminated array: %s", filename, arraynr, arraykey)
+	}
+
+	return settings, nil
+}
+
+// Apply the settings from the configuration file to all flags of the
+// subcommand that were not given on the command line.
+func apply_config(filename string) error {
+	settings, err := load_config(filename)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	cmdline.Visit(func(f *flag.Flag) {
+		given[f.Name] = true
+	})
+
+	for key, value := range settings {
+		if flag.Lookup(key) == nil || key == "config" {
+			return fmt.Errorf("Unknown setting in configuration file: %s, %s", filename, key)
+		}
+
+		// a configuration file may be shared by several subcommands, so
+		// the settings of the others are ignored
+		if given[key] || cmdline.Lookup(key) == nil {
+			continue
+		}
+
+		if err := cmdline.Set(key, value); err != nil {
+			return fmt.Errorf("Invalid setting in configuration file: %s, %s: %s", filename, key, err)
+		}
+	}
+
+	return nil
+}
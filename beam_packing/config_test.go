@@ -0,0 +1,91 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func write_config(t *testing.T, name string, content string) string {
+	t.Helper()
+
+	filename := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(filename, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	return filename
+}
+
+func TestLoadConfig(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		want    map[string]string
+	}{
+		{
+			"flat.yaml",
+			"---\nin: beams.dat # the input\nmethod: 'kmeans'\nbunch: 6\nout: \"a#b.json\"\n",
+			map[string]string{"in": "beams.dat", "method": "kmeans", "bunch": "6", "out": "a#b.json"},
+		},
+		{
+			"list.yml",
+			"offline:\n  - tpn-0-2\n  - \"tpn-0-3\"\nnodes: [n1, n2]\n",
+			map[string]string{"offline": "tpn-0-2,tpn-0-3", "nodes": "n1,n2"},
+		},
+		{
+			"flat.toml",
+			"in = \"beams.dat\"\nbunch = 6\noffline = [\"tpn-0-2\", \"tpn-0-3\"]\n",
+			map[string]string{"in": "beams.dat", "bunch": "6", "offline": "tpn-0-2,tpn-0-3"},
+		},
+		{
+			"multiline.toml",
+			"offline = [\n  \"tpn-0-2\", # broken\n  \"tpn-0-3\",\n]\nbunch = 6\n",
+			map[string]string{"offline": "tpn-0-2,tpn-0-3", "bunch": "6"},
+		},
+	}
+
+	for _, c := range cases {
+		got, err := load_config(write_config(t, c.name, c.content))
+		if err != nil {
+			t.Errorf("%s: %s", c.name, err)
+			continue
+		}
+
+		if !reflect.DeepEqual(got, c.want) {
+			t.Errorf("%s: got %v, want %v", c.name, got, c.want)
+		}
+	}
+}
+
+func TestLoadConfigUnsupported(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		line    string
+	}{
+		{"section.toml", "bunch = 6\n[pack]\nmethod = \"kmeans\"\n", ":2:"},
+		{"tables.toml", "[[nodes]]\nname = \"n1\"\n", ":1:"},
+		{"inline.toml", "nodes = { a = 1 }\n", ":1:"},
+		{"unterminated.toml", "bunch = 6\noffline = [\n\"n1\",\n", ":2:"},
+		{"nested.yaml", "pack:\n  method: kmeans\n", ":2:"},
+		{"listmap.yaml", "nodes:\n  - name: n1\n", ":2:"},
+		{"item.yaml", "- n1\n", ":1:"},
+		{"flow.yaml", "pack: {method: kmeans}\n", ":1:"},
+		{"invalid.yaml", "bunch 6\n", ":1:"},
+	}
+
+	for _, c := range cases {
+		_, err := load_config(write_config(t, c.name, c.content))
+		if err == nil {
+			t.Errorf("%s: no error", c.name)
+			continue
+		}
+
+		if !strings.Contains(err.Error(), c.line) {
+			t.Errorf("%s: error does not name line %s: %s", c.name, c.line, err)
+		}
+	}
+}
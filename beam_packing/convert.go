@@ -0,0 +1,72 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Get the output beam format of convert mode. In auto mode, it follows
+// from the extension of the output file, or else is the other one of the
+// input format.
+func get_convert_format() string {
+	if *convertto != "auto" {
+		return *convertto
+	}
+
+	switch strings.ToLower(filepath.Ext(*outfile)) {
+	case ".json":
+		return "fbfuse"
+	case ".dat", ".txt", ".tsv":
+		return "dat"
+	}
+
+	input := *informat
+	if input == "auto" {
+		input = beampack.InputFormat(*infile)
+	}
+
+	if input == "fbfuse" {
+		return "dat"
+	}
+
+	return "fbfuse"
+}
+
+// Convert the beams of the -in file between the tab-separated beam position
+// format and FBFUSE beam configuration JSON, keeping the beam names, shapes
+// and priority weights.
+func run_convert() {
+	if *convertto != "auto" && !slices.Contains(beampack.BeamFormats, *convertto) {
+		usagef("Unknown beam format: %s", *convertto)
+	}
+
+	to := get_convert_format()
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	switch to {
+	case "dat":
+		err = beampack.WriteDat(out, beams)
+	case "fbfuse":
+		err = beampack.WriteFBFUSE(out, beams)
+	}
+
+	if err != nil {
+		fatalf("Could not write beams: %s", err)
+	}
+
+	slog.Info("Converted beams", "beams", len(beams), "format", to)
+}
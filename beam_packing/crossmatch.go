@@ -0,0 +1,63 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Annotate the single-pulse candidates in the files given as arguments with
+// the bunch, node and sky position of their beams from a packing file.
+func run_crossmatch() {
+	if *packingfile == "" {
+		usagef("No packing file given.")
+	}
+
+	if cmdline.NArg() == 0 {
+		usagef("No candidate files given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	records, err := beampack.ReadPacking(*packingfile)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	var cands []beampack.Candidate
+
+	for _, filename := range cmdline.Args() {
+		c, err := beampack.LoadCandidates(filename)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		cands = append(cands, c...)
+	}
+
+	annotated := beampack.CrossMatch(cands, records)
+
+	var missing int
+	for _, a := range annotated {
+		if !a.Found {
+			missing++
+		}
+	}
+
+	if missing > 0 {
+		slog.Warn("Candidates in beams that are not part of the packing", "candidates", missing)
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteAnnotated(out, annotated, *format); err != nil {
+		fatalf("Could not write candidates: %s", err)
+	}
+}
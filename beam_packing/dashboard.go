@@ -0,0 +1,308 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"html/template"
+	"log/slog"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The size of the tiling plot of the dashboard in pixels.
+const dashboard_plot_size = 640
+
+// The interval of the keep-alive comments of the dashboard event stream.
+const dashboard_keepalive = 30 * time.Second
+
+// The last packing of the service, which the dashboard shows, and the
+// dashboards that wait for the next one.
+type dashboard_state struct {
+	mu sync.Mutex
+
+	packing  *beampack.Packing
+	report   beampack.Report
+	updated  time.Time
+	client   string
+	version  int64
+	watchers map[chan int64]bool
+}
+
+var dashboard = &dashboard_state{watchers: make(map[chan int64]bool)}
+
+// Record a new packing and notify the dashboards.
+func (d *dashboard_state) update(packing *beampack.Packing, dist beampack.DistanceFunc, client string) {
+	report := beampack.Score(packing, dist)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.packing = packing
+	d.report = report
+	d.updated = time.Now()
+	d.client = client
+	d.version++
+
+	for ch := range d.watchers {
+		select {
+		case ch <- d.version:
+		default:
+		}
+	}
+}
+
+// Register a dashboard that waits for new packings.
+func (d *dashboard_state) watch() chan int64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	ch := make(chan int64, 1)
+	d.watchers[ch] = true
+
+	return ch
+}
+
+func (d *dashboard_state) unwatch(ch chan int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delete(d.watchers, ch)
+}
+
+// A row of the bunch table of the dashboard.
+type dashboard_bunch struct {
+	ID      int
+	Node    string
+	Color   string
+	Beams   int
+	MaxSep  float64
+	MeanSep float64
+	Radius  float64
+	Area    float64
+}
+
+// A row of the node assignment table of the dashboard.
+type dashboard_node struct {
+	Node    string
+	Bunches []int
+	Beams   int
+}
+
+// The data of the dashboard page.
+type dashboard_page struct {
+	Version int64
+	Updated time.Time
+	Age     time.Duration
+	Client  string
+	Method  string
+	Seed    int64
+	Report  beampack.Report
+	Bunches []dashboard_bunch
+	Nodes   []dashboard_node
+}
+
+// Get the data of the dashboard page for the last packing, or nil if there
+// is none yet.
+func (d *dashboard_state) get_page() (*dashboard_page, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if d.packing == nil {
+		return nil, nil
+	}
+
+	page := &dashboard_page{
+		Version: d.version,
+		Updated: d.updated,
+		Age:     time.Since(d.updated).Round(time.Second),
+		Client:  d.client,
+		Method:  d.packing.Method,
+		Seed:    d.packing.Seed,
+		Report:  d.report,
+	}
+
+	stats := make(map[int]beampack.BunchStats)
+	for _, s := range d.report.Bunches {
+		stats[s.ID] = s
+	}
+
+	bynode := make(map[string]int)
+
+	for i, b := range d.packing.Bunches {
+		s := stats[b.ID]
+
+		// the colours of the plot
+		c := beampack.PlotColor(i)
+
+		var nbeams int
+		for _, beam := range b.Beams {
+			if !beam.Dummy {
+				nbeams++
+			}
+		}
+
+		page.Bunches = append(page.Bunches, dashboard_bunch{
+			ID:      b.ID,
+			Node:    b.Node,
+			Color:   c,
+			Beams:   nbeams,
+			MaxSep:  s.MaxSep,
+			MeanSep: s.MeanSep,
+			Radius:  s.Circle.Radius,
+			Area:    s.Area,
+		})
+
+		n, ok := bynode[b.Node]
+		if !ok {
+			n = len(page.Nodes)
+			page.Nodes = append(page.Nodes, dashboard_node{Node: b.Node})
+			bynode[b.Node] = n
+		}
+
+		page.Nodes[n].Bunches = append(page.Nodes[n].Bunches, b.ID)
+		page.Nodes[n].Beams += nbeams
+	}
+
+	sort.SliceStable(page.Nodes, func(i, j int) bool {
+		return page.Nodes[i].Node < page.Nodes[j].Node
+	})
+
+	return page, nil
+}
+
+var dashboard_template = template.Must(template.New("dashboard").Funcs(template.FuncMap{
+	"deg":      func(v float64) string { return fmt.Sprintf("%.4f", v) },
+	"area":     func(v float64) string { return fmt.Sprintf("%.3g", v) },
+	"plotsize": func() int { return dashboard_plot_size },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Beam packing</title>
+<style>
+body { font-family: sans-serif; margin: 1em 2em; }
+.panels { display: flex; flex-wrap: wrap; gap: 2em; align-items: flex-start; }
+table { border-collapse: collapse; }
+th, td { padding: 0.15em 0.6em; text-align: right; border-bottom: 1px solid #ddd; }
+th { background: #f4f4f4; }
+td.name { text-align: left; }
+.swatch { display: inline-block; width: 0.9em; height: 0.9em; vertical-align: middle; }
+.tables { max-height: 90vh; overflow-y: auto; }
+</style>
+</head>
+<body>
+<h1>Beam packing</h1>
+{{if .}}
+<p>Packing {{.Version}} at {{.Updated.UTC.Format "2006-01-02 15:04:05"}} UTC ({{.Age}} ago){{if .Client}} for {{.Client}}{{end}},
+method {{.Method}}, seed {{.Seed}}: {{.Report.NBeams}} beams in {{len .Bunches}} bunches of {{.Report.MinSize}} to {{.Report.MaxSize}} beams,
+max separation {{deg .Report.MaxSep}}, mean {{deg .Report.MeanSep}}, 95th percentile {{deg .Report.P95Sep}}, total {{deg .Report.TotDist}} deg.</p>
+<div class="panels">
+<div><img src="/dashboard/plot.svg?v={{.Version}}" width="{{plotsize}}" height="{{plotsize}}" alt="Tiling of packing {{.Version}}"></div>
+<div class="tables">
+<h2>Nodes</h2>
+<table>
+<tr><th>Node</th><th>Bunches</th><th>Beams</th></tr>
+{{range .Nodes}}<tr><td class="name">{{if .Node}}{{.Node}}{{else}}-{{end}}</td><td class="name">{{range $i, $b := .Bunches}}{{if $i}}, {{end}}{{$b}}{{end}}</td><td>{{.Beams}}</td></tr>
+{{end}}</table>
+<h2>Bunches</h2>
+<table>
+<tr><th>Bunch</th><th>Node</th><th>Beams</th><th>Max sep</th><th>Mean sep</th><th>Radius</th><th>Area</th></tr>
+{{range .Bunches}}<tr><td><span class="swatch" style="background: {{.Color}}"></span> {{.ID}}</td><td class="name">{{.Node}}</td><td>{{.Beams}}</td><td>{{deg .MaxSep}}</td><td>{{deg .MeanSep}}</td><td>{{deg .Radius}}</td><td>{{area .Area}}</td></tr>
+{{end}}</table>
+</div>
+</div>
+{{else}}
+<p>No packing has been computed yet.</p>
+{{end}}
+<script>
+new EventSource("/dashboard/events").addEventListener("packing", function() { location.reload(); });
+</script>
+</body>
+</html>
+`))
+
+// Serve the dashboard page of the last packing.
+func handle_dashboard(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" && r.URL.Path != "/dashboard" {
+		http.NotFound(w, r)
+		return
+	}
+
+	page, err := dashboard.get_page()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := dashboard_template.Execute(&b, page); err != nil {
+		slog.Error("Could not render dashboard", "error", err)
+		http.Error(w, "Could not render dashboard.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Write(b.Bytes())
+}
+
+// Serve the tiling plot of the last packing as SVG. The dashboard shows it as
+// image rather than inline, so that nothing in it is run as part of the page.
+func handle_dashboard_plot(w http.ResponseWriter, r *http.Request) {
+	dashboard.mu.Lock()
+	packing := dashboard.packing
+	dashboard.mu.Unlock()
+
+	if packing == nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	var b bytes.Buffer
+	if err := beampack.PlotSVG(&b, packing, dashboard_plot_size); err != nil {
+		slog.Error("Could not render dashboard plot", "error", err)
+		http.Error(w, "Could not render plot.", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "image/svg+xml")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'unsafe-inline'")
+	w.Write(b.Bytes())
+}
+
+// Stream an event to the dashboards whenever a new packing is computed, as
+// server-sent events.
+func handle_dashboard_events(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported.", http.StatusInternalServerError)
+		return
+	}
+
+	ch := dashboard.watch()
+	defer dashboard.unwatch(ch)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(dashboard_keepalive)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case version := <-ch:
+			fmt.Fprintf(w, "event: packing\ndata: %d\n\n", version)
+		case <-ticker.C:
+			fmt.Fprintf(w, ": keep-alive\n\n")
+		}
+
+		flusher.Flush()
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Compare the two packing output files given as arguments and report what
+// changed.
+func run_diff() {
+	if cmdline.NArg() != 2 {
+		usagef("Two packing files are required: OLD NEW")
+	}
+
+	old, err := beampack.ReadPacking(cmdline.Arg(0))
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	new, err := beampack.ReadPacking(cmdline.Arg(1))
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteDiff(out, beampack.Compare(old, new)); err != nil {
+		fatalf("Could not write diff: %s", err)
+	}
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Plan the dedispersion of the packing file given as argument: split the DM
+// trials of the -dm-plan across the workers of every node and write the
+// per-node schedules.
+func run_dm_plan() {
+	packing := read_packing_arg()
+
+	if *dmplanfile == "" {
+		usagef("No DM plan given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	if *dmworkers < 1 {
+		usagef("The number of DM workers must be positive: %d", *dmworkers)
+	}
+
+	plan, err := beampack.LoadDMPlan(*dmplanfile)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	schedules, err := beampack.DMSchedules(packing, plan, beampack.DMScheduleOptions{Workers: *dmworkers})
+	if err != nil {
+		fatal(err)
+	}
+
+	for _, s := range schedules {
+		slog.Info("Scheduled DM trials", "node", s.Node, "bunches", len(s.Bunches), "beams", s.Beams,
+			"cost", s.Cost, "imbalance", s.Imbalance)
+	}
+
+	if *dmschedules != "" {
+		if err := write_dm_schedules(schedules, *dmschedules); err != nil {
+			fatal(fmt.Errorf("Could not write DM schedules: %w", err))
+		}
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteDMSchedules(out, schedules, *format); err != nil {
+		fatalf("Could not write DM schedules: %s", err)
+	}
+}
+
+// Write the DM schedule of every node into the directory. The files are
+// named after the nodes, or after the bunch for bunches without node, as the
+// pipeline configurations.
+func write_dm_schedules(schedules []beampack.DMSchedule, dir string) error {
+	if local, ok := beampack.LocalPath(dir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			return err
+		}
+	}
+
+	for _, s := range schedules {
+		name := s.Node
+		if name == "" {
+			name = fmt.Sprintf("bunch%03d", s.Bunches[0])
+		}
+
+		filename := beampack.JoinSink(dir, name+".yaml")
+
+		f, err := create_output(filename)
+		if err != nil {
+			return err
+		}
+
+		err = beampack.WriteDMSchedule(f, s)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	slog.Info("Wrote DM schedules", "dir", dir, "nodes", len(schedules))
+
+	return nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Simulate the drift of the targets given as arguments or in the -catalogue
+// out of their beams, as the beam footprints evolve over the observation.
+// The footprints take the modelled beam shape at the boresight every
+// -drift-step from the start over the duration.
+func run_drift() {
+	if cmdline.NArg() == 0 && *catalogue == "" {
+		usagef("No positions or source catalogue given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	if *driftstep <= 0 || *duration < 0 {
+		usagef("The drift step and duration must be positive: %s, %s", *driftstep, *duration)
+	}
+
+	start, err := get_start_time()
+	if err != nil {
+		fatal(classify(exit_usage, err))
+	}
+
+	sources := get_sources()
+	packing := get_lookup_packing()
+
+	var beams []beampack.Beam
+	for _, b := range packing.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				beams = append(beams, beam)
+			}
+		}
+	}
+
+	if len(beams) == 0 {
+		fatalf("No coherent beams to simulate.")
+	}
+
+	centre := beampack.GetBoresight(beams)
+	if is_set("boresight") {
+		ra, dec, err := parse_position(*boresight)
+		if err != nil {
+			fatal(err)
+		}
+
+		centre = beampack.Tangent{RA: ra, Dec: dec}
+	}
+
+	// the nominal beam shape from the input or the settings
+	a, b, angle, ok := beampack.MeanShape(beams)
+	if !ok {
+		a, b, angle = *semimajor, *semiminor, *pa
+	}
+
+	n := int(*duration / *driftstep)
+	end := start.Add(time.Duration(n) * *driftstep)
+
+	epochs := beampack.GetEpochs(beampack.MeerKAT, centre.RA, centre.Dec, start, end.Sub(start), n+1, a, b, angle)
+
+	list := beampack.SimulateDrift(packing, sources, epochs)
+
+	counts := make(map[string]int)
+	first := -1.0
+
+	for _, d := range list {
+		counts[d.Status]++
+
+		if d.Epoch >= 0 && (first < 0 || d.Elapsed < first) {
+			first = d.Elapsed
+		}
+	}
+
+	slog.Info("Simulated beam drift", "targets", len(list), "epochs", len(epochs), "inside", counts["inside"],
+		"drifted", counts["drifted"], "gap", counts["gap"], "outside", counts["outside"])
+
+	if first >= 0 {
+		slog.Warn("Targets drift out of their beams, retile before", "after", time.Duration(first*float64(time.Second)))
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteDrift(out, list, *format); err != nil {
+		fatalf("Could not write beam drift: %s", err)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Get the start time of the observation, by default now.
+func get_start_time() (time.Time, error) {
+	if *starttime == "" {
+		return time.Now().UTC(), nil
+	}
+
+	t, err := time.Parse(time.RFC3339, *starttime)
+	if err != nil {
+		return t, fmt.Errorf("Invalid start time: %s, %s", *starttime, err)
+	}
+
+	return t, nil
+}
+
+// Write the repacking of an epoch into the epoch directory.
+func write_epoch_packing(packing *beampack.Packing, nr int, dist beampack.DistanceFunc) error {
+	filename := beampack.JoinSink(*epochdir, fmt.Sprintf("epoch%03d.%s", nr, get_format_ext()))
+
+	f, err := create_output(filename)
+	if err != nil {
+		return err
+	}
+
+	err = write_output(f, packing, *format, dist)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Evaluate the packing at several epochs of an observation, as the beam
+// shape changes with hour angle. The beams are packed for the beam shape at
+// the start and that packing is compared at every epoch with a repacking
+// for the beam shape of the epoch. Epochs at which the mean intra-bunch
+// separation of the initial packing exceeds the one of the repacking by more
+// than the degradation threshold are flagged, as are the epochs at which the
+// boresight has set.
+func run_epochs() {
+	if _, err := check_settings(); err != nil {
+		fatal(err)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	start, err := get_start_time()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *nepochs < 1 {
+		usagef("The number of epochs must be positive: %d", *nepochs)
+	}
+
+	centre := beampack.GetBoresight(beams)
+	if is_set("boresight") {
+		ra, dec, err := parse_position(*boresight)
+		if err != nil {
+			fatal(err)
+		}
+
+		centre = beampack.Tangent{RA: ra, Dec: dec}
+	}
+
+	// the nominal beam shape from the input or the settings
+	a, b, angle, ok := beampack.MeanShape(beams)
+	if !ok {
+		a, b, angle = *semimajor, *semiminor, *pa
+	}
+
+	epochs := beampack.GetEpochs(beampack.MeerKAT, centre.RA, centre.Dec, start, *duration, *nepochs, a, b, angle)
+
+	s := get_seed()
+
+	initial, err := compute_packing(beams, epochs[0].Metric(), s)
+	if err != nil {
+		fatal(err)
+	}
+
+	if local, ok := beampack.LocalPath(*epochdir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			fatal(err)
+		}
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	fmt.Fprintf(out, "# boresight: %.6f, %.6f, start: %s, duration: %s\n", centre.RA, centre.Dec, start.Format(time.RFC3339), *duration)
+	fmt.Fprintf(out, "%5s %-20s %8s %8s %8s %10s %10s %8s %12s %12s %8s %8s\n",
+		"epoch", "time", "ha", "el", "parang", "semimajor", "semiminor", "pa", "mean_sep", "repacked", "ratio", "status")
+
+	ndegraded := 0
+
+	for i, e := range epochs {
+		dist := e.Metric()
+
+		repacked, err := compute_packing(beams, dist, s)
+		if err != nil {
+			fatal(err)
+		}
+
+		current := beampack.Score(initial, dist).MeanSep
+		best := beampack.Score(repacked, dist).MeanSep
+
+		ratio := 1.0
+		if best > 0 {
+			ratio = current / best
+		}
+
+		status := "ok"
+		switch {
+		case e.Elevation < 0:
+			status = "set"
+		case ratio > *degrade:
+			status = "degraded"
+			ndegraded++
+		}
+
+		fmt.Fprintf(out, "%5d %-20s %8.3f %8.2f %8.2f %10.6f %10.6f %8.2f %12.6f %12.6f %8.4f %8s\n",
+			i, e.Time.UTC().Format(time.RFC3339), e.HourAngle, e.Elevation, e.Parallactic,
+			e.SemiMajor, e.SemiMinor, e.PA, current, best, ratio, status)
+
+		if *epochdir != "" {
+			repacked.Provenance = get_provenance(*infile)
+
+			if err := write_epoch_packing(repacked, i, dist); err != nil {
+				fatalf("Could not write packing: %s", err)
+			}
+		}
+	}
+
+	slog.Info("Evaluated epochs", "epochs", len(epochs), "degraded", ndegraded)
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The exit codes of the failure classes. They are stable, so that pipeline
+// scripts can react to the class of a failure.
+const (
+	// an internal or otherwise unclassified error
+	exit_internal = 1
+	// invalid command-line arguments or settings
+	exit_usage = 2
+	// an input file that does not exist or cannot be downloaded
+	exit_missing = 3
+	// an input file that cannot be parsed
+	exit_parse = 4
+	// packing constraints that cannot be satisfied
+	exit_unsatisfiable = 5
+	// a packing that fails validation, or inputs that failed in batch mode
+	exit_check = 6
+)
+
+// The names of the failure classes, as reported with the error.
+var exit_classes = map[int]string{
+	exit_internal:      "internal",
+	exit_usage:         "usage",
+	exit_missing:       "input_missing",
+	exit_parse:         "parse_error",
+	exit_unsatisfiable: "unsatisfiable",
+	exit_check:         "check_failed",
+}
+
+// An error of a failure class, which keeps the message of the error.
+type classified_error struct {
+	code int
+	err  error
+}
+
+func (e *classified_error) Error() string {
+	return e.err.Error()
+}
+
+func (e *classified_error) Unwrap() error {
+	return e.err
+}
+
+// Assign the error to the failure class of the exit code.
+func classify(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+
+	return &classified_error{code: code, err: err}
+}
+
+// Classify an error of loading an input file: the file does not exist, or
+// it cannot be parsed. Errors that already have a class, e.g. constraints
+// that cannot be satisfied, keep it.
+func input_error(err error) error {
+	var c *classified_error
+
+	switch {
+	case err == nil:
+		return nil
+	case errors.As(err, &c), errors.Is(err, beampack.ErrUnsatisfiable):
+		return err
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, beampack.ErrUnavailable):
+		return classify(exit_missing, err)
+	default:
+		return classify(exit_parse, err)
+	}
+}
+
+// Get the exit code of an error.
+func get_exit_code(err error) int {
+	var c *classified_error
+	var p *beampack.ParseError
+
+	switch {
+	case errors.As(err, &c):
+		return c.code
+	case errors.Is(err, beampack.ErrUnsatisfiable):
+		return exit_unsatisfiable
+	case errors.Is(err, fs.ErrNotExist), errors.Is(err, beampack.ErrUnavailable):
+		return exit_missing
+	case errors.As(err, &p):
+		return exit_parse
+	default:
+		return exit_internal
+	}
+}
+
+// Log the formatted usage error and exit.
+func usagef(format string, args ...any) {
+	fatal(classify(exit_usage, fmt.Errorf(format, args...)))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/sigproc"
+)
+
+// Print the headers of the SIGPROC filterbank files given as arguments.
+func run_filinfo() {
+	if cmdline.NArg() == 0 {
+		usagef("No filterbank files given.")
+	}
+
+	if *format != "text" && *format != "json" {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	type entry struct {
+		File string `json:"file"`
+		*sigproc.Header
+		RA       float64 `json:"ra"`
+		Dec      float64 `json:"dec"`
+		Duration float64 `json:"duration"`
+	}
+
+	var entries []entry
+
+	for _, filename := range cmdline.Args() {
+		h, err := sigproc.ReadFile(filename)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		entries = append(entries, entry{filename, h, h.RA(), h.Dec(), h.Duration()})
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if *format == "json" {
+		enc := json.NewEncoder(out)
+		enc.SetIndent("", "  ")
+
+		if err := enc.Encode(entries); err != nil {
+			fatalf("Could not write headers: %s", err)
+		}
+
+		return
+	}
+
+	for i, e := range entries {
+		if i > 0 {
+			fmt.Fprintln(out)
+		}
+
+		fmt.Fprintf(out, "File: %s\n", e.File)
+		fmt.Fprintf(out, "Source name: %s\n", e.SourceName)
+		fmt.Fprintf(out, "RA, Dec: %.6f, %.6f deg (src_raj: %.4f, src_dej: %.4f)\n", e.RA, e.Dec, e.SrcRAJ, e.SrcDEJ)
+		fmt.Fprintf(out, "Start time: %.10f MJD\n", e.TStart)
+		fmt.Fprintf(out, "Sampling time: %g s\n", e.TSamp)
+		fmt.Fprintf(out, "Samples: %d (%.3f s)\n", e.NSamples, e.Duration)
+		fmt.Fprintf(out, "Channels: %d, fch1: %.6f MHz, foff: %.6f MHz, bandwidth: %.6f MHz\n", e.NChans, e.Fch1, e.Foff, e.Bandwidth())
+		fmt.Fprintf(out, "Bits: %d, IFs: %d, beam: %d of %d\n", e.NBits, e.NIFs, e.IBeam, e.NBeams)
+		fmt.Fprintf(out, "Telescope ID: %d, machine ID: %d, data type: %d\n", e.TelescopeID, e.MachineID, e.DataType)
+
+		if _, err := fmt.Fprintf(out, "Header size: %d bytes\n", e.HeaderSize); err != nil {
+			fatalf("Could not write headers: %s", err)
+		}
+	}
+}
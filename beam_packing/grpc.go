@@ -0,0 +1,160 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The path of the Pack method of the gRPC service in proto/beampack.proto.
+const grpc_pack_method = "/meertrap.beampack.v1.BeamPacker/Pack"
+
+// The gRPC status codes used by the service.
+const (
+	grpc_ok              = 0
+	grpc_invalid         = 3
+	grpc_resource_limits = 8
+	grpc_unimplemented   = 12
+	grpc_internal        = 13
+)
+
+// The maximum size of a gRPC request message.
+const grpc_max_message = 64 << 20
+
+// Percent-encode a gRPC status message.
+func grpc_escape(text string) string {
+	var sb strings.Builder
+
+	for i := 0; i < len(text); i++ {
+		if c := text[i]; c < 0x20 || c > 0x7e || c == '%' {
+			fmt.Fprintf(&sb, "%%%02X", c)
+		} else {
+			sb.WriteByte(c)
+		}
+	}
+
+	return sb.String()
+}
+
+// Read the single length-prefixed message of a unary gRPC request.
+func read_grpc_message(r io.Reader) ([]byte, int, error) {
+	var prefix [5]byte
+
+	if _, err := io.ReadFull(r, prefix[:]); err != nil {
+		return nil, grpc_invalid, fmt.Errorf("Invalid request: %s", err)
+	}
+
+	if prefix[0] != 0 {
+		return nil, grpc_unimplemented, fmt.Errorf("Compressed messages are not supported.")
+	}
+
+	size := binary.BigEndian.Uint32(prefix[1:])
+	if size > grpc_max_message {
+		return nil, grpc_resource_limits, fmt.Errorf("Request too large: %d bytes", size)
+	}
+
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		return nil, grpc_invalid, fmt.Errorf("Invalid request: %s", err)
+	}
+
+	return data, grpc_ok, nil
+}
+
+// Convert a gRPC packing request to a request of the REST endpoint.
+func get_grpc_request(req *beampack.PackRequest) pack_request {
+	r := pack_request{
+		Method:     req.Method,
+		Bunch:      req.Bunch,
+		NGroups:    req.NGroups,
+		NBeams:     req.NBeams,
+		Metric:     req.Metric,
+		Optimize:   req.Optimize,
+		Iterations: req.Iterations,
+		Seed:       req.Seed,
+		Pinned:     req.Pinned,
+	}
+
+	for _, b := range req.Beams {
+		r.Beams = append(r.Beams, request_beam{Name: b.Name, X: b.X, Y: b.Y, Incoherent: b.Incoherent})
+	}
+
+	return r
+}
+
+// Handle a call of the Pack method of the gRPC service. The status of the
+// call is sent in the trailers.
+func handle_grpc_pack(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "invalid"
+
+	defer func() {
+		metrics.observe(status, time.Since(start))
+	}()
+
+	if r.Method != http.MethodPost || r.ProtoMajor != 2 || !strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc") {
+		http.Error(w, "Only gRPC requests over HTTP/2 are supported.", http.StatusUnsupportedMediaType)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/grpc+proto")
+
+	finish := func(code int, err error) {
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", strconv.Itoa(code))
+
+		if err != nil {
+			w.Header().Set(http.TrailerPrefix+"Grpc-Message", grpc_escape(err.Error()))
+		}
+	}
+
+	data, code, err := read_grpc_message(r.Body)
+	if err != nil {
+		finish(code, err)
+		return
+	}
+
+	req, err := beampack.DecodePackRequest(data)
+	if err != nil {
+		finish(grpc_invalid, fmt.Errorf("Invalid request: %s", err))
+		return
+	}
+
+	preq := get_grpc_request(req)
+
+	packing, dist, err := serve_packing(preq)
+	if err != nil {
+		status = "failed"
+		finish(grpc_invalid, err)
+		return
+	}
+
+	status = "ok"
+	metrics.observe_packing(packing, dist)
+
+	packing.Provenance = get_data_provenance("grpc", data)
+	packing.Provenance.Parameters = get_request_parameters(preq)
+
+	dashboard.update(packing, dist, r.RemoteAddr)
+
+	message := beampack.EncodePacking(packing, dist)
+
+	frame := make([]byte, 5, 5+len(message))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(message)))
+
+	if _, err := w.Write(append(frame, message...)); err != nil {
+		slog.Error("Could not write response", "error", err)
+		finish(grpc_internal, err)
+		return
+	}
+
+	finish(grpc_ok, nil)
+
+	slog.Info("Packed beams", "beams", packing.NBeams(), "bunches", len(packing.Bunches), "client", r.RemoteAddr, "protocol", "grpc")
+}
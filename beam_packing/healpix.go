@@ -0,0 +1,79 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Rasterise the half-power footprints of the beams of the pointings given
+// as FILE[@RA,DEC] arguments, or of the -in beams, onto a HEALPix map of
+// the number of pointings covering every pixel. With -healpix-add, the
+// coverage is added to an existing map, e.g. of the survey so far.
+func run_healpix() {
+	if !slices.Contains(beampack.HEALPixOrderings, *healpixorder) {
+		usagef("Unknown HEALPix pixel ordering: %s", *healpixorder)
+	}
+
+	h, err := beampack.NewHEALPix(*nside, *healpixorder == "nested")
+	if err != nil {
+		usagef("%s", err)
+	}
+
+	m := beampack.NewHEALPixMap(h)
+
+	if *healpixadd != "" {
+		if m, err = beampack.LoadHEALPixMap(*healpixadd); err != nil {
+			fatal(input_error(err))
+		}
+
+		if m.NSide != h.NSide || m.Nested != h.Nested {
+			fatalf("The HEALPix map does not match the settings: %s, nside %d %s", *healpixadd, m.NSide, m.Ordering())
+		}
+	}
+
+	args := cmdline.Args()
+	if len(args) == 0 {
+		args = []string{*infile}
+	}
+
+	opts := beampack.FootprintOptions{
+		SemiMajor: *semimajor,
+		SemiMinor: *semiminor,
+		PA:        *pa,
+	}
+
+	for _, arg := range args {
+		pt, err := load_pointing(arg)
+		if err != nil {
+			fatal(err)
+		}
+
+		// the beams at their absolute positions
+		beams, err := beampack.Merge([]beampack.Pointing{pt})
+		if err != nil {
+			fatal(err)
+		}
+
+		n, err := m.AddPointing(beams, opts)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		slog.Debug("Added pointing to the coverage map", "pointing", pt.Name, "beams", len(beams), "pixels", n)
+	}
+
+	slog.Info("Computed the HEALPix coverage map", "pointings", len(args), "nside", h.NSide, "resolution", h.Resolution(),
+		"pixels", len(m.Values), "area", m.Area())
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteHEALPixMap(out, m); err != nil {
+		fatalf("Could not write HEALPix map: %s", err)
+	}
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"strings"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Match the coherent beam detections in the candidate files given as
+// arguments against the incoherent beam detections in time and DM. The
+// incoherent beam detections are read from the -ib-cands files, or they are
+// the detections in the incoherent beams of the -packing file.
+func run_ibmatch() {
+	if cmdline.NArg() == 0 {
+		usagef("No candidate files given.")
+	}
+
+	if *ibcands == "" && *packingfile == "" {
+		usagef("No incoherent beam candidate files or packing file given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	if *window <= 0 {
+		usagef("The time window must be positive: %v", *window)
+	}
+
+	incoherent := make(map[int]bool)
+
+	if *packingfile != "" {
+		records, err := beampack.ReadPacking(*packingfile)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		for _, rec := range records {
+			if strings.HasPrefix(rec.Name, beampack.IncoherentPrefix) {
+				incoherent[rec.Beam] = true
+			}
+		}
+	}
+
+	var cb, ib []beampack.Candidate
+
+	for _, filename := range cmdline.Args() {
+		c, err := beampack.LoadCandidates(filename)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		for _, cand := range c {
+			if incoherent[cand.Beam] {
+				ib = append(ib, cand)
+			} else {
+				cb = append(cb, cand)
+			}
+		}
+	}
+
+	if *ibcands != "" {
+		for _, filename := range strings.Split(*ibcands, ",") {
+			c, err := beampack.LoadCandidates(filename)
+			if err != nil {
+				fatal(input_error(err))
+			}
+
+			ib = append(ib, c...)
+		}
+	}
+
+	labelled := beampack.MatchIncoherent(cb, ib, beampack.IBMatchOptions{
+		Window: *window,
+		DMTol:  *dmtol,
+	})
+
+	counts := make(map[string]int)
+	for _, l := range labelled {
+		// the confirmed incoherent beam candidates are counted with their
+		// coherent beam counterparts
+		if !l.Incoherent || l.Label == beampack.LabelIBOnly {
+			counts[l.Label]++
+		}
+	}
+
+	slog.Info("Matched coherent and incoherent beam candidates", "cb", len(cb), "ib", len(ib),
+		"confirmed", counts[beampack.LabelConfirmed], "cb_only", counts[beampack.LabelCBOnly], "ib_only", counts[beampack.LabelIBOnly])
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteLabelled(out, labelled, *format); err != nil {
+		fatalf("Could not write candidates: %s", err)
+	}
+}
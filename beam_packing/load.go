@@ -0,0 +1,89 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Estimate the processing load of the nodes of the packing file given as
+// argument from the expected candidate rates of its beams and the
+// throughput of the nodes. The exit status is non-zero if any node is
+// likely to fall behind, so that the packing can be adjusted before the
+// observation starts.
+func run_load() {
+	packing := read_packing_arg()
+
+	if *throughput < 0 {
+		usagef("The node throughput must not be negative: %g", *throughput)
+	}
+
+	if *maxutil <= 0 {
+		usagef("The maximum node utilization must be positive: %g", *maxutil)
+	}
+
+	if *ratefile != "" {
+		if err := load_packing_rates(packing); err != nil {
+			fatal(input_error(err))
+		}
+	}
+
+	var nodes []beampack.Node
+	if *nodefile != "" {
+		var err error
+		if nodes, err = load_nodes(); err != nil {
+			fatal(err)
+		}
+	}
+
+	e := beampack.EstimateLoad(packing, nodes, beampack.EstimateOptions{
+		Throughput:     *throughput,
+		MaxUtilization: *maxutil,
+	})
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = beampack.WriteLoadEstimate(out, e)
+	out.Close()
+
+	if err != nil {
+		fatalf("Could not write load estimate: %s", err)
+	}
+
+	for _, n := range e.Nodes {
+		if n.Flagged() {
+			slog.Warn("Node likely to fall behind", "node", n.Node, "status", n.Status, "load", n.Load, "throughput", n.Throughput, "utilization", n.Utilization)
+		}
+	}
+
+	if e.Flagged > 0 {
+		slog.Error("Nodes likely to fall behind", "nodes", e.Flagged, "class", exit_classes[exit_check], "exit_code", exit_check)
+		exit(exit_check)
+	}
+
+	slog.Info("Estimated node loads", "nodes", len(e.Nodes), "load", e.Load, "throughput", e.Throughput, "max_utilization", e.MaxUtilization)
+}
+
+// Set the expected candidate rates of the beams of the packing from the
+// rates file. The bunches share one array of beams for that.
+func load_packing_rates(packing *beampack.Packing) error {
+	var beams []beampack.Beam
+	for _, b := range packing.Bunches {
+		beams = append(beams, b.Beams...)
+	}
+
+	if err := beampack.LoadRates(*ratefile, beams); err != nil {
+		return err
+	}
+
+	k := 0
+	for i, b := range packing.Bunches {
+		packing.Bunches[i].Beams = beams[k : k+len(b.Beams)]
+		k += len(b.Beams)
+	}
+
+	return nil
+}
@@ -0,0 +1,98 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Localize a source from its per-beam S/N in the detections file given as
+// argument and the beam positions.
+func run_localize() {
+	if cmdline.NArg() != 1 {
+		usagef("One detections file is required.")
+	}
+
+	if *format != "text" && *format != "json" {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dets, err := beampack.LoadDetections(cmdline.Arg(0), beams)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	opts := beampack.LocalizeOptions{
+		SemiMajor: *semimajor,
+		SemiMinor: *semiminor,
+		PA:        *pa,
+		Grid:      *locgrid,
+	}
+
+	if *locnondet {
+		opts.Beams = beams
+	}
+
+	if opts.PrimaryBeam, err = get_primary_beam(beams); err != nil {
+		usagef("%s", err)
+	}
+
+	// the tangent plane is about the boresight, by default the mean
+	// direction of the detections
+	if *projection == "gnomonic" {
+		tangent, err := get_tangent()
+		if err != nil {
+			fatal(err)
+		}
+
+		if tangent == nil {
+			var detected []beampack.Beam
+			for _, d := range dets {
+				detected = append(detected, d.Beam)
+			}
+
+			t := beampack.GetBoresight(detected)
+			tangent = &t
+		}
+
+		opts.Tangent = tangent
+	}
+
+	loc, err := beampack.Localize(dets, opts)
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.Info("Localized source", "x", loc.X, "y", loc.Y, "amplitude", loc.Amplitude, "chi2", loc.Chi2, "beams", loc.NBeams)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteLocalization(out, loc, *format); err != nil {
+		fatalf("Could not write localization: %s", err)
+	}
+
+	if *locmap != "" {
+		f, err := create_output(*locmap)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteLikelihoodMap(f, loc)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write likelihood map: %s", err)
+		}
+	}
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"os"
+)
+
+// Set up the leveled logging to stderr. The messages are logged as text or
+// as JSON objects for ingestion by the observatory log aggregation.
+func setup_logging() error {
+	level := slog.LevelInfo
+
+	switch {
+	case *verbose && *quiet:
+		return fmt.Errorf("The verbose and quiet options are mutually exclusive.")
+	case *verbose:
+		level = slog.LevelDebug
+	case *quiet:
+		level = slog.LevelWarn
+	}
+
+	opts := &slog.HandlerOptions{Level: level}
+
+	var handler slog.Handler
+
+	switch *logformat {
+	case "text":
+		handler = slog.NewTextHandler(os.Stderr, opts)
+	case "json":
+		handler = slog.NewJSONHandler(os.Stderr, opts)
+	default:
+		return fmt.Errorf("Unknown log format: %s", *logformat)
+	}
+
+	slog.SetDefault(slog.New(handler))
+
+	return nil
+}
+
+// Log the error with its failure class and exit with the exit code of the
+// class. With the JSON log format, the last line on stderr is the error as
+// JSON object.
+func fatal(err error) {
+	code := get_exit_code(err)
+
+	slog.Error(err.Error(), "class", exit_classes[code], "exit_code", code)
+	exit(code)
+}
+
+// Log the formatted error message and exit.
+func fatalf(format string, args ...any) {
+	fatal(fmt.Errorf(format, args...))
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Pack the beams and report which beams and bunches contain known sources
+// from the catalogue.
+func run_match() {
+	if *catalogue == "" {
+		usagef("No source catalogue given.")
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	sources, err := beampack.LoadCatalogue(*catalogue)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	// the beams are in J2000 when loaded
+	if eq, _ := get_equinox(*catequinox); eq != beampack.J2000 {
+		beampack.ConvertSources(sources, eq, beampack.J2000)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	matches := beampack.MatchSources(packing, sources, *radius)
+
+	if err := beampack.WriteMatches(out, matches); err != nil {
+		fatalf("Could not write matches: %s", err)
+	}
+}
+
+// Annotate the bunches of the packing with the known pulsars and
+// calibrators from the -tag-pulsars and -tag-calibrators catalogues that
+// lie within their beams. The sources without kind in the catalogue get
+// the one of the option.
+func tag_bunches(packing *beampack.Packing) error {
+	var sources []beampack.Source
+
+	for _, c := range []struct{ filename, kind string }{{*tagpulsars, "pulsar"}, {*tagcals, "calibrator"}} {
+		if c.filename == "" {
+			continue
+		}
+
+		list, err := beampack.LoadCatalogue(c.filename)
+		if err != nil {
+			return input_error(err)
+		}
+
+		for i := range list {
+			if list[i].Kind == "" {
+				list[i].Kind = c.kind
+			}
+		}
+
+		sources = append(sources, list...)
+	}
+
+	if len(sources) == 0 {
+		return nil
+	}
+
+	// the beams are in J2000 when loaded
+	eq, err := get_equinox(*catequinox)
+	if err != nil {
+		return classify(exit_usage, err)
+	}
+
+	if eq != beampack.J2000 {
+		beampack.ConvertSources(sources, eq, beampack.J2000)
+	}
+
+	tagged := beampack.TagBunches(packing, sources, *radius)
+
+	for _, b := range packing.Bunches {
+		if len(b.Tags) == 0 {
+			continue
+		}
+
+		names := make([]string, len(b.Tags))
+		for i, t := range b.Tags {
+			names[i] = t.Source
+		}
+
+		slog.Info("Bunch contains known sources", "bunch", b.ID, "node", b.Node, "sources", names)
+	}
+
+	slog.Info("Tagged bunches with known sources", "catalogue_sources", len(sources), "bunches", tagged)
+
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The upper bounds of the request latency histogram in seconds.
+var latency_buckets = []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60}
+
+// The metrics of the packing service, which are exposed in the Prometheus
+// text format.
+type service_metrics struct {
+	mu sync.Mutex
+
+	started time.Time
+
+	// the number of requests by outcome: ok, invalid or failed
+	requests map[string]int64
+
+	// the request latency histogram, the counts are not cumulative
+	latency_counts []int64
+	latency_sum    float64
+	latency_count  int64
+
+	beams int64
+
+	// the quality of the last packing
+	last      time.Time
+	bunches   int
+	maxsep    float64
+	meansep   float64
+	p95sep    float64
+	totalsep  float64
+	lastbeams int
+}
+
+var metrics = &service_metrics{
+	started:        time.Now(),
+	requests:       map[string]int64{"ok": 0, "invalid": 0, "failed": 0},
+	latency_counts: make([]int64, len(latency_buckets)),
+}
+
+// Record a packing request with its outcome and latency.
+func (m *service_metrics) observe(status string, latency time.Duration) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.requests[status]++
+
+	s := latency.Seconds()
+	m.latency_sum += s
+	m.latency_count++
+
+	for i, le := range latency_buckets {
+		if s <= le {
+			m.latency_counts[i]++
+			break
+		}
+	}
+}
+
+// Record the beams and the quality of a successful packing.
+func (m *service_metrics) observe_packing(packing *beampack.Packing, dist beampack.DistanceFunc) {
+	report := beampack.Score(packing, dist)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.beams += int64(packing.NBeams())
+
+	m.last = time.Now()
+	m.lastbeams = packing.NBeams()
+	m.bunches = len(packing.Bunches)
+	m.maxsep = report.MaxSep
+	m.meansep = report.MeanSep
+	m.p95sep = report.P95Sep
+	m.totalsep = report.TotDist
+}
+
+// Write the metrics in the Prometheus text exposition format.
+func (m *service_metrics) write(w io.Writer) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	format_float := func(v float64) string {
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+
+	header := func(name, kind, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+	}
+
+	header("beam_packer_start_time_seconds", "gauge", "Start time of the service since the Unix epoch in seconds.")
+	fmt.Fprintf(w, "beam_packer_start_time_seconds %d\n", m.started.Unix())
+
+	header("beam_packer_build_info", "gauge", "Version of the beam packer.")
+	fmt.Fprintf(w, "beam_packer_build_info{version=%q} 1\n", get_version())
+
+	header("beam_packer_requests_total", "counter", "Number of packing requests by outcome.")
+	for _, status := range []string{"ok", "invalid", "failed"} {
+		fmt.Fprintf(w, "beam_packer_requests_total{status=%q} %d\n", status, m.requests[status])
+	}
+
+	header("beam_packer_request_duration_seconds", "histogram", "Latency of the packing requests in seconds.")
+	var cumulative int64
+	for i, le := range latency_buckets {
+		cumulative += m.latency_counts[i]
+		fmt.Fprintf(w, "beam_packer_request_duration_seconds_bucket{le=%q} %d\n", format_float(le), cumulative)
+	}
+	fmt.Fprintf(w, "beam_packer_request_duration_seconds_bucket{le=\"+Inf\"} %d\n", m.latency_count)
+	fmt.Fprintf(w, "beam_packer_request_duration_seconds_sum %s\n", format_float(m.latency_sum))
+	fmt.Fprintf(w, "beam_packer_request_duration_seconds_count %d\n", m.latency_count)
+
+	header("beam_packer_beams_total", "counter", "Number of beams packed.")
+	fmt.Fprintf(w, "beam_packer_beams_total %d\n", m.beams)
+
+	// the quality gauges are only meaningful after the first packing
+	if m.last.IsZero() {
+		return nil
+	}
+
+	gauges := []struct {
+		name  string
+		help  string
+		value string
+	}{
+		{"beam_packer_last_packing_timestamp_seconds", "Time of the last packing since the Unix epoch in seconds.", strconv.FormatInt(m.last.Unix(), 10)},
+		{"beam_packer_last_packing_beams", "Number of beams of the last packing.", strconv.Itoa(m.lastbeams)},
+		{"beam_packer_last_packing_bunches", "Number of bunches of the last packing.", strconv.Itoa(m.bunches)},
+		{"beam_packer_last_packing_max_separation", "Maximum intra-bunch separation of the last packing.", format_float(m.maxsep)},
+		{"beam_packer_last_packing_mean_separation", "Mean intra-bunch separation of the last packing.", format_float(m.meansep)},
+		{"beam_packer_last_packing_p95_separation", "95th percentile of the intra-bunch separations of the last packing.", format_float(m.p95sep)},
+		{"beam_packer_last_packing_total_separation", "Total intra-bunch distance of the last packing.", format_float(m.totalsep)},
+	}
+
+	for _, g := range gauges {
+		header(g.name, "gauge", g.help)
+		if _, err := fmt.Fprintf(w, "%s %s\n", g.name, g.value); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Handle a metrics request.
+func handle_metrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	metrics.write(w)
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"log/slog"
+	"path/filepath"
+	"strings"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Get the pointing name from the beam position file name.
+func get_pointing_name(filename string) string {
+	name := filepath.Base(filename)
+	return strings.TrimSuffix(name, filepath.Ext(name))
+}
+
+// Load a pointing given as FILE or FILE@RA,DEC. With a boresight, the beam
+// positions in the file are offsets from it.
+func load_pointing(arg string) (beampack.Pointing, error) {
+	filename, position, found := strings.Cut(arg, "@")
+
+	pt := beampack.Pointing{Name: get_pointing_name(filename)}
+
+	if found {
+		ra, dec, err := parse_position(position)
+		if err != nil {
+			return pt, err
+		}
+
+		pt.Boresight = &beampack.Tangent{RA: ra, Dec: dec}
+	}
+
+	beams, err := load_beams(filename)
+	if err != nil {
+		return pt, err
+	}
+
+	pt.Beams = beams
+
+	return pt, nil
+}
+
+// Compute one packing across the beams of the pointings given as
+// arguments.
+func run_mosaic() {
+	if cmdline.NArg() < 1 {
+		usagef("At least one pointing is required: FILE[@RA,DEC] ...")
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	var pointings []beampack.Pointing
+
+	for _, arg := range cmdline.Args() {
+		pt, err := load_pointing(arg)
+		if err != nil {
+			fatal(err)
+		}
+
+		pointings = append(pointings, pt)
+	}
+
+	beams, err := beampack.Merge(pointings)
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.Info("Merged pointings", "pointings", len(pointings), "beams", len(beams))
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	packing.Provenance = get_provenance(cmdline.Args()...)
+
+	write_packing(packing, dist)
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The fields of an output file name template.
+type output_fields struct {
+	// The input file name without directory and extensions.
+	Input string
+	// The boresight as RA_Dec, and its coordinates.
+	Boresight string
+	RA        float64
+	Dec       float64
+	Method    string
+	Seed      int64
+	Bunch     int
+	NBeams    int
+	NBunches  int
+	ObsID     string
+	Version   string
+	// The output format and its file extension.
+	Format string
+	Ext    string
+	// The time of the packing, also as date (YYYY-MM-DD) and time
+	// (HHMMSS) in UTC.
+	Created time.Time
+	Date    string
+	Time    string
+}
+
+// Check whether an output file name is a template.
+func is_template(name string) bool {
+	return strings.Contains(name, "{{")
+}
+
+// Get the input file name without directory and extensions. Compressed
+// files lose both extensions.
+func get_input_base(filename string) string {
+	// the query of a URL is not part of the name
+	if beampack.IsURL(filename) {
+		if u, err := url.Parse(filename); err == nil {
+			filename = u.Path
+		}
+	}
+
+	base := filepath.Base(filename)
+	for _, ext := range []string{".gz", ".zst", filepath.Ext(strings.TrimSuffix(strings.TrimSuffix(base, ".gz"), ".zst"))} {
+		base = strings.TrimSuffix(base, ext)
+	}
+
+	return base
+}
+
+// Get the boresight of a packing: the tangent point of the projection, the
+// boresight given on the command line, or the mean direction of the
+// coherent beams.
+func get_packing_boresight(packing *beampack.Packing) beampack.Tangent {
+	if packing.Tangent != nil {
+		return *packing.Tangent
+	}
+
+	if is_set("boresight") {
+		if ra, dec, err := parse_position(*boresight); err == nil {
+			return beampack.Tangent{RA: ra, Dec: dec}
+		}
+	}
+
+	var coherent []beampack.Beam
+	for _, b := range packing.Bunches {
+		for _, beam := range b.Beams {
+			if !beam.Incoherent && !beam.Dummy {
+				coherent = append(coherent, beam)
+			}
+		}
+	}
+
+	return beampack.GetBoresight(coherent)
+}
+
+// Get the template fields of a packing of the input file.
+func get_output_fields(packing *beampack.Packing, filename string) output_fields {
+	t := get_packing_boresight(packing)
+
+	created := time.Now().UTC()
+	if packing.Provenance != nil {
+		created = packing.Provenance.Created
+	}
+
+	// rounded, without negative zero
+	format_coord := func(v float64) string {
+		if v = math.Round(v*1e4) / 1e4; v == 0 {
+			v = 0
+		}
+
+		return strconv.FormatFloat(v, 'f', 4, 64)
+	}
+
+	return output_fields{
+		Input:     get_input_base(filename),
+		Boresight: format_coord(t.RA) + "_" + format_coord(t.Dec),
+		RA:        t.RA,
+		Dec:       t.Dec,
+		Method:    packing.Method,
+		Seed:      packing.Seed,
+		Bunch:     *bunch,
+		NBeams:    packing.NBeams(),
+		NBunches:  len(packing.Bunches),
+		ObsID:     *obsid,
+		Version:   get_version(),
+		Format:    *format,
+		Ext:       get_format_ext(),
+		Created:   created,
+		Date:      created.Format("2006-01-02"),
+		Time:      created.Format("150405"),
+	}
+}
+
+// Expand an output file name template, e.g. {{.Boresight}}_{{.Method}}.json,
+// with the fields of a packing of the input file.
+func expand_output_name(tmpl string, packing *beampack.Packing, filename string) (string, error) {
+	t, err := template.New("output").Option("missingkey=error").Parse(tmpl)
+	if err != nil {
+		return "", fmt.Errorf("Invalid output file name template: %s, %s", tmpl, err)
+	}
+
+	var b strings.Builder
+	if err := t.Execute(&b, get_output_fields(packing, filename)); err != nil {
+		return "", fmt.Errorf("Could not expand output file name template: %s, %s", tmpl, err)
+	}
+
+	name := b.String()
+	if name == "" {
+		return "", fmt.Errorf("The output file name template expands to an empty name: %s", tmpl)
+	}
+
+	return name, nil
+}
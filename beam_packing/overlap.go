@@ -0,0 +1,50 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Compute the fractional overlaps of the half-power footprints of the beams
+// of the -in file and write them as sparse matrix. Beams without a shape in
+// the input take the one of the settings.
+func run_overlap() {
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	if *minoverlap < 0 || *minoverlap > 1 {
+		usagef("The minimum overlap fraction must be between 0 and 1: %g", *minoverlap)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	opts := beampack.OverlapOptions{
+		SemiMajor:   *semimajor,
+		SemiMinor:   *semiminor,
+		PA:          *pa,
+		MinFraction: *minoverlap,
+	}
+
+	m, err := beampack.Overlaps(beams, opts)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	slog.Info("Computed beam overlaps", "beams", len(m.Beams), "pairs", len(m.Overlaps))
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteOverlaps(out, m, *format); err != nil {
+		fatalf("Could not write beam overlaps: %s", err)
+	}
+}
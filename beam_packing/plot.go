@@ -0,0 +1,40 @@
+package main
+
+import (
+	"io"
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Read the packing file given as argument.
+func read_packing_arg() *beampack.Packing {
+	if cmdline.NArg() != 1 {
+		usagef("One packing file is required.")
+	}
+
+	records, err := beampack.ReadPacking(cmdline.Arg(0))
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	return beampack.FromRecords(records)
+}
+
+// Plot the packing file given as argument to the output file.
+func run_plot() {
+	packing := read_packing_arg()
+
+	if *outfile == "" {
+		usagef("No plot file given.")
+	}
+
+	err := write_format_output(*outfile, "plot", beampack.PlotFormats, func(w io.Writer, format string) error {
+		return beampack.Plot(w, packing, format)
+	})
+	if err != nil {
+		fatalf("Could not plot packing: %s", err)
+	}
+
+	slog.Info("Plotted packing", "file", *outfile, "bunches", len(packing.Bunches))
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/pprof"
+	"os"
+	"runtime"
+	rpprof "runtime/pprof"
+)
+
+// The output of the running CPU profile, if any.
+var cpuprofile_out io.WriteCloser
+
+// Start the CPU profile if requested.
+func start_profiling() error {
+	if *cpuprofile == "" {
+		return nil
+	}
+
+	out, err := create_output(*cpuprofile)
+	if err != nil {
+		return err
+	}
+
+	if err := rpprof.StartCPUProfile(out); err != nil {
+		out.Close()
+		return fmt.Errorf("Could not start CPU profile: %s, %s", *cpuprofile, err)
+	}
+
+	cpuprofile_out = out
+	slog.Debug("Started CPU profile", "file", *cpuprofile)
+
+	return nil
+}
+
+// Stop the CPU profile and write the heap profile if requested. Errors are
+// only logged, so that they do not mask the outcome of the command.
+func stop_profiling() {
+	if cpuprofile_out != nil {
+		rpprof.StopCPUProfile()
+
+		if err := cpuprofile_out.Close(); err != nil {
+			slog.Warn("Could not write CPU profile", "file", *cpuprofile, "error", err)
+		}
+
+		cpuprofile_out = nil
+	}
+
+	if *memprofile != "" {
+		if err := write_memprofile(*memprofile); err != nil {
+			slog.Warn("Could not write memory profile", "file", *memprofile, "error", err)
+		}
+
+		*memprofile = ""
+	}
+}
+
+// Write the heap profile with up-to-date statistics.
+func write_memprofile(filename string) error {
+	out, err := create_output(filename)
+	if err != nil {
+		return err
+	}
+
+	runtime.GC()
+
+	err = rpprof.WriteHeapProfile(out)
+	if cerr := out.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// Stop the profiling and exit with the exit code.
+func exit(code int) {
+	stop_profiling()
+	os.Exit(code)
+}
+
+// Register the net/http/pprof endpoints under /debug/pprof/ on the mux.
+func register_pprof(mux *http.ServeMux) {
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+}
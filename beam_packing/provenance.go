@@ -0,0 +1,114 @@
+package main
+
+import (
+	"flag"
+	"log/slog"
+	"os"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The version of the packer, which can be set at build time with
+// -ldflags "-X main.version=...". By default it is taken from the build
+// information, i.e. the module version or the VCS revision.
+var version = ""
+
+// Get the version of the packer.
+func get_version() string {
+	if version != "" {
+		return version
+	}
+
+	info, ok := debug.ReadBuildInfo()
+	if !ok {
+		return "unknown"
+	}
+
+	v := info.Main.Version
+	if v != "" && v != "(devel)" {
+		return v
+	}
+
+	var revision, modified string
+	for _, s := range info.Settings {
+		switch s.Key {
+		case "vcs.revision":
+			revision = s.Value
+		case "vcs.modified":
+			modified = s.Value
+		}
+	}
+
+	if revision == "" {
+		return "devel"
+	}
+
+	if len(revision) > 12 {
+		revision = revision[:12]
+	}
+
+	if modified == "true" {
+		revision += "-dirty"
+	}
+
+	return revision
+}
+
+// The settings that determine a packing and that are recorded with it.
+var packing_parameters = []string{
+	"nbeams", "bunch", "ngroups", "remainder", "method", "metric", "semimajor", "semiminor", "pa",
+	"antennas", "ants", "freq", "hour-angle", "projection", "boresight", "optimize", "iterations", "population", "generations", "chains", "fitness",
+	"ib-policy", "constraints", "regions", "weights", "flagged", "dead", "rebalance", "subtilings", "subtiling-eps", "subtiling-min-points", "rates", "balance", "previous", "churn", "nodes", "equinox", "primary-beam", "pb-fwhm", "pb-weight", "pb-order",
+}
+
+// Get the packing parameters as name=value pairs.
+func get_parameters() string {
+	var params []string
+
+	for _, name := range packing_parameters {
+		if f := flag.Lookup(name); f != nil {
+			params = append(params, name+"="+f.Value.String())
+		}
+	}
+
+	return strings.Join(params, " ")
+}
+
+// Get the provenance of a packing of the input files. The inputs are hashed
+// if they are files, but not if they are read from stdin or fetched from
+// the portal.
+func get_provenance(inputs ...string) *beampack.Provenance {
+	prov := &beampack.Provenance{
+		Version:    get_version(),
+		Created:    time.Now().UTC(),
+		Parameters: get_parameters(),
+	}
+
+	for _, name := range inputs {
+		in := beampack.InputFile{Name: name}
+
+		if st, err := os.Stat(name); err == nil && st.Mode().IsRegular() {
+			hash, err := beampack.HashFile(name)
+			if err != nil {
+				slog.Warn("Could not hash input file", "file", name, "error", err)
+			}
+
+			in.SHA256 = hash
+		}
+
+		prov.Inputs = append(prov.Inputs, in)
+	}
+
+	return prov
+}
+
+// Get the provenance of a packing of received data.
+func get_data_provenance(name string, data []byte) *beampack.Provenance {
+	prov := get_provenance()
+	prov.Inputs = []beampack.InputFile{{Name: name, SHA256: beampack.HashData(data)}}
+
+	return prov
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Estimate the coherent beam shape from the antenna positions at the
+// observing frequency and hour angle, for the Dec of the boresight.
+func estimate_psf() (beampack.PSF, []beampack.Antenna, error) {
+	_, dec, err := parse_position(*boresight)
+	if err != nil {
+		return beampack.PSF{}, nil, classify(exit_usage, err)
+	}
+
+	antennas, err := beampack.LoadAntennas(*antennafile, beampack.MeerKAT)
+	if err != nil {
+		return beampack.PSF{}, nil, input_error(err)
+	}
+
+	if *ants != "" {
+		var names []string
+		for _, name := range strings.Split(*ants, ",") {
+			names = append(names, strings.TrimSpace(name))
+		}
+
+		if antennas, err = beampack.SelectAntennas(antennas, names); err != nil {
+			return beampack.PSF{}, nil, classify(exit_usage, err)
+		}
+	}
+
+	psf, err := beampack.EstimatePSF(antennas, beampack.MeerKAT, dec, *hourangle, *freq)
+	if err != nil {
+		return beampack.PSF{}, nil, classify(exit_usage, err)
+	}
+
+	return psf, antennas, nil
+}
+
+// Replace the beam shape settings with the estimate from the antenna
+// positions.
+func apply_psf() error {
+	psf, antennas, err := estimate_psf()
+	if err != nil {
+		return err
+	}
+
+	*semimajor, *semiminor, *pa = psf.SemiMajor, psf.SemiMinor, psf.PA
+
+	slog.Info("Estimated the beam shape", "antennas", len(antennas), "semimajor", psf.SemiMajor, "semiminor", psf.SemiMinor, "pa", psf.PA)
+
+	return nil
+}
+
+// Write the coherent beam shape estimated from the antenna positions and
+// the extent of the array.
+func run_psf() {
+	if *antennafile == "" {
+		usagef("No antenna file given.")
+	}
+
+	psf, antennas, err := estimate_psf()
+	if err != nil {
+		fatal(err)
+	}
+
+	e := beampack.GetArrayExtent(antennas)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	fmt.Fprintf(out, "Semi-major: %.6f, semi-minor: %.6f, PA: %.2f, frequency: %g, hour angle: %g\n",
+		psf.SemiMajor, psf.SemiMinor, psf.PA, *freq, *hourangle)
+
+	_, err = fmt.Fprintf(out, "Antennas: %d, baselines: %d, shortest: %.1f, median: %.1f, longest: %.1f, radius: %.1f\n",
+		e.NAntennas, e.NBaselines, e.Shortest, e.Median, e.Longest, e.Radius)
+	if err != nil {
+		fatalf("Could not write beam shape: %s", err)
+	}
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Store the packing in the packing database.
+func store_packing(packing *beampack.Packing) {
+	info := beampack.StoredPacking{
+		Observation: *obsid,
+		Input:       *infile,
+		Version:     get_version(),
+		Parameters:  get_parameters(),
+	}
+
+	if prov := packing.Provenance; prov != nil {
+		info.Created = prov.Created
+		info.Parameters = prov.Parameters
+
+		var names, hashes []string
+		for _, in := range prov.Inputs {
+			names = append(names, in.Name)
+			hashes = append(hashes, in.SHA256)
+		}
+
+		info.Input = strings.Join(names, ",")
+		info.InputHash = strings.Join(hashes, ",")
+	}
+
+	id, err := beampack.StorePacking(*dbfile, packing, info)
+	if err != nil {
+		fatal(err)
+	}
+
+	slog.Info("Stored packing", "db", *dbfile, "id", id, "observation", *obsid)
+}
+
+// Retrieve historical packings from the packing database. Without packing
+// ID, the stored packings are listed, optionally only the ones of an
+// observation or the one in effect at a given time. With packing ID, the
+// packing is written in the output format.
+func run_query() {
+	if *dbfile == "" {
+		usagef("No packing database given.")
+	}
+
+	if *queryid != 0 {
+		dist, err := check_settings()
+		if err != nil {
+			fatal(err)
+		}
+
+		info, packing, err := beampack.LoadStoredPacking(*dbfile, *queryid)
+		if errors.Is(err, fs.ErrNotExist) {
+			fatal(classify(exit_missing, err))
+		} else if err != nil {
+			fatal(err)
+		}
+
+		slog.Info("Loaded packing", "id", info.ID, "observation", info.Observation, "created", info.Created.Format(time.RFC3339))
+
+		out, err := create_output(*outfile)
+		if err != nil {
+			fatal(err)
+		}
+		defer close_output(out)
+
+		if err := write_output(out, packing, *format, dist); err != nil {
+			fatalf("Could not write packing: %s", err)
+		}
+
+		return
+	}
+
+	q := beampack.PackingQuery{Observation: *obsid}
+
+	if *queryat != "" {
+		t, err := time.Parse(time.RFC3339, *queryat)
+		if err != nil {
+			usagef("Invalid time: %s, %s", *queryat, err)
+		}
+
+		q.At = t
+	}
+
+	packings, err := beampack.QueryPackings(*dbfile, q)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	fmt.Fprintf(out, "%6s %-20s %-16s %-10s %6s %8s %20s %s\n",
+		"id", "created", "observation", "method", "nbeams", "nbunches", "seed", "input")
+
+	for _, p := range packings {
+		fmt.Fprintf(out, "%6d %-20s %-16s %-10s %6d %8d %20d %s\n",
+			p.ID, p.Created.UTC().Format(time.RFC3339), p.Observation, p.Method, p.NBeams, p.NBunches, p.Seed, p.Input)
+	}
+}
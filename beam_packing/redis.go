@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A minimal Redis client that speaks enough of the RESP protocol for
+// publish/subscribe messaging.
+type redis_conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+}
+
+// Connect to the Redis server.
+func dial_redis(addr string) (*redis_conn, error) {
+	conn, err := net.DialTimeout("tcp", addr, 10*time.Second)
+	if err != nil {
+		return nil, fmt.Errorf("Could not connect to Redis server: %s, %s", addr, err)
+	}
+
+	return &redis_conn{conn: conn, reader: bufio.NewReader(conn)}, nil
+}
+
+func (c *redis_conn) Close() error {
+	return c.conn.Close()
+}
+
+// Send a command as array of bulk strings.
+func (c *redis_conn) send(args ...string) error {
+	var sb strings.Builder
+
+	fmt.Fprintf(&sb, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&sb, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+
+	_, err := io.WriteString(c.conn, sb.String())
+
+	return err
+}
+
+// Read a single reply line without the line ending.
+func (c *redis_conn) read_line() (string, error) {
+	line, err := c.reader.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimSuffix(line, "\r\n"), nil
+}
+
+// Read a reply. Simple and bulk strings are returned as string, integers as
+// int64 and arrays as []any. Error replies are returned as error.
+func (c *redis_conn) receive() (any, error) {
+	line, err := c.read_line()
+	if err != nil {
+		return nil, err
+	}
+
+	if line == "" {
+		return nil, fmt.Errorf("Invalid Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+
+	case '-':
+		return nil, fmt.Errorf("Redis error: %s", line[1:])
+
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Redis bulk string length: %s", line)
+		}
+
+		// null bulk string
+		if n < 0 {
+			return nil, nil
+		}
+
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.reader, buf); err != nil {
+			return nil, err
+		}
+
+		return string(buf[:n]), nil
+
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("Invalid Redis array length: %s", line)
+		}
+
+		if n < 0 {
+			return nil, nil
+		}
+
+		items := make([]any, n)
+		for i := range items {
+			if items[i], err = c.receive(); err != nil {
+				return nil, err
+			}
+		}
+
+		return items, nil
+
+	default:
+		return nil, fmt.Errorf("Invalid Redis reply: %s", line)
+	}
+}
+
+// Publish a message on a channel.
+func (c *redis_conn) publish(channel string, message string) error {
+	if err := c.send("PUBLISH", channel, message); err != nil {
+		return err
+	}
+
+	_, err := c.receive()
+
+	return err
+}
+
+// Subscribe to a channel.
+func (c *redis_conn) subscribe(channel string) error {
+	if err := c.send("SUBSCRIBE", channel); err != nil {
+		return err
+	}
+
+	reply, err := c.receive()
+	if err != nil {
+		return err
+	}
+
+	items, ok := reply.([]any)
+	if !ok || len(items) != 3 || items[0] != "subscribe" {
+		return fmt.Errorf("Unexpected reply to subscription: %v", reply)
+	}
+
+	return nil
+}
+
+// Wait for the next message on the subscribed channels.
+func (c *redis_conn) next_message() (string, string, error) {
+	for {
+		reply, err := c.receive()
+		if err != nil {
+			return "", "", err
+		}
+
+		items, ok := reply.([]any)
+		if !ok || len(items) != 3 || items[0] != "message" {
+			continue
+		}
+
+		channel, _ := items[1].(string)
+		payload, _ := items[2].(string)
+
+		return channel, payload, nil
+	}
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// A beam in a packing request.
+type request_beam struct {
+	Name       string  `json:"name"`
+	X          float64 `json:"x"`
+	Y          float64 `json:"y"`
+	Incoherent bool    `json:"incoherent"`
+}
+
+// A packing request. Settings that are not given default to the values of
+// the command-line flags.
+type pack_request struct {
+	Beams      []request_beam `json:"beams"`
+	Method     *string        `json:"method"`
+	Bunch      *int           `json:"bunch"`
+	NGroups    *int           `json:"ngroups"`
+	NBeams     *int           `json:"nbeams"`
+	Metric     *string        `json:"metric"`
+	Optimize   *string        `json:"optimize"`
+	Iterations *int           `json:"iterations"`
+	Seed       *int64         `json:"seed"`
+	// Groups of beams, by name or number, that must be packed into the
+	// same bunch.
+	Pinned [][]string `json:"pinned"`
+}
+
+// Get the value of an optional request setting, or its default.
+func get_setting[T any](value *T, def T) T {
+	if value == nil {
+		return def
+	}
+
+	return *value
+}
+
+// Get the packing parameters of a request. The settings given in the
+// request follow the defaults and override them.
+func get_request_parameters(req pack_request) string {
+	params := []string{get_parameters()}
+
+	add := func(name string, value any) {
+		params = append(params, fmt.Sprintf("%s=%v", name, value))
+	}
+
+	if req.Method != nil {
+		add("method", *req.Method)
+	}
+	if req.Bunch != nil {
+		add("bunch", *req.Bunch)
+	}
+	if req.NGroups != nil {
+		add("ngroups", *req.NGroups)
+	}
+	if req.NBeams != nil {
+		add("nbeams", *req.NBeams)
+	}
+	if req.Metric != nil {
+		add("metric", *req.Metric)
+	}
+	if req.Optimize != nil {
+		add("optimize", *req.Optimize)
+	}
+	if req.Iterations != nil {
+		add("iterations", *req.Iterations)
+	}
+	if len(req.Pinned) > 0 {
+		add("pinned", len(req.Pinned))
+	}
+
+	return strings.Join(params, " ")
+}
+
+// Compute the packing for a request.
+func serve_packing(req pack_request) (*beampack.Packing, beampack.DistanceFunc, error) {
+	if len(req.Beams) == 0 {
+		return nil, nil, fmt.Errorf("No beams given.")
+	}
+
+	var dist beampack.DistanceFunc
+	var err error
+
+	if name := get_setting(req.Metric, *metric); name == "elliptical" {
+		dist = beampack.Elliptical(*semimajor, *semiminor, *pa)
+	} else if dist, err = beampack.GetMetric(name); err != nil {
+		return nil, nil, err
+	}
+
+	// the incoherent beams are recognised like in the input files, unless
+	// the client flags them
+	beams := make([]beampack.Beam, len(req.Beams))
+	for i, b := range req.Beams {
+		beams[i] = beampack.Beam{Nr: i, Name: b.Name, X: b.X, Y: b.Y, Incoherent: b.Incoherent}
+		if beams[i].Name == "" {
+			beams[i].Name = beampack.BeamName(i)
+		}
+
+		if strings.HasPrefix(beams[i].Name, beampack.IncoherentPrefix) {
+			beams[i].Incoherent = true
+		}
+	}
+
+	mark_incoherent(beams)
+
+	pinned, err := beampack.ResolveConstraints(req.Pinned, beams)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	seed := get_setting(req.Seed, *seed)
+	if seed == 0 {
+		seed = time.Now().UnixNano()
+	}
+
+	rng := rand.New(rand.NewSource(seed))
+
+	tangent, err := get_tangent()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// all beams are packed by default
+	n := max(get_setting(req.NBeams, *nbeams), 0)
+
+	packing, err := beampack.Pack(beams, beampack.Options{
+		NBeams:  n,
+		Bunch:   get_setting(req.Bunch, *bunch),
+		NGroups: get_setting(req.NGroups, *ngroups),
+		Method:  get_setting(req.Method, *method),
+		Metric:  dist,
+		Seed:    seed,
+		Rand:    rng,
+		Pinned:  pinned,
+
+		Incoherent:     *ibpolicy,
+		IncoherentNode: *ibnode,
+
+		Projection: *projection,
+		Boresight:  tangent,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	switch opt := get_setting(req.Optimize, *optimize); opt {
+	case "none":
+	case "anneal":
+		packing = beampack.Anneal(packing, get_setting(req.Iterations, *iterations), dist, rng)
+	case "ga":
+		opts, err := get_ga_options()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		packing = beampack.Evolve(packing, opts, dist, rng)
+	default:
+		return nil, nil, fmt.Errorf("Unknown optimizer: %s", opt)
+	}
+
+	if *nodefile != "" {
+		nodes, err := load_nodes()
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if err := beampack.Assign(packing, nodes); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return packing, dist, nil
+}
+
+// Handle a packing request. The beam positions are posted as JSON, and the
+// packing is returned in the JSON output format.
+func handle_pack(w http.ResponseWriter, r *http.Request) {
+	start := time.Now()
+	status := "invalid"
+
+	defer func() {
+		metrics.observe(status, time.Since(start))
+	}()
+
+	if r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Only POST requests are supported.", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req pack_request
+
+	body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, 64<<20))
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	packing, dist, err := serve_packing(req)
+	if err != nil {
+		status = "failed"
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	status = "ok"
+	metrics.observe_packing(packing, dist)
+
+	packing.Provenance = get_data_provenance("request", body)
+	packing.Provenance.Parameters = get_request_parameters(req)
+
+	dashboard.update(packing, dist, r.RemoteAddr)
+
+	w.Header().Set("Content-Type", "application/json")
+
+	if err := beampack.Write(w, packing, "json", dist); err != nil {
+		slog.Error("Could not write response", "error", err)
+		return
+	}
+
+	slog.Info("Packed beams", "beams", packing.NBeams(), "bunches", len(packing.Bunches), "client", r.RemoteAddr)
+}
+
+// Run the packer as HTTP service. The gRPC service is served on the same
+// address over HTTP/2 without TLS.
+func run_serve() {
+	if _, err := check_settings(); err != nil {
+		fatal(err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/pack", handle_pack)
+	mux.HandleFunc(grpc_pack_method, handle_grpc_pack)
+	mux.HandleFunc("/metrics", handle_metrics)
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, "ok")
+	})
+
+	if *dashboardon {
+		mux.HandleFunc("/", handle_dashboard)
+		mux.HandleFunc("/dashboard/plot.svg", handle_dashboard_plot)
+		mux.HandleFunc("/dashboard/events", handle_dashboard_events)
+	}
+
+	if *pprofon {
+		register_pprof(mux)
+	}
+
+	slog.Info("Listening", "address", *listen)
+
+	server := &http.Server{Addr: *listen, Handler: mux, Protocols: new(http.Protocols)}
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetUnencryptedHTTP2(true)
+
+	if err := server.ListenAndServe(); err != nil {
+		fatal(err)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+)
+
+// The service recognises the incoherent beams like the pack mode, by their
+// name prefix and by -ib-name.
+func TestServePackingIncoherent(t *testing.T) {
+	defer func(name string) { *ibname = name }(*ibname)
+	*ibname = "beam7"
+
+	var beams []request_beam
+	for i := 0; i < 12; i++ {
+		beams = append(beams, request_beam{Name: fmt.Sprintf("cfbf%05d", i), X: 0.01 * float64(i%4), Y: 0.01 * float64(i/4)})
+	}
+
+	beams = append(beams, request_beam{Name: "ifbf00000"}, request_beam{Name: "beam7"})
+
+	bunch := 6
+	packing, _, err := serve_packing(pack_request{Beams: beams, Bunch: &bunch})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last := packing.Bunches[len(packing.Bunches)-1]
+	if len(packing.Bunches) != 3 || len(last.Beams) != 2 {
+		t.Fatalf("wrong bunches: %d, %v", len(packing.Bunches), last.Beams)
+	}
+
+	for _, beam := range last.Beams {
+		if !beam.Incoherent || (beam.Name != "ifbf00000" && beam.Name != "beam7") {
+			t.Errorf("wrong incoherent beam: %+v", beam)
+		}
+	}
+}
@@ -0,0 +1,260 @@
+// Package sigproc reads the headers of SIGPROC filterbank (.fil) files.
+package sigproc
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+)
+
+// Header holds the fields of a SIGPROC filterbank header. Fields that are
+// missing from the header are zero.
+type Header struct {
+	SourceName  string `json:"source_name"`
+	RawDataFile string `json:"rawdatafile,omitempty"`
+	TelescopeID int    `json:"telescope_id"`
+	MachineID   int    `json:"machine_id"`
+	DataType    int    `json:"data_type"`
+	// The source position as given in the header, in the SIGPROC
+	// hhmmss.s and ddmmss.s notation.
+	SrcRAJ  float64 `json:"src_raj"`
+	SrcDEJ  float64 `json:"src_dej"`
+	AzStart float64 `json:"az_start"`
+	ZaStart float64 `json:"za_start"`
+	// The start time as MJD and the sampling time in seconds.
+	TStart float64 `json:"tstart"`
+	TSamp  float64 `json:"tsamp"`
+	NBits  int     `json:"nbits"`
+	NChans int     `json:"nchans"`
+	NIFs   int     `json:"nifs"`
+	NBeams int     `json:"nbeams"`
+	IBeam  int     `json:"ibeam"`
+	// The centre frequency of the first channel and the channel width in
+	// MHz.
+	Fch1        float64 `json:"fch1"`
+	Foff        float64 `json:"foff"`
+	RefDM       float64 `json:"refdm,omitempty"`
+	Period      float64 `json:"period,omitempty"`
+	Barycentric int     `json:"barycentric"`
+	Signed      bool    `json:"signed"`
+	// The number of samples in the data, if given in the header or derived
+	// from the file size.
+	NSamples int `json:"nsamples"`
+	// The size of the header in bytes, i.e. the offset of the data.
+	HeaderSize int64 `json:"header_size"`
+}
+
+// The longest keyword or string value that is accepted. Longer strings mean
+// that the file is not a filterbank file.
+const max_string = 4096
+
+// Read a length-prefixed string.
+func read_string(r io.Reader, n *int64) (string, error) {
+	var length int32
+	if err := binary.Read(r, binary.LittleEndian, &length); err != nil {
+		return "", err
+	}
+
+	if length <= 0 || length > max_string {
+		return "", fmt.Errorf("Invalid string length: %d", length)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return "", err
+	}
+
+	*n += 4 + int64(length)
+
+	return string(buf), nil
+}
+
+// Read an integer value.
+func read_int(r io.Reader, n *int64) (int, error) {
+	var v int32
+	if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+		return 0, err
+	}
+
+	*n += 4
+
+	return int(v), nil
+}
+
+// Read a double value.
+func read_double(r io.Reader, n *int64) (float64, error) {
+	var v float64
+	if err := binary.Read(r, binary.LittleEndian, &v); err != nil {
+		return 0, err
+	}
+
+	*n += 8
+
+	return v, nil
+}
+
+// Read parses a SIGPROC header from r, which must be positioned at the start
+// of the file. The data start after HeaderSize bytes.
+func Read(r io.Reader) (*Header, error) {
+	h := &Header{}
+
+	start, err := read_string(r, &h.HeaderSize)
+	if err != nil || start != "HEADER_START" {
+		return nil, fmt.Errorf("Not a SIGPROC filterbank header.")
+	}
+
+	ints := map[string]*int{
+		"telescope_id": &h.TelescopeID,
+		"machine_id":   &h.MachineID,
+		"data_type":    &h.DataType,
+		"nbits":        &h.NBits,
+		"nchans":       &h.NChans,
+		"nifs":         &h.NIFs,
+		"nbeams":       &h.NBeams,
+		"ibeam":        &h.IBeam,
+		"barycentric":  &h.Barycentric,
+		"nsamples":     &h.NSamples,
+	}
+
+	doubles := map[string]*float64{
+		"src_raj":  &h.SrcRAJ,
+		"src_dej":  &h.SrcDEJ,
+		"az_start": &h.AzStart,
+		"za_start": &h.ZaStart,
+		"tstart":   &h.TStart,
+		"tsamp":    &h.TSamp,
+		"fch1":     &h.Fch1,
+		"foff":     &h.Foff,
+		"refdm":    &h.RefDM,
+		"period":   &h.Period,
+	}
+
+	texts := map[string]*string{
+		"source_name": &h.SourceName,
+		"rawdatafile": &h.RawDataFile,
+	}
+
+	for {
+		key, err := read_string(r, &h.HeaderSize)
+		if err != nil {
+			return nil, fmt.Errorf("Could not read header keyword: %s", err)
+		}
+
+		switch {
+		case key == "HEADER_END":
+			return h, nil
+
+		case key == "FREQUENCY_START" || key == "FREQUENCY_END":
+			// the markers of the channel frequency list
+
+		case ints[key] != nil:
+			if *ints[key], err = read_int(r, &h.HeaderSize); err != nil {
+				return nil, fmt.Errorf("Could not read header value: %s, %s", key, err)
+			}
+
+		case doubles[key] != nil:
+			if *doubles[key], err = read_double(r, &h.HeaderSize); err != nil {
+				return nil, fmt.Errorf("Could not read header value: %s, %s", key, err)
+			}
+
+		case texts[key] != nil:
+			if *texts[key], err = read_string(r, &h.HeaderSize); err != nil {
+				return nil, fmt.Errorf("Could not read header value: %s, %s", key, err)
+			}
+
+		case key == "fchannel":
+			if _, err := read_double(r, &h.HeaderSize); err != nil {
+				return nil, fmt.Errorf("Could not read header value: %s, %s", key, err)
+			}
+
+		case key == "signed":
+			var v [1]byte
+			if _, err := io.ReadFull(r, v[:]); err != nil {
+				return nil, fmt.Errorf("Could not read header value: %s, %s", key, err)
+			}
+
+			h.Signed = v[0] != 0
+			h.HeaderSize++
+
+		default:
+			// the size of the value of an unknown keyword is unknown
+			return nil, fmt.Errorf("Unknown header keyword: %s", key)
+		}
+	}
+}
+
+// ReadFile reads the header of a filterbank file. Unless the header gives
+// the number of samples, it is derived from the file size.
+func ReadFile(filename string) (*Header, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("Could not open file: %s, %w", filename, err)
+	}
+	defer f.Close()
+
+	h, err := Read(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("Could not read header: %s, %s", filename, err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("Could not stat file: %s, %s", filename, err)
+	}
+
+	if h.NSamples == 0 {
+		if size := h.SampleSize(); size > 0 {
+			h.NSamples = int(float64(info.Size()-h.HeaderSize) / size)
+		}
+	}
+
+	return h, nil
+}
+
+// SampleSize returns the size of a time sample in bytes, or zero if the
+// header does not give the data layout.
+func (h *Header) SampleSize() float64 {
+	nifs := max(h.NIFs, 1)
+
+	return float64(h.NChans*nifs*h.NBits) / 8
+}
+
+// Duration returns the length of the data in seconds.
+func (h *Header) Duration() float64 {
+	return float64(h.NSamples) * h.TSamp
+}
+
+// Bandwidth returns the total bandwidth in MHz, which is negative if the
+// channel frequencies decrease.
+func (h *Header) Bandwidth() float64 {
+	return float64(h.NChans) * h.Foff
+}
+
+// Convert a value in SIGPROC ddmmss.s (or hhmmss.s) notation to degrees (or
+// hours).
+func from_sigproc(v float64) float64 {
+	sign := 1.0
+	if v < 0 {
+		sign = -1
+		v = -v
+	}
+
+	d := math.Floor(v / 10000)
+	m := math.Floor((v - d*10000) / 100)
+	s := v - d*10000 - m*100
+
+	return sign * (d + m/60 + s/3600)
+}
+
+// RA returns the right ascension of the source in degrees.
+func (h *Header) RA() float64 {
+	return 15 * from_sigproc(h.SrcRAJ)
+}
+
+// Dec returns the declination of the source in degrees.
+func (h *Header) Dec() float64 {
+	return from_sigproc(h.SrcDEJ)
+}
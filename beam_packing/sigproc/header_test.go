@@ -0,0 +1,113 @@
+package sigproc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Write a filterbank header like the MeerTRAP pipeline.
+func get_test_header() []byte {
+	var b bytes.Buffer
+
+	put_string := func(s string) {
+		binary.Write(&b, binary.LittleEndian, int32(len(s)))
+		b.WriteString(s)
+	}
+
+	put_int := func(key string, v int32) {
+		put_string(key)
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	put_double := func(key string, v float64) {
+		put_string(key)
+		binary.Write(&b, binary.LittleEndian, v)
+	}
+
+	put_string("HEADER_START")
+	put_string("source_name")
+	put_string("J0835-4510")
+	put_int("telescope_id", 64)
+	put_int("machine_id", 0)
+	put_int("data_type", 1)
+	put_double("src_raj", 83520.5)
+	put_double("src_dej", -451030.0)
+	put_double("tstart", 60000.5)
+	put_double("tsamp", 306.24e-6)
+	put_int("nbits", 8)
+	put_int("nchans", 1024)
+	put_int("nifs", 1)
+	put_int("ibeam", 12)
+	put_double("fch1", 1711.58203125)
+	put_double("foff", -0.8359375)
+	put_string("signed")
+	b.WriteByte(1)
+	put_string("HEADER_END")
+
+	return b.Bytes()
+}
+
+func TestRead(t *testing.T) {
+	raw := get_test_header()
+
+	h, err := Read(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.HeaderSize != int64(len(raw)) {
+		t.Errorf("wrong header size: %d, want %d", h.HeaderSize, len(raw))
+	}
+
+	if h.SourceName != "J0835-4510" || h.TelescopeID != 64 || h.NChans != 1024 || h.NBits != 8 || h.IBeam != 12 || !h.Signed {
+		t.Errorf("wrong header: %+v", h)
+	}
+
+	if math.Abs(h.RA()-128.8354167) > 1e-6 || math.Abs(h.Dec()+45.175) > 1e-9 {
+		t.Errorf("wrong position: %g, %g", h.RA(), h.Dec())
+	}
+
+	if math.Abs(h.Bandwidth()+856) > 1e-9 || h.SampleSize() != 1024 {
+		t.Errorf("wrong data layout: %g MHz, %g bytes", h.Bandwidth(), h.SampleSize())
+	}
+}
+
+func TestReadInvalid(t *testing.T) {
+	raw := get_test_header()
+
+	cases := map[string][]byte{
+		"empty":       nil,
+		"not sigproc": []byte("SIMPLE  =                    T"),
+		"truncated":   raw[:len(raw)-5],
+		"unknown":     append(raw[:16:16], []byte{7, 0, 0, 0, 'u', 'n', 'k', 'n', 'o', 'w', 'n'}...),
+	}
+
+	for name, data := range cases {
+		if _, err := Read(bytes.NewReader(data)); err == nil {
+			t.Errorf("%s: no error", name)
+		}
+	}
+}
+
+func TestReadFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "test.fil")
+
+	// 100 samples of 1024 channels of 8 bits
+	data := append(get_test_header(), make([]byte, 100*1024)...)
+	if err := os.WriteFile(filename, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h, err := ReadFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if h.NSamples != 100 || math.Abs(h.Duration()-100*306.24e-6) > 1e-12 {
+		t.Errorf("wrong number of samples: %d, %g s", h.NSamples, h.Duration())
+	}
+}
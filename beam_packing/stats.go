@@ -0,0 +1,88 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Write the quality report of the packing file given as argument, and the
+// distribution of the intra-bunch separations if requested. Without
+// packing file, write the spacing diagnostics of the beams instead.
+func run_stats() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	if cmdline.NArg() == 0 {
+		run_spacing(dist)
+		return
+	}
+
+	packing := read_packing_arg()
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteReport(out, beampack.Score(packing, dist)); err != nil {
+		fatalf("Could not write report: %s", err)
+	}
+
+	if *sepfile != "" {
+		write_separations(packing, dist)
+	}
+}
+
+// Write the nearest-neighbour spacing diagnostics of the input beams, and
+// the distribution of the nearest-neighbour separations if requested.
+func run_spacing(dist beampack.DistanceFunc) {
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	r := beampack.Spacing(beams, dist, beampack.SpacingOptions{
+		Tolerance:     *duptol,
+		OutlierFactor: *outlierfactor,
+	})
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteSpacing(out, r); err != nil {
+		fatalf("Could not write spacing diagnostics: %s", err)
+	}
+
+	if *sepfile != "" {
+		f, err := create_output(*sepfile)
+		if err != nil {
+			fatal(err)
+		}
+
+		err = beampack.WriteSeparations(f, r.Separations, *sepbins)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+
+		if err != nil {
+			fatalf("Could not write separations: %s", err)
+		}
+	}
+
+	if len(r.Duplicates) > 0 {
+		slog.Warn("Duplicated beams in the input", "file", *infile, "duplicates", len(r.Duplicates))
+	}
+
+	if len(r.Outliers) > 0 {
+		slog.Warn("Beams far from the tiling in the input", "file", *infile, "outliers", len(r.Outliers))
+	}
+}
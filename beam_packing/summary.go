@@ -0,0 +1,62 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Pack the input beams and write the consolidated summary of the
+// observation, with the metadata of the -sb schedule block file.
+func run_summary() {
+	if *sbfile == "" {
+		usagef("No schedule block file given.")
+	}
+
+	if *format != "text" && *format != "json" {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	sb, err := beampack.LoadScheduleBlock(*sbfile)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	packing.Provenance = get_provenance(*infile, *sbfile)
+
+	summary := beampack.Summarize(sb, packing, dist)
+
+	if summary.TargetSep != nil && *summary.TargetSep > *maxtargetsep {
+		slog.Warn("The beams are not formed on the target of the schedule block", "target", sb.Targets[0],
+			"separation", *summary.TargetSep)
+	}
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteSummary(out, summary, *format); err != nil {
+		fatalf("Could not write observation summary: %s", err)
+	}
+
+	slog.Info("Wrote observation summary", "schedule_block", sb.ID, "beams", summary.Beams, "bunches", summary.Bunches)
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// The commands of the interactive mode.
+const tui_help = `Commands:
+  list               list the bunches with their quality metrics
+  show ID            list the beams of a bunch
+  plot [ID]          draw the tiling, optionally highlighting a bunch
+  move BEAM ID       move a beam into a bunch
+  swap BEAM BEAM     exchange the bunches of two beams
+  undo               undo the last change
+  write              write the packing and quit
+  quit               quit without writing
+`
+
+// List the bunches of the packing with their quality metrics.
+func tui_list(w io.Writer, packing *beampack.Packing, dist beampack.DistanceFunc) {
+	report := beampack.Score(packing, dist)
+
+	fmt.Fprintf(w, "%5s %-12s %5s %12s %12s\n", "bunch", "node", "beams", "max_sep", "mean_sep")
+
+	for i, stats := range report.Bunches {
+		fmt.Fprintf(w, "%5d %-12s %5d %12.6f %12.6f\n", stats.ID, packing.Bunches[i].Node, stats.Size, stats.MaxSep, stats.MeanSep)
+	}
+
+	fmt.Fprintf(w, "total: %d beams, %d bunches, max_sep: %.6f, mean_sep: %.6f\n", report.NBeams, len(report.Bunches), report.MaxSep, report.MeanSep)
+}
+
+// List the beams of a bunch.
+func tui_show(w io.Writer, packing *beampack.Packing, id int) error {
+	for _, b := range packing.Bunches {
+		if b.ID != id {
+			continue
+		}
+
+		for rank, beam := range b.Beams {
+			fmt.Fprintf(w, "%4d %-20s %12.6f %12.6f\n", rank, beam.Name, beam.X, beam.Y)
+		}
+
+		return nil
+	}
+
+	return fmt.Errorf("Unknown bunch: %d", id)
+}
+
+// Parse the bunch ID argument of a command.
+func parse_bunch_id(text string) (int, error) {
+	id, err := strconv.Atoi(text)
+	if err != nil {
+		return 0, fmt.Errorf("Invalid bunch ID: %s", text)
+	}
+
+	return id, nil
+}
+
+// Compute the packing and let the operator inspect it and move beams
+// between bunches on the terminal before writing it.
+func run_tui() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	if *infile == beampack.Stdin {
+		usagef("The interactive mode reads the commands from stdin, the beams must be read from file.")
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	packing.Provenance = get_provenance(*infile)
+
+	var history []*beampack.Packing
+
+	w := os.Stdout
+	scanner := bufio.NewScanner(os.Stdin)
+
+	tui_list(w, packing, dist)
+	fmt.Fprint(w, "Type help for the list of commands.\n> ")
+
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+
+		var cmd string
+		if len(fields) > 0 {
+			cmd = fields[0]
+		}
+
+		args := len(fields) - 1
+		err = nil
+
+		switch {
+		case cmd == "":
+		case cmd == "help":
+			fmt.Fprint(w, tui_help)
+		case cmd == "list":
+			tui_list(w, packing, dist)
+		case cmd == "show" && args == 1:
+			var id int
+			if id, err = parse_bunch_id(fields[1]); err == nil {
+				err = tui_show(w, packing, id)
+			}
+		case cmd == "plot" && args <= 1:
+			id := -1
+			if args == 1 {
+				id, err = parse_bunch_id(fields[1])
+			}
+			if err == nil {
+				err = beampack.Scatter(w, packing, *tuiwidth, id)
+			}
+		case cmd == "move" && args == 2:
+			var id int
+			if id, err = parse_bunch_id(fields[2]); err == nil {
+				next := packing.Clone()
+				if err = next.Move(fields[1], id, dist); err == nil {
+					history = append(history, packing)
+					packing = next
+					slog.Info("Moved beam", "beam", fields[1], "bunch", id)
+				}
+			}
+		case cmd == "swap" && args == 2:
+			next := packing.Clone()
+			if err = next.Swap(fields[1], fields[2], dist); err == nil {
+				history = append(history, packing)
+				packing = next
+				slog.Info("Swapped beams", "a", fields[1], "b", fields[2])
+			}
+		case cmd == "undo":
+			if len(history) == 0 {
+				err = fmt.Errorf("Nothing to undo.")
+			} else {
+				packing = history[len(history)-1]
+				history = history[:len(history)-1]
+			}
+		case cmd == "write":
+			write_packing(packing, dist)
+			return
+		case cmd == "quit":
+			slog.Info("Quit without writing the packing")
+			return
+		default:
+			err = fmt.Errorf("Invalid command: %s, type help for the list of commands", scanner.Text())
+		}
+
+		if err != nil {
+			fmt.Fprintln(w, "Error:", err)
+		}
+
+		fmt.Fprint(w, "> ")
+	}
+
+	if err := scanner.Err(); err != nil {
+		fatalf("Could not read commands: %s", err)
+	}
+
+	slog.Warn("End of input, the packing was not written")
+}
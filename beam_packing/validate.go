@@ -0,0 +1,70 @@
+package main
+
+import (
+	"log/slog"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Validate an externally supplied packing file, given as argument or with
+// -packing, against the beam positions and report the violations. The exit status is non-zero if there are any,
+// so that this can gate the start of the search pipeline.
+func run_validate() {
+	filename := *packingfile
+	if cmdline.NArg() == 1 {
+		filename = cmdline.Arg(0)
+	}
+
+	if filename == "" {
+		usagef("No packing file given.")
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	records, err := beampack.ReadPacking(filename)
+	if err != nil {
+		fatal(input_error(err))
+	}
+
+	flagged, err := get_flagged(beams)
+	if err != nil {
+		fatal(err)
+	}
+
+	v := beampack.Validate(records, beams, beampack.ValidateOptions{
+		Bunch:     *bunch,
+		NGroups:   *ngroups,
+		Remainder: *remainder,
+		MaxRadius: *maxradius,
+		Flagged:   flagged,
+	}, dist)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+
+	err = beampack.WriteValidation(out, v)
+	out.Close()
+
+	if err != nil {
+		fatalf("Could not write validation report: %s", err)
+	}
+
+	if len(v.Violations) > 0 {
+		slog.Error("Invalid packing", "file", filename, "violations", len(v.Violations), "class", exit_classes[exit_check], "exit_code", exit_check)
+		exit(exit_check)
+	}
+
+	slog.Info("Valid packing", "file", filename, "beams", v.NBeams, "bunches", v.NBunches)
+}
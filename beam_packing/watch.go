@@ -0,0 +1,96 @@
+package main
+
+import (
+	"log/slog"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Pack new beam position files as soon as they appear in the watched
+// directory. The directory is polled, and a file is only packed once its
+// size and modification time have not changed between two polls, so that
+// partially written files are skipped. Files that exist at startup are
+// ignored. The packings are written like in batch mode.
+func run_watch() {
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	get_seed()
+
+	if local, ok := beampack.LocalPath(*outdir); ok {
+		if err := os.MkdirAll(local, 0755); err != nil {
+			fatalf("Could not create output directory: %s, %s", *outdir, err)
+		}
+	}
+
+	glob := filepath.Join(*watchdir, *pattern)
+
+	// the files already handled, or present at startup
+	seen := make(map[string]bool)
+	// the files that have appeared, with their last observed state
+	pending := make(map[string]os.FileInfo)
+
+	files, err := filepath.Glob(glob)
+	if err != nil {
+		usagef("Invalid file name pattern: %s, %s", *pattern, err)
+	}
+
+	for _, filename := range files {
+		seen[filename] = true
+	}
+
+	slog.Info("Watching for new files", "pattern", glob)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			slog.Info("Stopped watching", "dir", *watchdir)
+			return
+		case <-ticker.C:
+		}
+
+		files, _ := filepath.Glob(glob)
+
+		for _, filename := range files {
+			if seen[filename] {
+				continue
+			}
+
+			info, err := os.Stat(filename)
+			if err != nil {
+				continue
+			}
+
+			last, ok := pending[filename]
+			pending[filename] = info
+
+			if !ok || last.Size() != info.Size() || !last.ModTime().Equal(info.ModTime()) {
+				continue
+			}
+
+			delete(pending, filename)
+			seen[filename] = true
+
+			r := pack_batch_file(filename, dist)
+			if r.err != nil {
+				slog.Error("Could not pack file", "file", filename, "error", r.err)
+				continue
+			}
+
+			slog.Info("Packed file", "file", filename, "output", r.outfile, "bunches", len(r.report.Bunches), "max_sep", r.report.MaxSep)
+		}
+	}
+}
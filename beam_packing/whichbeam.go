@@ -0,0 +1,126 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+
+	"github.com/fjankowsk/meertrap_misc/beam_packing/beampack"
+)
+
+// Get the packing to look the positions up in: the -packing file, or the
+// packing of the input beams.
+func get_lookup_packing() *beampack.Packing {
+	if *packingfile != "" {
+		records, err := beampack.ReadPacking(*packingfile)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		return beampack.FromRecords(records)
+	}
+
+	dist, err := check_settings()
+	if err != nil {
+		fatal(err)
+	}
+
+	beams, err := load_beams(*infile)
+	if err != nil {
+		fatal(err)
+	}
+
+	dist = get_metric(dist, beams)
+
+	packing, err := compute_packing(beams, dist, get_seed())
+	if err != nil {
+		fatal(err)
+	}
+
+	return packing
+}
+
+// Get the sky positions given as arguments or in the -catalogue, in J2000.
+func get_sources() []beampack.Source {
+	var sources []beampack.Source
+
+	for _, arg := range cmdline.Args() {
+		src, err := beampack.ParseSource(arg)
+		if err != nil {
+			usagef("%s", err)
+		}
+
+		sources = append(sources, src)
+	}
+
+	if *catalogue != "" {
+		c, err := beampack.LoadCatalogue(*catalogue)
+		if err != nil {
+			fatal(input_error(err))
+		}
+
+		sources = append(sources, c...)
+	}
+
+	// the beams are in J2000 when loaded
+	eq, err := get_equinox(*catequinox)
+	if err != nil {
+		fatal(classify(exit_usage, err))
+	}
+
+	if eq != beampack.J2000 {
+		beampack.ConvertSources(sources, eq, beampack.J2000)
+	}
+
+	return sources
+}
+
+// Report the coherent beams nearest to the sky positions given as arguments
+// or in the -catalogue, with their bunches and nodes.
+func run_which_beam() {
+	if cmdline.NArg() == 0 && *catalogue == "" {
+		usagef("No positions or source catalogue given.")
+	}
+
+	if !slices.Contains(beampack.Formats, *format) {
+		usagef("Unknown output format: %s", *format)
+	}
+
+	if *nearest < 1 {
+		usagef("The number of nearest beams must be positive: %d", *nearest)
+	}
+
+	sources := get_sources()
+	packing := get_lookup_packing()
+
+	opts := beampack.NearestOptions{
+		N:         *nearest,
+		SemiMajor: *semimajor,
+		SemiMinor: *semiminor,
+		PA:        *pa,
+	}
+
+	if *radius > 0 {
+		opts.SemiMajor, opts.SemiMinor, opts.PA = *radius, *radius, 0
+	}
+
+	list := beampack.NearestBeams(packing, sources, opts)
+
+	var inside int
+	for _, l := range list {
+		if l.Rank == 1 && l.Widths <= 0.5 {
+			inside++
+		}
+	}
+
+	slog.Info("Looked up the nearest beams", "positions", len(sources), "within_beam", inside)
+
+	out, err := create_output(*outfile)
+	if err != nil {
+		fatal(err)
+	}
+	defer close_output(out)
+
+	if err := beampack.WriteNearest(out, list, *format); err != nil {
+		fatalf("Could not write nearest beams: %s", err)
+	}
+}